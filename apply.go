@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ============ 声明式配置: plan / apply ============
+//
+// 对比配置与加密锁的实际状态（文件列表、大小、权限、可读内容的摘要、
+// 用户ID、期限），生成变更计划，只执行达到目标状态所需的操作。
+//
+// PIN 和种子码次数无法直接读取:
+//   - PIN 先校验配置中的当前PIN，失败时才尝试新PIN；修改PIN成功后记录到
+//     状态文件，之后对同一把锁优先校验新PIN，重复执行不会消耗重试次数；
+//   - 种子码次数只在计划中已有其他变更或指定 -force 时设置。
+
+// keyFile 加密锁上的数据文件
+type keyFile struct {
+	attr    DataFileAttr
+	content []byte // 文件内容，nil 表示不可读取
+}
+
+// keyState 加密锁当前状态
+type keyState struct {
+	userID   uint32
+	deadline uint32
+	files    map[uint16]*keyFile
+	adminPIN string // 校验通过的开发商PIN
+	userPIN  string // 当前用户PIN（推断）
+}
+
+// planChange 一项变更
+type planChange struct {
+	action  string       // +: 新增, ~: 修改, -: 删除, -/+: 重建
+	summary string       // 描述
+	step    string       // 审计步骤名
+	apply   func() error // 执行函数
+}
+
+// applyPlan 变更计划
+type applyPlan struct {
+	changes []planChange
+	notes   []string
+}
+
+// shortDigest 返回数据的 SHA-256 摘要前缀
+func shortDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%x", sum[:6])
+}
+
+// verifyAnyPIN 依次尝试候选PIN，返回校验通过的PIN
+func verifyAnyPIN(c *deviceConn, flags int, candidates ...string) (string, error) {
+	var lastErr error
+	for _, pin := range candidates {
+		if pin == "" {
+			continue
+		}
+		if lastErr = c.verifyPIN(flags, pin); lastErr == nil {
+			return pin, nil
+		}
+	}
+	return "", lastErr
+}
+
+// readKeyState 读取加密锁状态，需要先校验开发商PIN
+func readKeyState(c *deviceConn, profile *provisionProfile) (*keyState, error) {
+	state := &keyState{userID: c.info.MUserID, files: make(map[uint16]*keyFile)}
+
+	var err error
+	if state.deadline, err = c.deadline(); err != nil {
+		return nil, fmt.Errorf("读取期限失败: %v", err)
+	}
+
+	list, err := c.listFiles()
+	if err != nil {
+		return nil, fmt.Errorf("列举文件失败: %v", err)
+	}
+
+	wanted := make(map[uint16]bool)
+	for _, f := range profile.Files {
+		wanted[f.ID] = true
+	}
+	for _, item := range list {
+		kf := &keyFile{attr: item.MAttr}
+		state.files[item.MFileID] = kf
+		if !wanted[item.MFileID] || item.MAttr.MSize == 0 {
+			continue
+		}
+		buffer := make([]byte, item.MAttr.MSize)
+		if err := c.readDataFile(item.MFileID, buffer); err == nil {
+			kf.content = buffer
+		} else if isCallAborted(err) {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// pinCandidates 按是否已记录修改返回PIN的校验顺序
+//
+// 未记录修改时先校验当前PIN，只有当前PIN失败时才尝试新PIN，避免每次执行
+// 都因校验尚未生效的新PIN而消耗一次重试次数。
+func pinCandidates(current, next string, changed bool) []string {
+	if next == "" || next == current {
+		return []string{current}
+	}
+	if changed {
+		return []string{next, current}
+	}
+	return []string{current, next}
+}
+
+// planProfile 校验PIN、读取状态并生成变更计划
+//
+// applied 为该锁已记录完成的步骤 (见 loadApplyState)，可以为 nil。
+// includeUnobservable 为 true 时总是设置无法读取当前值的项（种子码次数）。
+func planProfile(c *deviceConn, profile *provisionProfile, applied map[string]bool, includeUnobservable bool) (*applyPlan, error) {
+	// 用户PIN: 只在需要修改时校验
+	userPIN := profile.UserPIN
+	if profile.NewUserPIN != "" {
+		if pin, err := verifyAnyPIN(c, FLAG_USERPIN, pinCandidates(profile.UserPIN, profile.NewUserPIN, applied[STEP_CHANGE_USER_PIN])...); err == nil {
+			userPIN = pin
+		}
+	}
+
+	adminPIN, err := verifyAnyPIN(c, FLAG_ADMINPIN, pinCandidates(profile.AdminPIN, profile.NewAdminPIN, applied[STEP_CHANGE_ADMIN_PIN])...)
+	if err != nil {
+		return nil, fmt.Errorf("校验开发商PIN失败: %v", err)
+	}
+
+	state, err := readKeyState(c, profile)
+	if err != nil {
+		return nil, err
+	}
+	state.adminPIN = adminPIN
+	state.userPIN = userPIN
+
+	return buildPlan(c, profile, state, includeUnobservable)
+}
+
+// buildPlan 根据当前状态生成变更计划
+func buildPlan(c *deviceConn, profile *provisionProfile, state *keyState, includeUnobservable bool) (*applyPlan, error) {
+	plan := &applyPlan{}
+	add := func(action, summary, step string, apply func() error) {
+		plan.changes = append(plan.changes, planChange{action: action, summary: summary, step: step, apply: apply})
+	}
+
+	// 用户ID
+	if profile.UserID != nil && *profile.UserID != state.userID {
+		userID := *profile.UserID
+		add("~", fmt.Sprintf("user_id: %08X -> %08X", state.userID, userID), fmt.Sprintf("user-id=%08X", userID), func() error {
+			setUserIDFunc, err := c.proc(FUNC_SETUSERID)
+			if err != nil {
+				return err
+			}
+			return c.call(FUNC_SETUSERID, func() (err error) {
+				_, err = setUserID(setUserIDFunc, c.handle, userID)
+				return
+			})
+		})
+	}
+
+	// 删除多余文件
+	if profile.PruneFiles {
+		wanted := make(map[uint16]bool)
+		for _, f := range profile.Files {
+			wanted[f.ID] = true
+		}
+		var extra []uint16
+		for id := range state.files {
+			if !wanted[id] {
+				extra = append(extra, id)
+			}
+		}
+		sort.Slice(extra, func(i, j int) bool { return extra[i] < extra[j] })
+		for _, id := range extra {
+			id := id
+			add("-", fmt.Sprintf("删除文件 0x%04X", id), fmt.Sprintf("delete-file=%04X", id), func() error {
+				return c.deleteDataFile(id)
+			})
+		}
+	}
+
+	// 文件
+	for _, f := range profile.Files {
+		f := f
+		content, err := f.content(profile.baseDir)
+		if err != nil {
+			return nil, fmt.Errorf("文件 0x%04X: %v", f.ID, err)
+		}
+		attr := DataFileAttr{MSize: uint32(f.Size), MReadPriv: uint16(f.ReadPriv), MWritePriv: uint16(f.WritePriv)}
+		desc := fmt.Sprintf("大小 %d, 读权限 %d, 写权限 %d", attr.MSize, attr.MReadPriv, attr.MWritePriv)
+
+		current, exists := state.files[f.ID]
+		switch {
+		case !exists:
+			add("+", fmt.Sprintf("创建文件 0x%04X (%s)", f.ID, desc), fmt.Sprintf("file=%04X", f.ID), func() error {
+				return c.createDataFileWithContent(f.ID, attr, content)
+			})
+			continue
+		case current.attr != attr:
+			add("-/+", fmt.Sprintf("重建文件 0x%04X (大小 %d -> %d, 读权限 %d -> %d, 写权限 %d -> %d)", f.ID,
+				current.attr.MSize, attr.MSize, current.attr.MReadPriv, attr.MReadPriv, current.attr.MWritePriv, attr.MWritePriv),
+				fmt.Sprintf("file=%04X", f.ID), func() error {
+					if err := c.deleteDataFile(f.ID); err != nil {
+						return err
+					}
+					return c.createDataFileWithContent(f.ID, attr, content)
+				})
+			continue
+		}
+
+		if len(content) == 0 {
+			continue
+		}
+		var from string
+		switch {
+		case current.content == nil:
+			from = "不可读取"
+		case bytes.Equal(current.content[:len(content)], content):
+			continue
+		default:
+			from = shortDigest(current.content[:len(content)])
+		}
+		add("~", fmt.Sprintf("写入文件 0x%04X 内容 (sha256 %s -> %s)", f.ID, from, shortDigest(content)), fmt.Sprintf("write-file=%04X", f.ID), func() error {
+			return c.writeDataFile(f.ID, content)
+		})
+	}
+
+	// 期限
+	if profile.Deadline != "" {
+		deadline, _ := parseDeadline(profile.Deadline)
+		if deadline != state.deadline {
+			add("~", fmt.Sprintf("deadline: %s -> %s", formatDeadline(state.deadline), formatDeadline(deadline)), "deadline="+profile.Deadline, func() error {
+				setDeadlineFunc, err := c.proc(FUNC_SETDEADLINE)
+				if err != nil {
+					return err
+				}
+				return c.call(FUNC_SETDEADLINE, func() (err error) {
+					_, err = setDeadline(setDeadlineFunc, c.handle, deadline)
+					return
+				})
+			})
+		}
+	}
+
+	changeUserPIN := profile.NewUserPIN != "" && state.userPIN != profile.NewUserPIN
+	changeAdminPIN := profile.NewAdminPIN != "" && state.adminPIN != profile.NewAdminPIN
+
+	// 种子码次数：无法读取当前值，只在有其他变更 (包括期限和PIN) 时一并设置
+	if profile.SeedLimit != nil {
+		seedLimit := *profile.SeedLimit
+		if includeUnobservable || len(plan.changes) > 0 || changeUserPIN || changeAdminPIN {
+			add("~", fmt.Sprintf("seed_limit: ? -> %d", seedLimit), fmt.Sprintf("seed-limit=%d", seedLimit), func() error {
+				limitSeedCountFunc, err := c.proc(FUNC_LIMITSEEDCOUNT)
+				if err != nil {
+					return err
+				}
+				return c.call(FUNC_LIMITSEEDCOUNT, func() (err error) {
+					_, err = limitSeedCount(limitSeedCountFunc, c.handle, seedLimit)
+					return
+				})
+			})
+		} else {
+			plan.notes = append(plan.notes, "seed_limit 当前值无法读取，未设置 (使用 -force 强制设置)")
+		}
+	}
+
+	// 最后修改PIN，避免中途失败后无法用已知PIN重试
+	if changeUserPIN {
+		add("~", "修改用户PIN", STEP_CHANGE_USER_PIN, func() error {
+			return c.changePIN(FLAG_USERPIN, state.userPIN, profile.NewUserPIN, profile.UserTryCount)
+		})
+	}
+	if changeAdminPIN {
+		add("~", "修改开发商PIN", STEP_CHANGE_ADMIN_PIN, func() error {
+			return c.changePIN(FLAG_ADMINPIN, state.adminPIN, profile.NewAdminPIN, profile.AdminTryCount)
+		})
+	}
+
+	return plan, nil
+}
+
+// show 显示变更计划
+func (p *applyPlan) show() {
+	if len(p.changes) == 0 {
+		fmt.Println("无需变更，加密锁已符合配置")
+	}
+	for _, change := range p.changes {
+		fmt.Printf("  %-3s %s\n", change.action, change.summary)
+	}
+	for _, note := range p.notes {
+		fmt.Printf("  注意: %s\n", note)
+	}
+	if len(p.changes) > 0 {
+		fmt.Printf("共 %d 项变更\n", len(p.changes))
+	}
+}
+
+// ============ 设备操作封装 ============
+
+// deadline 读取期限
+func (c *deviceConn) deadline() (uint32, error) {
+	getDeadlineFunc, err := c.proc(FUNC_GETDEADLINE)
+	if err != nil {
+		return 0, err
+	}
+	var deadline uint32
+	err = c.call(FUNC_GETDEADLINE, func() (err error) {
+		deadline, _, err = getDeadline(getDeadlineFunc, c.handle)
+		return
+	})
+	return deadline, err
+}
+
+// listFiles 列举数据文件，需要开发商权限
+func (c *deviceConn) listFiles() ([]DataFileList, error) {
+	listFileFunc, err := c.proc(FUNC_LISTFILE)
+	if err != nil {
+		return nil, err
+	}
+	var list []DataFileList
+	err = c.call(FUNC_LISTFILE, func() (err error) {
+		list, _, err = listDataFiles(listFileFunc, c.handle)
+		return
+	})
+	return list, err
+}
+
+// readDataFile 从起始位置读取数据文件，填满 buffer
+func (c *deviceConn) readDataFile(fileID uint16, buffer []byte) error {
+	readFileFunc, err := c.proc(FUNC_READFILE)
+	if err != nil {
+		return err
+	}
+	return c.call(FUNC_READFILE, func() (err error) {
+		_, _, err = readFile(readFileFunc, c.handle, uintptr(fileID), 0, buffer)
+		return
+	})
+}
+
+// createDataFileWithContent 创建数据文件并写入初始内容
+func (c *deviceConn) createDataFileWithContent(fileID uint16, attr DataFileAttr, content []byte) error {
+	createFileFunc, err := c.proc(FUNC_CREATEFILE)
+	if err != nil {
+		return err
+	}
+	err = c.call(FUNC_CREATEFILE, func() (err error) {
+		_, err = createDataFile(createFileFunc, c.handle, fileID, attr)
+		return
+	})
+	if err != nil {
+		return err
+	}
+	if len(content) == 0 {
+		return nil
+	}
+	return c.writeDataFile(fileID, content)
+}
+
+// writeDataFile 从起始位置写入数据文件
+func (c *deviceConn) writeDataFile(fileID uint16, content []byte) error {
+	writeFileFunc, err := c.proc(FUNC_WRITEFILE)
+	if err != nil {
+		return err
+	}
+	return c.call(FUNC_WRITEFILE, func() (err error) {
+		_, err = writeFile(writeFileFunc, c.handle, FILE_DATA, fileID, 0, content)
+		return
+	})
+}
+
+// deleteDataFile 删除数据文件
+func (c *deviceConn) deleteDataFile(fileID uint16) error {
+	deleteFileFunc, err := c.proc(FUNC_DELETEFILE)
+	if err != nil {
+		return err
+	}
+	return c.call(FUNC_DELETEFILE, func() (err error) {
+		_, err = deleteFile(deleteFileFunc, c.handle, FILE_DATA, fileID)
+		return
+	})
+}
+
+// changePIN 修改PIN
+func (c *deviceConn) changePIN(flags int, oldPIN, newPIN string, tryCount int) error {
+	changePINFunc, err := c.proc(FUNC_CHANGEPIN)
+	if err != nil {
+		return err
+	}
+	return c.call(FUNC_CHANGEPIN, func() (err error) {
+		_, err = changePIN(changePINFunc, c.handle, flags, oldPIN, newPIN, tryCount)
+		return
+	})
+}
+
+// ============ 状态记录 ============
+
+// 修改PIN的步骤名，完成后记录到状态文件
+const (
+	STEP_CHANGE_USER_PIN  = "change-user-pin"
+	STEP_CHANGE_ADMIN_PIN = "change-admin-pin"
+)
+
+// applyStateRecord 状态文件中的一条记录，每行一条 JSON
+type applyStateRecord struct {
+	Time    time.Time `json:"time"`    // 完成时间
+	HID     string    `json:"hid"`     // 硬件ID
+	Profile string    `json:"profile"` // 配置名称
+	Step    string    `json:"step"`    // 已完成的步骤
+}
+
+// loadApplyState 读取状态文件，返回指定加密锁和配置已完成的步骤
+func loadApplyState(path, hid, profile string) (map[string]bool, error) {
+	applied := make(map[string]bool)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return applied, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec applyStateRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue // 忽略中断时写入的不完整行
+		}
+		if rec.HID == hid && rec.Profile == profile {
+			applied[rec.Step] = true
+		}
+	}
+	return applied, scanner.Err()
+}
+
+// appendApplyState 追加一条已完成步骤的记录
+func appendApplyState(path string, rec applyStateRecord) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ============ 命令行 ============
+
+// confirm 等待用户输入 yes 确认
+func confirm(prompt string) bool {
+	fmt.Printf("%s (输入 yes 确认): ", prompt)
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(line) == "yes"
+}
+
+// runApplyCommand 执行 apply 子命令
+func runApplyCommand(args []string) error {
+	fs := flag.NewFlagSet("apply", flag.ContinueOnError)
+	index := fs.Int("device", 0, "设备序号")
+	planOnly := fs.Bool("plan", false, "只显示变更计划，不执行")
+	autoApprove := fs.Bool("auto-approve", false, "不询问直接执行")
+	force := fs.Bool("force", false, "总是设置无法读取当前值的项（种子码次数）")
+	statePath := fs.String("state", "apply-state.jsonl", "记录已完成的PIN修改，避免重复执行时消耗PIN重试次数")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("用法: apply [-device N] [-plan] [-auto-approve] [-force] [-state 文件] 配置文件")
+	}
+
+	profile, err := loadProfile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := commandContext()
+	defer stop()
+
+	conn, err := connectDevice(ctx, *index)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+
+	applied, err := loadApplyState(*statePath, conn.info.HID(), profile.Name)
+	if err != nil {
+		return fmt.Errorf("读取状态文件失败: %v", err)
+	}
+
+	fmt.Println("\n读取加密锁状态...")
+	plan, err := planProfile(conn, profile, applied, *force)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\n变更计划 (设备 %d, HID %s, 配置 %s):\n", *index, conn.info.HID(), profile.Name)
+	plan.show()
+	if *planOnly || len(plan.changes) == 0 {
+		return nil
+	}
+	if !*autoApprove && !confirm("\n是否执行以上变更?") {
+		return fmt.Errorf("已取消")
+	}
+
+	for i, change := range plan.changes {
+		fmt.Printf("\n[%d/%d] %s %s\n", i+1, len(plan.changes), change.action, change.summary)
+		if err := change.apply(); err != nil {
+			return fmt.Errorf("%s 失败: %v (已完成 %d 项)", change.summary, err, i)
+		}
+		if change.step == STEP_CHANGE_USER_PIN || change.step == STEP_CHANGE_ADMIN_PIN {
+			rec := applyStateRecord{Time: time.Now().UTC(), HID: conn.info.HID(), Profile: profile.Name, Step: change.step}
+			if err := appendApplyState(*statePath, rec); err != nil {
+				logger.Warn("写入状态文件失败", "path", *statePath, "err", err)
+			}
+		}
+	}
+	fmt.Printf("\n已完成 %d 项变更\n", len(plan.changes))
+	return nil
+}
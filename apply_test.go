@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+// TestBuildPlanSeedLimit 无法读取的种子码次数只在有其他变更时设置，且在修改PIN之前
+func TestBuildPlanSeedLimit(t *testing.T) {
+	limit := 100
+	steps := func(plan *applyPlan) []string {
+		var names []string
+		for _, change := range plan.changes {
+			names = append(names, change.step)
+		}
+		return names
+	}
+
+	cases := []struct {
+		name    string
+		profile provisionProfile
+		want    []string
+	}{
+		{"无其他变更", provisionProfile{SeedLimit: &limit}, nil},
+		{"只修改期限", provisionProfile{SeedLimit: &limit, Deadline: "720h"}, []string{"deadline=720h", "seed-limit=100"}},
+		{"只修改PIN", provisionProfile{SeedLimit: &limit, NewUserPIN: "87654321", NewAdminPIN: "ABCDEF0123456789"},
+			[]string{"seed-limit=100", STEP_CHANGE_USER_PIN, STEP_CHANGE_ADMIN_PIN}},
+	}
+	for _, tc := range cases {
+		state := &keyState{files: map[uint16]*keyFile{}, userPIN: DEFAULT_USER_PIN, adminPIN: DEFAULT_ADMIN_PIN}
+		plan, err := buildPlan(nil, &tc.profile, state, false)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		got := steps(plan)
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: 步骤 %v, 期望 %v", tc.name, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s: 步骤 %v, 期望 %v", tc.name, got, tc.want)
+				break
+			}
+		}
+		if tc.want == nil && len(plan.notes) != 1 {
+			t.Errorf("%s: 缺少未设置 seed_limit 的提示 %v", tc.name, plan.notes)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+// ============ 后端接口 ============
+
+// Backend 加密锁操作后端
+//
+// 每个方法对应一个 SDK 接口，返回值约定与底层辅助函数一致：
+// 结果、返回码、错误。实现本身不保证线程安全，并发访问应通过 Session。
+type Backend interface {
+	Enum() ([]DongleInfo, uint32, error)
+	Open(index int) (DongleHandle, uint32, error)
+	Close(handle DongleHandle) (uint32, error)
+	ReadFile(handle DongleHandle, fileID uint16, offset int, buffer []byte) (uint32, error)
+	ReadData(handle DongleHandle, offset int, buffer []byte) (uint32, error)
+	WriteData(handle DongleHandle, offset int, data []byte) (uint32, error)
+	VerifyPIN(handle DongleHandle, flags int, pin string) (int, uint32, error)
+	Seed(handle DongleHandle, seedData []byte) ([]byte, uint32, error)
+	GetDeadline(handle DongleHandle) (uint32, uint32, error)
+	GetUTCTime(handle DongleHandle) (uint32, uint32, error)
+	GenRandom(handle DongleHandle, length int) ([]byte, uint32, error)
+	EccSign(handle DongleHandle, fileID uint16, hash []byte) ([]byte, uint32, error)
+}
+
+// typedFileReader 可以指定文件类型读取文件的后端，用于读取参数扫描
+type typedFileReader interface {
+	ReadFileTyped(handle DongleHandle, fileType int, fileID uint16, offset int, buffer []byte) (uint32, error)
+}
+
+// errTypedReadUnsupported 后端不支持指定文件类型读取
+var errTypedReadUnsupported = errors.New("当前后端不支持指定文件类型读取，请使用原生动态库")
+
+// nativeBackend 通过 purego 调用厂商动态库的后端
+type nativeBackend struct {
+	lib uintptr
+
+	mu    sync.Mutex
+	procs map[string]uintptr // 已解析的函数地址
+}
+
+// newNativeBackend 加载动态库
+func newNativeBackend(libPath string) (*nativeBackend, error) {
+	lib, err := loadLibrary(libPath)
+	if err != nil {
+		return nil, err
+	}
+	return &nativeBackend{lib: lib, procs: make(map[string]uintptr)}, nil
+}
+
+// proc 获取并缓存函数地址
+func (b *nativeBackend) proc(funcName string) (uintptr, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if addr, ok := b.procs[funcName]; ok {
+		return addr, nil
+	}
+	addr, err := getProcAddress(b.lib, funcName)
+	if err != nil {
+		return 0, err
+	}
+	b.procs[funcName] = addr
+	return addr, nil
+}
+
+// Unload 卸载动态库
+func (b *nativeBackend) Unload() {
+	unloadLibrary(b.lib)
+}
+
+func (b *nativeBackend) Enum() ([]DongleInfo, uint32, error) {
+	enumFunc, err := b.proc(FUNC_ENUM)
+	if err != nil {
+		return nil, DONGLE_UNKNOWN_ERROR, err
+	}
+	keyList, _, retCode, err := enumDevices(enumFunc)
+	return keyList, retCode, err
+}
+
+func (b *nativeBackend) Open(index int) (DongleHandle, uint32, error) {
+	openFunc, err := b.proc(FUNC_OPEN)
+	if err != nil {
+		return 0, DONGLE_UNKNOWN_ERROR, err
+	}
+	return openDevice(openFunc, index)
+}
+
+func (b *nativeBackend) Close(handle DongleHandle) (uint32, error) {
+	closeFunc, err := b.proc(FUNC_CLOSE)
+	if err != nil {
+		closeFunc = 0 // Close 是可选的
+	}
+	return closeDevice(closeFunc, handle)
+}
+
+func (b *nativeBackend) ReadFile(handle DongleHandle, fileID uint16, offset int, buffer []byte) (uint32, error) {
+	readFileFunc, err := b.proc(FUNC_READFILE)
+	if err != nil {
+		return DONGLE_UNKNOWN_ERROR, err
+	}
+	retCode, _, err := readFile(readFileFunc, handle, uintptr(fileID), uintptr(offset), buffer)
+	return retCode, err
+}
+
+func (b *nativeBackend) ReadFileTyped(handle DongleHandle, fileType int, fileID uint16, offset int, buffer []byte) (uint32, error) {
+	readFileFunc, err := b.proc(FUNC_READFILE)
+	if err != nil {
+		return DONGLE_UNKNOWN_ERROR, err
+	}
+	return readFileTyped(readFileFunc, handle, fileType, fileID, offset, buffer)
+}
+
+func (b *nativeBackend) ReadData(handle DongleHandle, offset int, buffer []byte) (uint32, error) {
+	readDataFunc, err := b.proc(FUNC_READDATA)
+	if err != nil {
+		return DONGLE_UNKNOWN_ERROR, err
+	}
+	return readData(readDataFunc, handle, offset, buffer)
+}
+
+func (b *nativeBackend) WriteData(handle DongleHandle, offset int, data []byte) (uint32, error) {
+	writeDataFunc, err := b.proc(FUNC_WRITEDATA)
+	if err != nil {
+		return DONGLE_UNKNOWN_ERROR, err
+	}
+	return writeData(writeDataFunc, handle, offset, data)
+}
+
+func (b *nativeBackend) VerifyPIN(handle DongleHandle, flags int, pin string) (int, uint32, error) {
+	verifyPINFunc, err := b.proc(FUNC_VERIFYPIN)
+	if err != nil {
+		return 0, DONGLE_UNKNOWN_ERROR, err
+	}
+	retCode, remain, err := verifyPIN(verifyPINFunc, handle, flags, pin)
+	return remain, retCode, err
+}
+
+func (b *nativeBackend) Seed(handle DongleHandle, seedData []byte) ([]byte, uint32, error) {
+	seedFunc, err := b.proc(FUNC_SEED)
+	if err != nil {
+		return nil, DONGLE_UNKNOWN_ERROR, err
+	}
+	return seed(seedFunc, handle, seedData)
+}
+
+func (b *nativeBackend) GetDeadline(handle DongleHandle) (uint32, uint32, error) {
+	getDeadlineFunc, err := b.proc(FUNC_GETDEADLINE)
+	if err != nil {
+		return 0, DONGLE_UNKNOWN_ERROR, err
+	}
+	return getDeadline(getDeadlineFunc, handle)
+}
+
+func (b *nativeBackend) GetUTCTime(handle DongleHandle) (uint32, uint32, error) {
+	getUTCTimeFunc, err := b.proc(FUNC_GETUTCTIME)
+	if err != nil {
+		return 0, DONGLE_UNKNOWN_ERROR, err
+	}
+	return getUTCTime(getUTCTimeFunc, handle)
+}
+
+func (b *nativeBackend) GenRandom(handle DongleHandle, length int) ([]byte, uint32, error) {
+	genRandomFunc, err := b.proc(FUNC_GENRANDOM)
+	if err != nil {
+		return nil, DONGLE_UNKNOWN_ERROR, err
+	}
+	return genRandom(genRandomFunc, handle, length)
+}
+
+func (b *nativeBackend) EccSign(handle DongleHandle, fileID uint16, hash []byte) ([]byte, uint32, error) {
+	eccSignFunc, err := b.proc(FUNC_ECCSIGN)
+	if err != nil {
+		return nil, DONGLE_UNKNOWN_ERROR, err
+	}
+	return eccSign(eccSignFunc, handle, fileID, hash)
+}
+
+// errorCode 从返回码和错误中取出用于统计的返回码
+func errorCode(retCode uint32, err error) uint32 {
+	if err != nil && retCode == DONGLE_SUCCESS {
+		return DONGLE_UNKNOWN_ERROR
+	}
+	return retCode
+}
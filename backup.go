@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ============ 备份与恢复 ============
+//
+// 归档为 JSON 格式，外层记录内容的 SHA-256 校验和，指定密钥时附加
+// HMAC-SHA256 签名。无法读取的文件和区域只记录属性，恢复时跳过。
+
+// BACKUP_FORMAT_VERSION 归档格式版本
+const BACKUP_FORMAT_VERSION = 1
+
+// backupRegion 数据区或共享内存中的一段
+type backupRegion struct {
+	Offset   int    `json:"offset"`         // 偏移量
+	Size     int    `json:"size"`           // 长度
+	Readable bool   `json:"readable"`       // 是否读取成功
+	Data     []byte `json:"data,omitempty"` // 内容
+}
+
+// backupFile 数据文件
+type backupFile struct {
+	ID       uint16       `json:"id"`                // 文件ID
+	Attr     DataFileAttr `json:"attr"`              // 文件属性
+	Readable bool         `json:"readable"`          // 是否读取成功
+	Content  []byte       `json:"content,omitempty"` // 内容
+}
+
+// backupArchive 归档内容
+type backupArchive struct {
+	Version     int            `json:"version"`      // 格式版本
+	Created     time.Time      `json:"created"`      // 创建时间
+	HID         string         `json:"hid"`          // 硬件ID
+	Info        DongleInfo     `json:"info"`         // 设备信息
+	Deadline    uint32         `json:"deadline"`     // 期限
+	Files       []backupFile   `json:"files"`        // 数据文件
+	DataZone    []backupRegion `json:"data_zone"`    // 数据区
+	ShareMemory []backupRegion `json:"share_memory"` // 共享内存
+}
+
+// sealedEnvelope 带校验和的文件格式，内容以原始 JSON 保存以便校验
+type sealedEnvelope struct {
+	Content json.RawMessage `json:"content"`        // 内容
+	SHA256  string          `json:"sha256"`         // 内容校验和
+	HMAC    string          `json:"hmac,omitempty"` // 内容签名
+}
+
+// sealEnvelope 序列化内容并计算校验和，指定密钥时附加签名
+func sealEnvelope(v interface{}, key []byte) ([]byte, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	env := sealedEnvelope{Content: body, SHA256: hex.EncodeToString(sum[:])}
+	if len(key) > 0 {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(body)
+		env.HMAC = hex.EncodeToString(mac.Sum(nil))
+	}
+	return json.MarshalIndent(env, "", "  ")
+}
+
+// openEnvelope 校验校验和与签名并解析内容，返回内容的 SHA-256
+func openEnvelope(data []byte, key []byte, v interface{}) (string, error) {
+	var env sealedEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return "", fmt.Errorf("解析文件失败: %v", err)
+	}
+
+	// MarshalIndent 会缩进原始 JSON，校验前先压缩
+	body, err := compactJSON(env.Content)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(body)
+	if hex.EncodeToString(sum[:]) != env.SHA256 {
+		return "", fmt.Errorf("校验和不匹配，文件可能已损坏")
+	}
+
+	switch {
+	case len(key) > 0 && env.HMAC == "":
+		return "", fmt.Errorf("文件未签名")
+	case len(key) > 0:
+		mac := hmac.New(sha256.New, key)
+		mac.Write(body)
+		expected, _ := hex.DecodeString(env.HMAC)
+		if !hmac.Equal(mac.Sum(nil), expected) {
+			return "", fmt.Errorf("签名校验失败")
+		}
+	case env.HMAC != "":
+		logger.Warn("文件已签名，但未指定 -key，仅校验了校验和")
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return "", fmt.Errorf("解析内容失败: %v", err)
+	}
+	return env.SHA256, nil
+}
+
+// sealArchive 序列化归档并计算校验和与签名
+func sealArchive(archive *backupArchive, key []byte) ([]byte, error) {
+	return sealEnvelope(archive, key)
+}
+
+// openArchive 校验并解析归档
+func openArchive(data []byte, key []byte) (*backupArchive, error) {
+	archive := &backupArchive{}
+	if _, err := openEnvelope(data, key, archive); err != nil {
+		return nil, fmt.Errorf("归档无效: %v", err)
+	}
+	if archive.Version != BACKUP_FORMAT_VERSION {
+		return nil, fmt.Errorf("不支持的归档版本: %d", archive.Version)
+	}
+	return archive, nil
+}
+
+// compactJSON 去除 JSON 中的空白
+func compactJSON(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// loadKey 读取签名密钥文件
+func loadKey(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("密钥文件 %s 为空", path)
+	}
+	return key, nil
+}
+
+// backupDevice 读取加密锁中所有可读取的内容
+func backupDevice(c *deviceConn) (*backupArchive, error) {
+	archive := &backupArchive{
+		Version: BACKUP_FORMAT_VERSION,
+		Created: time.Now().UTC(),
+		HID:     c.info.HID(),
+		Info:    c.info,
+	}
+
+	var err error
+	if archive.Deadline, err = c.deadline(); err != nil {
+		return nil, fmt.Errorf("读取期限失败: %v", err)
+	}
+
+	// 数据文件
+	list, err := c.listFiles()
+	if err != nil {
+		return nil, fmt.Errorf("列举文件失败: %v", err)
+	}
+	for _, item := range list {
+		f := backupFile{ID: item.MFileID, Attr: item.MAttr}
+		if item.MAttr.MSize > 0 {
+			buffer := make([]byte, item.MAttr.MSize)
+			if err := c.readDataFile(item.MFileID, buffer); err == nil {
+				f.Readable = true
+				f.Content = buffer
+			} else if isCallAborted(err) {
+				return nil, err
+			} else {
+				logger.Warn("文件不可读取，跳过内容", "file_id", fmt.Sprintf("0x%04X", item.MFileID), "err", err)
+			}
+		}
+		archive.Files = append(archive.Files, f)
+	}
+
+	// 数据区，按权限分段读取
+	zone, err := c.openDataZone()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range [][2]int{{0, DATA_ZONE_USER_SIZE}, {DATA_ZONE_USER_SIZE, DATA_ZONE_SIZE - DATA_ZONE_USER_SIZE}} {
+		region := backupRegion{Offset: r[0], Size: r[1]}
+		buffer := make([]byte, r[1])
+		if _, err := zone.ReadAt(buffer, int64(r[0])); err == nil {
+			region.Readable = true
+			region.Data = buffer
+		} else if isCallAborted(err) {
+			return nil, err
+		} else {
+			logger.Warn("数据区不可读取，跳过", "offset", r[0], "size", r[1], "err", err)
+		}
+		archive.DataZone = append(archive.DataZone, region)
+	}
+
+	// 共享内存
+	mem, err := c.openShareMemory()
+	if err != nil {
+		return nil, err
+	}
+	region := backupRegion{Offset: 0, Size: SHARE_MEMORY_SIZE}
+	buffer := make([]byte, SHARE_MEMORY_SIZE)
+	if _, err := mem.ReadAt(buffer, 0); err == nil {
+		region.Readable = true
+		region.Data = buffer
+	} else if isCallAborted(err) {
+		return nil, err
+	}
+	archive.ShareMemory = append(archive.ShareMemory, region)
+
+	return archive, nil
+}
+
+// restoreProfile 将归档转换为配置，复用 apply 的对比与执行逻辑
+func restoreProfile(archive *backupArchive, adminPIN string) *provisionProfile {
+	userID := archive.Info.MUserID
+	profile := &provisionProfile{
+		Name:          "restore:" + archive.HID,
+		AdminPIN:      adminPIN,
+		AdminTryCount: 15,
+		UserPIN:       DEFAULT_USER_PIN,
+		UserTryCount:  15,
+		UserID:        &userID,
+		Deadline:      formatDeadline(archive.Deadline),
+	}
+	for _, f := range archive.Files {
+		pf := profileFile{
+			ID:        f.ID,
+			Size:      int(f.Attr.MSize),
+			ReadPriv:  int(f.Attr.MReadPriv),
+			WritePriv: int(f.Attr.MWritePriv),
+		}
+		if f.Readable {
+			pf.Content = hex.EncodeToString(f.Content)
+		}
+		profile.Files = append(profile.Files, pf)
+	}
+	return profile
+}
+
+// checkRestoreTarget 检查目标锁是否可以恢复该归档
+func checkRestoreTarget(c *deviceConn, archive *backupArchive, force bool) error {
+	if c.info.MIsMother != archive.Info.MIsMother {
+		return fmt.Errorf("母锁/子锁类型不一致 (归档: %d, 目标: %d)", archive.Info.MIsMother, c.info.MIsMother)
+	}
+	if c.info.MPID != archive.Info.MPID {
+		if !force {
+			return fmt.Errorf("产品ID不一致 (归档: %08X, 目标: %08X)，目标锁未使用相同种子初始化，可用 -force 忽略", archive.Info.MPID, c.info.MPID)
+		}
+		logger.Warn("产品ID不一致", "archive_pid", fmt.Sprintf("%08X", archive.Info.MPID), "target_pid", fmt.Sprintf("%08X", c.info.MPID))
+	}
+
+	list, err := c.listFiles()
+	if err != nil {
+		return fmt.Errorf("列举文件失败: %v", err)
+	}
+	if len(list) > 0 && !force {
+		return fmt.Errorf("目标锁不是空白锁 (已有 %d 个数据文件)，可用 -force 覆盖", len(list))
+	}
+	return nil
+}
+
+// ============ 命令行 ============
+
+// runBackupCommand 执行 backup 子命令
+func runBackupCommand(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	index := fs.Int("device", 0, "设备序号")
+	pin := fs.String("pin", DEFAULT_ADMIN_PIN, "开发商PIN")
+	keyPath := fs.String("key", "", "签名密钥文件，指定后使用 HMAC-SHA256 签名")
+	output := fs.String("o", "", "归档文件")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output == "" {
+		return fmt.Errorf("用法: backup [-device N] [-pin PIN] [-key 文件] -o 归档文件")
+	}
+	key, err := loadKey(*keyPath)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := commandContext()
+	defer stop()
+
+	conn, err := connectDevice(ctx, *index)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+
+	if err := conn.verifyPIN(FLAG_ADMINPIN, *pin); err != nil {
+		return fmt.Errorf("校验开发商PIN失败: %v", err)
+	}
+
+	archive, err := backupDevice(conn)
+	if err != nil {
+		return err
+	}
+	data, err := sealArchive(archive, key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*output, data, 0600); err != nil {
+		return err
+	}
+
+	skipped := 0
+	for _, f := range archive.Files {
+		if !f.Readable {
+			skipped++
+		}
+	}
+	fmt.Printf("已备份 HID %s 到 %s: %d 个文件 (%d 个不可读取)\n", archive.HID, *output, len(archive.Files), skipped)
+	return nil
+}
+
+// runRestoreCommand 执行 restore 子命令
+func runRestoreCommand(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	index := fs.Int("device", 0, "设备序号")
+	pin := fs.String("pin", DEFAULT_ADMIN_PIN, "目标锁开发商PIN")
+	keyPath := fs.String("key", "", "签名密钥文件，指定后校验 HMAC-SHA256 签名")
+	force := fs.Bool("force", false, "忽略产品ID不一致和非空白锁检查")
+	withShareMemory := fs.Bool("sharemem", false, "同时恢复共享内存")
+	autoApprove := fs.Bool("auto-approve", false, "不询问直接执行")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("用法: restore [-device N] [-pin PIN] [-key 文件] [-force] [-sharemem] [-auto-approve] 归档文件")
+	}
+	key, err := loadKey(*keyPath)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	archive, err := openArchive(data, key)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("归档: HID %s, 创建于 %s, %d 个文件\n", archive.HID, archive.Created.Format(time.RFC3339), len(archive.Files))
+
+	ctx, stop := commandContext()
+	defer stop()
+
+	conn, err := connectDevice(ctx, *index)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+
+	if err := conn.verifyPIN(FLAG_ADMINPIN, *pin); err != nil {
+		return fmt.Errorf("校验开发商PIN失败: %v", err)
+	}
+	if err := checkRestoreTarget(conn, archive, *force); err != nil {
+		return err
+	}
+
+	profile := restoreProfile(archive, *pin)
+	if err := profile.validate(); err != nil {
+		return err
+	}
+	plan, err := planProfile(conn, profile, nil, true)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\n恢复计划 (设备 %d, HID %s):\n", *index, conn.info.HID())
+	plan.show()
+	for _, f := range archive.Files {
+		if !f.Readable {
+			fmt.Printf("  跳过 文件 0x%04X 内容 (备份时不可读取)\n", f.ID)
+		}
+	}
+	for _, r := range archive.DataZone {
+		if r.Readable {
+			fmt.Printf("  ~   写入数据区 [%d, %d)\n", r.Offset, r.Offset+r.Size)
+		} else {
+			fmt.Printf("  跳过 数据区 [%d, %d) (备份时不可读取)\n", r.Offset, r.Offset+r.Size)
+		}
+	}
+	if *withShareMemory {
+		fmt.Println("  ~   写入共享内存")
+	}
+	if !*autoApprove && !confirm("\n是否执行恢复?") {
+		return fmt.Errorf("已取消")
+	}
+
+	for _, change := range plan.changes {
+		fmt.Printf("\n%s %s\n", change.action, change.summary)
+		if err := change.apply(); err != nil {
+			return fmt.Errorf("%s 失败: %v", change.summary, err)
+		}
+	}
+
+	zone, err := conn.openDataZone()
+	if err != nil {
+		return err
+	}
+	for _, r := range archive.DataZone {
+		if !r.Readable {
+			continue
+		}
+		if _, err := zone.WriteAt(r.Data, int64(r.Offset)); err != nil {
+			return fmt.Errorf("写入数据区 [%d, %d) 失败: %v", r.Offset, r.Offset+r.Size, err)
+		}
+	}
+
+	if *withShareMemory {
+		mem, err := conn.openShareMemory()
+		if err != nil {
+			return err
+		}
+		for _, r := range archive.ShareMemory {
+			if r.Readable {
+				if _, err := mem.WriteAt(r.Data, int64(r.Offset)); err != nil {
+					return fmt.Errorf("写入共享内存失败: %v", err)
+				}
+			}
+		}
+	}
+
+	fmt.Printf("\n已将 HID %s 的备份恢复到 HID %s\n", archive.HID, conn.info.HID())
+	return nil
+}
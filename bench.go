@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// ============ 性能测试 ============
+//
+// 各绑定函数每次调用都会重新执行 purego.RegisterFunc。bench 命令分两部分
+// 回答这个开销是否值得优化：
+//
+//   - 调用开销：测量 RegisterFunc 本身、预先注册后的调用，以及现有绑定函数
+//     (每次注册) 的调用。调用部分需要 -fake 指定不访问设备的桩库，测得的
+//     就是纯粹的 Go→C 开销；指定 -fake 时注册也使用桩库，不需要厂商库。
+//   - 设备吞吐：在真实设备上按不同缓冲区大小读取，给出每次调用耗时和吞吐，
+//     以及大块读取的推荐分块大小。
+//
+// 同样的测量在 bench_test.go 中有对应的 go test -bench 版本，使用编译出的
+// 桩库和模拟后端，不需要设备。主程序不链接 testing 包，计时循环在这里实现。
+
+// 性能测试默认值
+const (
+	BENCH_SIZES         = "16,32,64,128,256,512,1024,2048,4096,8192"
+	BENCH_BYTES         = 64 * 1024   // 每种缓冲区大小读取的总字节数
+	BENCH_FAKE_HANDLE   = 1           // 桩库使用的句柄，非零即可通过绑定函数的检查
+	BENCH_OPTIMAL_SLACK = 0.05        // 吞吐在最大值 5% 以内的最小分块视为最优
+	BENCH_TIME          = time.Second // 每项调用开销测试的目标运行时间
+	BENCH_MAX_N         = 1000000000  // 每项调用开销测试的最大调用次数
+)
+
+// benchReadDataFunc Dongle_ReadData 的函数原型
+type benchReadDataFunc func(handle DongleHandle, offset int32, data unsafe.Pointer, size int32) uint32
+
+// benchResult 一项调用开销测试的结果
+type benchResult struct {
+	n       int
+	elapsed time.Duration
+	bytes   uint64 // 累计分配的字节数
+	allocs  uint64 // 累计分配次数
+}
+
+// benchRun 调用 n 次 fn 并统计耗时和内存分配
+func benchRun(n int, fn func()) benchResult {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		fn()
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	return benchResult{n: n, elapsed: elapsed, bytes: after.TotalAlloc - before.TotalAlloc, allocs: after.Mallocs - before.Mallocs}
+}
+
+// benchLoop 与 testing.Benchmark 一样逐步增加调用次数，直到总耗时达到 target
+func benchLoop(target time.Duration, fn func()) benchResult {
+	n := 1
+	for {
+		r := benchRun(n, fn)
+		if r.elapsed >= target || n >= BENCH_MAX_N {
+			return r
+		}
+		// 按已测得的耗时估算所需次数并多留 20%，每轮最多增加 100 倍
+		next := n * 100
+		if r.elapsed > 0 {
+			next = min(int(int64(n)*int64(target)*6/5/int64(r.elapsed)), next)
+		}
+		n = min(max(next, n+1), BENCH_MAX_N)
+	}
+}
+
+// benchResultLine 格式化一项测试结果
+func benchResultLine(w *tabwriter.Writer, name string, r benchResult) {
+	n := uint64(r.n)
+	fmt.Fprintf(w, "%s\t%d\t%d ns/op\t%d B/op\t%d allocs/op\n", name, r.n, r.elapsed.Nanoseconds()/int64(r.n), r.bytes/n, r.allocs/n)
+}
+
+// runFFIBenchmarks 测量函数注册和调用开销
+//
+// fakePath 非空时注册和调用都使用桩库中的 Dongle_ReadData，不需要厂商库；
+// 否则只从 libPath 取得函数地址测量注册开销，不调用其中的函数。
+func runFFIBenchmarks(libPath, fakePath string) error {
+	path, what := libPath, "库"
+	if fakePath != "" {
+		path, what = fakePath, "桩库"
+	}
+	lib, err := loadLibrary(path)
+	if err != nil {
+		return fmt.Errorf("加载%s失败: %v", what, err)
+	}
+	defer purego.Dlclose(lib)
+	addr, err := purego.Dlsym(lib, FUNC_READDATA)
+	if err != nil {
+		return fmt.Errorf("%s中没有 %s: %v", what, FUNC_READDATA, err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "测试\t次数\t耗时\t内存\t分配")
+	benchResultLine(w, "RegisterFunc", benchLoop(BENCH_TIME, func() {
+		var fn benchReadDataFunc
+		purego.RegisterFunc(&fn, addr)
+	}))
+
+	if fakePath == "" {
+		w.Flush()
+		fmt.Println("未指定 -fake，跳过调用开销测试")
+		return nil
+	}
+
+	buffer := make([]byte, 16)
+	var registered benchReadDataFunc
+	purego.RegisterFunc(&registered, addr)
+	benchResultLine(w, "调用 (预先注册)", benchLoop(BENCH_TIME, func() {
+		registered(BENCH_FAKE_HANDLE, 0, unsafe.Pointer(&buffer[0]), int32(len(buffer)))
+	}))
+	benchResultLine(w, "调用 (每次注册)", benchLoop(BENCH_TIME, func() {
+		var fn benchReadDataFunc
+		purego.RegisterFunc(&fn, addr)
+		fn(BENCH_FAKE_HANDLE, 0, unsafe.Pointer(&buffer[0]), int32(len(buffer)))
+	}))
+	benchResultLine(w, "readData 绑定函数", benchLoop(BENCH_TIME, func() {
+		readData(addr, BENCH_FAKE_HANDLE, 0, buffer)
+	}))
+	return w.Flush()
+}
+
+// ============ 设备吞吐 ============
+
+// benchThroughput 一种缓冲区大小的读取结果
+type benchThroughput struct {
+	size    int
+	calls   int
+	elapsed time.Duration
+	retCode uint32 // 失败时的返回码
+	err     error
+}
+
+// bytesPerSecond 吞吐 (字节/秒)
+func (t benchThroughput) bytesPerSecond() float64 {
+	if t.elapsed <= 0 {
+		return 0
+	}
+	return float64(t.size*t.calls) / t.elapsed.Seconds()
+}
+
+// measureThroughput 以 size 为单位读取 total 字节，数据区按顺序循环读取，文件总是从偏移 0 读取
+func measureThroughput(ctx context.Context, backend Backend, handle DongleHandle, target string, fileID uint16, size, total int) benchThroughput {
+	t := benchThroughput{size: size}
+	buffer := make([]byte, size)
+	offset := 0
+	start := time.Now()
+	for read := 0; read < total; read += size {
+		if offset+size > DATA_ZONE_SIZE {
+			offset = 0
+		}
+		var ctxErr error
+		if target == "file" {
+			ctxErr = callNative(ctx, FUNC_READFILE, func() { t.retCode, t.err = backend.ReadFile(handle, fileID, 0, buffer) })
+		} else {
+			ctxErr = callNative(ctx, FUNC_READDATA, func() { t.retCode, t.err = backend.ReadData(handle, offset, buffer) })
+		}
+		if ctxErr != nil {
+			t.err = ctxErr
+		}
+		if t.err != nil {
+			break
+		}
+		t.calls++
+		offset += size
+	}
+	t.elapsed = time.Since(start)
+	return t
+}
+
+// runDeviceBenchmarks 按不同缓冲区大小测量读取吞吐，并输出推荐的分块大小
+func runDeviceBenchmarks(index int, target string, fileID uint16, sizes []int, total int) error {
+	required := FUNC_READDATA
+	if target == "file" {
+		required = FUNC_READFILE
+	}
+	backend, cleanup, err := openTestBackend(FUNC_ENUM, FUNC_OPEN, FUNC_CLOSE, required)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	ctx, stop := commandContext()
+	defer stop()
+
+	var keyList []DongleInfo
+	var retCode uint32
+	if ctxErr := callNative(ctx, FUNC_ENUM, func() { keyList, retCode, err = backend.Enum() }); ctxErr != nil {
+		return ctxErr
+	}
+	if err != nil {
+		return fmt.Errorf("枚举设备失败 (0x%08X): %v", retCode, err)
+	}
+	if index < 0 || index >= len(keyList) {
+		return fmt.Errorf("设备序号 %d 超出范围 (共 %d 个设备)", index, len(keyList))
+	}
+
+	var handle DongleHandle
+	if ctxErr := callNative(ctx, FUNC_OPEN, func() { handle, retCode, err = backend.Open(index) }); ctxErr != nil {
+		return ctxErr
+	}
+	if err != nil {
+		return fmt.Errorf("打开设备 %d 失败 (0x%08X): %v", index, retCode, err)
+	}
+	defer callNative(context.WithoutCancel(ctx), FUNC_CLOSE, func() { backend.Close(handle) })
+
+	var results []benchThroughput
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "缓冲区\t调用次数\t每次耗时\t吞吐\t")
+	for _, size := range sizes {
+		t := measureThroughput(ctx, backend, handle, target, fileID, size, total)
+		if isCallAborted(t.err) {
+			w.Flush()
+			return t.err
+		}
+		if t.err != nil && t.calls == 0 {
+			fmt.Fprintf(w, "%d\t-\t-\t-\t失败 0x%08X: %v\n", size, t.retCode, t.err)
+			continue
+		}
+		note := ""
+		if t.err != nil {
+			note = fmt.Sprintf("第 %d 次调用失败 0x%08X", t.calls+1, t.retCode)
+		}
+		fmt.Fprintf(w, "%d\t%d\t%v\t%.1f KiB/s\t%s\n", size, t.calls, (t.elapsed / time.Duration(t.calls)).Round(time.Microsecond), t.bytesPerSecond()/1024, note)
+		if t.err == nil {
+			results = append(results, t)
+		}
+	}
+	w.Flush()
+
+	if len(results) == 0 {
+		return fmt.Errorf("所有缓冲区大小都读取失败")
+	}
+	// 吞吐接近最大值时选择较小的分块，出错时重试的代价更小
+	var best float64
+	for _, t := range results {
+		best = max(best, t.bytesPerSecond())
+	}
+	for _, t := range results {
+		if t.bytesPerSecond() >= best*(1-BENCH_OPTIMAL_SLACK) {
+			fmt.Printf("\n推荐分块大小: %d 字节 (%.1f KiB/s，最大 %.1f KiB/s)\n", t.size, t.bytesPerSecond()/1024, best/1024)
+			break
+		}
+	}
+	return nil
+}
+
+// runBenchCommand 测量调用开销和设备读取吞吐
+func runBenchCommand(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	libPath := fs.String("lib", getLibraryPath(), "未指定 -fake 时测量注册开销使用的动态库")
+	fakePath := fs.String("fake", "", "不访问设备的桩库，实现 Dongle_ReadData，用于测量注册和调用开销，指定后不需要厂商库")
+	index := fs.Int("device", 0, "设备序号")
+	target := fs.String("target", "data", "吞吐测试读取的对象: data (数据区) 或 file (文件)")
+	fileID := fs.String("file-id", fmt.Sprintf("0x%04X", TEST_FILE_ID), "-target file 时读取的文件ID")
+	sizeSpec := fs.String("sizes", BENCH_SIZES, "缓冲区大小列表，支持 起-止:步长")
+	total := fs.Int("bytes", BENCH_BYTES, "每种缓冲区大小读取的总字节数")
+	only := fs.String("only", "", "只运行一部分: ffi 或 device")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *target != "data" && *target != "file" {
+		return fmt.Errorf("未知读取对象: %s (可选 data、file)", *target)
+	}
+	if *only != "" && *only != "ffi" && *only != "device" {
+		return fmt.Errorf("未知部分: %s (可选 ffi、device)", *only)
+	}
+	id, err := parseFileID(*fileID)
+	if err != nil {
+		return err
+	}
+	maxSize := int64(DATA_ZONE_SIZE)
+	if *target == "file" {
+		maxSize = DATA_FILE_MAX_SIZE
+	}
+	sizes, err := parseSweep(strings.Fields(*sizeSpec), 1, maxSize)
+	if err != nil {
+		return err
+	}
+	if *total <= 0 {
+		return fmt.Errorf("-bytes 必须大于 0")
+	}
+
+	if *only != "device" {
+		fmt.Println("=== 调用开销 ===")
+		if err := runFFIBenchmarks(*libPath, *fakePath); err != nil {
+			return err
+		}
+	}
+	if *only != "ffi" {
+		fmt.Printf("\n=== 设备吞吐 (%s, 每种大小 %d 字节) ===\n", *target, *total)
+		if err := runDeviceBenchmarks(*index, *target, id, sizes, *total); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// benchStubSource 只实现 Dongle_ReadData 的桩库，不访问设备
+const benchStubSource = `
+unsigned int Dongle_ReadData(void *handle, int offset, unsigned char *data, int size) {
+	return 0;
+}
+`
+
+// loadBenchStub 用系统的 C 编译器编译桩库并返回 Dongle_ReadData 的地址
+//
+// 没有 C 编译器时跳过，测得的是与 bench -fake 相同的纯 Go→C 开销。
+func loadBenchStub(b *testing.B) uintptr {
+	b.Helper()
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		b.Skip("没有 C 编译器，跳过需要桩库的测试")
+	}
+	dir := b.TempDir()
+	src := filepath.Join(dir, "stub.c")
+	lib := filepath.Join(dir, "libstub.so")
+	if err := os.WriteFile(src, []byte(benchStubSource), 0644); err != nil {
+		b.Fatal(err)
+	}
+	if out, err := exec.Command(cc, "-shared", "-fPIC", "-o", lib, src).CombinedOutput(); err != nil {
+		b.Skipf("编译桩库失败: %v\n%s", err, out)
+	}
+
+	handle, err := loadLibrary(lib)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { purego.Dlclose(handle) })
+	addr, err := purego.Dlsym(handle, FUNC_READDATA)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return addr
+}
+
+func BenchmarkRegisterFunc(b *testing.B) {
+	addr := loadBenchStub(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var fn benchReadDataFunc
+		purego.RegisterFunc(&fn, addr)
+	}
+}
+
+func BenchmarkCallRegistered(b *testing.B) {
+	addr := loadBenchStub(b)
+	buffer := make([]byte, 16)
+	var fn benchReadDataFunc
+	purego.RegisterFunc(&fn, addr)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fn(BENCH_FAKE_HANDLE, 0, unsafe.Pointer(&buffer[0]), int32(len(buffer)))
+	}
+}
+
+func BenchmarkCallRegisterEach(b *testing.B) {
+	addr := loadBenchStub(b)
+	buffer := make([]byte, 16)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var fn benchReadDataFunc
+		purego.RegisterFunc(&fn, addr)
+		fn(BENCH_FAKE_HANDLE, 0, unsafe.Pointer(&buffer[0]), int32(len(buffer)))
+	}
+}
+
+func BenchmarkReadDataBinding(b *testing.B) {
+	addr := loadBenchStub(b)
+	buffer := make([]byte, 16)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		readData(addr, BENCH_FAKE_HANDLE, 0, buffer)
+	}
+}
+
+// BenchmarkSessionReadData 经过会话读取模拟设备的数据区，测量会话排队和线程切换的开销
+func BenchmarkSessionReadData(b *testing.B) {
+	session, err := OpenSession(context.Background(), newSimBackend(1), 0, SessionOptions{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer session.Close()
+
+	for _, size := range []int{16, 256, 4096} {
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			buffer := make([]byte, size)
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := session.ReadData(context.Background(), 0, buffer); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestMeasureThroughputSim(t *testing.T) {
+	sim := newSimBackend(1)
+	handle, _, err := sim.Open(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Close(handle)
+
+	// 超过数据区大小时从偏移 0 重新读取
+	r := measureThroughput(context.Background(), sim, handle, "data", 0, 1024, DATA_ZONE_SIZE*2)
+	if r.err != nil || r.calls != DATA_ZONE_SIZE*2/1024 {
+		t.Errorf("数据区: %d 次调用, %v", r.calls, r.err)
+	}
+	r = measureThroughput(context.Background(), sim, handle, "file", TEST_FILE_ID, SOAK_READ_SIZE, SOAK_READ_SIZE*4)
+	if r.err != nil || r.calls != 4 || r.bytesPerSecond() <= 0 {
+		t.Errorf("文件: %d 次调用, %v", r.calls, r.err)
+	}
+	r = measureThroughput(context.Background(), sim, handle, "file", 0x7FFF, 16, 64)
+	if r.err == nil || r.calls != 0 || r.retCode == DONGLE_SUCCESS {
+		t.Errorf("不存在的文件: %d 次调用, 0x%08X, %v", r.calls, r.retCode, r.err)
+	}
+}
+
+func TestBenchLoop(t *testing.T) {
+	calls := 0
+	r := benchLoop(10*time.Millisecond, func() { calls++ })
+	if r.n <= 1 || r.elapsed < 10*time.Millisecond || calls < r.n {
+		t.Errorf("n = %d, 耗时 %v, 调用 %d 次", r.n, r.elapsed, calls)
+	}
+}
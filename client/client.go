@@ -0,0 +1,189 @@
+// Package client 是 rockey serve 授权守护进程的 Go 客户端
+//
+// 守护进程通过 Unix 套接字提供 HTTP/JSON 接口，本包只依赖标准库，
+// 可以直接嵌入需要检查加密锁的程序中。
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultSocket 守护进程默认的套接字路径
+const DefaultSocket = "/run/rockey/rockey.sock"
+
+// DeviceInfo 设备信息
+type DeviceInfo struct {
+	HID      string `json:"hid"`
+	PID      string `json:"pid"`
+	UserID   string `json:"user_id"`
+	Agent    uint32 `json:"agent"`
+	Version  uint16 `json:"version"`
+	Type     uint16 `json:"type"`
+	DevType  uint32 `json:"dev_type"`
+	IsMother bool   `json:"is_mother"`
+	BirthDay string `json:"birthday"`
+}
+
+// Error 守护进程返回的错误
+type Error struct {
+	Status  int    // HTTP 状态码
+	Message string // 错误描述
+	RetCode uint32 // 设备返回码，非设备错误时为 0
+}
+
+func (e *Error) Error() string {
+	if e.RetCode != 0 {
+		return fmt.Sprintf("%s (错误码: %08X)", e.Message, e.RetCode)
+	}
+	return e.Message
+}
+
+// Client 守护进程客户端，可并发使用
+type Client struct {
+	http *http.Client
+}
+
+// New 创建连接到指定套接字的客户端
+func New(socket string) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+		MaxIdleConns:    4,
+		IdleConnTimeout: 30 * time.Second,
+	}
+	return &Client{http: &http.Client{Transport: transport}}
+}
+
+// Devices 枚举守护进程可见的所有设备
+func (c *Client) Devices(ctx context.Context) ([]DeviceInfo, error) {
+	var resp struct {
+		Devices []DeviceInfo `json:"devices"`
+	}
+	if err := c.call(ctx, http.MethodGet, "/v1/devices", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Devices, nil
+}
+
+// Info 返回守护进程持有的设备信息
+func (c *Client) Info(ctx context.Context) (DeviceInfo, error) {
+	var info DeviceInfo
+	err := c.call(ctx, http.MethodGet, "/v1/info", nil, &info)
+	return info, err
+}
+
+// ReadFile 读取锁内文件
+func (c *Client) ReadFile(ctx context.Context, fileID uint16, offset, length int) ([]byte, error) {
+	return c.read(ctx, map[string]interface{}{"zone": "file", "file_id": fileID, "offset": offset, "length": length})
+}
+
+// ReadData 读取数据区
+func (c *Client) ReadData(ctx context.Context, offset, length int) ([]byte, error) {
+	return c.read(ctx, map[string]interface{}{"zone": "data", "offset": offset, "length": length})
+}
+
+func (c *Client) read(ctx context.Context, req map[string]interface{}) ([]byte, error) {
+	var resp struct {
+		Data string `json:"data"`
+	}
+	if err := c.call(ctx, http.MethodPost, "/v1/read", req, &resp); err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(resp.Data)
+}
+
+// Seed 种子码运算
+func (c *Client) Seed(ctx context.Context, data []byte) ([]byte, error) {
+	var resp struct {
+		Result string `json:"result"`
+	}
+	req := map[string]string{"data": hex.EncodeToString(data)}
+	if err := c.call(ctx, http.MethodPost, "/v1/seed", req, &resp); err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(resp.Result)
+}
+
+// Random 生成硬件随机数
+func (c *Client) Random(ctx context.Context, length int) ([]byte, error) {
+	var resp struct {
+		Data string `json:"data"`
+	}
+	req := map[string]int{"length": length}
+	if err := c.call(ctx, http.MethodPost, "/v1/random", req, &resp); err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(resp.Data)
+}
+
+// Sign 使用锁内 ECC 私钥对摘要签名，返回 r||s
+func (c *Client) Sign(ctx context.Context, fileID uint16, hash []byte) ([]byte, error) {
+	var resp struct {
+		Signature string `json:"signature"`
+	}
+	req := map[string]interface{}{"file_id": fileID, "hash": hex.EncodeToString(hash)}
+	if err := c.call(ctx, http.MethodPost, "/v1/sign", req, &resp); err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(resp.Signature)
+}
+
+// call 发送请求并解析响应
+func (c *Client) call(ctx context.Context, method, path string, req, resp interface{}) error {
+	// 主机名只用于构造 URL，实际连接由 DialContext 决定
+	return doJSON(ctx, c.http, method, "http://rockey"+path, req, resp)
+}
+
+// doJSON 发送 JSON 请求，非 2xx 响应转换为 *Error，resp 为 nil 时忽略响应体
+func doJSON(ctx context.Context, hc *http.Client, method, url string, req, resp interface{}) error {
+	var body bytes.Buffer
+	if req != nil {
+		if err := json.NewEncoder(&body).Encode(req); err != nil {
+			return err
+		}
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, url, &body)
+	if err != nil {
+		return err
+	}
+	if req != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+
+	httpResp, err := hc.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode < 200 || httpResp.StatusCode > 299 {
+		var e struct {
+			Error   string `json:"error"`
+			RetCode string `json:"ret_code"`
+		}
+		if err := json.NewDecoder(httpResp.Body).Decode(&e); err != nil {
+			return &Error{Status: httpResp.StatusCode, Message: httpResp.Status}
+		}
+		apiErr := &Error{Status: httpResp.StatusCode, Message: e.Error}
+		if e.RetCode != "" {
+			code, _ := strconv.ParseUint(e.RetCode, 16, 32)
+			apiErr.RetCode = uint32(code)
+		}
+		return apiErr
+	}
+	if resp == nil {
+		return nil
+	}
+	return json.NewDecoder(httpResp.Body).Decode(resp)
+}
@@ -0,0 +1,117 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Lease 浮动授权租约
+type Lease struct {
+	ID      string    `json:"id"`
+	Client  string    `json:"client"`
+	Granted time.Time `json:"granted"`
+	Expires time.Time `json:"expires"`
+}
+
+// LeaseClient 浮动授权服务客户端，使用双向 TLS 连接
+type LeaseClient struct {
+	base string
+	http *http.Client
+}
+
+// LoadTLSConfig 加载客户端证书和用于校验服务端的 CA
+func LoadTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("加载客户端证书失败: %v", err)
+	}
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("%s 中没有有效的 CA 证书", caFile)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: pool, MinVersion: tls.VersionTLS12}, nil
+}
+
+// NewLeaseClient 创建连接到 addr (host:port) 的客户端
+func NewLeaseClient(addr string, tlsConfig *tls.Config) *LeaseClient {
+	transport := &http.Transport{TLSClientConfig: tlsConfig, IdleConnTimeout: 90 * time.Second}
+	return &LeaseClient{base: "https://" + addr, http: &http.Client{Transport: transport, Timeout: 30 * time.Second}}
+}
+
+// Acquire 申请一个席位，没有空闲席位时返回 Status 为 409 的 *Error
+func (c *LeaseClient) Acquire(ctx context.Context) (Lease, error) {
+	var lease Lease
+	err := doJSON(ctx, c.http, http.MethodPost, c.base+"/v1/leases", struct{}{}, &lease)
+	return lease, err
+}
+
+// Renew 续约，租约已过期时返回 Status 为 404 的 *Error
+func (c *LeaseClient) Renew(ctx context.Context, id string) (Lease, error) {
+	var lease Lease
+	err := doJSON(ctx, c.http, http.MethodPost, c.base+"/v1/leases/"+url.PathEscape(id)+"/renew", struct{}{}, &lease)
+	return lease, err
+}
+
+// Release 归还租约
+func (c *LeaseClient) Release(ctx context.Context, id string) error {
+	return doJSON(ctx, c.http, http.MethodDelete, c.base+"/v1/leases/"+url.PathEscape(id), nil, nil)
+}
+
+// Status 返回席位上限和当前所有租约
+func (c *LeaseClient) Status(ctx context.Context) (int, []Lease, error) {
+	var resp struct {
+		Seats  int     `json:"seats"`
+		Leases []Lease `json:"leases"`
+	}
+	if err := doJSON(ctx, c.http, http.MethodGet, c.base+"/v1/leases", nil, &resp); err != nil {
+		return 0, nil, err
+	}
+	return resp.Seats, resp.Leases, nil
+}
+
+// KeepAlive 在租约有效期过去三分之一时续约，直到 ctx 结束
+//
+// 租约无法续约且已过期时，向返回的通道发送错误并停止；ctx 结束时关闭通道，
+// 调用方应随后调用 Release 归还席位。
+func (c *LeaseClient) KeepAlive(ctx context.Context, lease Lease) <-chan error {
+	lost := make(chan error, 1)
+	go func() {
+		defer close(lost)
+		for {
+			wait := time.Until(lease.Expires) / 3
+			if wait < time.Second {
+				wait = time.Second
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+
+			renewed, err := c.Renew(ctx, lease.ID)
+			if err == nil {
+				lease = renewed
+				continue
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			// 网络抖动时在租约到期前继续重试
+			if apiErr, ok := err.(*Error); (ok && apiErr.Status != http.StatusServiceUnavailable) || time.Now().After(lease.Expires) {
+				lost <- fmt.Errorf("租约 %s 已失效: %v", lease.ID, err)
+				return
+			}
+		}
+	}()
+	return lost
+}
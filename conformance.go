@@ -0,0 +1,721 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// ============ 一致性测试 ============
+//
+// 厂商每次发布新的 libRockeyARM.so 时，按固定脚本对真实加密锁执行一遍操作：
+// 枚举、打开、PIN、文件 创建/写/读/删除、随机数、摘要、签名、关闭，以及零
+// 长度缓冲区、越界偏移、无效句柄等边界情况，逐项断言返回码。结果按库版本
+// (文件校验和) 和架构保存，便于对比不同版本的行为。
+//
+// 所有调用都经过 rawDongle 直接进入库函数，不经过包装函数的参数检查，
+// 否则边界情况的返回码来自 Go 代码而不是库。每个边界情况按错误码的含义
+// 断言一个确定的返回码：长度错误为 DONGLE_INVALID_SIZE，偏移错误为
+// DONGLE_INVALID_OFFSET，文件不存在为 DONGLE_INVALID_FILEID，句柄无效为
+// DONGLE_INVALID_HANDLE。库返回其他错误码同样记为失败，以便发现版本差异。
+//
+// 会修改加密锁的步骤 (校验开发商PIN、创建/删除文件) 只在 -write 时执行；
+// 不执行错误PIN的测试，以免消耗重试次数。测试文件以 CONFORMANCE_MARKER
+// 开头，只删除带该标记的文件，文件ID已被其他文件占用时拒绝执行。
+
+// 一致性测试默认值
+const (
+	CONFORMANCE_FILE_ID   = 0x0F00 // 测试用数据文件ID
+	CONFORMANCE_FILE_SIZE = 256    // 测试用数据文件大小
+	CONFORMANCE_DIR       = "conformance-results"
+	CONFORMANCE_MARKER    = "ROCKEY-CONFORMANCE" // 测试文件开头的标记
+)
+
+// conformanceResult 一项测试的结果
+type conformanceResult struct {
+	Name     string   `json:"name"`             // 测试名
+	Result   Severity `json:"result"`           // 通过/失败/跳过
+	Expected string   `json:"expected"`         // 期望的返回码
+	RetCode  string   `json:"ret_code"`         // 实际返回码
+	Detail   string   `json:"detail,omitempty"` // 说明
+}
+
+// conformanceReport 一次测试的完整记录
+type conformanceReport struct {
+	Created       time.Time           `json:"created"`        // 测试时间
+	GOOS          string              `json:"goos"`           // 操作系统
+	GOARCH        string              `json:"goarch"`         // 架构
+	Library       string              `json:"library"`        // 库文件路径
+	LibrarySHA256 string              `json:"library_sha256"` // 库文件校验和
+	BuildID       string              `json:"build_id"`       // 库文件 GNU build-id
+	Device        DongleInfo          `json:"device"`         // 被测设备
+	Results       []conformanceResult `json:"results"`        // 各项结果
+}
+
+// ============ 原始调用 ============
+
+// rawDongle 直接调用厂商库函数的接口，不做任何 Go 侧的参数检查
+//
+// readData、genRandom 等包装函数会在调用前拒绝零句柄、零长度和越界参数，
+// 返回的是 Go 代码自己的返回码，不能反映库的行为，一致性测试因此只通过本
+// 接口调用。缓冲区按 C 的方式传入指针和长度，size 可以小于 len(buf)，
+// 以便在零长度的情况下仍传入有效指针。
+type rawDongle interface {
+	Has(funcName string) bool
+	Enum(list []DongleInfo, count *int32) uint32
+	Open(handle *DongleHandle, index int) uint32
+	Close(handle DongleHandle) uint32
+	ReadData(handle DongleHandle, offset int, buf []byte, size int) uint32
+	GenRandom(handle DongleHandle, size int, out []byte) uint32
+	Hash(handle DongleHandle, flag int, data []byte, size int, out []byte) uint32
+	VerifyPIN(handle DongleHandle, flags int, pin string, remain *int32) uint32
+	CreateFile(handle DongleHandle, fileType int, fileID uint16, attr *DataFileAttr) uint32
+	WriteFile(handle DongleHandle, fileType int, fileID uint16, offset int, data []byte, size int) uint32
+	ReadFile(handle DongleHandle, fileID uint16, offset int, buf []byte, size int) uint32
+	DeleteFile(handle DongleHandle, fileType int, fileID uint16) uint32
+	EccSign(handle DongleHandle, fileID uint16, hash []byte, size int, out []byte) uint32
+}
+
+// rawPointer 返回缓冲区首地址，空缓冲区为 NULL
+func rawPointer(buf []byte) unsafe.Pointer {
+	if len(buf) == 0 {
+		return nil
+	}
+	return unsafe.Pointer(&buf[0])
+}
+
+// conformanceFuncs 一致性测试用到的库函数
+var conformanceFuncs = []string{
+	FUNC_ENUM, FUNC_OPEN, FUNC_CLOSE, FUNC_READDATA, FUNC_GENRANDOM, FUNC_HASH, FUNC_VERIFYPIN,
+	FUNC_CREATEFILE, FUNC_WRITEFILE, FUNC_READFILE, FUNC_DELETEFILE, FUNC_ECCSIGN,
+}
+
+// nativeRawDongle 通过 purego 调用动态库的 rawDongle，函数原型与各包装函数一致
+type nativeRawDongle struct {
+	procs map[string]uintptr
+}
+
+// newNativeRawDongle 解析库中的函数地址，缺少的函数不调用
+func newNativeRawDongle(lib uintptr) *nativeRawDongle {
+	d := &nativeRawDongle{procs: make(map[string]uintptr)}
+	for _, name := range conformanceFuncs {
+		if addr, err := getProcAddress(lib, name); err == nil {
+			d.procs[name] = addr
+		}
+	}
+	return d
+}
+
+func (d *nativeRawDongle) Has(funcName string) bool {
+	_, ok := d.procs[funcName]
+	return ok
+}
+
+func (d *nativeRawDongle) Enum(list []DongleInfo, count *int32) uint32 {
+	var fn func(infoList unsafe.Pointer, count *int32) uint32
+	purego.RegisterFunc(&fn, d.procs[FUNC_ENUM])
+	var p unsafe.Pointer
+	if len(list) > 0 {
+		p = unsafe.Pointer(&list[0])
+	}
+	return fn(p, count)
+}
+
+func (d *nativeRawDongle) Open(handle *DongleHandle, index int) uint32 {
+	var fn func(handle *DongleHandle, index int) uint32
+	purego.RegisterFunc(&fn, d.procs[FUNC_OPEN])
+	return fn(handle, index)
+}
+
+func (d *nativeRawDongle) Close(handle DongleHandle) uint32 {
+	var fn func(handle DongleHandle) uint32
+	purego.RegisterFunc(&fn, d.procs[FUNC_CLOSE])
+	return fn(handle)
+}
+
+func (d *nativeRawDongle) ReadData(handle DongleHandle, offset int, buf []byte, size int) uint32 {
+	var fn func(handle DongleHandle, offset int32, data unsafe.Pointer, size int32) uint32
+	purego.RegisterFunc(&fn, d.procs[FUNC_READDATA])
+	return fn(handle, int32(offset), rawPointer(buf), int32(size))
+}
+
+func (d *nativeRawDongle) GenRandom(handle DongleHandle, size int, out []byte) uint32 {
+	var fn func(handle DongleHandle, length int32, random unsafe.Pointer) uint32
+	purego.RegisterFunc(&fn, d.procs[FUNC_GENRANDOM])
+	return fn(handle, int32(size), rawPointer(out))
+}
+
+func (d *nativeRawDongle) Hash(handle DongleHandle, flag int, data []byte, size int, out []byte) uint32 {
+	var fn func(handle DongleHandle, flag int32, data unsafe.Pointer, dataLen int32, hash unsafe.Pointer) uint32
+	purego.RegisterFunc(&fn, d.procs[FUNC_HASH])
+	return fn(handle, int32(flag), rawPointer(data), int32(size), rawPointer(out))
+}
+
+func (d *nativeRawDongle) VerifyPIN(handle DongleHandle, flags int, pin string, remain *int32) uint32 {
+	var fn func(handle DongleHandle, flags int32, pin string, remainCount *int32) uint32
+	purego.RegisterFunc(&fn, d.procs[FUNC_VERIFYPIN])
+	return fn(handle, int32(flags), pin, remain)
+}
+
+func (d *nativeRawDongle) CreateFile(handle DongleHandle, fileType int, fileID uint16, attr *DataFileAttr) uint32 {
+	var fn func(handle DongleHandle, fileType int32, fileID uint16, attr unsafe.Pointer) uint32
+	purego.RegisterFunc(&fn, d.procs[FUNC_CREATEFILE])
+	return fn(handle, int32(fileType), fileID, unsafe.Pointer(attr))
+}
+
+func (d *nativeRawDongle) WriteFile(handle DongleHandle, fileType int, fileID uint16, offset int, data []byte, size int) uint32 {
+	var fn func(handle DongleHandle, fileType int32, fileID uint16, offset uint16, data unsafe.Pointer, size int32) uint32
+	purego.RegisterFunc(&fn, d.procs[FUNC_WRITEFILE])
+	return fn(handle, int32(fileType), fileID, uint16(offset), rawPointer(data), int32(size))
+}
+
+func (d *nativeRawDongle) ReadFile(handle DongleHandle, fileID uint16, offset int, buf []byte, size int) uint32 {
+	var fn func(handle DongleHandle, fileID uintptr, offset uintptr, buffer unsafe.Pointer, size uintptr) uint32
+	purego.RegisterFunc(&fn, d.procs[FUNC_READFILE])
+	return fn(handle, uintptr(fileID), uintptr(offset), rawPointer(buf), uintptr(size))
+}
+
+func (d *nativeRawDongle) DeleteFile(handle DongleHandle, fileType int, fileID uint16) uint32 {
+	var fn func(handle DongleHandle, fileType int32, fileID uint16) uint32
+	purego.RegisterFunc(&fn, d.procs[FUNC_DELETEFILE])
+	return fn(handle, int32(fileType), fileID)
+}
+
+func (d *nativeRawDongle) EccSign(handle DongleHandle, fileID uint16, hash []byte, size int, out []byte) uint32 {
+	var fn func(handle DongleHandle, fileID uint16, hash unsafe.Pointer, hashLen int32, out unsafe.Pointer) uint32
+	purego.RegisterFunc(&fn, d.procs[FUNC_ECCSIGN])
+	return fn(handle, fileID, rawPointer(hash), int32(size), rawPointer(out))
+}
+
+// ============ 测试执行 ============
+
+// conformanceRun 一次测试的执行状态
+type conformanceRun struct {
+	ctx     context.Context
+	lib     rawDongle
+	handle  DongleHandle // 被测设备的句柄，关闭后为 0
+	pinOK   bool         // 已校验过用户或开发商PIN
+	results []conformanceResult
+	err     error // 调用超时或被取消，之后不再调用
+}
+
+// record 记录结果并输出一行
+func (r *conformanceRun) record(res conformanceResult) {
+	marks := map[Severity]string{CHECK_PASS: "✓", CHECK_FAIL: "✗", CHECK_SKIP: "-"}
+	line := fmt.Sprintf("%s %-24s 期望 %-8s 实际 %-8s", marks[res.Result], res.Name, res.Expected, res.RetCode)
+	if res.Detail != "" {
+		line += "  " + res.Detail
+	}
+	fmt.Println(line)
+	r.results = append(r.results, res)
+}
+
+// expect 断言返回码等于 want
+func (r *conformanceRun) expect(name string, want, got uint32, detail string) bool {
+	res := conformanceResult{Name: name, Result: CHECK_PASS, Expected: fmt.Sprintf("%08X", want), RetCode: fmt.Sprintf("%08X", got), Detail: detail}
+	if got != want {
+		res.Result = CHECK_FAIL
+		if res.Detail == "" {
+			res.Detail = getErrorDescription(got)
+		}
+	}
+	r.record(res)
+	return res.Result == CHECK_PASS
+}
+
+// fail 记录一项失败，例如返回码正确但数据不符
+func (r *conformanceRun) fail(name string, got uint32, detail string) {
+	r.record(conformanceResult{Name: name, Result: CHECK_FAIL, Expected: fmt.Sprintf("%08X", DONGLE_SUCCESS), RetCode: fmt.Sprintf("%08X", got), Detail: detail})
+}
+
+// skip 记录跳过的测试
+func (r *conformanceRun) skip(name, reason string) {
+	r.record(conformanceResult{Name: name, Result: CHECK_SKIP, Expected: "-", RetCode: "-", Detail: reason})
+}
+
+// call 执行一次原生调用，超时或被取消时记录错误并返回 false，之后的测试不再执行
+func (r *conformanceRun) call(name string, fn func()) bool {
+	if r.err != nil {
+		return false
+	}
+	if err := callNative(r.ctx, name, fn); err != nil {
+		r.err = err
+		return false
+	}
+	return true
+}
+
+// has 检查库中是否有该函数，缺少时记录跳过
+func (r *conformanceRun) has(name, funcName string) bool {
+	if !r.lib.Has(funcName) {
+		r.skip(name, fmt.Sprintf("库中没有 %s", funcName))
+		return false
+	}
+	return true
+}
+
+// ============ 测试脚本 ============
+
+// conformanceOptions 测试选项
+type conformanceOptions struct {
+	write    bool   // 执行会修改加密锁的步骤
+	adminPIN string // 开发商PIN
+	userPIN  string // 用户PIN，签名前校验
+	fileID   uint16 // 测试用数据文件ID
+	eccFile  int    // ECC 私钥文件ID，小于 0 时不测试签名
+}
+
+// testOpen 打开边界情况
+func (r *conformanceRun) testOpen(count int) {
+	var handle DongleHandle
+	var retCode uint32
+	if !r.call(FUNC_OPEN, func() { retCode = r.lib.Open(&handle, count) }) {
+		return
+	}
+	r.expect("open-out-of-range", DONGLE_NOT_FOUND, retCode, "")
+	if retCode == DONGLE_SUCCESS && r.lib.Has(FUNC_CLOSE) {
+		r.call(FUNC_CLOSE, func() { r.lib.Close(handle) })
+	}
+}
+
+// testInvalidHandle 零句柄
+func (r *conformanceRun) testInvalidHandle() {
+	buffer := make([]byte, 16)
+	var retCode uint32
+	if r.has("invalid-handle-read", FUNC_READDATA) {
+		if !r.call(FUNC_READDATA, func() { retCode = r.lib.ReadData(0, 0, buffer, len(buffer)) }) {
+			return
+		}
+		r.expect("invalid-handle-read", DONGLE_INVALID_HANDLE, retCode, "")
+	}
+	if r.has("invalid-handle-random", FUNC_GENRANDOM) {
+		if !r.call(FUNC_GENRANDOM, func() { retCode = r.lib.GenRandom(0, len(buffer), buffer) }) {
+			return
+		}
+		r.expect("invalid-handle-random", DONGLE_INVALID_HANDLE, retCode, "")
+	}
+}
+
+// testDataZone 数据区读取及越界，缓冲区总是足够大，越界只体现在偏移和长度参数上
+func (r *conformanceRun) testDataZone() {
+	if !r.has("data-read", FUNC_READDATA) {
+		return
+	}
+	buffer := make([]byte, 16)
+	var retCode uint32
+	if !r.call(FUNC_READDATA, func() { retCode = r.lib.ReadData(r.handle, 0, buffer, len(buffer)) }) {
+		return
+	}
+	r.expect("data-read", DONGLE_SUCCESS, retCode, "")
+
+	cases := []struct {
+		name   string
+		offset int
+		size   int
+		want   uint32
+	}{
+		{"data-read-zero-length", 0, 0, DONGLE_INVALID_SIZE},
+		{"data-read-negative-offset", -1, 16, DONGLE_INVALID_OFFSET},
+		{"data-read-offset-oob", DATA_ZONE_SIZE, 16, DONGLE_INVALID_OFFSET},
+		{"data-read-span-oob", DATA_ZONE_SIZE - 8, 16, DONGLE_INVALID_SIZE},
+	}
+	for _, tc := range cases {
+		if !r.call(FUNC_READDATA, func() { retCode = r.lib.ReadData(r.handle, tc.offset, buffer, tc.size) }) {
+			return
+		}
+		r.expect(tc.name, tc.want, retCode, "")
+	}
+}
+
+// testRandom 随机数
+func (r *conformanceRun) testRandom() {
+	if !r.has("random", FUNC_GENRANDOM) {
+		return
+	}
+	out := make([]byte, RANDOM_MAX_LEN+1)
+	var retCode uint32
+	if !r.call(FUNC_GENRANDOM, func() { retCode = r.lib.GenRandom(r.handle, 16, out) }) {
+		return
+	}
+	if r.expect("random", DONGLE_SUCCESS, retCode, "") && bytes.Equal(out[:16], make([]byte, 16)) {
+		r.fail("random-content", retCode, fmt.Sprintf("随机数异常: %X", out[:16]))
+	}
+	if !r.call(FUNC_GENRANDOM, func() { retCode = r.lib.GenRandom(r.handle, 0, out) }) {
+		return
+	}
+	r.expect("random-zero-length", DONGLE_INVALID_SIZE, retCode, "")
+	if !r.call(FUNC_GENRANDOM, func() { retCode = r.lib.GenRandom(r.handle, RANDOM_MAX_LEN+1, out) }) {
+		return
+	}
+	r.expect("random-too-long", DONGLE_INVALID_SIZE, retCode, "")
+}
+
+// testHash 锁内摘要，与标准库的结果对比
+func (r *conformanceRun) testHash() {
+	if !r.has("hash", FUNC_HASH) {
+		return
+	}
+	input := []byte("abc")
+	md5Sum := md5.Sum(input)
+	sha1Sum := sha1.Sum(input)
+	cases := []struct {
+		name string
+		flag int
+		want []byte // 为空时只检查长度
+	}{
+		{"hash-md5", FLAG_HASH_MD5, md5Sum[:]},
+		{"hash-sha1", FLAG_HASH_SHA1, sha1Sum[:]},
+		{"hash-sm3", FLAG_HASH_SM3, nil},
+	}
+	var retCode uint32
+	for _, tc := range cases {
+		// 输出缓冲区按最长的摘要分配，摘要长度只能由已知算法推断
+		out := make([]byte, 64)
+		if !r.call(FUNC_HASH, func() { retCode = r.lib.Hash(r.handle, tc.flag, input, len(input), out) }) {
+			return
+		}
+		if !r.expect(tc.name, DONGLE_SUCCESS, retCode, "") {
+			continue
+		}
+		digest := out[:hashSizes[tc.flag]]
+		if (tc.want != nil && !bytes.Equal(digest, tc.want)) || bytes.Equal(digest, make([]byte, len(digest))) {
+			r.fail(tc.name+"-digest", retCode, fmt.Sprintf("摘要 %X 与期望 %X 不符", digest, tc.want))
+		}
+	}
+	out := make([]byte, 64)
+	if !r.call(FUNC_HASH, func() { retCode = r.lib.Hash(r.handle, FLAG_HASH_SHA1, input, 0, out) }) {
+		return
+	}
+	r.expect("hash-zero-length", DONGLE_INVALID_SIZE, retCode, "")
+}
+
+// testFiles 数据文件 创建/写/读/删除，需要开发商权限
+func (r *conformanceRun) testFiles(opts conformanceOptions) {
+	names := []string{"pin-admin", "file-create", "file-write", "file-read", "file-write-zero-length",
+		"file-write-offset-oob", "file-read-zero-length", "file-read-past-end", "file-delete", "file-read-deleted"}
+	if !opts.write {
+		for _, name := range names {
+			r.skip(name, "需要 -write")
+		}
+		return
+	}
+	if !r.has("pin-admin", FUNC_VERIFYPIN) {
+		return
+	}
+	var retCode uint32
+	var remain int32
+	if !r.call(FUNC_VERIFYPIN, func() { retCode = r.lib.VerifyPIN(r.handle, FLAG_ADMINPIN, opts.adminPIN, &remain) }) {
+		return
+	}
+	if !r.expect("pin-admin", DONGLE_SUCCESS, retCode, "") {
+		for _, name := range names[1:] {
+			r.skip(name, "开发商PIN校验失败")
+		}
+		return
+	}
+	r.pinOK = true
+	if !r.has("file-create", FUNC_CREATEFILE) || !r.has("file-write", FUNC_WRITEFILE) ||
+		!r.has("file-read", FUNC_READFILE) || !r.has("file-delete", FUNC_DELETEFILE) {
+		return
+	}
+
+	// 上次测试中断时留下的测试文件带有标记，可以删除；其他文件属于用户，不能动
+	header := make([]byte, len(CONFORMANCE_MARKER))
+	if !r.call(FUNC_READFILE, func() { retCode = r.lib.ReadFile(r.handle, opts.fileID, 0, header, len(header)) }) {
+		return
+	}
+	switch {
+	case retCode == DONGLE_INVALID_FILEID:
+	case retCode == DONGLE_SUCCESS && string(header) == CONFORMANCE_MARKER:
+		if !r.call(FUNC_DELETEFILE, func() { retCode = r.lib.DeleteFile(r.handle, FILE_DATA, opts.fileID) }) {
+			return
+		}
+		if retCode != DONGLE_SUCCESS {
+			r.err = fmt.Errorf("删除上次遗留的测试文件 0x%04X 失败: %s", opts.fileID, getErrorDescription(retCode))
+			return
+		}
+	default:
+		r.err = fmt.Errorf("文件 0x%04X 已存在且不是一致性测试创建的 (读取返回 0x%08X)，请用 -file-id 指定未使用的文件ID", opts.fileID, retCode)
+		return
+	}
+
+	attr := DataFileAttr{MSize: CONFORMANCE_FILE_SIZE, MReadPriv: FILE_PRIV_ANONYMOUS, MWritePriv: FILE_PRIV_ADMIN}
+	if !r.call(FUNC_CREATEFILE, func() { retCode = r.lib.CreateFile(r.handle, FILE_DATA, opts.fileID, &attr) }) {
+		return
+	}
+	if !r.expect("file-create", DONGLE_SUCCESS, retCode, fmt.Sprintf("文件ID 0x%04X", opts.fileID)) {
+		return
+	}
+
+	content := make([]byte, 64)
+	rand.Read(content)
+	copy(content, CONFORMANCE_MARKER)
+	if !r.call(FUNC_WRITEFILE, func() { retCode = r.lib.WriteFile(r.handle, FILE_DATA, opts.fileID, 0, content, len(content)) }) {
+		return
+	}
+	r.expect("file-write", DONGLE_SUCCESS, retCode, "")
+
+	buffer := make([]byte, len(content))
+	if !r.call(FUNC_READFILE, func() { retCode = r.lib.ReadFile(r.handle, opts.fileID, 0, buffer, len(buffer)) }) {
+		return
+	}
+	if r.expect("file-read", DONGLE_SUCCESS, retCode, "") && !bytes.Equal(buffer, content) {
+		r.fail("file-read-content", retCode, "读回的内容与写入的不同")
+	}
+
+	if !r.call(FUNC_WRITEFILE, func() { retCode = r.lib.WriteFile(r.handle, FILE_DATA, opts.fileID, 0, content, 0) }) {
+		return
+	}
+	r.expect("file-write-zero-length", DONGLE_INVALID_SIZE, retCode, "")
+	if !r.call(FUNC_WRITEFILE, func() {
+		retCode = r.lib.WriteFile(r.handle, FILE_DATA, opts.fileID, CONFORMANCE_FILE_SIZE, content, len(content))
+	}) {
+		return
+	}
+	r.expect("file-write-offset-oob", DONGLE_INVALID_OFFSET, retCode, "")
+	if !r.call(FUNC_READFILE, func() { retCode = r.lib.ReadFile(r.handle, opts.fileID, 0, buffer, 0) }) {
+		return
+	}
+	r.expect("file-read-zero-length", DONGLE_INVALID_SIZE, retCode, "")
+	if !r.call(FUNC_READFILE, func() {
+		retCode = r.lib.ReadFile(r.handle, opts.fileID, CONFORMANCE_FILE_SIZE, buffer, 16)
+	}) {
+		return
+	}
+	r.expect("file-read-past-end", DONGLE_INVALID_OFFSET, retCode, "")
+
+	if !r.call(FUNC_DELETEFILE, func() { retCode = r.lib.DeleteFile(r.handle, FILE_DATA, opts.fileID) }) {
+		return
+	}
+	r.expect("file-delete", DONGLE_SUCCESS, retCode, "")
+	if !r.call(FUNC_READFILE, func() { retCode = r.lib.ReadFile(r.handle, opts.fileID, 0, buffer, 16) }) {
+		return
+	}
+	r.expect("file-read-deleted", DONGLE_INVALID_FILEID, retCode, "")
+}
+
+// testSign ECC 签名
+//
+// 签名需要PIN权限，未校验PIN时库可能先返回访问被拒绝，长度的边界情况
+// 因此只在校验过PIN后执行。
+func (r *conformanceRun) testSign(opts conformanceOptions) {
+	if !r.has("ecc-sign", FUNC_ECCSIGN) {
+		return
+	}
+	var retCode uint32
+	if opts.userPIN != "" && r.has("pin-user", FUNC_VERIFYPIN) {
+		var remain int32
+		if !r.call(FUNC_VERIFYPIN, func() { retCode = r.lib.VerifyPIN(r.handle, FLAG_USERPIN, opts.userPIN, &remain) }) {
+			return
+		}
+		r.pinOK = r.expect("pin-user", DONGLE_SUCCESS, retCode, "") || r.pinOK
+	}
+	if !r.pinOK {
+		for _, name := range []string{"ecc-sign-zero-length", "ecc-sign-hash-too-long", "ecc-sign"} {
+			r.skip(name, "需要 -user-pin 或 -write")
+		}
+		return
+	}
+
+	hash := make([]byte, ECC_HASH_MAX_LEN+1)
+	signature := make([]byte, ECC_SIGNATURE_SIZE)
+	if !r.call(FUNC_ECCSIGN, func() { retCode = r.lib.EccSign(r.handle, 0, hash, 0, signature) }) {
+		return
+	}
+	r.expect("ecc-sign-zero-length", DONGLE_INVALID_SIZE, retCode, "")
+	if !r.call(FUNC_ECCSIGN, func() { retCode = r.lib.EccSign(r.handle, 0, hash, len(hash), signature) }) {
+		return
+	}
+	r.expect("ecc-sign-hash-too-long", DONGLE_INVALID_SIZE, retCode, "")
+
+	if opts.eccFile < 0 {
+		r.skip("ecc-sign", "需要 -ecc-file")
+		return
+	}
+	digest := sha1.Sum([]byte("abc"))
+	if !r.call(FUNC_ECCSIGN, func() { retCode = r.lib.EccSign(r.handle, uint16(opts.eccFile), digest[:], len(digest), signature) }) {
+		return
+	}
+	if r.expect("ecc-sign", DONGLE_SUCCESS, retCode, fmt.Sprintf("私钥文件 0x%04X", opts.eccFile)) && bytes.Equal(signature, make([]byte, ECC_SIGNATURE_SIZE)) {
+		r.fail("ecc-sign-content", retCode, "签名为全 0")
+	}
+}
+
+// testClose 关闭、重复关闭及零句柄
+func (r *conformanceRun) testClose() {
+	if !r.has("close", FUNC_CLOSE) {
+		return
+	}
+	handle := r.handle
+	var retCode uint32
+	if !r.call(FUNC_CLOSE, func() { retCode = r.lib.Close(handle) }) {
+		return
+	}
+	if r.expect("close", DONGLE_SUCCESS, retCode, "") {
+		r.handle = 0
+	}
+	if !r.call(FUNC_CLOSE, func() { retCode = r.lib.Close(handle) }) {
+		return
+	}
+	r.expect("close-twice", DONGLE_INVALID_HANDLE, retCode, "")
+	if !r.call(FUNC_CLOSE, func() { retCode = r.lib.Close(0) }) {
+		return
+	}
+	r.expect("close-zero-handle", DONGLE_INVALID_HANDLE, retCode, "")
+}
+
+// runConformance 在 lib 上按脚本执行所有测试
+func runConformance(ctx context.Context, lib rawDongle, index int, opts conformanceOptions) (*conformanceReport, error) {
+	r := &conformanceRun{ctx: ctx, lib: lib}
+	for _, name := range []string{FUNC_ENUM, FUNC_OPEN} {
+		if !lib.Has(name) {
+			return nil, fmt.Errorf("库中没有 %s", name)
+		}
+	}
+
+	var count int32
+	var retCode uint32
+	if !r.call(FUNC_ENUM, func() { retCode = lib.Enum(nil, &count) }) {
+		return nil, r.err
+	}
+	if !r.expect("enumerate", DONGLE_SUCCESS, retCode, fmt.Sprintf("%d 个设备", count)) || count <= 0 {
+		return nil, fmt.Errorf("未找到设备")
+	}
+	keyList := make([]DongleInfo, count)
+	if !r.call(FUNC_ENUM, func() { retCode = lib.Enum(keyList, &count) }) {
+		return nil, r.err
+	}
+	if retCode != DONGLE_SUCCESS {
+		return nil, fmt.Errorf("枚举设备失败: %s", getErrorDescription(retCode))
+	}
+	if index < 0 || index >= int(count) || int(count) > len(keyList) {
+		return nil, fmt.Errorf("设备序号 %d 超出范围 (共 %d 个设备)", index, count)
+	}
+
+	if !r.call(FUNC_OPEN, func() { retCode = lib.Open(&r.handle, index) }) {
+		return nil, r.err
+	}
+	if !r.expect("open", DONGLE_SUCCESS, retCode, fmt.Sprintf("设备 %d, HID %s", index, keyList[index].HID())) {
+		return nil, fmt.Errorf("打开设备失败: %s", getErrorDescription(retCode))
+	}
+	defer func() {
+		// 中途退出时关闭设备，卡住的调用仍未返回时不再调用
+		if r.handle != 0 && lib.Has(FUNC_CLOSE) && stuckCalls.Load() == 0 {
+			callNative(context.WithoutCancel(ctx), FUNC_CLOSE, func() { lib.Close(r.handle) })
+		}
+	}()
+
+	r.testOpen(int(count))
+	r.testInvalidHandle()
+	r.testDataZone()
+	r.testRandom()
+	r.testHash()
+	r.testFiles(opts)
+	r.testSign(opts)
+	r.testClose()
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return &conformanceReport{
+		Created: time.Now(),
+		GOOS:    runtime.GOOS,
+		GOARCH:  runtime.GOARCH,
+		Device:  keyList[index],
+		Results: r.results,
+	}, nil
+}
+
+// runConformanceCommand 执行一致性测试并按库版本和架构保存结果
+func runConformanceCommand(args []string) error {
+	fs := flag.NewFlagSet("conformance", flag.ContinueOnError)
+	index := fs.Int("device", 0, "设备序号")
+	write := fs.Bool("write", false, "执行会修改加密锁的步骤 (校验开发商PIN、创建/删除测试文件)")
+	adminPIN := fs.String("pin", DEFAULT_ADMIN_PIN, "开发商PIN，仅 -write 时使用")
+	userPIN := fs.String("user-pin", "", "用户PIN，签名前校验")
+	fileID := fs.String("file-id", fmt.Sprintf("0x%04X", CONFORMANCE_FILE_ID), "测试用数据文件ID，必须未被使用，测试结束后删除")
+	eccFile := fs.String("ecc-file", "", "ECC 私钥文件ID，指定后测试签名")
+	dir := fs.String("dir", CONFORMANCE_DIR, "结果保存目录")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	id, err := parseFileID(*fileID)
+	if err != nil {
+		return err
+	}
+	eccFileID := -1
+	if *eccFile != "" {
+		eccID, err := parseFileID(*eccFile)
+		if err != nil {
+			return err
+		}
+		eccFileID = int(eccID)
+	}
+
+	ctx, stop := commandContext()
+	defer stop()
+
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("此程序仅支持Linux平台，当前平台: %s", runtime.GOOS)
+	}
+	libPath := getLibraryPath()
+	lib, err := loadLibrary(libPath)
+	if err != nil {
+		return fmt.Errorf("加载库失败: %v", err)
+	}
+	defer unloadLibrary(lib)
+
+	report, err := runConformance(ctx, newNativeRawDongle(lib), *index, conformanceOptions{
+		write:    *write,
+		adminPIN: *adminPIN,
+		userPIN:  *userPIN,
+		fileID:   id,
+		eccFile:  eccFileID,
+	})
+	if err != nil {
+		return err
+	}
+	report.Library = libPath
+	if info, err := analyzeLibrary(libPath); err == nil {
+		report.LibrarySHA256 = info.SHA256
+		report.BuildID = info.BuildID
+	}
+
+	counts := make(map[Severity]int)
+	for _, res := range report.Results {
+		counts[res.Result]++
+	}
+	fmt.Printf("\n通过 %d, 失败 %d, 跳过 %d\n", counts[CHECK_PASS], counts[CHECK_FAIL], counts[CHECK_SKIP])
+
+	// 按架构和库文件校验和命名，同一库版本重复测试时覆盖
+	version := shortHash(report.LibrarySHA256)
+	path := filepath.Join(*dir, fmt.Sprintf("%s-%s.json", report.GOARCH, version))
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("结果已保存: %s (库 %s, build-id %s)\n", path, version, report.BuildID)
+
+	if counts[CHECK_FAIL] > 0 {
+		return fmt.Errorf("%d 项测试失败", counts[CHECK_FAIL])
+	}
+	return nil
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// simRawDongle 以模拟后端代替动态库的 rawDongle，记录每次调用的参数
+//
+// 与库函数一样只按参数本身判断，没有 Go 侧的预检查。
+type simRawDongle struct {
+	sim     *simBackend
+	missing map[string]bool // 模拟库中缺少的函数
+	calls   []string
+}
+
+func newSimRawDongle() *simRawDongle {
+	return &simRawDongle{sim: newSimBackend(1), missing: make(map[string]bool)}
+}
+
+func (d *simRawDongle) logf(format string, args ...interface{}) {
+	d.calls = append(d.calls, fmt.Sprintf(format, args...))
+}
+
+// called 是否有以 prefix 开头的调用记录
+func (d *simRawDongle) called(prefix string) bool {
+	for _, call := range d.calls {
+		if strings.HasPrefix(call, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// slice 模拟 C 函数按长度参数访问缓冲区
+func (d *simRawDongle) slice(buf []byte, size int) ([]byte, bool) {
+	if size < 0 || size > len(buf) {
+		return nil, false
+	}
+	return buf[:size], true
+}
+
+func (d *simRawDongle) Has(funcName string) bool {
+	return !d.missing[funcName]
+}
+
+func (d *simRawDongle) Enum(list []DongleInfo, count *int32) uint32 {
+	d.logf("Enum(%d)", len(list))
+	keyList, retCode, _ := d.sim.Enum()
+	if retCode == DONGLE_SUCCESS {
+		copy(list, keyList)
+		*count = int32(len(keyList))
+	}
+	return retCode
+}
+
+func (d *simRawDongle) Open(handle *DongleHandle, index int) uint32 {
+	d.logf("Open(%d)", index)
+	h, retCode, _ := d.sim.Open(index)
+	*handle = h
+	return retCode
+}
+
+func (d *simRawDongle) Close(handle DongleHandle) uint32 {
+	d.logf("Close(%d)", handle)
+	retCode, _ := d.sim.Close(handle)
+	return retCode
+}
+
+func (d *simRawDongle) ReadData(handle DongleHandle, offset int, buf []byte, size int) uint32 {
+	d.logf("ReadData(%d, %d, %d)", handle, offset, size)
+	data, ok := d.slice(buf, size)
+	if !ok {
+		return DONGLE_INVALID_SIZE
+	}
+	retCode, _ := d.sim.ReadData(handle, offset, data)
+	return retCode
+}
+
+func (d *simRawDongle) GenRandom(handle DongleHandle, size int, out []byte) uint32 {
+	d.logf("GenRandom(%d, %d)", handle, size)
+	random, retCode, _ := d.sim.GenRandom(handle, size)
+	copy(out, random)
+	return retCode
+}
+
+func (d *simRawDongle) Hash(handle DongleHandle, flag int, data []byte, size int, out []byte) uint32 {
+	d.logf("Hash(%d, %d, %d)", handle, flag, size)
+	d.sim.mu.Lock()
+	defer d.sim.mu.Unlock()
+	if _, retCode, err := d.sim.device(handle); err != nil {
+		return retCode
+	}
+	input, ok := d.slice(data, size)
+	if !ok || size == 0 {
+		return DONGLE_INVALID_SIZE
+	}
+	switch flag {
+	case FLAG_HASH_MD5:
+		sum := md5.Sum(input)
+		copy(out, sum[:])
+	case FLAG_HASH_SHA1:
+		sum := sha1.Sum(input)
+		copy(out, sum[:])
+	case FLAG_HASH_SM3:
+		// 标准库没有 SM3，测试只检查长度
+		sum := sha256.Sum256(input)
+		copy(out, sum[:])
+	default:
+		return DONGLE_INVALID_PARAMETER
+	}
+	return DONGLE_SUCCESS
+}
+
+func (d *simRawDongle) VerifyPIN(handle DongleHandle, flags int, pin string, remain *int32) uint32 {
+	d.logf("VerifyPIN(%d, %d)", handle, flags)
+	n, retCode, _ := d.sim.VerifyPIN(handle, flags, pin)
+	*remain = int32(n)
+	return retCode
+}
+
+// admin 查找已校验开发商PIN的设备，调用方持有锁
+func (d *simRawDongle) admin(handle DongleHandle) (*simDevice, uint32) {
+	dev, retCode, err := d.sim.device(handle)
+	if err != nil {
+		return nil, retCode
+	}
+	if dev.verified < FLAG_ADMINPIN {
+		return nil, DONGLE_ACCESS_DENIED
+	}
+	return dev, DONGLE_SUCCESS
+}
+
+func (d *simRawDongle) CreateFile(handle DongleHandle, fileType int, fileID uint16, attr *DataFileAttr) uint32 {
+	d.logf("CreateFile(%d, %d, 0x%04X)", handle, fileType, fileID)
+	d.sim.mu.Lock()
+	defer d.sim.mu.Unlock()
+	dev, retCode := d.admin(handle)
+	if retCode != DONGLE_SUCCESS {
+		return retCode
+	}
+	if _, ok := dev.files[fileID]; ok || attr.MSize == 0 {
+		return DONGLE_INVALID_FILEID
+	}
+	dev.files[fileID] = make([]byte, attr.MSize)
+	return DONGLE_SUCCESS
+}
+
+func (d *simRawDongle) WriteFile(handle DongleHandle, fileType int, fileID uint16, offset int, data []byte, size int) uint32 {
+	d.logf("WriteFile(%d, 0x%04X, %d, %d)", handle, fileID, offset, size)
+	d.sim.mu.Lock()
+	defer d.sim.mu.Unlock()
+	dev, retCode := d.admin(handle)
+	if retCode != DONGLE_SUCCESS {
+		return retCode
+	}
+	content, ok := dev.files[fileID]
+	if !ok {
+		return DONGLE_INVALID_FILEID
+	}
+	input, ok := d.slice(data, size)
+	if !ok || size == 0 {
+		return DONGLE_INVALID_SIZE
+	}
+	if offset < 0 || offset+size > len(content) {
+		return DONGLE_INVALID_OFFSET
+	}
+	copy(content[offset:], input)
+	return DONGLE_SUCCESS
+}
+
+func (d *simRawDongle) ReadFile(handle DongleHandle, fileID uint16, offset int, buf []byte, size int) uint32 {
+	d.logf("ReadFile(%d, 0x%04X, %d, %d)", handle, fileID, offset, size)
+	out, ok := d.slice(buf, size)
+	if !ok || size == 0 {
+		return DONGLE_INVALID_SIZE
+	}
+	retCode, _ := d.sim.ReadFile(handle, fileID, offset, out)
+	return retCode
+}
+
+func (d *simRawDongle) DeleteFile(handle DongleHandle, fileType int, fileID uint16) uint32 {
+	d.logf("DeleteFile(%d, 0x%04X)", handle, fileID)
+	d.sim.mu.Lock()
+	defer d.sim.mu.Unlock()
+	dev, retCode := d.admin(handle)
+	if retCode != DONGLE_SUCCESS {
+		return retCode
+	}
+	if _, ok := dev.files[fileID]; !ok {
+		return DONGLE_INVALID_FILEID
+	}
+	delete(dev.files, fileID)
+	return DONGLE_SUCCESS
+}
+
+func (d *simRawDongle) EccSign(handle DongleHandle, fileID uint16, hash []byte, size int, out []byte) uint32 {
+	d.logf("EccSign(%d, 0x%04X, %d)", handle, fileID, size)
+	input, ok := d.slice(hash, size)
+	if !ok {
+		return DONGLE_INVALID_SIZE
+	}
+	signature, retCode, _ := d.sim.EccSign(handle, fileID, input)
+	copy(out, signature)
+	return retCode
+}
+
+// conformanceCounts 按结果统计
+func conformanceCounts(report *conformanceReport) map[Severity][]string {
+	counts := make(map[Severity][]string)
+	for _, res := range report.Results {
+		counts[res.Result] = append(counts[res.Result], res.Name)
+	}
+	return counts
+}
+
+// simConformanceOptions 在模拟设备上执行全部测试的选项
+var simConformanceOptions = conformanceOptions{
+	write:    true,
+	adminPIN: DEFAULT_ADMIN_PIN,
+	userPIN:  DEFAULT_USER_PIN,
+	fileID:   CONFORMANCE_FILE_ID,
+	eccFile:  0x0001,
+}
+
+func TestConformanceSim(t *testing.T) {
+	lib := newSimRawDongle()
+	report, err := runConformance(context.Background(), lib, 0, simConformanceOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := conformanceCounts(report)
+	if len(counts[CHECK_FAIL]) > 0 || len(counts[CHECK_SKIP]) > 0 {
+		t.Fatalf("失败 %v, 跳过 %v", counts[CHECK_FAIL], counts[CHECK_SKIP])
+	}
+
+	// 边界情况必须真正调用到库函数
+	for _, call := range []string{
+		"Open(1)",
+		"ReadData(0, 0, 16)",
+		"GenRandom(0, 16)",
+		"ReadData(1, 0, 0)",
+		"ReadData(1, -1, 16)",
+		fmt.Sprintf("ReadData(1, %d, 16)", DATA_ZONE_SIZE),
+		"GenRandom(1, 0)",
+		fmt.Sprintf("GenRandom(1, %d)", RANDOM_MAX_LEN+1),
+		fmt.Sprintf("Hash(1, %d, 0)", FLAG_HASH_SHA1),
+		fmt.Sprintf("WriteFile(1, 0x%04X, 0, 0)", CONFORMANCE_FILE_ID),
+		fmt.Sprintf("ReadFile(1, 0x%04X, 0, 0)", CONFORMANCE_FILE_ID),
+		"EccSign(1, 0x0000, 0)",
+		fmt.Sprintf("EccSign(1, 0x0000, %d)", ECC_HASH_MAX_LEN+1),
+		"Close(0)",
+	} {
+		if !lib.called(call) {
+			t.Errorf("没有调用 %s", call)
+		}
+	}
+	if n := len(lib.sim.handles); n != 0 {
+		t.Errorf("测试结束后还有 %d 个句柄未关闭", n)
+	}
+}
+
+// permissiveRawDongle 零长度读取返回成功的库
+type permissiveRawDongle struct {
+	*simRawDongle
+}
+
+func (d permissiveRawDongle) ReadData(handle DongleHandle, offset int, buf []byte, size int) uint32 {
+	if size == 0 {
+		return DONGLE_SUCCESS
+	}
+	return d.simRawDongle.ReadData(handle, offset, buf, size)
+}
+
+func TestConformanceReportsLibraryBehavior(t *testing.T) {
+	lib := newSimRawDongle()
+	lib.missing[FUNC_HASH] = true
+	report, err := runConformance(context.Background(), permissiveRawDongle{lib}, 0, conformanceOptions{eccFile: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := conformanceCounts(report)
+	if strings.Join(counts[CHECK_FAIL], ",") != "data-read-zero-length" {
+		t.Errorf("失败项 %v, 期望只有 data-read-zero-length", counts[CHECK_FAIL])
+	}
+	skipped := strings.Join(counts[CHECK_SKIP], ",")
+	if !strings.Contains(skipped, "hash") || !strings.Contains(skipped, "file-create") || !strings.Contains(skipped, "ecc-sign") {
+		t.Errorf("跳过项 %v", counts[CHECK_SKIP])
+	}
+	if lib.called("Hash(") {
+		t.Error("调用了库中没有的函数")
+	}
+	if lib.called("VerifyPIN(") || lib.called("CreateFile(") {
+		t.Error("未指定 -write 时修改了加密锁")
+	}
+}
+
+func TestConformanceTestFile(t *testing.T) {
+	// 上次中断时遗留的测试文件被删除后重新创建
+	lib := newSimRawDongle()
+	lib.sim.devices[0].files[CONFORMANCE_FILE_ID] = append([]byte(CONFORMANCE_MARKER), make([]byte, 16)...)
+	report, err := runConformance(context.Background(), lib, 0, simConformanceOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed := conformanceCounts(report)[CHECK_FAIL]; len(failed) > 0 {
+		t.Errorf("失败 %v", failed)
+	}
+	if _, ok := lib.sim.devices[0].files[CONFORMANCE_FILE_ID]; ok {
+		t.Error("测试结束后测试文件仍然存在")
+	}
+
+	// 没有标记的文件属于用户，拒绝执行且不删除
+	lib = newSimRawDongle()
+	customer := []byte("customer licence data")
+	lib.sim.devices[0].files[CONFORMANCE_FILE_ID] = customer
+	if _, err := runConformance(context.Background(), lib, 0, simConformanceOptions); err == nil || !strings.Contains(err.Error(), "-file-id") {
+		t.Fatalf("文件ID被占用时 runConformance = %v", err)
+	}
+	if lib.called("DeleteFile(") || lib.called("CreateFile(") || lib.called("WriteFile(") {
+		t.Errorf("修改了用户文件: %v", lib.calls)
+	}
+	if got := lib.sim.devices[0].files[CONFORMANCE_FILE_ID]; string(got) != string(customer) {
+		t.Errorf("用户文件被修改: %q", got)
+	}
+	if n := len(lib.sim.handles); n != 0 {
+		t.Errorf("拒绝执行后还有 %d 个句柄未关闭", n)
+	}
+}
+
+// TestConformanceNative 对真实加密锁执行一致性测试
+//
+// 默认跳过。ROCKEY_CONFORMANCE_LIB 指定厂商动态库路径时执行只读测试，
+// 另设 ROCKEY_CONFORMANCE_WRITE=1 时以 ROCKEY_CONFORMANCE_PIN (默认出厂
+// 开发商PIN) 执行文件测试，ROCKEY_CONFORMANCE_USER_PIN 指定时测试签名边界。
+func TestConformanceNative(t *testing.T) {
+	libPath := os.Getenv("ROCKEY_CONFORMANCE_LIB")
+	if libPath == "" {
+		t.Skip("未设置 ROCKEY_CONFORMANCE_LIB，跳过真实设备测试")
+	}
+	lib, err := loadLibrary(libPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unloadLibrary(lib)
+
+	opts := conformanceOptions{
+		write:    os.Getenv("ROCKEY_CONFORMANCE_WRITE") == "1",
+		adminPIN: DEFAULT_ADMIN_PIN,
+		userPIN:  os.Getenv("ROCKEY_CONFORMANCE_USER_PIN"),
+		fileID:   CONFORMANCE_FILE_ID,
+		eccFile:  -1,
+	}
+	if pin := os.Getenv("ROCKEY_CONFORMANCE_PIN"); pin != "" {
+		opts.adminPIN = pin
+	}
+	report, err := runConformance(context.Background(), newNativeRawDongle(lib), 0, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range report.Results {
+		if res.Result == CHECK_FAIL {
+			t.Errorf("%s: 期望 %s, 实际 %s %s", res.Name, res.Expected, res.RetCode, res.Detail)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// ============ 随机数与签名 ============
+
+// 随机数与签名长度
+const (
+	RANDOM_MAX_LEN     = 128 // 单次生成随机数的最大长度
+	ECC_HASH_MAX_LEN   = 32  // ECC 签名的摘要最大长度
+	ECC_SIGNATURE_SIZE = 64  // ECC 签名长度 (r||s)
+)
+
+// 摘要算法
+const (
+	FLAG_HASH_MD5  = 0 // MD5，16 字节
+	FLAG_HASH_SHA1 = 1 // SHA1，20 字节
+	FLAG_HASH_SM3  = 2 // SM3，32 字节
+)
+
+// hashSizes 摘要算法对应的输出长度
+var hashSizes = map[int]int{
+	FLAG_HASH_MD5:  16,
+	FLAG_HASH_SHA1: 20,
+	FLAG_HASH_SM3:  32,
+}
+
+// genRandom 生成指定长度的硬件随机数
+func genRandom(genRandomFunc uintptr, handle DongleHandle, length int) ([]byte, uint32, error) {
+	if handle == 0 {
+		return nil, DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+	if length <= 0 || length > RANDOM_MAX_LEN {
+		return nil, DONGLE_INVALID_SIZE, fmt.Errorf("%s: 随机数长度 %d 超出范围 (1-%d)", getErrorDescription(DONGLE_INVALID_SIZE), length, RANDOM_MAX_LEN)
+	}
+
+	// 函数原型: DWORD Dongle_GenRandom(DONGLE_HANDLE hDongle, int nLen, BYTE* pRandom)
+	type GenRandomFuncType func(handle DongleHandle, length int32, random unsafe.Pointer) uint32
+
+	var genRandomFuncGo GenRandomFuncType
+	purego.RegisterFunc(&genRandomFuncGo, genRandomFunc)
+
+	out := make([]byte, length)
+	start := time.Now()
+	retCode := genRandomFuncGo(handle, int32(length), unsafe.Pointer(&out[0]))
+	traceCall(FUNC_GENRANDOM, handle, retCode, start, "len", length)
+
+	if retCode != DONGLE_SUCCESS {
+		return nil, retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+	return out, retCode, nil
+}
+
+// eccSign 使用锁内 ECC 私钥文件对摘要签名，需要先校验用户PIN
+func eccSign(eccSignFunc uintptr, handle DongleHandle, fileID uint16, hash []byte) ([]byte, uint32, error) {
+	if handle == 0 {
+		return nil, DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+	if len(hash) == 0 || len(hash) > ECC_HASH_MAX_LEN {
+		return nil, DONGLE_INVALID_SIZE, fmt.Errorf("%s: 摘要长度 %d 超出范围 (1-%d)", getErrorDescription(DONGLE_INVALID_SIZE), len(hash), ECC_HASH_MAX_LEN)
+	}
+
+	// 函数原型: DWORD Dongle_EccSign(DONGLE_HANDLE hDongle, WORD wPriFileID, BYTE* pHashData, int nHashDataLen, BYTE* pOutData)
+	type EccSignFuncType func(handle DongleHandle, fileID uint16, hash unsafe.Pointer, hashLen int32, out unsafe.Pointer) uint32
+
+	var eccSignFuncGo EccSignFuncType
+	purego.RegisterFunc(&eccSignFuncGo, eccSignFunc)
+
+	out := make([]byte, ECC_SIGNATURE_SIZE)
+	start := time.Now()
+	retCode := eccSignFuncGo(handle, fileID, unsafe.Pointer(&hash[0]), int32(len(hash)), unsafe.Pointer(&out[0]))
+	traceCall(FUNC_ECCSIGN, handle, retCode, start, "file_id", fmt.Sprintf("0x%04X", fileID))
+
+	if retCode != DONGLE_SUCCESS {
+		return nil, retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+	return out, retCode, nil
+}
+
+// hashData 使用锁内算法计算摘要
+func hashData(hashFunc uintptr, handle DongleHandle, flag int, data []byte) ([]byte, uint32, error) {
+	if handle == 0 {
+		return nil, DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+	size, ok := hashSizes[flag]
+	if !ok {
+		return nil, DONGLE_INVALID_PARAMETER, fmt.Errorf("%s: 未知摘要算法 %d", getErrorDescription(DONGLE_INVALID_PARAMETER), flag)
+	}
+	if len(data) == 0 {
+		return nil, DONGLE_INVALID_BUFFER, fmt.Errorf(getErrorDescription(DONGLE_INVALID_BUFFER))
+	}
+
+	// 函数原型: DWORD Dongle_HASH(DONGLE_HANDLE hDongle, int nFlag, BYTE* pInData, int nDataLen, BYTE* pHash)
+	type HashFuncType func(handle DongleHandle, flag int32, data unsafe.Pointer, dataLen int32, hash unsafe.Pointer) uint32
+
+	var hashFuncGo HashFuncType
+	purego.RegisterFunc(&hashFuncGo, hashFunc)
+
+	out := make([]byte, size)
+	start := time.Now()
+	retCode := hashFuncGo(handle, int32(flag), unsafe.Pointer(&data[0]), int32(len(data)), unsafe.Pointer(&out[0]))
+	traceCall(FUNC_HASH, handle, retCode, start, "flag", flag, "len", len(data))
+
+	if retCode != DONGLE_SUCCESS {
+		return nil, retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+	return out, retCode, nil
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// ============ 数据区与共享内存 ============
+
+// 区域大小（参见 Rockey-ARM 开发手册）
+const (
+	DATA_ZONE_SIZE      = 8192 // 数据区大小，8K
+	DATA_ZONE_USER_SIZE = 4096 // 数据区前 4K 用户权限可写，后 4K 仅开发商可写
+	SHARE_MEMORY_SIZE   = 32   // 共享内存大小，32字节
+)
+
+// checkRegion 检查偏移量和长度是否在区域范围内
+func checkRegion(offset int64, length int, size int) (uint32, error) {
+	if offset < 0 || offset >= int64(size) {
+		return DONGLE_INVALID_OFFSET, fmt.Errorf("%s: 偏移量 %d 超出范围 [0, %d)", getErrorDescription(DONGLE_INVALID_OFFSET), offset, size)
+	}
+	if length <= 0 || offset+int64(length) > int64(size) {
+		return DONGLE_INVALID_SIZE, fmt.Errorf("%s: 偏移量 %d + 长度 %d 超出区域大小 %d", getErrorDescription(DONGLE_INVALID_SIZE), offset, length, size)
+	}
+	return DONGLE_SUCCESS, nil
+}
+
+// readData 读取数据区
+func readData(readDataFunc uintptr, handle DongleHandle, offset int, buffer []byte) (uint32, error) {
+	if handle == 0 {
+		return DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+	if retCode, err := checkRegion(int64(offset), len(buffer), DATA_ZONE_SIZE); err != nil {
+		return retCode, err
+	}
+
+	// 函数原型: DWORD Dongle_ReadData(DONGLE_HANDLE hDongle, int nOffset, BYTE* pData, int nDataLen)
+	type ReadDataFuncType func(handle DongleHandle, offset int32, data unsafe.Pointer, size int32) uint32
+
+	var readDataFuncGo ReadDataFuncType
+	purego.RegisterFunc(&readDataFuncGo, readDataFunc)
+
+	start := time.Now()
+	retCode := readDataFuncGo(handle, int32(offset), unsafe.Pointer(&buffer[0]), int32(len(buffer)))
+	traceCall(FUNC_READDATA, handle, retCode, start, "offset", offset, "size", len(buffer))
+
+	if retCode != DONGLE_SUCCESS {
+		return retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+	return retCode, nil
+}
+
+// writeData 写入数据区
+func writeData(writeDataFunc uintptr, handle DongleHandle, offset int, data []byte) (uint32, error) {
+	if handle == 0 {
+		return DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+	if retCode, err := checkRegion(int64(offset), len(data), DATA_ZONE_SIZE); err != nil {
+		return retCode, err
+	}
+
+	// 函数原型: DWORD Dongle_WriteData(DONGLE_HANDLE hDongle, int nOffset, BYTE* pData, int nDataLen)
+	type WriteDataFuncType func(handle DongleHandle, offset int32, data unsafe.Pointer, size int32) uint32
+
+	var writeDataFuncGo WriteDataFuncType
+	purego.RegisterFunc(&writeDataFuncGo, writeDataFunc)
+
+	start := time.Now()
+	retCode := writeDataFuncGo(handle, int32(offset), unsafe.Pointer(&data[0]), int32(len(data)))
+	traceCall(FUNC_WRITEDATA, handle, retCode, start, "offset", offset, "size", len(data))
+
+	if retCode != DONGLE_SUCCESS {
+		return retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+	return retCode, nil
+}
+
+// readShareMemory 读取共享内存，buffer 长度必须为 SHARE_MEMORY_SIZE
+func readShareMemory(readShareMemoryFunc uintptr, handle DongleHandle, buffer []byte) (uint32, error) {
+	if handle == 0 {
+		return DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+	if len(buffer) != SHARE_MEMORY_SIZE {
+		return DONGLE_INVALID_BUFFER, fmt.Errorf("%s: 共享内存缓冲区必须为 %d 字节", getErrorDescription(DONGLE_INVALID_BUFFER), SHARE_MEMORY_SIZE)
+	}
+
+	// 函数原型: DWORD Dongle_ReadShareMemory(DONGLE_HANDLE hDongle, BYTE* pData)
+	type ReadShareMemoryFuncType func(handle DongleHandle, data unsafe.Pointer) uint32
+
+	var readShareMemoryFuncGo ReadShareMemoryFuncType
+	purego.RegisterFunc(&readShareMemoryFuncGo, readShareMemoryFunc)
+
+	start := time.Now()
+	retCode := readShareMemoryFuncGo(handle, unsafe.Pointer(&buffer[0]))
+	traceCall(FUNC_READSHAREMEMORY, handle, retCode, start)
+
+	if retCode != DONGLE_SUCCESS {
+		return retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+	return retCode, nil
+}
+
+// writeShareMemory 从起始位置写入共享内存
+func writeShareMemory(writeShareMemoryFunc uintptr, handle DongleHandle, data []byte) (uint32, error) {
+	if handle == 0 {
+		return DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+	if retCode, err := checkRegion(0, len(data), SHARE_MEMORY_SIZE); err != nil {
+		return retCode, err
+	}
+
+	// 函数原型: DWORD Dongle_WriteShareMemory(DONGLE_HANDLE hDongle, BYTE* pData, int nLen)
+	type WriteShareMemoryFuncType func(handle DongleHandle, data unsafe.Pointer, size int32) uint32
+
+	var writeShareMemoryFuncGo WriteShareMemoryFuncType
+	purego.RegisterFunc(&writeShareMemoryFuncGo, writeShareMemoryFunc)
+
+	start := time.Now()
+	retCode := writeShareMemoryFuncGo(handle, unsafe.Pointer(&data[0]), int32(len(data)))
+	traceCall(FUNC_WRITESHAREMEMORY, handle, retCode, start, "size", len(data))
+
+	if retCode != DONGLE_SUCCESS {
+		return retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+	return retCode, nil
+}
+
+// ============ io.ReaderAt / io.WriterAt ============
+
+// dataZone 数据区，实现 io.ReaderAt 和 io.WriterAt
+type dataZone struct {
+	readFunc  uintptr
+	writeFunc uintptr
+	conn      *deviceConn
+}
+
+// ReadAt 从数据区读取，越过区域末尾时返回 io.EOF
+func (z *dataZone) ReadAt(p []byte, off int64) (int, error) {
+	if off >= DATA_ZONE_SIZE {
+		return 0, io.EOF
+	}
+	n := len(p)
+	if off+int64(n) > DATA_ZONE_SIZE {
+		n = int(DATA_ZONE_SIZE - off)
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	err := z.conn.call(FUNC_READDATA, func() (err error) {
+		_, err = readData(z.readFunc, z.conn.handle, int(off), p[:n])
+		return
+	})
+	if err != nil {
+		return 0, err
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt 写入数据区，不允许越过区域末尾
+func (z *dataZone) WriteAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	err := z.conn.call(FUNC_WRITEDATA, func() (err error) {
+		_, err = writeData(z.writeFunc, z.conn.handle, int(off), p)
+		return
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// shareMemory 共享内存，实现 io.ReaderAt 和 io.WriterAt
+//
+// 原生接口只能整块读取、从起始位置写入。共享内存用于进程间协调，按偏移
+// 写入需要先读出整块再回写，两次调用之间其他进程的写入会被覆盖，因此
+// WriteAt 只接受从偏移 0 开始的写入，每次写入对应一次原生调用。
+type shareMemory struct {
+	readFunc  uintptr
+	writeFunc uintptr
+	conn      *deviceConn
+}
+
+// ReadAt 从共享内存读取，越过区域末尾时返回 io.EOF
+func (m *shareMemory) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("%s: 偏移量 %d", getErrorDescription(DONGLE_INVALID_OFFSET), off)
+	}
+	if off >= SHARE_MEMORY_SIZE {
+		return 0, io.EOF
+	}
+	block := make([]byte, SHARE_MEMORY_SIZE)
+	err := m.conn.call(FUNC_READSHAREMEMORY, func() (err error) {
+		_, err = readShareMemory(m.readFunc, m.conn.handle, block)
+		return
+	})
+	if err != nil {
+		return 0, err
+	}
+	n := copy(p, block[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt 从起始位置写入共享内存，off 必须为 0
+func (m *shareMemory) WriteAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if off != 0 {
+		return 0, fmt.Errorf("%s: 共享内存只能从偏移量 0 写入 (按偏移写入不是原子操作，会覆盖其他进程的修改)", getErrorDescription(DONGLE_INVALID_OFFSET))
+	}
+	if _, err := checkRegion(off, len(p), SHARE_MEMORY_SIZE); err != nil {
+		return 0, err
+	}
+	err := m.conn.call(FUNC_WRITESHAREMEMORY, func() (err error) {
+		_, err = writeShareMemory(m.writeFunc, m.conn.handle, p)
+		return
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// openDataZone 获取数据区读写接口
+func (c *deviceConn) openDataZone() (*dataZone, error) {
+	readFunc, err := c.proc(FUNC_READDATA)
+	if err != nil {
+		return nil, err
+	}
+	writeFunc, err := c.proc(FUNC_WRITEDATA)
+	if err != nil {
+		return nil, err
+	}
+	return &dataZone{readFunc: readFunc, writeFunc: writeFunc, conn: c}, nil
+}
+
+// openShareMemory 获取共享内存读写接口
+func (c *deviceConn) openShareMemory() (*shareMemory, error) {
+	readFunc, err := c.proc(FUNC_READSHAREMEMORY)
+	if err != nil {
+		return nil, err
+	}
+	writeFunc, err := c.proc(FUNC_WRITESHAREMEMORY)
+	if err != nil {
+		return nil, err
+	}
+	return &shareMemory{readFunc: readFunc, writeFunc: writeFunc, conn: c}, nil
+}
+
+// ============ 命令行 ============
+
+// parseHexInput 解析十六进制输入，忽略空白和冒号分隔符
+func parseHexInput(s string) ([]byte, error) {
+	s = strings.NewReplacer(" ", "", ":", "", "\n", "", "\t", "").Replace(s)
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("无效的十六进制数据: %v", err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("十六进制数据为空")
+	}
+	return data, nil
+}
+
+// runDataCommand 执行 data 子命令
+func runDataCommand(args []string) error {
+	return runRegionCommand("data", DATA_ZONE_SIZE, args, func(c *deviceConn) (io.ReaderAt, io.WriterAt, error) {
+		z, err := c.openDataZone()
+		return z, z, err
+	})
+}
+
+// runShareMemCommand 执行 sharemem 子命令
+func runShareMemCommand(args []string) error {
+	return runRegionCommand("sharemem", SHARE_MEMORY_SIZE, args, func(c *deviceConn) (io.ReaderAt, io.WriterAt, error) {
+		m, err := c.openShareMemory()
+		return m, m, err
+	})
+}
+
+// runRegionCommand 导出或修改一个区域
+func runRegionCommand(name string, size int, args []string, open func(c *deviceConn) (io.ReaderAt, io.WriterAt, error)) error {
+	if len(args) == 0 || (args[0] != "dump" && args[0] != "patch") {
+		return fmt.Errorf("用法: %s dump|patch [选项]", name)
+	}
+	action := args[0]
+
+	fs := flag.NewFlagSet(name+" "+action, flag.ContinueOnError)
+	index := fs.Int("device", 0, "设备序号")
+	offset := fs.Int("offset", 0, "起始偏移量")
+	length := fs.Int("len", size, "导出长度（dump）")
+	output := fs.String("o", "", "将原始数据写入文件（dump）")
+	hexData := fs.String("hex", "", "要写入的十六进制数据（patch）")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	var data []byte
+	if action == "patch" {
+		var err error
+		if data, err = parseHexInput(*hexData); err != nil {
+			return err
+		}
+		if _, err := checkRegion(int64(*offset), len(data), size); err != nil {
+			return err
+		}
+	} else {
+		if *length > size-*offset {
+			*length = size - *offset
+		}
+		if _, err := checkRegion(int64(*offset), *length, size); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := commandContext()
+	defer stop()
+
+	conn, err := connectDevice(ctx, *index)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+
+	r, w, err := open(conn)
+	if err != nil {
+		return err
+	}
+
+	if action == "patch" {
+		if _, err := w.WriteAt(data, int64(*offset)); err != nil {
+			return fmt.Errorf("写入失败: %v", err)
+		}
+		fmt.Printf("已写入 %d 字节 (偏移量 %d)\n", len(data), *offset)
+		return nil
+	}
+
+	buffer := make([]byte, *length)
+	n, err := r.ReadAt(buffer, int64(*offset))
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("读取失败: %v", err)
+	}
+	buffer = buffer[:n]
+
+	if *output != "" {
+		if err := os.WriteFile(*output, buffer, 0600); err != nil {
+			return err
+		}
+		fmt.Printf("已将 %d 字节写入 %s\n", n, *output)
+		return nil
+	}
+
+	fmt.Printf("偏移量 %d, 长度 %d:\n", *offset, n)
+	showBinHex(buffer)
+	fmt.Println(hex.EncodeToString(buffer))
+	return nil
+}
@@ -0,0 +1,741 @@
+package main
+
+import (
+	"context"
+	"debug/elf"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/ebitengine/purego"
+)
+
+// ============ 诊断检查 ============
+//
+// 诊断由一组按顺序执行的检查组成，每个检查给出 通过/警告/失败 的结果、
+// 证据和修复建议。检查可以声明前置检查，前置检查失败或被跳过时不再执行，
+// 避免在库都加载不了的情况下继续报一串无意义的错误。
+
+// Severity 检查结果级别
+type Severity int
+
+const (
+	CHECK_PASS Severity = iota // 通过
+	CHECK_WARN                 // 警告，不影响后续检查
+	CHECK_FAIL                 // 失败
+	CHECK_SKIP                 // 未执行
+)
+
+// severityNames 级别名称，用于输出
+var severityNames = map[Severity]string{
+	CHECK_PASS: "pass",
+	CHECK_WARN: "warn",
+	CHECK_FAIL: "fail",
+	CHECK_SKIP: "skip",
+}
+
+func (s Severity) String() string {
+	return severityNames[s]
+}
+
+// MarshalText 以名称输出到 JSON
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// CheckResult 一个检查的结果
+type CheckResult struct {
+	Name        string   `json:"name"`                  // 检查名
+	Severity    Severity `json:"severity"`              // 级别
+	Summary     string   `json:"summary"`               // 一句话结论
+	Evidence    []string `json:"evidence,omitempty"`    // 支持结论的事实
+	Remediation []string `json:"remediation,omitempty"` // 修复建议
+	DurationMS  float64  `json:"duration_ms"`           // 耗时 (毫秒)
+}
+
+// Check 诊断检查
+type Check interface {
+	Name() string                  // 检查名，用于 -only/-skip
+	Requires() []string            // 前置检查
+	Run(env *diagEnv) *CheckResult // 执行检查
+}
+
+// checkFunc 用函数实现的检查
+type checkFunc struct {
+	name     string
+	requires []string
+	run      func(env *diagEnv) *CheckResult
+}
+
+func (c checkFunc) Name() string                  { return c.name }
+func (c checkFunc) Requires() []string            { return c.requires }
+func (c checkFunc) Run(env *diagEnv) *CheckResult { return c.run(env) }
+
+// diagEnv 检查之间共享的状态
+type diagEnv struct {
+	libPath string
+	lib     uintptr        // 动态库句柄
+	dongles []usbDevice    // sysfs 中找到的加密锁
+	backend *nativeBackend // 枚举后创建
+	infos   []DongleInfo
+	handle  DongleHandle
+	opened  bool
+	stuck   bool // 原生调用超时未返回，不能再关闭句柄或卸载动态库
+
+	displayID func(string) string // 输出序列号、硬件ID 前的替换，支持包 -hash-ids 时使用
+}
+
+// id 返回证据中输出的设备标识
+func (e *diagEnv) id(id string) string {
+	if e.displayID == nil {
+		return id
+	}
+	return e.displayID(id)
+}
+
+// close 释放检查过程中打开的资源
+func (e *diagEnv) close() {
+	if e.stuck {
+		return
+	}
+	if e.opened {
+		e.backend.Close(e.handle)
+	}
+	if e.backend != nil {
+		e.backend.Unload()
+	}
+	if e.lib != 0 {
+		purego.Dlclose(e.lib)
+	}
+}
+
+// call 经 callNative 以全局超时执行一次原生调用
+func (e *diagEnv) call(name string, fn func()) error {
+	if err := callNative(context.Background(), name, fn); err != nil {
+		e.stuck = true
+		return err
+	}
+	return nil
+}
+
+// result 构造检查结果
+func result(severity Severity, summary string, evidence ...string) *CheckResult {
+	return &CheckResult{Severity: severity, Summary: summary, Evidence: evidence}
+}
+
+// fix 附加修复建议
+func (r *CheckResult) fix(remediation ...string) *CheckResult {
+	r.Remediation = append(r.Remediation, remediation...)
+	return r
+}
+
+// ============ 检查项 ============
+
+// 检查名
+const (
+	CHECK_PLATFORM     = "platform"
+	CHECK_LIBRARY      = "library"
+	CHECK_ELF_ARCH     = "elf-arch"
+	CHECK_DEPENDENCIES = "dependencies"
+	CHECK_SYMBOLS      = "symbols"
+	CHECK_USB_NODE     = "usb-node"
+	CHECK_PERMISSIONS  = "permissions"
+	CHECK_KERNEL       = "kernel-module"
+	CHECK_ENUMERATION  = "enumeration"
+	CHECK_OPEN         = "open"
+	CHECK_READ         = "read"
+	CHECK_CLOCK        = "clock"
+)
+
+// CLOCK_MAX_SKEW 锁内时钟与系统时钟允许的偏差
+const CLOCK_MAX_SKEW = 5 * time.Minute
+
+// diagnosticChecks 按执行顺序排列的检查
+var diagnosticChecks = []Check{
+	checkFunc{CHECK_PLATFORM, nil, checkPlatform},
+	checkFunc{CHECK_LIBRARY, []string{CHECK_PLATFORM}, checkLibrary},
+	checkFunc{CHECK_ELF_ARCH, []string{CHECK_LIBRARY}, checkELFArch},
+	checkFunc{CHECK_DEPENDENCIES, []string{CHECK_ELF_ARCH}, checkDependencies},
+	checkFunc{CHECK_SYMBOLS, []string{CHECK_DEPENDENCIES}, checkSymbols},
+	checkFunc{CHECK_USB_NODE, []string{CHECK_PLATFORM}, checkUSBNode},
+	checkFunc{CHECK_PERMISSIONS, []string{CHECK_USB_NODE}, checkPermissions},
+	checkFunc{CHECK_KERNEL, []string{CHECK_PLATFORM}, checkKernelModules},
+	checkFunc{CHECK_ENUMERATION, []string{CHECK_SYMBOLS}, checkEnumeration},
+	checkFunc{CHECK_OPEN, []string{CHECK_ENUMERATION}, checkOpen},
+	checkFunc{CHECK_READ, []string{CHECK_OPEN}, checkRead},
+	checkFunc{CHECK_CLOCK, []string{CHECK_READ}, checkClock},
+}
+
+// checkPlatform 操作系统和架构
+func checkPlatform(env *diagEnv) *CheckResult {
+	evidence := fmt.Sprintf("GOOS=%s GOARCH=%s (%s)", runtime.GOOS, runtime.GOARCH, runtime.Version())
+	if runtime.GOOS != "linux" {
+		return result(CHECK_FAIL, "此程序仅支持Linux平台", evidence)
+	}
+	if _, ok := elfMachines[runtime.GOARCH]; !ok {
+		return result(CHECK_WARN, "当前架构没有对应的厂商动态库", evidence).
+			fix("使用 -hid 实验性纯 Go 传输，或在 x86_64/arm64/loong64 上运行")
+	}
+	return result(CHECK_PASS, "Linux 平台，架构受支持", evidence)
+}
+
+// checkLibrary 动态库文件
+func checkLibrary(env *diagEnv) *CheckResult {
+	env.libPath = getLibraryPath()
+	info, err := os.Stat(env.libPath)
+	if err != nil {
+		return result(CHECK_FAIL, "库文件不存在", err.Error()).fix(
+			"将库文件放到以下位置之一:",
+			"./lib/linux/arm64/libRockeyARM.so.0.3 (ARM64)",
+			"./lib/linux/loong64/libRockeyARM.so.0.3 (Loong64)",
+			"./lib/linux/libRockeyARM.so (x86_64)",
+		)
+	}
+	return result(CHECK_PASS, "库文件存在",
+		fmt.Sprintf("路径: %s", env.libPath),
+		fmt.Sprintf("大小: %d 字节, 权限: %v", info.Size(), info.Mode()),
+		fmt.Sprintf("修改时间: %v", info.ModTime().Format(time.RFC3339)),
+	)
+}
+
+// elfMachines GOARCH 对应的 ELF 机器类型
+var elfMachines = map[string]elf.Machine{
+	"amd64":   elf.EM_X86_64,
+	"arm64":   elf.EM_AARCH64,
+	"loong64": elf.EM_LOONGARCH,
+}
+
+// checkELFArch 动态库架构与当前进程一致
+func checkELFArch(env *diagEnv) *CheckResult {
+	f, err := elf.Open(env.libPath)
+	if err != nil {
+		return result(CHECK_FAIL, "库文件不是有效的 ELF 文件", err.Error()).fix("重新获取库文件，可能已损坏")
+	}
+	defer f.Close()
+
+	evidence := fmt.Sprintf("库: %v %v, 进程: %s", f.Machine, f.Class, runtime.GOARCH)
+	want, ok := elfMachines[runtime.GOARCH]
+	if !ok {
+		return result(CHECK_WARN, "无法确认架构是否匹配", evidence)
+	}
+	if f.Machine != want || f.Class != elf.ELFCLASS64 {
+		return result(CHECK_FAIL, "库文件架构与当前程序不匹配", evidence).
+			fix(fmt.Sprintf("使用 %s 架构的库文件，或构建对应架构的程序", runtime.GOARCH))
+	}
+	return result(CHECK_PASS, "库文件架构匹配", evidence)
+}
+
+// checkDependencies 依赖库能否找到并加载动态库
+func checkDependencies(env *diagEnv) *CheckResult {
+	var evidence, missing []string
+	if f, err := elf.Open(env.libPath); err == nil {
+		needed, _ := f.ImportedLibraries()
+		f.Close()
+		for _, lib := range needed {
+			if path := findSharedLibrary(lib, filepath.Dir(env.libPath)); path != "" {
+				evidence = append(evidence, fmt.Sprintf("%s => %s", lib, path))
+			} else {
+				evidence = append(evidence, fmt.Sprintf("%s => 未找到", lib))
+				missing = append(missing, lib)
+			}
+		}
+	}
+
+	lib, err := loadLibrary(env.libPath)
+	if err != nil {
+		r := result(CHECK_FAIL, "动态库加载失败", append([]string{err.Error()}, evidence...)...)
+		if len(missing) > 0 {
+			r.fix(fmt.Sprintf("安装缺少的依赖库: %s", strings.Join(missing, ", ")))
+		}
+		return r.fix("确认库文件未损坏且架构匹配")
+	}
+	env.lib = lib
+	if len(missing) > 0 {
+		// 动态库已加载成功，说明依赖在未搜索的路径中 (例如 ld.so.conf 配置的目录)
+		return result(CHECK_WARN, "动态库加载成功，但部分依赖不在常见目录中", evidence...)
+	}
+	return result(CHECK_PASS, "依赖库齐全，动态库加载成功", evidence...)
+}
+
+// findSharedLibrary 在常见目录中查找共享库
+func findSharedLibrary(name, libDir string) string {
+	dirs := filepath.SplitList(os.Getenv("LD_LIBRARY_PATH"))
+	dirs = append(dirs, libDir, "/lib", "/usr/lib", "/lib64", "/usr/lib64", "/usr/local/lib")
+	if triplet := multiarchTriplets[runtime.GOARCH]; triplet != "" {
+		dirs = append(dirs, "/lib/"+triplet, "/usr/lib/"+triplet)
+	}
+	for _, dir := range dirs {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// multiarchTriplets Debian 系多架构库目录
+var multiarchTriplets = map[string]string{
+	"amd64":   "x86_64-linux-gnu",
+	"arm64":   "aarch64-linux-gnu",
+	"loong64": "loongarch64-linux-gnu",
+}
+
+// diagRequiredSymbols 基本功能必需的函数，缺少时失败；其余缺少时只警告
+var diagRequiredSymbols = []string{
+	FUNC_ENUM, FUNC_OPEN, FUNC_CLOSE, FUNC_READFILE, FUNC_READDATA, FUNC_VERIFYPIN, FUNC_GETUTCTIME,
+}
+
+// diagOptionalSymbols 部分命令使用的函数
+var diagOptionalSymbols = []string{
+	FUNC_WRITEDATA, FUNC_READSHAREMEMORY, FUNC_WRITESHAREMEMORY, FUNC_DOWNLOADEXEFILE, FUNC_RUNEXEFILE,
+	FUNC_CHANGEPIN, FUNC_SETUSERID, FUNC_SETDEADLINE, FUNC_LIMITSEEDCOUNT, FUNC_CREATEFILE,
+	FUNC_WRITEFILE, FUNC_REQUESTINIT, FUNC_GETINITDATAFROMMOTHER, FUNC_INITSON, FUNC_LISTFILE,
+	FUNC_DELETEFILE, FUNC_GETDEADLINE, FUNC_SEED, FUNC_MAKEUPDATEPACKETFROMMOTHER, FUNC_UPDATE,
+	FUNC_GENRANDOM, FUNC_ECCSIGN, FUNC_HASH,
+}
+
+// checkSymbols 函数符号
+func checkSymbols(env *diagEnv) *CheckResult {
+	var missingRequired, missingOptional []string
+	for _, sym := range diagRequiredSymbols {
+		if _, err := getProcAddress(env.lib, sym); err != nil {
+			missingRequired = append(missingRequired, sym)
+		}
+	}
+	for _, sym := range diagOptionalSymbols {
+		if _, err := getProcAddress(env.lib, sym); err != nil {
+			missingOptional = append(missingOptional, sym)
+		}
+	}
+
+	total := len(diagRequiredSymbols) + len(diagOptionalSymbols)
+	found := fmt.Sprintf("找到 %d/%d 个函数", total-len(missingRequired)-len(missingOptional), total)
+	switch {
+	case len(missingRequired) > 0:
+		return result(CHECK_FAIL, "缺少必需的函数", found, "缺少: "+strings.Join(append(missingRequired, missingOptional...), ", ")).
+			fix("检查库文件版本是否与本程序匹配")
+	case len(missingOptional) > 0:
+		return result(CHECK_WARN, "缺少部分命令使用的函数", found, "缺少: "+strings.Join(missingOptional, ", "))
+	}
+	return result(CHECK_PASS, "函数符号齐全", found)
+}
+
+// checkUSBNode sysfs 中的加密锁设备
+func checkUSBNode(env *diagEnv) *CheckResult {
+	devices, err := scanUSB(SYSFS_USB_DEVICES, "/dev")
+	if err != nil {
+		return result(CHECK_FAIL, "无法读取 USB 设备列表", err.Error()).
+			fix("容器中运行时挂载 /sys 并传入设备，例如 --device /dev/bus/usb")
+	}
+	for _, dev := range devices {
+		if dev.IsDongle() {
+			env.dongles = append(env.dongles, dev)
+		}
+	}
+	if len(env.dongles) == 0 {
+		// 同一厂商的其他产品可能是未登记型号的加密锁，也可能是 ePass 等令牌
+		var unknown []string
+		for _, dev := range devices {
+			if dev.Vendor == ROCKEY_USB_VENDOR {
+				unknown = append(unknown, fmt.Sprintf("%s %04X:%04X %s", dev.Name, dev.Vendor, dev.Product, dev.ProductName))
+			}
+		}
+		if len(unknown) > 0 {
+			return result(CHECK_FAIL, "未发现已知型号的加密锁", unknown...).
+				fix(fmt.Sprintf("以上是飞天诚信的其他产品，确认是加密锁时用 -usb-id %04x:<产品ID> 指定", ROCKEY_USB_VENDOR))
+		}
+		return result(CHECK_FAIL, "未发现加密锁", fmt.Sprintf("共 %d 个USB设备，没有已知的加密锁产品ID", len(devices))).
+			fix("插入加密锁，检查指示灯是否亮起", "查看系统日志: dmesg | tail -20")
+	}
+
+	var evidence []string
+	for _, dev := range env.dongles {
+		line := fmt.Sprintf("%s %04X:%04X %s 总线 %03d 端口 %s 设备号 %03d, 驱动: %s",
+			dev.Name, dev.Vendor, dev.Product, dev.ProductName, dev.Bus, dev.Port, dev.DevNum, dev.Drivers())
+		if dev.Serial != "" {
+			line += ", 序列号: " + env.id(dev.Serial)
+		}
+		evidence = append(evidence, line)
+	}
+	return result(CHECK_PASS, fmt.Sprintf("发现 %d 个加密锁", len(env.dongles)), evidence...)
+}
+
+// checkPermissions 当前用户能否读写加密锁的设备节点
+func checkPermissions(env *diagEnv) *CheckResult {
+	userName, groups := currentUser()
+	evidence := []string{fmt.Sprintf("用户: %s, 组: %s", userName, strings.Join(groups, " "))}
+	accessible := 0
+	for _, dev := range env.dongles {
+		nodes := append([]usbNode{dev.DevNode}, dev.HIDRaw...)
+		for _, node := range nodes {
+			evidence = append(evidence, node.String())
+		}
+		if dev.DevNode.Readable && dev.DevNode.Writable {
+			accessible++
+		}
+	}
+	if accessible == 0 {
+		return result(CHECK_FAIL, "当前用户无权读写加密锁设备节点", evidence...).fix(
+			"插入加密锁后生成udev规则: sudo ./rockey-test udev",
+			"或使用sudo运行程序: sudo ./rockey-test -test",
+		)
+	}
+	if accessible < len(env.dongles) {
+		return result(CHECK_WARN, "部分加密锁无法读写", evidence...).fix("sudo ./rockey-test udev")
+	}
+	return result(CHECK_PASS, "设备节点可读写", evidence...)
+}
+
+// checkKernelModules USB/HID 内核模块
+func checkKernelModules(env *diagEnv) *CheckResult {
+	var evidence, missing []string
+	for _, mod := range []string{"usbcore", "usbhid", "hid"} {
+		if kernelModuleLoaded(mod) {
+			evidence = append(evidence, mod+": 已加载")
+		} else {
+			evidence = append(evidence, mod+": 未找到")
+			missing = append(missing, mod)
+		}
+	}
+	if len(missing) > 0 {
+		return result(CHECK_WARN, "USB/HID 内核模块可能未加载", evidence...).
+			fix("sudo modprobe " + strings.Join(missing, " "))
+	}
+	return result(CHECK_PASS, "USB/HID 内核模块已加载", evidence...)
+}
+
+// checkEnumeration 通过动态库枚举设备
+func checkEnumeration(env *diagEnv) *CheckResult {
+	backend, err := newNativeBackend(env.libPath)
+	if err != nil {
+		return result(CHECK_FAIL, "动态库加载失败", err.Error())
+	}
+	env.backend = backend
+
+	var retCode uint32
+	if err := env.call(FUNC_ENUM, func() { env.infos, retCode, err = backend.Enum() }); err != nil {
+		return result(CHECK_FAIL, "枚举设备超时", err.Error()).fix("重新插拔加密锁")
+	}
+	if err != nil {
+		return result(CHECK_FAIL, "枚举设备失败", fmt.Sprintf("错误码: 0x%08X, %v", retCode, err)).
+			fix("检查 usb-node 和 permissions 检查的结果")
+	}
+	if len(env.infos) == 0 {
+		return result(CHECK_FAIL, "动态库未发现设备").fix("检查 usb-node 和 permissions 检查的结果")
+	}
+
+	var evidence []string
+	for i, info := range env.infos {
+		evidence = append(evidence, fmt.Sprintf("设备 %d: HID %s, 产品ID 0x%08X, 版本 0x%04X, 母锁 %v", i, env.id(info.HID()), info.MPID, info.MVer, info.MIsMother != 0))
+	}
+	return result(CHECK_PASS, fmt.Sprintf("枚举到 %d 个设备", len(env.infos)), evidence...)
+}
+
+// checkOpen 打开第一个设备
+func checkOpen(env *diagEnv) *CheckResult {
+	var retCode uint32
+	var err error
+	if callErr := env.call(FUNC_OPEN, func() { env.handle, retCode, err = env.backend.Open(0) }); callErr != nil {
+		return result(CHECK_FAIL, "打开设备超时", callErr.Error()).fix("重新插拔加密锁")
+	}
+	if err != nil {
+		return result(CHECK_FAIL, "打开设备失败", fmt.Sprintf("错误码: 0x%08X, %v", retCode, err)).
+			fix("确认没有其他程序独占该设备")
+	}
+	env.opened = true
+	return result(CHECK_PASS, "设备打开成功", fmt.Sprintf("句柄: 0x%x", uintptr(env.handle)))
+}
+
+// checkRead 读取数据区开头
+func checkRead(env *diagEnv) *CheckResult {
+	buffer := make([]byte, 16)
+	var retCode uint32
+	var err error
+	if callErr := env.call(FUNC_READDATA, func() { retCode, err = env.backend.ReadData(env.handle, 0, buffer) }); callErr != nil {
+		return result(CHECK_FAIL, "读取数据区超时", callErr.Error()).fix("重新插拔加密锁")
+	}
+	if err != nil {
+		return result(CHECK_FAIL, "读取数据区失败", fmt.Sprintf("错误码: 0x%08X, %v", retCode, err)).
+			fix("检查库文件版本是否与硬件匹配")
+	}
+	return result(CHECK_PASS, "读取数据区成功", fmt.Sprintf("偏移 0, %d 字节", len(buffer)))
+}
+
+// checkClock 锁内时钟与系统时钟的偏差
+func checkClock(env *diagEnv) *CheckResult {
+	var utc, retCode uint32
+	var err error
+	if callErr := env.call(FUNC_GETUTCTIME, func() { utc, retCode, err = env.backend.GetUTCTime(env.handle) }); callErr != nil {
+		return result(CHECK_FAIL, "读取锁内时间超时", callErr.Error())
+	}
+	if err != nil {
+		return result(CHECK_WARN, "无法读取锁内时间", fmt.Sprintf("错误码: 0x%08X, %v", retCode, err))
+	}
+
+	dongleTime := time.Unix(int64(utc), 0).UTC()
+	now := time.Now().UTC()
+	skew := dongleTime.Sub(now).Round(time.Second)
+	evidence := []string{
+		fmt.Sprintf("锁内时间: %s", dongleTime.Format(time.RFC3339)),
+		fmt.Sprintf("系统时间: %s", now.Format(time.RFC3339)),
+		fmt.Sprintf("偏差: %v", skew),
+	}
+	if skew > CLOCK_MAX_SKEW || skew < -CLOCK_MAX_SKEW {
+		return result(CHECK_WARN, "锁内时钟与系统时钟偏差较大", evidence...).
+			fix("检查系统时间是否正确 (timedatectl)，有期限的授权以锁内时间为准")
+	}
+	return result(CHECK_PASS, "锁内时钟正常", evidence...)
+}
+
+// ============ 执行与输出 ============
+
+// runChecks 按顺序执行检查
+//
+// only 非空时只执行其中的检查以及它们依赖的前置检查；skip 中的检查不执行，
+// 依赖它们的检查也随之跳过。
+func runChecks(checks []Check, only, skip map[string]bool) []*CheckResult {
+	return runChecksWith(&diagEnv{}, checks, only, skip)
+}
+
+// runChecksWith 在指定的环境中执行检查，env 在返回前释放
+func runChecksWith(env *diagEnv, checks []Check, only, skip map[string]bool) []*CheckResult {
+	selected := make(map[string]bool)
+	if len(only) > 0 {
+		byName := make(map[string]Check)
+		for _, c := range checks {
+			byName[c.Name()] = c
+		}
+		var include func(name string)
+		include = func(name string) {
+			if selected[name] {
+				return
+			}
+			selected[name] = true
+			for _, req := range byName[name].Requires() {
+				include(req)
+			}
+		}
+		for name := range only {
+			include(name)
+		}
+	}
+
+	defer env.close()
+
+	var results []*CheckResult
+	status := make(map[string]Severity)
+	for _, c := range checks {
+		if len(only) > 0 && !selected[c.Name()] {
+			continue
+		}
+
+		var r *CheckResult
+		if skip[c.Name()] {
+			r = result(CHECK_SKIP, "按 -skip 跳过")
+		} else {
+			for _, req := range c.Requires() {
+				if s, ok := status[req]; ok && s != CHECK_PASS && s != CHECK_WARN {
+					r = result(CHECK_SKIP, fmt.Sprintf("前置检查 %s 未通过", req))
+					break
+				}
+			}
+		}
+		if r == nil {
+			start := time.Now()
+			r = c.Run(env)
+			r.DurationMS = float64(time.Since(start).Microseconds()) / 1000
+		}
+		r.Name = c.Name()
+		status[c.Name()] = r.Severity
+		results = append(results, r)
+	}
+	return results
+}
+
+// parseCheckNames 解析逗号分隔的检查名
+func parseCheckNames(s string, checks []Check) (map[string]bool, error) {
+	names := make(map[string]bool)
+	if s == "" {
+		return names, nil
+	}
+	known := make(map[string]bool)
+	var all []string
+	for _, c := range checks {
+		known[c.Name()] = true
+		all = append(all, c.Name())
+	}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if !known[name] {
+			return nil, fmt.Errorf("未知的检查: %s (可选 %s)", name, strings.Join(all, ", "))
+		}
+		names[name] = true
+	}
+	return names, nil
+}
+
+// checkCounts 各级别的数量
+func checkCounts(results []*CheckResult) map[Severity]int {
+	counts := make(map[Severity]int)
+	for _, r := range results {
+		counts[r.Severity]++
+	}
+	return counts
+}
+
+// writeChecksText 以文本输出结果
+func writeChecksText(w io.Writer, results []*CheckResult) {
+	fmt.Fprintln(w, "=== Rockey-ARM 诊断 ===")
+	fmt.Fprintf(w, "操作系统: %s, 架构: %s, Go版本: %s\n\n", runtime.GOOS, runtime.GOARCH, runtime.Version())
+	marks := map[Severity]string{CHECK_PASS: "✓", CHECK_WARN: "⚠", CHECK_FAIL: "✗", CHECK_SKIP: "-"}
+	for _, r := range results {
+		fmt.Fprintf(w, "%s [%s] %-14s %s\n", marks[r.Severity], strings.ToUpper(r.Severity.String()), r.Name, r.Summary)
+		for _, e := range r.Evidence {
+			fmt.Fprintf(w, "      %s\n", e)
+		}
+		for _, f := range r.Remediation {
+			fmt.Fprintf(w, "    → %s\n", f)
+		}
+	}
+	counts := checkCounts(results)
+	fmt.Fprintf(w, "\n通过 %d, 警告 %d, 失败 %d, 跳过 %d\n",
+		counts[CHECK_PASS], counts[CHECK_WARN], counts[CHECK_FAIL], counts[CHECK_SKIP])
+}
+
+// checkReport JSON 输出
+type checkReport struct {
+	Created time.Time      `json:"created"` // 诊断时间
+	GOOS    string         `json:"goos"`    // 操作系统
+	GOARCH  string         `json:"goarch"`  // 架构
+	Checks  []*CheckResult `json:"checks"`  // 检查结果
+	Summary map[string]int `json:"summary"` // 各级别的数量
+}
+
+// writeChecksJSON 以 JSON 输出结果
+func writeChecksJSON(w io.Writer, results []*CheckResult) error {
+	summary := make(map[string]int)
+	for s, name := range severityNames {
+		summary[name] = checkCounts(results)[s]
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(checkReport{
+		Created: time.Now().UTC(),
+		GOOS:    runtime.GOOS,
+		GOARCH:  runtime.GOARCH,
+		Checks:  results,
+		Summary: summary,
+	})
+}
+
+// JUnit XML 结构，警告视为通过并写入 system-out
+type junitTestSuite struct {
+	XMLName  xml.Name        `xml:"testsuite"`
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// writeChecksJUnit 以 JUnit XML 输出结果
+func writeChecksJUnit(w io.Writer, results []*CheckResult) error {
+	suite := junitTestSuite{Name: "rockey-diagnose", Tests: len(results)}
+	var total float64
+	for _, r := range results {
+		details := strings.Join(append(append([]string{}, r.Evidence...), r.Remediation...), "\n")
+		tc := junitTestCase{Name: r.Name, ClassName: "diagnose", Time: fmt.Sprintf("%.3f", r.DurationMS/1000)}
+		switch r.Severity {
+		case CHECK_FAIL:
+			suite.Failures++
+			tc.Failure = &junitMessage{Message: r.Summary, Body: details}
+		case CHECK_SKIP:
+			suite.Skipped++
+			tc.Skipped = &junitMessage{Message: r.Summary}
+		case CHECK_WARN:
+			tc.SystemOut = "WARN: " + r.Summary + "\n" + details
+		default:
+			tc.SystemOut = details
+		}
+		total += r.DurationMS / 1000
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	suite.Time = fmt.Sprintf("%.3f", total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suite); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// runDiagnoseCommand 运行诊断检查，有检查失败时返回错误
+func runDiagnoseCommand(args []string) error {
+	fs := flag.NewFlagSet("diagnose", flag.ContinueOnError)
+	format := fs.String("format", "text", "输出格式: text, json 或 junit")
+	onlyNames := fs.String("only", "", "只执行这些检查及其前置检查 (逗号分隔)")
+	skipNames := fs.String("skip", "", "跳过这些检查 (逗号分隔)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	only, err := parseCheckNames(*onlyNames, diagnosticChecks)
+	if err != nil {
+		return err
+	}
+	skip, err := parseCheckNames(*skipNames, diagnosticChecks)
+	if err != nil {
+		return err
+	}
+
+	var write func(io.Writer, []*CheckResult) error
+	switch *format {
+	case "text":
+		write = func(w io.Writer, results []*CheckResult) error {
+			writeChecksText(w, results)
+			return nil
+		}
+	case "json":
+		write = writeChecksJSON
+	case "junit":
+		write = writeChecksJUnit
+	default:
+		return fmt.Errorf("不支持的输出格式: %s (可选 text, json, junit)", *format)
+	}
+
+	results := runChecks(diagnosticChecks, only, skip)
+	if err := write(os.Stdout, results); err != nil {
+		return err
+	}
+	if failed := checkCounts(results)[CHECK_FAIL]; failed > 0 {
+		return fmt.Errorf("%d 项检查失败", failed)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// fakeChecks 返回一组依赖关系为 a <- b <- c、d 独立的检查，severity 指定各检查的结果
+func fakeChecks(ran *[]string, severity map[string]Severity) []Check {
+	check := func(name string, requires ...string) Check {
+		return checkFunc{name, requires, func(env *diagEnv) *CheckResult {
+			*ran = append(*ran, name)
+			return result(severity[name], name)
+		}}
+	}
+	return []Check{check("a"), check("b", "a"), check("c", "b"), check("d")}
+}
+
+func TestRunChecks(t *testing.T) {
+	cases := []struct {
+		name     string
+		only     map[string]bool
+		skip     map[string]bool
+		severity map[string]Severity
+		want     string // 各检查的 名称=级别
+		ran      string // 实际执行的检查
+	}{
+		{"全部通过", nil, nil, nil, "a=pass b=pass c=pass d=pass", "a b c d"},
+		{"-only 带上前置检查", map[string]bool{"c": true}, nil, nil, "a=pass b=pass c=pass", "a b c"},
+		{"-only 独立检查", map[string]bool{"d": true}, nil, nil, "d=pass", "d"},
+		{"-skip 跳过依赖链", nil, map[string]bool{"a": true}, nil, "a=skip b=skip c=skip d=pass", "d"},
+		{"-only 与 -skip", map[string]bool{"c": true}, map[string]bool{"b": true}, nil, "a=pass b=skip c=skip", "a"},
+		{"前置检查失败", nil, nil, map[string]Severity{"b": CHECK_FAIL}, "a=pass b=fail c=skip d=pass", "a b d"},
+		{"前置检查警告不影响后续", nil, nil, map[string]Severity{"a": CHECK_WARN}, "a=warn b=pass c=pass d=pass", "a b c d"},
+	}
+	for _, tc := range cases {
+		var ran []string
+		results := runChecks(fakeChecks(&ran, tc.severity), tc.only, tc.skip)
+		var got []string
+		for _, r := range results {
+			got = append(got, r.Name+"="+r.Severity.String())
+		}
+		if strings.Join(got, " ") != tc.want {
+			t.Errorf("%s: 结果 %v, 期望 %s", tc.name, got, tc.want)
+		}
+		if strings.Join(ran, " ") != tc.ran {
+			t.Errorf("%s: 执行了 %v, 期望 %s", tc.name, ran, tc.ran)
+		}
+	}
+
+	// 跳过的原因
+	var ran []string
+	results := runChecks(fakeChecks(&ran, map[string]Severity{"a": CHECK_FAIL}), nil, map[string]bool{"d": true})
+	if s := results[1].Summary; s != "前置检查 a 未通过" {
+		t.Errorf("b: %s", s)
+	}
+	if s := results[3].Summary; s != "按 -skip 跳过" {
+		t.Errorf("d: %s", s)
+	}
+}
+
+// TestDiagEnvCallStuck 之前有调用卡住时检查不再进入动态库
+func TestDiagEnvCallStuck(t *testing.T) {
+	stuckCalls.Add(1)
+	defer stuckCalls.Add(-1)
+
+	env := &diagEnv{}
+	called := false
+	if err := env.call(FUNC_ENUM, func() { called = true }); err == nil || called {
+		t.Fatalf("call = %v, 调用了 %v", err, called)
+	}
+	if !env.stuck {
+		t.Error("env.stuck = false")
+	}
+}
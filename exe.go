@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// ============ 锁内可执行程序 ============
+
+// 可执行程序调用权限
+const (
+	EXE_PRIV_ANONYMOUS = 0 // 匿名可调用
+	EXE_PRIV_USER      = 1 // 需要用户权限
+	EXE_PRIV_ADMIN     = 2 // 需要开发商权限
+)
+
+// 可执行程序限制
+const (
+	EXE_MAX_FILE_SIZE   = 0xFFFF // 文件大小字段为 WORD
+	EXE_INOUT_BUFFER_SZ = 1024   // RunExeFile 输入输出缓冲区最大长度
+)
+
+// ExeFileInfo 可执行文件信息，对应 SDK 的 EXE_FILE_INFO
+type ExeFileInfo struct {
+	MSize   uint16         // 文件大小
+	MFileID uint16         // 文件ID
+	MPriv   uint8          // 调用权限
+	MData   unsafe.Pointer // 文件数据
+}
+
+// ExeResult 可执行程序运行结果
+type ExeResult struct {
+	RetCode uint32 // 接口返回码
+	MainRet int32  // 锁内程序 main 函数返回值
+	Output  []byte // 输出数据
+}
+
+// String 返回运行结果的描述
+func (r ExeResult) String() string {
+	if r.RetCode != DONGLE_SUCCESS {
+		return fmt.Sprintf("调用失败: 0x%08X (%s)", r.RetCode, getErrorDescription(r.RetCode))
+	}
+	if r.MainRet == 0 {
+		return "运行成功: main 返回 0"
+	}
+	return fmt.Sprintf("程序返回错误: main 返回 %d (0x%08X)", r.MainRet, uint32(r.MainRet))
+}
+
+// downloadExeFile 下载可执行文件到加密锁，需要先校验开发商PIN
+func downloadExeFile(downloadFunc uintptr, handle DongleHandle, fileID uint16, priv uint8, data []byte) (uint32, error) {
+	if handle == 0 {
+		return DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+	if len(data) == 0 || len(data) > EXE_MAX_FILE_SIZE {
+		return DONGLE_INVALID_SIZE, fmt.Errorf("%s: 可执行文件大小 %d 超出范围 (1-%d)", getErrorDescription(DONGLE_INVALID_SIZE), len(data), EXE_MAX_FILE_SIZE)
+	}
+	if priv > EXE_PRIV_ADMIN {
+		return DONGLE_INVALID_PARAMETER, fmt.Errorf("%s: 调用权限 %d", getErrorDescription(DONGLE_INVALID_PARAMETER), priv)
+	}
+
+	// 函数原型: DWORD Dongle_DownloadExeFile(DONGLE_HANDLE hDongle, EXE_FILE_INFO* pExeFileInfo, int nCount)
+	type DownloadExeFileFuncType func(handle DongleHandle, info *ExeFileInfo, count int32) uint32
+
+	var downloadFuncGo DownloadExeFileFuncType
+	purego.RegisterFunc(&downloadFuncGo, downloadFunc)
+
+	// 结构体中含有指向 data 的指针，调用期间固定两者，避免被移动或回收
+	info := &ExeFileInfo{
+		MSize:   uint16(len(data)),
+		MFileID: fileID,
+		MPriv:   priv,
+		MData:   unsafe.Pointer(&data[0]),
+	}
+	var pinner runtime.Pinner
+	pinner.Pin(info)
+	pinner.Pin(&data[0])
+	defer pinner.Unpin()
+
+	start := time.Now()
+	retCode := downloadFuncGo(handle, info, 1)
+	traceCall(FUNC_DOWNLOADEXEFILE, handle, retCode, start, "file_id", fmt.Sprintf("0x%04X", fileID), "size", len(data), "priv", priv)
+
+	if retCode != DONGLE_SUCCESS {
+		return retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+	return retCode, nil
+}
+
+// runExeFile 运行锁内可执行文件，input 作为输入，输出长度与缓冲区长度相同
+func runExeFile(runFunc uintptr, handle DongleHandle, fileID uint16, input []byte, bufferSize int) (ExeResult, error) {
+	if handle == 0 {
+		return ExeResult{RetCode: DONGLE_INVALID_HANDLE}, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+	if bufferSize < len(input) {
+		bufferSize = len(input)
+	}
+	if bufferSize == 0 || bufferSize > EXE_INOUT_BUFFER_SZ {
+		return ExeResult{RetCode: DONGLE_INVALID_SIZE}, fmt.Errorf("%s: 输入输出缓冲区 %d 超出范围 (1-%d)", getErrorDescription(DONGLE_INVALID_SIZE), bufferSize, EXE_INOUT_BUFFER_SZ)
+	}
+
+	// 函数原型: DWORD Dongle_RunExeFile(DONGLE_HANDLE hDongle, WORD wFileID, BYTE* pInOutBuf, WORD wInOutBufLen, int* pMainRet)
+	type RunExeFileFuncType func(handle DongleHandle, fileID uint16, inOut unsafe.Pointer, size uint16, mainRet *int32) uint32
+
+	var runFuncGo RunExeFileFuncType
+	purego.RegisterFunc(&runFuncGo, runFunc)
+
+	buffer := make([]byte, bufferSize)
+	copy(buffer, input)
+
+	var mainRet int32
+	start := time.Now()
+	retCode := runFuncGo(handle, fileID, unsafe.Pointer(&buffer[0]), uint16(bufferSize), &mainRet)
+	traceCall(FUNC_RUNEXEFILE, handle, retCode, start, "file_id", fmt.Sprintf("0x%04X", fileID), "main_ret", mainRet)
+
+	result := ExeResult{RetCode: retCode, MainRet: mainRet}
+	if retCode != DONGLE_SUCCESS {
+		return result, fmt.Errorf(getErrorDescription(retCode))
+	}
+	result.Output = buffer
+	return result, nil
+}
+
+// ============ 版本标签 ============
+//
+// 锁内可执行文件无法读回，因此下载时在一个专用数据文件中记录每个文件ID
+// 对应的版本号和摘要，用于确认每把锁上的算法版本。标签不占用数据区；
+// 文件ID 可以用 -tag-file 修改，已存在且不是标签格式的文件不会被覆盖。
+
+// 版本标签文件布局
+const (
+	EXE_TAG_FILE_ID    = 0x0E00 // 默认标签文件ID
+	EXE_TAG_AREA_SIZE  = 256    // 标签文件大小
+	EXE_TAG_MAGIC      = "RKEX" // 标签文件标识
+	EXE_TAG_HEADER_SZ  = 8      // 标识(4) + 数量(2) + 保留(2)
+	EXE_TAG_ENTRY_SZ   = 32     // 每条记录大小
+	EXE_TAG_VERSION_SZ = 20     // 版本号最大长度
+	EXE_TAG_MAX        = (EXE_TAG_AREA_SIZE - EXE_TAG_HEADER_SZ) / EXE_TAG_ENTRY_SZ
+)
+
+// exeTag 可执行文件版本标签
+type exeTag struct {
+	FileID  uint16  // 文件ID
+	Size    uint16  // 文件大小
+	Digest  [8]byte // SHA-256 摘要前 8 字节
+	Version string  // 版本号
+}
+
+// newExeTag 根据文件内容生成版本标签
+func newExeTag(fileID uint16, version string, data []byte) (exeTag, error) {
+	if len(version) > EXE_TAG_VERSION_SZ {
+		return exeTag{}, fmt.Errorf("版本号过长: 最多 %d 字节", EXE_TAG_VERSION_SZ)
+	}
+	sum := sha256.Sum256(data)
+	tag := exeTag{FileID: fileID, Size: uint16(len(data)), Version: version}
+	copy(tag.Digest[:], sum[:])
+	return tag, nil
+}
+
+// decodeExeTags 解析标签区域，未初始化时返回空列表
+func decodeExeTags(area []byte) []exeTag {
+	if len(area) < EXE_TAG_HEADER_SZ || string(area[:4]) != EXE_TAG_MAGIC {
+		return nil
+	}
+	count := int(binary.LittleEndian.Uint16(area[4:6]))
+	if count > EXE_TAG_MAX {
+		count = EXE_TAG_MAX
+	}
+
+	tags := make([]exeTag, 0, count)
+	for i := 0; i < count; i++ {
+		entry := area[EXE_TAG_HEADER_SZ+i*EXE_TAG_ENTRY_SZ:][:EXE_TAG_ENTRY_SZ]
+		var tag exeTag
+		tag.FileID = binary.LittleEndian.Uint16(entry[0:2])
+		tag.Size = binary.LittleEndian.Uint16(entry[2:4])
+		copy(tag.Digest[:], entry[4:12])
+		tag.Version = string(bytes.TrimRight(entry[12:12+EXE_TAG_VERSION_SZ], "\x00"))
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// encodeExeTags 编码标签区域
+func encodeExeTags(tags []exeTag) ([]byte, error) {
+	if len(tags) > EXE_TAG_MAX {
+		return nil, fmt.Errorf("版本标签数量 %d 超过上限 %d", len(tags), EXE_TAG_MAX)
+	}
+	area := make([]byte, EXE_TAG_AREA_SIZE)
+	copy(area, EXE_TAG_MAGIC)
+	binary.LittleEndian.PutUint16(area[4:6], uint16(len(tags)))
+	for i, tag := range tags {
+		entry := area[EXE_TAG_HEADER_SZ+i*EXE_TAG_ENTRY_SZ:][:EXE_TAG_ENTRY_SZ]
+		binary.LittleEndian.PutUint16(entry[0:2], tag.FileID)
+		binary.LittleEndian.PutUint16(entry[2:4], tag.Size)
+		copy(entry[4:12], tag.Digest[:])
+		copy(entry[12:12+EXE_TAG_VERSION_SZ], tag.Version)
+	}
+	return area, nil
+}
+
+// readExeTags 从标签文件读取版本标签
+func readExeTags(c *deviceConn, fileID uint16) ([]exeTag, error) {
+	area := make([]byte, EXE_TAG_AREA_SIZE)
+	if err := c.readDataFile(fileID, area); err != nil {
+		return nil, err
+	}
+	return decodeExeTags(area), nil
+}
+
+// writeExeTag 更新或追加一个文件的版本标签，需要开发商权限
+//
+// 标签文件不存在时创建；已存在但内容不是标签格式 (也不是全零) 时报错，
+// 避免覆盖客户自己的文件。
+func writeExeTag(c *deviceConn, fileID uint16, tag exeTag) error {
+	list, err := c.listFiles()
+	if err != nil {
+		return fmt.Errorf("列举数据文件失败: %v", err)
+	}
+	exists := false
+	for _, item := range list {
+		if item.MFileID == fileID {
+			if item.MAttr.MSize != EXE_TAG_AREA_SIZE {
+				return fmt.Errorf("文件ID 0x%04X 已被占用 (大小 %d)，请用 -tag-file 指定其他文件ID", fileID, item.MAttr.MSize)
+			}
+			exists = true
+		}
+	}
+
+	var tags []exeTag
+	if exists {
+		area := make([]byte, EXE_TAG_AREA_SIZE)
+		if err := c.readDataFile(fileID, area); err != nil {
+			return fmt.Errorf("读取标签文件失败: %v", err)
+		}
+		if string(area[:4]) != EXE_TAG_MAGIC && !bytes.Equal(area, make([]byte, EXE_TAG_AREA_SIZE)) {
+			return fmt.Errorf("文件ID 0x%04X 不是版本标签文件，请用 -tag-file 指定其他文件ID", fileID)
+		}
+		tags = decodeExeTags(area)
+	}
+
+	replaced := false
+	for i := range tags {
+		if tags[i].FileID == tag.FileID {
+			tags[i] = tag
+			replaced = true
+		}
+	}
+	if !replaced {
+		tags = append(tags, tag)
+	}
+
+	area, err := encodeExeTags(tags)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		attr := DataFileAttr{MSize: EXE_TAG_AREA_SIZE, MReadPriv: FILE_PRIV_ANONYMOUS, MWritePriv: FILE_PRIV_ADMIN}
+		return c.createDataFileWithContent(fileID, attr, area)
+	}
+	return c.writeDataFile(fileID, area)
+}
+
+// showExeTags 显示版本标签
+func showExeTags(tags []exeTag) {
+	if len(tags) == 0 {
+		fmt.Println("未记录任何可执行文件版本")
+		return
+	}
+	fmt.Printf("%-8s %-8s %-20s %s\n", "文件ID", "大小", "版本", "摘要")
+	for _, tag := range tags {
+		fmt.Printf("0x%04X   %-8d %-20s %s\n", tag.FileID, tag.Size, tag.Version, hex.EncodeToString(tag.Digest[:]))
+	}
+}
+
+// ============ 命令行 ============
+
+// runExeCommand 执行 exe 子命令
+func runExeCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("用法: exe download|run|list [选项]")
+	}
+	action := args[0]
+
+	fs := flag.NewFlagSet("exe "+action, flag.ContinueOnError)
+	index := fs.Int("device", 0, "设备序号")
+	fileIDFlag := fs.String("file", "0", "可执行文件ID")
+	tagFileFlag := fs.String("tag-file", fmt.Sprintf("0x%04X", EXE_TAG_FILE_ID), "记录版本标签的数据文件ID")
+	var fileID, tagFile uint16
+	parseIDs := func() error {
+		var err error
+		if fileID, err = parseFileID(*fileIDFlag); err != nil {
+			return fmt.Errorf("-file: %v", err)
+		}
+		if tagFile, err = parseFileID(*tagFileFlag); err != nil {
+			return fmt.Errorf("-tag-file: %v", err)
+		}
+		return nil
+	}
+
+	switch action {
+	case "download":
+		priv := fs.Int("priv", EXE_PRIV_ANONYMOUS, "调用权限: 0=匿名, 1=用户, 2=开发商")
+		version := fs.String("tag", "", "版本标签，记录到标签文件")
+		pin := fs.String("pin", DEFAULT_ADMIN_PIN, "开发商PIN")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return fmt.Errorf("用法: exe download -file ID [-priv N] [-tag 版本] [-tag-file ID] [-pin PIN] program.bin")
+		}
+		if err := parseIDs(); err != nil {
+			return err
+		}
+		data, err := os.ReadFile(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		var tag exeTag
+		if *version != "" {
+			if tag, err = newExeTag(fileID, *version, data); err != nil {
+				return err
+			}
+		}
+
+		ctx, stop := commandContext()
+		defer stop()
+
+		conn, err := connectDevice(ctx, *index)
+		if err != nil {
+			return err
+		}
+		defer conn.close()
+
+		if err := conn.verifyPIN(FLAG_ADMINPIN, *pin); err != nil {
+			return fmt.Errorf("校验开发商PIN失败: %v", err)
+		}
+		downloadFunc, err := conn.proc(FUNC_DOWNLOADEXEFILE)
+		if err != nil {
+			return err
+		}
+		err = conn.call(FUNC_DOWNLOADEXEFILE, func() (err error) {
+			_, err = downloadExeFile(downloadFunc, conn.handle, fileID, uint8(*priv), data)
+			return
+		})
+		if err != nil {
+			return fmt.Errorf("下载失败: %v", err)
+		}
+		fmt.Printf("已下载 %s 到文件ID 0x%04X (%d 字节)\n", fs.Arg(0), fileID, len(data))
+
+		if *version != "" {
+			if err := writeExeTag(conn, tagFile, tag); err != nil {
+				return fmt.Errorf("写入版本标签失败: %v", err)
+			}
+			fmt.Printf("已记录版本标签: %s (标签文件 0x%04X)\n", *version, tagFile)
+		}
+		return nil
+
+	case "run":
+		hexData := fs.String("hex", "", "输入数据（十六进制）")
+		size := fs.Int("size", 0, "输入输出缓冲区长度，默认等于输入长度")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if err := parseIDs(); err != nil {
+			return err
+		}
+		var input []byte
+		if *hexData != "" {
+			var err error
+			if input, err = parseHexInput(*hexData); err != nil {
+				return err
+			}
+		}
+
+		ctx, stop := commandContext()
+		defer stop()
+
+		conn, err := connectDevice(ctx, *index)
+		if err != nil {
+			return err
+		}
+		defer conn.close()
+
+		runFunc, err := conn.proc(FUNC_RUNEXEFILE)
+		if err != nil {
+			return err
+		}
+		var result ExeResult
+		err = conn.call(FUNC_RUNEXEFILE, func() (err error) {
+			result, err = runExeFile(runFunc, conn.handle, fileID, input, *size)
+			return
+		})
+		fmt.Println(result)
+		if err != nil {
+			return err
+		}
+		fmt.Println("输出数据:")
+		showBinHex(result.Output)
+		fmt.Println(hex.EncodeToString(result.Output))
+		if result.MainRet != 0 {
+			return fmt.Errorf("锁内程序返回 %d", result.MainRet)
+		}
+		return nil
+
+	case "list":
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if err := parseIDs(); err != nil {
+			return err
+		}
+		ctx, stop := commandContext()
+		defer stop()
+
+		conn, err := connectDevice(ctx, *index)
+		if err != nil {
+			return err
+		}
+		defer conn.close()
+
+		tags, err := readExeTags(conn, tagFile)
+		if err != nil {
+			return fmt.Errorf("读取标签文件 0x%04X 失败: %v", tagFile, err)
+		}
+		showExeTags(tags)
+		return nil
+
+	default:
+		return fmt.Errorf("未知操作: exe %s", action)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// ============ 文件操作 ============
+
+// parseFileID 解析命令行中的文件ID，0x 开头为十六进制，否则为十进制
+func parseFileID(s string) (uint16, error) {
+	digits, base := strings.TrimSpace(s), 10
+	if hex, ok := strings.CutPrefix(strings.ToLower(digits), "0x"); ok {
+		digits, base = hex, 16
+	}
+	v, err := strconv.ParseUint(digits, base, 16)
+	if err != nil {
+		return 0, fmt.Errorf("无效的文件ID: %s (十进制或以 0x 开头的十六进制，不超过 0xFFFF)", s)
+	}
+	return uint16(v), nil
+}
+
+// 文件类型
+const (
+	FILE_DATA          = 1 // 数据文件
+	FILE_PRIKEY_RSA    = 2 // RSA私钥文件
+	FILE_PRIKEY_ECCSM2 = 3 // ECC/SM2私钥文件
+	FILE_KEY           = 4 // 对称密钥文件
+	FILE_EXE           = 5 // 可执行文件
+)
+
+// 文件访问权限
+const (
+	FILE_PRIV_ANONYMOUS = 0 // 匿名
+	FILE_PRIV_USER      = 1 // 用户
+	FILE_PRIV_ADMIN     = 2 // 开发商
+)
+
+// DATA_FILE_MAX_SIZE 数据文件最大长度（偏移量字段为 WORD）
+const DATA_FILE_MAX_SIZE = 0xFFFF
+
+// DataFileAttr 数据文件属性，对应 SDK 的 DATA_FILE_ATTR
+type DataFileAttr struct {
+	MSize      uint32 // 文件大小
+	MReadPriv  uint16 // 读权限
+	MWritePriv uint16 // 写权限
+}
+
+// fileTypeNames 文件类型名称
+var fileTypeNames = map[int]string{
+	FILE_DATA:          "data",
+	FILE_PRIKEY_RSA:    "rsa",
+	FILE_PRIKEY_ECCSM2: "ecc",
+	FILE_KEY:           "key",
+	FILE_EXE:           "exe",
+}
+
+// parseFileType 解析文件类型名称
+func parseFileType(name string) (int, error) {
+	for t, n := range fileTypeNames {
+		if n == name {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("未知文件类型: %s", name)
+}
+
+// createDataFile 创建数据文件，需要开发商权限
+func createDataFile(createFileFunc uintptr, handle DongleHandle, fileID uint16, attr DataFileAttr) (uint32, error) {
+	if handle == 0 {
+		return DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+	if attr.MSize == 0 || attr.MSize > DATA_FILE_MAX_SIZE {
+		return DONGLE_INVALID_SIZE, fmt.Errorf("%s: 文件大小 %d 超出范围 (1-%d)", getErrorDescription(DONGLE_INVALID_SIZE), attr.MSize, DATA_FILE_MAX_SIZE)
+	}
+
+	// 函数原型: DWORD Dongle_CreateFile(DONGLE_HANDLE hDongle, int nFileType, WORD wFileID, void* pFileAttr)
+	type CreateFileFuncType func(handle DongleHandle, fileType int32, fileID uint16, attr unsafe.Pointer) uint32
+
+	var createFileFuncGo CreateFileFuncType
+	purego.RegisterFunc(&createFileFuncGo, createFileFunc)
+
+	start := time.Now()
+	retCode := createFileFuncGo(handle, FILE_DATA, fileID, unsafe.Pointer(&attr))
+	traceCall(FUNC_CREATEFILE, handle, retCode, start, "file_id", fmt.Sprintf("0x%04X", fileID))
+
+	if retCode != DONGLE_SUCCESS {
+		return retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+	return retCode, nil
+}
+
+// writeFile 写入文件
+func writeFile(writeFileFunc uintptr, handle DongleHandle, fileType int, fileID uint16, offset int, data []byte) (uint32, error) {
+	if handle == 0 {
+		return DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+	if len(data) == 0 {
+		return DONGLE_INVALID_BUFFER, fmt.Errorf(getErrorDescription(DONGLE_INVALID_BUFFER))
+	}
+	if retCode, err := checkRegion(int64(offset), len(data), DATA_FILE_MAX_SIZE+1); err != nil {
+		return retCode, err
+	}
+
+	// 函数原型: DWORD Dongle_WriteFile(DONGLE_HANDLE hDongle, int nFileType, WORD wFileID, WORD wOffset, BYTE* pInData, int nDataLen)
+	type WriteFileFuncType func(handle DongleHandle, fileType int32, fileID uint16, offset uint16, data unsafe.Pointer, size int32) uint32
+
+	var writeFileFuncGo WriteFileFuncType
+	purego.RegisterFunc(&writeFileFuncGo, writeFileFunc)
+
+	start := time.Now()
+	retCode := writeFileFuncGo(handle, int32(fileType), fileID, uint16(offset), unsafe.Pointer(&data[0]), int32(len(data)))
+	traceCall(FUNC_WRITEFILE, handle, retCode, start, "file_id", fmt.Sprintf("0x%04X", fileID), "offset", offset, "size", len(data))
+
+	if retCode != DONGLE_SUCCESS {
+		return retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+	return retCode, nil
+}
+
+// readFileTyped 按包含文件类型的 6 参数原型读取文件
+//
+// readFile 先尝试 5 参数原型，失败后才以数据文件类型尝试 6 参数原型；这里
+// 只调用 6 参数原型并使用指定的类型，用于确认不同库版本实际使用的原型。
+func readFileTyped(readFileFunc uintptr, handle DongleHandle, fileType int, fileID uint16, offset int, buffer []byte) (uint32, error) {
+	if handle == 0 {
+		return DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+	if len(buffer) == 0 {
+		return DONGLE_INVALID_BUFFER, fmt.Errorf(getErrorDescription(DONGLE_INVALID_BUFFER))
+	}
+
+	type ReadFileFuncType func(handle DongleHandle, fileType uintptr, fileID uintptr, offset uintptr, buffer unsafe.Pointer, size uintptr) uint32
+
+	var readFileFuncGo ReadFileFuncType
+	purego.RegisterFunc(&readFileFuncGo, readFileFunc)
+
+	start := time.Now()
+	retCode := readFileFuncGo(handle, uintptr(fileType), uintptr(fileID), uintptr(offset), unsafe.Pointer(&buffer[0]), uintptr(len(buffer)))
+	traceCall(FUNC_READFILE, handle, retCode, start, "args", 6, "file_type", fileType, "file_id", fmt.Sprintf("0x%04X", fileID), "offset", offset, "size", len(buffer))
+
+	if retCode != DONGLE_SUCCESS {
+		return retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+	return retCode, nil
+}
+
+// DataFileList 数据文件列表项，对应 SDK 的 DATA_FILE_LIST
+type DataFileList struct {
+	MFileID  uint16       // 文件ID
+	MReserve uint16       // 保留
+	MAttr    DataFileAttr // 文件属性
+}
+
+// LIST_FILE_MAX_COUNT 列举文件时最多返回的文件数
+const LIST_FILE_MAX_COUNT = 256
+
+// listDataFiles 列举数据文件，需要开发商权限
+func listDataFiles(listFileFunc uintptr, handle DongleHandle) ([]DataFileList, uint32, error) {
+	if handle == 0 {
+		return nil, DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+
+	// 函数原型: DWORD Dongle_ListFile(DONGLE_HANDLE hDongle, int nFileType, void* pFileList, int* pDataLen)
+	type ListFileFuncType func(handle DongleHandle, fileType int32, list unsafe.Pointer, dataLen *int32) uint32
+
+	var listFileFuncGo ListFileFuncType
+	purego.RegisterFunc(&listFileFuncGo, listFileFunc)
+
+	list := make([]DataFileList, LIST_FILE_MAX_COUNT)
+	dataLen := int32(len(list) * int(unsafe.Sizeof(list[0])))
+	start := time.Now()
+	retCode := listFileFuncGo(handle, FILE_DATA, unsafe.Pointer(&list[0]), &dataLen)
+	traceCall(FUNC_LISTFILE, handle, retCode, start, "len", dataLen)
+
+	// 没有文件时部分版本的库返回 DONGLE_INVALID_FILEID
+	if retCode == DONGLE_INVALID_FILEID {
+		return nil, DONGLE_SUCCESS, nil
+	}
+	if retCode != DONGLE_SUCCESS {
+		return nil, retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+
+	count := int(dataLen) / int(unsafe.Sizeof(list[0]))
+	if count < 0 || count > len(list) {
+		return nil, DONGLE_INVALID_SIZE, fmt.Errorf("%s: 文件列表长度 %d", getErrorDescription(DONGLE_INVALID_SIZE), dataLen)
+	}
+	return list[:count], retCode, nil
+}
+
+// deleteFile 删除文件，需要开发商权限
+func deleteFile(deleteFileFunc uintptr, handle DongleHandle, fileType int, fileID uint16) (uint32, error) {
+	if handle == 0 {
+		return DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+
+	// 函数原型: DWORD Dongle_DeleteFile(DONGLE_HANDLE hDongle, int nFileType, WORD wFileID)
+	type DeleteFileFuncType func(handle DongleHandle, fileType int32, fileID uint16) uint32
+
+	var deleteFileFuncGo DeleteFileFuncType
+	purego.RegisterFunc(&deleteFileFuncGo, deleteFileFunc)
+
+	start := time.Now()
+	retCode := deleteFileFuncGo(handle, int32(fileType), fileID)
+	traceCall(FUNC_DELETEFILE, handle, retCode, start, "file_id", fmt.Sprintf("0x%04X", fileID))
+
+	if retCode != DONGLE_SUCCESS {
+		return retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+	return retCode, nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestParseFileID(t *testing.T) {
+	cases := []struct {
+		in   string
+		want uint16
+	}{
+		{"16", 16},
+		{"0x16", 0x16},
+		{"0X0F00", 0x0F00},
+		{" 0x0001 ", 1},
+		{"0", 0},
+		{"65535", 0xFFFF},
+		{"010", 10},
+	}
+	for _, tc := range cases {
+		got, err := parseFileID(tc.in)
+		if err != nil || got != tc.want {
+			t.Errorf("parseFileID(%q) = 0x%04X, %v, 期望 0x%04X", tc.in, got, err, tc.want)
+		}
+	}
+	for _, bad := range []string{"", "0x", "0F00", "65536", "0x10000", "-1", "abc"} {
+		if _, err := parseFileID(bad); err == nil {
+			t.Errorf("parseFileID(%q) 应返回错误", bad)
+		}
+	}
+}
@@ -4,4 +4,5 @@ go 1.22
 
 require (
     github.com/ebitengine/purego v0.9.1
+    gopkg.in/yaml.v3 v3.0.1
 )
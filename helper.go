@@ -0,0 +1,749 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ============ 辅助进程 ============
+//
+// 动态库在本进程内 Dlopen，库内部崩溃（例如函数原型不匹配）会直接带走整个
+// 程序。helperBackend 把动态库放到单独的辅助进程中：辅助进程就是本程序以
+// 隐藏命令 HELPER_COMMAND 重新启动，加载动态库后通过一对管道以紧凑的二进制
+// 协议处理请求。辅助进程崩溃时调用方得到带退出状态的 *HelperExitError，
+// 调用超时被终止时得到 *HelperTimeoutError，下一次调用自动重启辅助进程；旧进程中打开的句柄随之失效，返回无效句柄，
+// Session 会按硬件ID重连。
+//
+// 帧格式 (小端)：
+//
+//	请求: 长度(4) 操作码(1) 参数...
+//	响应: 长度(4) 返回码(4) 错误信息(字节串) 结果...
+//
+// 长度不含自身的 4 字节；字节串为 长度(4) + 内容。辅助进程启动后先发送一个
+// 操作码为 0 的响应，返回码非零表示动态库加载失败。
+
+// HELPER_COMMAND 启动辅助进程的隐藏命令
+const HELPER_COMMAND = "__native-helper"
+
+// 协议参数
+const (
+	HELPER_MAX_FRAME = 1 << 20 // 单帧最大长度
+	HELPER_REQ_FD    = 3       // 辅助进程读取请求的文件描述符
+	HELPER_RESP_FD   = 4       // 辅助进程写入响应的文件描述符
+)
+
+// 操作码
+const (
+	HELPER_OP_ENUM byte = iota + 1
+	HELPER_OP_OPEN
+	HELPER_OP_CLOSE
+	HELPER_OP_READFILE
+	HELPER_OP_READDATA
+	HELPER_OP_WRITEDATA
+	HELPER_OP_VERIFYPIN
+	HELPER_OP_SEED
+	HELPER_OP_GETDEADLINE
+	HELPER_OP_GETUTCTIME
+	HELPER_OP_GENRANDOM
+	HELPER_OP_ECCSIGN
+)
+
+// helperFunctions 操作码对应的函数名，用于错误信息
+var helperFunctions = map[byte]string{
+	HELPER_OP_ENUM:        FUNC_ENUM,
+	HELPER_OP_OPEN:        FUNC_OPEN,
+	HELPER_OP_CLOSE:       FUNC_CLOSE,
+	HELPER_OP_READFILE:    FUNC_READFILE,
+	HELPER_OP_READDATA:    FUNC_READDATA,
+	HELPER_OP_WRITEDATA:   FUNC_WRITEDATA,
+	HELPER_OP_VERIFYPIN:   FUNC_VERIFYPIN,
+	HELPER_OP_SEED:        FUNC_SEED,
+	HELPER_OP_GETDEADLINE: FUNC_GETDEADLINE,
+	HELPER_OP_GETUTCTIME:  FUNC_GETUTCTIME,
+	HELPER_OP_GENRANDOM:   FUNC_GENRANDOM,
+	HELPER_OP_ECCSIGN:     FUNC_ECCSIGN,
+}
+
+// HelperExitError 辅助进程在调用过程中退出
+type HelperExitError struct {
+	Function string // 正在执行的函数
+	ExitCode int    // 退出码，被信号终止时为 -1
+	Status   string // 退出状态描述，例如 "signal: segmentation fault"
+}
+
+func (e *HelperExitError) Error() string {
+	return fmt.Sprintf("%s: 辅助进程异常退出 (%s)", e.Function, e.Status)
+}
+
+// HelperTimeoutError 调用超时，辅助进程被终止
+//
+// 与 callNative 的超时一样无法确定调用是否已经生效，errors.Is 匹配
+// context.DeadlineExceeded，isCallAborted 返回 true。
+type HelperTimeoutError struct {
+	Function string        // 正在执行的函数
+	Timeout  time.Duration // 单次调用超时
+}
+
+func (e *HelperTimeoutError) Error() string {
+	return fmt.Sprintf("%s: 辅助进程在 %v 内未响应，已终止", e.Function, e.Timeout)
+}
+
+func (e *HelperTimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// ============ 编解码 ============
+
+// frameWriter 构造一帧的内容
+type frameWriter struct {
+	buf []byte
+}
+
+func (w *frameWriter) u8(v byte)    { w.buf = append(w.buf, v) }
+func (w *frameWriter) u16(v uint16) { w.buf = binary.LittleEndian.AppendUint16(w.buf, v) }
+func (w *frameWriter) u32(v uint32) { w.buf = binary.LittleEndian.AppendUint32(w.buf, v) }
+func (w *frameWriter) u64(v uint64) { w.buf = binary.LittleEndian.AppendUint64(w.buf, v) }
+
+func (w *frameWriter) bytes(v []byte) {
+	w.u32(uint32(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+// frameReader 按顺序解析一帧的内容，出错后后续读取均返回零值
+type frameReader struct {
+	buf []byte
+	err error
+}
+
+func (r *frameReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.buf) {
+		r.err = fmt.Errorf("帧数据不完整")
+		return nil
+	}
+	v := r.buf[:n]
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *frameReader) u8() byte {
+	if v := r.take(1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+func (r *frameReader) u16() uint16 {
+	if v := r.take(2); v != nil {
+		return binary.LittleEndian.Uint16(v)
+	}
+	return 0
+}
+
+func (r *frameReader) u32() uint32 {
+	if v := r.take(4); v != nil {
+		return binary.LittleEndian.Uint32(v)
+	}
+	return 0
+}
+
+func (r *frameReader) u64() uint64 {
+	if v := r.take(8); v != nil {
+		return binary.LittleEndian.Uint64(v)
+	}
+	return 0
+}
+
+func (r *frameReader) bytes() []byte {
+	n := r.u32()
+	if v := r.take(int(n)); v != nil {
+		return append([]byte(nil), v...)
+	}
+	return nil
+}
+
+// writeFrame 写入一帧
+func writeFrame(w io.Writer, payload []byte) error {
+	frame := make([]byte, 4, 4+len(payload))
+	binary.LittleEndian.PutUint32(frame, uint32(len(payload)))
+	_, err := w.Write(append(frame, payload...))
+	return err
+}
+
+// readFrame 读取一帧
+func readFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	n := binary.LittleEndian.Uint32(header[:])
+	if n > HELPER_MAX_FRAME {
+		return nil, fmt.Errorf("帧长度 %d 超过上限 %d", n, HELPER_MAX_FRAME)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// encodeResponse 构造响应帧内容
+func encodeResponse(retCode uint32, err error, result func(w *frameWriter)) []byte {
+	var w frameWriter
+	w.u32(retCode)
+	if err != nil {
+		w.bytes([]byte(err.Error()))
+	} else {
+		w.bytes(nil)
+	}
+	if result != nil {
+		result(&w)
+	}
+	return w.buf
+}
+
+// ============ 辅助进程端 ============
+
+// serveHelper 从 r 读取请求，调用 backend 后把响应写入 w，直到 r 关闭
+func serveHelper(backend Backend, r io.Reader, w io.Writer) error {
+	in := bufio.NewReader(r)
+	for {
+		payload, err := readFrame(in)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := writeFrame(w, handleHelperRequest(backend, payload)); err != nil {
+			return err
+		}
+	}
+}
+
+// handleHelperRequest 执行一个请求并返回响应帧内容
+func handleHelperRequest(backend Backend, payload []byte) []byte {
+	req := &frameReader{buf: payload}
+	op := req.u8()
+	var handle DongleHandle
+	if op != HELPER_OP_ENUM && op != HELPER_OP_OPEN {
+		handle = DongleHandle(req.u64())
+	}
+
+	var retCode uint32
+	var err error
+	var result func(w *frameWriter)
+	switch op {
+	case HELPER_OP_ENUM:
+		var keyList []DongleInfo
+		keyList, retCode, err = backend.Enum()
+		result = func(w *frameWriter) {
+			var buf bytes.Buffer
+			binary.Write(&buf, binary.LittleEndian, keyList)
+			w.bytes(buf.Bytes())
+		}
+	case HELPER_OP_OPEN:
+		index := int(int32(req.u32()))
+		if req.err == nil {
+			handle, retCode, err = backend.Open(index)
+		}
+		result = func(w *frameWriter) { w.u64(uint64(handle)) }
+	case HELPER_OP_CLOSE:
+		if req.err == nil {
+			retCode, err = backend.Close(handle)
+		}
+	case HELPER_OP_READFILE, HELPER_OP_READDATA:
+		var fileID uint16
+		if op == HELPER_OP_READFILE {
+			fileID = req.u16()
+		}
+		offset := int(int32(req.u32()))
+		size := req.u32()
+		if req.err == nil && size > HELPER_MAX_FRAME/2 {
+			req.err = fmt.Errorf("读取长度 %d 超过上限", size)
+		}
+		buffer := make([]byte, size)
+		if req.err == nil {
+			if op == HELPER_OP_READFILE {
+				retCode, err = backend.ReadFile(handle, fileID, offset, buffer)
+			} else {
+				retCode, err = backend.ReadData(handle, offset, buffer)
+			}
+		}
+		result = func(w *frameWriter) { w.bytes(buffer) }
+	case HELPER_OP_WRITEDATA:
+		offset := int(int32(req.u32()))
+		data := req.bytes()
+		if req.err == nil {
+			retCode, err = backend.WriteData(handle, offset, data)
+		}
+	case HELPER_OP_VERIFYPIN:
+		flags := int(int32(req.u32()))
+		pin := string(req.bytes())
+		var remain int
+		if req.err == nil {
+			remain, retCode, err = backend.VerifyPIN(handle, flags, pin)
+		}
+		result = func(w *frameWriter) { w.u32(uint32(int32(remain))) }
+	case HELPER_OP_SEED, HELPER_OP_GENRANDOM, HELPER_OP_ECCSIGN:
+		var out []byte
+		switch op {
+		case HELPER_OP_SEED:
+			seedData := req.bytes()
+			if req.err == nil {
+				out, retCode, err = backend.Seed(handle, seedData)
+			}
+		case HELPER_OP_GENRANDOM:
+			length := int(int32(req.u32()))
+			if req.err == nil {
+				out, retCode, err = backend.GenRandom(handle, length)
+			}
+		case HELPER_OP_ECCSIGN:
+			fileID := req.u16()
+			hash := req.bytes()
+			if req.err == nil {
+				out, retCode, err = backend.EccSign(handle, fileID, hash)
+			}
+		}
+		result = func(w *frameWriter) { w.bytes(out) }
+	case HELPER_OP_GETDEADLINE, HELPER_OP_GETUTCTIME:
+		var value uint32
+		if req.err == nil {
+			if op == HELPER_OP_GETDEADLINE {
+				value, retCode, err = backend.GetDeadline(handle)
+			} else {
+				value, retCode, err = backend.GetUTCTime(handle)
+			}
+		}
+		result = func(w *frameWriter) { w.u32(value) }
+	default:
+		req.err = fmt.Errorf("未知操作码: %d", op)
+	}
+
+	if req.err != nil {
+		return encodeResponse(DONGLE_INVALID_PARAMETER, fmt.Errorf("%s: %v", getErrorDescription(DONGLE_INVALID_PARAMETER), req.err), result)
+	}
+	return encodeResponse(retCode, err, result)
+}
+
+// runNativeHelper 辅助进程入口，返回进程退出码
+func runNativeHelper(args []string) int {
+	fs := flag.NewFlagSet(HELPER_COMMAND, flag.ContinueOnError)
+	backendName := fs.String("backend", "native", "后端: native 或 sim")
+	libPath := fs.String("lib", getLibraryPath(), "动态库路径")
+	simCount := fs.Int("sim-count", 1, "模拟后端的设备数量")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	// 终端的中断信号会发给整个进程组，辅助进程由父进程关闭管道退出
+	signal.Ignore(os.Interrupt)
+
+	req := os.NewFile(HELPER_REQ_FD, "helper-request")
+	resp := os.NewFile(HELPER_RESP_FD, "helper-response")
+	if req == nil || resp == nil {
+		fmt.Fprintln(os.Stderr, "错误: 辅助进程缺少通信管道")
+		return 2
+	}
+
+	var backend Backend
+	var err error
+	switch *backendName {
+	case "native":
+		backend, err = newNativeBackend(*libPath)
+	case "sim":
+		backend = newSimBackend(*simCount)
+	default:
+		err = fmt.Errorf("未知后端: %s (可选 native、sim)", *backendName)
+	}
+	if err != nil {
+		writeFrame(resp, encodeResponse(DONGLE_UNKNOWN_ERROR, err, nil))
+		return 1
+	}
+	if err := writeFrame(resp, encodeResponse(DONGLE_SUCCESS, nil, nil)); err != nil {
+		return 1
+	}
+
+	if err := serveHelper(backend, req, resp); err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 辅助进程通信失败: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// ============ 调用方 ============
+
+// helperProcess 一个正在运行的辅助进程
+type helperProcess struct {
+	cmd  *exec.Cmd
+	req  *os.File      // 请求管道写端
+	resp *bufio.Reader // 响应管道读端
+	file *os.File      // 响应管道读端的文件
+}
+
+// helperBackend 在辅助进程中调用动态库的后端
+type helperBackend struct {
+	args    []string      // 辅助进程参数
+	timeout time.Duration // 单次调用超时，超时后终止辅助进程，0 表示不限制
+
+	mu       sync.Mutex
+	proc     *helperProcess
+	handles  map[DongleHandle]DongleHandle // 当前辅助进程中打开的句柄 -> 辅助进程内的句柄
+	next     DongleHandle                  // 上一次分配的句柄，重启后不复用
+	restarts int                           // 辅助进程已重启的次数
+}
+
+// newHelperBackend 启动加载 libPath 的辅助进程
+func newHelperBackend(libPath string, timeout time.Duration) (*helperBackend, error) {
+	return startHelperBackend([]string{"-backend", "native", "-lib", libPath}, timeout)
+}
+
+// startHelperBackend 按参数启动辅助进程
+func startHelperBackend(args []string, timeout time.Duration) (*helperBackend, error) {
+	b := &helperBackend{args: args, timeout: timeout, handles: make(map[DongleHandle]DongleHandle)}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.start(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// start 启动辅助进程并等待就绪，调用方持有 mu
+func (b *helperBackend) start() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("无法定位程序路径: %v", err)
+	}
+	reqR, reqW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	respR, respW, err := os.Pipe()
+	if err != nil {
+		reqR.Close()
+		reqW.Close()
+		return err
+	}
+
+	cmd := exec.Command(exe, append([]string{HELPER_COMMAND}, b.args...)...)
+	// 动态库可能直接写标准输出，不能让它混入协议
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{reqR, respW} // 对应 HELPER_REQ_FD、HELPER_RESP_FD
+	err = cmd.Start()
+	reqR.Close()
+	respW.Close()
+	if err != nil {
+		reqW.Close()
+		respR.Close()
+		return fmt.Errorf("启动辅助进程失败: %v", err)
+	}
+
+	proc := &helperProcess{cmd: cmd, req: reqW, resp: bufio.NewReader(respR), file: respR}
+	payload, err := readFrame(proc.resp)
+	if err != nil {
+		return b.stop(proc, "启动", err)
+	}
+	resp := &frameReader{buf: payload}
+	retCode := resp.u32()
+	message := resp.bytes()
+	if resp.err != nil || retCode != DONGLE_SUCCESS {
+		proc.kill()
+		return fmt.Errorf("辅助进程初始化失败: %s", message)
+	}
+
+	b.proc = proc
+	b.handles = make(map[DongleHandle]DongleHandle)
+	logger.Debug("辅助进程已启动", "pid", cmd.Process.Pid, "restarts", b.restarts)
+	return nil
+}
+
+// kill 终止辅助进程并回收
+func (p *helperProcess) kill() {
+	p.req.Close()
+	p.cmd.Process.Kill()
+	p.cmd.Wait()
+	p.file.Close()
+}
+
+// stop 通信失败后回收辅助进程，返回带退出状态的错误，调用方持有 mu
+func (b *helperBackend) stop(proc *helperProcess, function string, cause error) error {
+	proc.req.Close()
+	// 管道断开通常意味着进程已经退出，稍等片刻取得真实的退出状态
+	done := make(chan struct{})
+	go func() {
+		proc.cmd.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		proc.cmd.Process.Kill()
+		<-done
+	}
+	proc.file.Close()
+	if b.proc == proc {
+		b.proc = nil
+	}
+
+	state := proc.cmd.ProcessState
+	exitErr := &HelperExitError{Function: function, ExitCode: state.ExitCode(), Status: state.String()}
+	logger.Warn("辅助进程退出", "function", function, "status", exitErr.Status, "err", cause)
+	return exitErr
+}
+
+// Unload 关闭辅助进程
+func (b *helperBackend) Unload() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.proc == nil {
+		return
+	}
+	proc := b.proc
+	b.proc = nil
+	proc.req.Close() // 辅助进程读到 EOF 后退出
+	done := make(chan struct{})
+	go func() {
+		proc.cmd.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		proc.cmd.Process.Kill()
+		<-done
+	}
+	proc.file.Close()
+}
+
+// Restarts 返回辅助进程已重启的次数
+func (b *helperBackend) Restarts() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.restarts
+}
+
+// call 发送一个请求并读取响应，辅助进程未运行时先启动
+func (b *helperBackend) call(op byte, handle DongleHandle, args func(w *frameWriter)) (*frameReader, uint32, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	function := helperFunctions[op]
+	if b.proc == nil {
+		b.restarts++
+		if err := b.start(); err != nil {
+			return nil, DONGLE_UNKNOWN_ERROR, err
+		}
+	}
+	var w frameWriter
+	w.u8(op)
+	if op != HELPER_OP_ENUM && op != HELPER_OP_OPEN {
+		remote, ok := b.handles[handle]
+		if !ok {
+			// 句柄属于已退出的辅助进程
+			if op == HELPER_OP_CLOSE {
+				return &frameReader{}, DONGLE_SUCCESS, nil
+			}
+			return nil, DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+		}
+		w.u64(uint64(remote))
+	}
+	if args != nil {
+		args(&w)
+	}
+
+	proc := b.proc
+	var timedOut atomic.Bool
+	if b.timeout > 0 {
+		timer := time.AfterFunc(b.timeout, func() {
+			timedOut.Store(true)
+			proc.cmd.Process.Kill()
+		})
+		defer timer.Stop()
+	}
+	fail := func(err error) error {
+		exitErr := b.stop(proc, function, err)
+		if timedOut.Load() {
+			return &HelperTimeoutError{Function: function, Timeout: b.timeout}
+		}
+		return exitErr
+	}
+	if err := writeFrame(proc.req, w.buf); err != nil {
+		return nil, DONGLE_UNKNOWN_ERROR, fail(err)
+	}
+	payload, err := readFrame(proc.resp)
+	if err != nil {
+		return nil, DONGLE_UNKNOWN_ERROR, fail(err)
+	}
+
+	resp := &frameReader{buf: payload}
+	retCode := resp.u32()
+	message := resp.bytes()
+	if resp.err != nil {
+		return nil, DONGLE_UNKNOWN_ERROR, b.stop(proc, function, resp.err)
+	}
+	if len(message) > 0 {
+		return resp, retCode, errors.New(string(message))
+	}
+	return resp, retCode, nil
+}
+
+// helperResult 检查结果解析是否出错
+func helperResult(resp *frameReader, retCode uint32, err error) (uint32, error) {
+	if err == nil && resp.err != nil {
+		return DONGLE_UNKNOWN_ERROR, fmt.Errorf("辅助进程响应无效: %v", resp.err)
+	}
+	return retCode, err
+}
+
+func (b *helperBackend) Enum() ([]DongleInfo, uint32, error) {
+	resp, retCode, err := b.call(HELPER_OP_ENUM, 0, nil)
+	if resp == nil {
+		return nil, retCode, err
+	}
+	data := resp.bytes()
+	if retCode, err = helperResult(resp, retCode, err); err != nil {
+		return nil, retCode, err
+	}
+	size := binary.Size(DongleInfo{})
+	if len(data)%size != 0 {
+		return nil, DONGLE_UNKNOWN_ERROR, fmt.Errorf("辅助进程响应无效: 设备信息长度 %d", len(data))
+	}
+	keyList := make([]DongleInfo, len(data)/size)
+	binary.Read(bytes.NewReader(data), binary.LittleEndian, keyList)
+	return keyList, retCode, nil
+}
+
+func (b *helperBackend) Open(index int) (DongleHandle, uint32, error) {
+	resp, retCode, err := b.call(HELPER_OP_OPEN, 0, func(w *frameWriter) { w.u32(uint32(int32(index))) })
+	if resp == nil {
+		return 0, retCode, err
+	}
+	remote := DongleHandle(resp.u64())
+	if retCode, err = helperResult(resp, retCode, err); err != nil {
+		return 0, retCode, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.next++
+	b.handles[b.next] = remote
+	return b.next, retCode, nil
+}
+
+func (b *helperBackend) Close(handle DongleHandle) (uint32, error) {
+	resp, retCode, err := b.call(HELPER_OP_CLOSE, handle, nil)
+	if resp != nil {
+		b.mu.Lock()
+		delete(b.handles, handle)
+		b.mu.Unlock()
+	}
+	return retCode, err
+}
+
+func (b *helperBackend) ReadFile(handle DongleHandle, fileID uint16, offset int, buffer []byte) (uint32, error) {
+	resp, retCode, err := b.call(HELPER_OP_READFILE, handle, func(w *frameWriter) {
+		w.u16(fileID)
+		w.u32(uint32(int32(offset)))
+		w.u32(uint32(len(buffer)))
+	})
+	if resp == nil {
+		return retCode, err
+	}
+	copy(buffer, resp.bytes())
+	return helperResult(resp, retCode, err)
+}
+
+func (b *helperBackend) ReadData(handle DongleHandle, offset int, buffer []byte) (uint32, error) {
+	resp, retCode, err := b.call(HELPER_OP_READDATA, handle, func(w *frameWriter) {
+		w.u32(uint32(int32(offset)))
+		w.u32(uint32(len(buffer)))
+	})
+	if resp == nil {
+		return retCode, err
+	}
+	copy(buffer, resp.bytes())
+	return helperResult(resp, retCode, err)
+}
+
+func (b *helperBackend) WriteData(handle DongleHandle, offset int, data []byte) (uint32, error) {
+	_, retCode, err := b.call(HELPER_OP_WRITEDATA, handle, func(w *frameWriter) {
+		w.u32(uint32(int32(offset)))
+		w.bytes(data)
+	})
+	return retCode, err
+}
+
+func (b *helperBackend) VerifyPIN(handle DongleHandle, flags int, pin string) (int, uint32, error) {
+	resp, retCode, err := b.call(HELPER_OP_VERIFYPIN, handle, func(w *frameWriter) {
+		w.u32(uint32(int32(flags)))
+		w.bytes([]byte(pin))
+	})
+	if resp == nil {
+		return 0, retCode, err
+	}
+	remain := int(int32(resp.u32()))
+	retCode, err = helperResult(resp, retCode, err)
+	return remain, retCode, err
+}
+
+// callBytes 调用返回字节串的函数
+func (b *helperBackend) callBytes(op byte, handle DongleHandle, args func(w *frameWriter)) ([]byte, uint32, error) {
+	resp, retCode, err := b.call(op, handle, args)
+	if resp == nil {
+		return nil, retCode, err
+	}
+	out := resp.bytes()
+	if retCode, err = helperResult(resp, retCode, err); err != nil {
+		return nil, retCode, err
+	}
+	return out, retCode, nil
+}
+
+// callValue 调用返回 32 位数值的函数
+func (b *helperBackend) callValue(op byte, handle DongleHandle) (uint32, uint32, error) {
+	resp, retCode, err := b.call(op, handle, nil)
+	if resp == nil {
+		return 0, retCode, err
+	}
+	value := resp.u32()
+	retCode, err = helperResult(resp, retCode, err)
+	return value, retCode, err
+}
+
+func (b *helperBackend) Seed(handle DongleHandle, seedData []byte) ([]byte, uint32, error) {
+	return b.callBytes(HELPER_OP_SEED, handle, func(w *frameWriter) { w.bytes(seedData) })
+}
+
+func (b *helperBackend) GetDeadline(handle DongleHandle) (uint32, uint32, error) {
+	return b.callValue(HELPER_OP_GETDEADLINE, handle)
+}
+
+func (b *helperBackend) GetUTCTime(handle DongleHandle) (uint32, uint32, error) {
+	return b.callValue(HELPER_OP_GETUTCTIME, handle)
+}
+
+func (b *helperBackend) GenRandom(handle DongleHandle, length int) ([]byte, uint32, error) {
+	return b.callBytes(HELPER_OP_GENRANDOM, handle, func(w *frameWriter) { w.u32(uint32(int32(length))) })
+}
+
+func (b *helperBackend) EccSign(handle DongleHandle, fileID uint16, hash []byte) ([]byte, uint32, error) {
+	return b.callBytes(HELPER_OP_ECCSIGN, handle, func(w *frameWriter) {
+		w.u16(fileID)
+		w.bytes(hash)
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestMain 测试程序以 HELPER_COMMAND 重新启动时作为辅助进程运行
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == HELPER_COMMAND {
+		os.Exit(runNativeHelper(os.Args[2:]))
+	}
+	os.Exit(m.Run())
+}
+
+// helperPID 当前辅助进程的 PID
+func helperPID(t *testing.T, b *helperBackend) int {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.proc == nil {
+		t.Fatal("辅助进程未运行")
+	}
+	return b.proc.cmd.Process.Pid
+}
+
+func TestHelperRestartAfterCrash(t *testing.T) {
+	b, err := startHelperBackend([]string{"-backend", "sim"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Unload()
+
+	handle, _, err := b.Open(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 16)
+	if _, err := b.ReadData(handle, 0, buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	// 会话中途辅助进程被杀死
+	if err := syscall.Kill(helperPID(t, b), syscall.SIGKILL); err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.ReadData(handle, 0, buffer)
+	var exitErr *HelperExitError
+	if !errors.As(err, &exitErr) || exitErr.Function != FUNC_READDATA || exitErr.ExitCode != -1 {
+		t.Fatalf("辅助进程被杀死后 ReadData = %v, 期望 *HelperExitError", err)
+	}
+
+	// 下一次调用重启辅助进程，旧句柄失效
+	keyList, _, err := b.Enum()
+	if err != nil || len(keyList) != 1 {
+		t.Fatalf("重启后 Enum = %v, %v", keyList, err)
+	}
+	if n := b.Restarts(); n != 1 {
+		t.Fatalf("Restarts = %d, 期望 1", n)
+	}
+	if retCode, err := b.ReadData(handle, 0, buffer); retCode != DONGLE_INVALID_HANDLE || err == nil {
+		t.Fatalf("旧句柄 ReadData = 0x%08X, %v, 期望 DONGLE_INVALID_HANDLE", retCode, err)
+	}
+	if _, err := b.Close(handle); err != nil {
+		t.Fatalf("关闭旧句柄 = %v", err)
+	}
+
+	handle, _, err = b.Open(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.ReadData(handle, 0, buffer); err != nil {
+		t.Fatalf("重新打开后 ReadData = %v", err)
+	}
+	b.Close(handle)
+}
+
+func TestHelperTimeout(t *testing.T) {
+	timeout := 200 * time.Millisecond
+	b, err := startHelperBackend([]string{"-backend", "sim"}, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Unload()
+
+	// 暂停的辅助进程不再响应，超时后被终止
+	if err := syscall.Kill(helperPID(t, b), syscall.SIGSTOP); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = b.Enum()
+	var timeoutErr *HelperTimeoutError
+	if !errors.As(err, &timeoutErr) || timeoutErr.Function != FUNC_ENUM || timeoutErr.Timeout != timeout {
+		t.Fatalf("辅助进程无响应时 Enum = %v, 期望 *HelperTimeoutError", err)
+	}
+	if !isCallAborted(err) {
+		t.Error("超时错误应被 isCallAborted 识别")
+	}
+
+	if _, _, err := b.Enum(); err != nil {
+		t.Fatalf("重启后 Enum = %v", err)
+	}
+	if n := b.Restarts(); n != 1 {
+		t.Fatalf("Restarts = %d, 期望 1", n)
+	}
+}
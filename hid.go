@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ============ 纯 Go HID 后端 ============
+
+// HID_TIMEOUT 等待设备响应的超时
+const HID_TIMEOUT = 5 * time.Second
+
+// hidDevice 已打开的 HID 设备节点
+type hidDevice interface {
+	io.ReadWriteCloser
+	SetReadDeadline(t time.Time) error
+}
+
+// hidCheckCommand 检查指令是否允许发送
+func hidCheckCommand(cmd hidCommand) error {
+	if hidConfirmedINS[cmd.INS] || *hidUnverified {
+		return nil
+	}
+	return fmt.Errorf("纯Go HID 指令 %02X 未经抓包确认，默认不发送；确认设备是加密锁后可用 -hid-unverified 试验，或使用动态库", cmd.INS)
+}
+
+// hidTransceive 发送一条命令并读取响应
+func hidTransceive(dev hidDevice, cmd hidCommand) ([]byte, uint32, error) {
+	if err := hidCheckCommand(cmd); err != nil {
+		return nil, DONGLE_UNKNOWN_ERROR, err
+	}
+	msg, err := cmd.encode()
+	if err != nil {
+		return nil, DONGLE_INVALID_SIZE, err
+	}
+	reports, err := fragmentHID(msg)
+	if err != nil {
+		return nil, DONGLE_INVALID_SIZE, err
+	}
+	for _, report := range reports {
+		// hidraw 写入时首字节为报告ID，设备不使用报告ID时为 0
+		if _, err := dev.Write(append([]byte{0}, report...)); err != nil {
+			return nil, DONGLE_UNKNOWN_ERROR, fmt.Errorf("写入 HID 报告失败: %v", err)
+		}
+	}
+
+	// 部分内核版本的 hidraw 不支持超时，忽略错误
+	dev.SetReadDeadline(time.Now().Add(HID_TIMEOUT))
+	var assembler hidAssembler
+	report := make([]byte, HID_REPORT_SIZE)
+	for {
+		n, err := dev.Read(report)
+		if err != nil {
+			return nil, DONGLE_UNKNOWN_ERROR, fmt.Errorf("读取 HID 报告失败: %v", err)
+		}
+		done, err := assembler.add(report[:n])
+		if err != nil {
+			return nil, DONGLE_UNKNOWN_ERROR, fmt.Errorf("HID 响应无效: %v", err)
+		}
+		if done {
+			break
+		}
+	}
+
+	data, sw, err := decodeHIDResponse(assembler.msg)
+	if err != nil {
+		return nil, DONGLE_UNKNOWN_ERROR, fmt.Errorf("HID 响应无效: %v", err)
+	}
+	if retCode := hidStatusCode(sw); retCode != DONGLE_SUCCESS {
+		return nil, retCode, fmt.Errorf("%s (状态字: %04X)", getErrorDescription(retCode), sw)
+	}
+	return data, DONGLE_SUCCESS, nil
+}
+
+// hidBackend 直接通过 hidraw 访问加密锁的后端，实验性质，只支持部分只读操作
+//
+// 只打开 knownDongleIDs 中产品ID的设备，同一厂商的 ePass、FIDO 等令牌不会
+// 收到任何报文。
+type hidBackend struct {
+	mu      sync.Mutex
+	paths   []string // 最近一次枚举到的设备节点，Open 的序号以此为准
+	handles map[DongleHandle]hidDevice
+	next    DongleHandle
+}
+
+// newHIDBackend 创建纯 Go HID 后端
+func newHIDBackend() *hidBackend {
+	return &hidBackend{handles: make(map[DongleHandle]hidDevice)}
+}
+
+// openHIDDevice 打开设备节点
+func openHIDDevice(path string) (hidDevice, error) {
+	return os.OpenFile(path, os.O_RDWR, 0)
+}
+
+// device 查找句柄对应的设备
+func (b *hidBackend) device(handle DongleHandle) (hidDevice, uint32, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	dev, ok := b.handles[handle]
+	if !ok {
+		return nil, DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+	return dev, DONGLE_SUCCESS, nil
+}
+
+// hidUnsupported 纯 Go 传输尚未实现的操作
+func hidUnsupported(function string) error {
+	return fmt.Errorf("纯Go HID 传输暂不支持 %s，请使用动态库", function)
+}
+
+func (b *hidBackend) Enum() ([]DongleInfo, uint32, error) {
+	// 未确认的指令在打开设备前就拒绝
+	if err := hidCheckCommand(hidGetInfo()); err != nil {
+		return nil, DONGLE_UNKNOWN_ERROR, err
+	}
+	var paths []string
+	for _, id := range knownDongleIDs {
+		found, err := findHIDRaw(id.Vendor, id.Product)
+		if err != nil {
+			return nil, DONGLE_UNKNOWN_ERROR, err
+		}
+		paths = append(paths, found...)
+	}
+	sort.Strings(paths)
+
+	var keyList []DongleInfo
+	var found []string
+	for _, path := range paths {
+		dev, err := openHIDDevice(path)
+		if err != nil {
+			logger.Warn("无法打开 HID 设备", "path", path, "err", err)
+			continue
+		}
+		data, _, err := hidTransceive(dev, hidGetInfo())
+		dev.Close()
+		if err != nil {
+			logger.Warn("读取设备信息失败", "path", path, "err", err)
+			continue
+		}
+		info, err := decodeHIDInfo(data)
+		if err != nil {
+			logger.Warn("设备信息无效", "path", path, "err", err)
+			continue
+		}
+		keyList = append(keyList, info)
+		found = append(found, path)
+	}
+
+	b.mu.Lock()
+	b.paths = found
+	b.mu.Unlock()
+	if len(keyList) == 0 {
+		return nil, DONGLE_NOT_FOUND, fmt.Errorf(getErrorDescription(DONGLE_NOT_FOUND))
+	}
+	return keyList, DONGLE_SUCCESS, nil
+}
+
+func (b *hidBackend) Open(index int) (DongleHandle, uint32, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if index < 0 || index >= len(b.paths) {
+		return 0, DONGLE_NOT_FOUND, fmt.Errorf("%s: 设备序号 %d 超出范围 (共 %d 个设备，请先枚举)", getErrorDescription(DONGLE_NOT_FOUND), index, len(b.paths))
+	}
+	dev, err := openHIDDevice(b.paths[index])
+	if err != nil {
+		if os.IsPermission(err) {
+			return 0, DONGLE_ACCESS_DENIED, fmt.Errorf("%s: %v", getErrorDescription(DONGLE_ACCESS_DENIED), err)
+		}
+		return 0, DONGLE_NOT_FOUND, fmt.Errorf("%s: %v", getErrorDescription(DONGLE_NOT_FOUND), err)
+	}
+	b.next++
+	b.handles[b.next] = dev
+	return b.next, DONGLE_SUCCESS, nil
+}
+
+func (b *hidBackend) Close(handle DongleHandle) (uint32, error) {
+	b.mu.Lock()
+	dev, ok := b.handles[handle]
+	delete(b.handles, handle)
+	b.mu.Unlock()
+	if ok {
+		dev.Close()
+	}
+	return DONGLE_SUCCESS, nil
+}
+
+// readInto 执行读取命令，响应长度必须与缓冲区一致
+func (b *hidBackend) readInto(handle DongleHandle, cmd hidCommand, buffer []byte) (uint32, error) {
+	if len(buffer) == 0 {
+		return DONGLE_INVALID_BUFFER, fmt.Errorf(getErrorDescription(DONGLE_INVALID_BUFFER))
+	}
+	dev, retCode, err := b.device(handle)
+	if err != nil {
+		return retCode, err
+	}
+	data, retCode, err := hidTransceive(dev, cmd)
+	if err != nil {
+		return retCode, err
+	}
+	if len(data) != len(buffer) {
+		return DONGLE_INVALID_SIZE, fmt.Errorf("%s: 响应长度 %d，应为 %d", getErrorDescription(DONGLE_INVALID_SIZE), len(data), len(buffer))
+	}
+	copy(buffer, data)
+	return retCode, nil
+}
+
+func (b *hidBackend) ReadFile(handle DongleHandle, fileID uint16, offset int, buffer []byte) (uint32, error) {
+	cmd, retCode, err := hidReadFile(fileID, offset, len(buffer))
+	if err != nil {
+		return retCode, err
+	}
+	return b.readInto(handle, cmd, buffer)
+}
+
+func (b *hidBackend) ReadData(handle DongleHandle, offset int, buffer []byte) (uint32, error) {
+	cmd, retCode, err := hidReadData(offset, len(buffer))
+	if err != nil {
+		return retCode, err
+	}
+	return b.readInto(handle, cmd, buffer)
+}
+
+func (b *hidBackend) WriteData(handle DongleHandle, offset int, data []byte) (uint32, error) {
+	return DONGLE_UNKNOWN_ERROR, hidUnsupported(FUNC_WRITEDATA)
+}
+
+func (b *hidBackend) VerifyPIN(handle DongleHandle, flags int, pin string) (int, uint32, error) {
+	return 0, DONGLE_UNKNOWN_ERROR, hidUnsupported(FUNC_VERIFYPIN)
+}
+
+func (b *hidBackend) Seed(handle DongleHandle, seedData []byte) ([]byte, uint32, error) {
+	return nil, DONGLE_UNKNOWN_ERROR, hidUnsupported(FUNC_SEED)
+}
+
+func (b *hidBackend) GetDeadline(handle DongleHandle) (uint32, uint32, error) {
+	return 0, DONGLE_UNKNOWN_ERROR, hidUnsupported(FUNC_GETDEADLINE)
+}
+
+func (b *hidBackend) GetUTCTime(handle DongleHandle) (uint32, uint32, error) {
+	buffer := make([]byte, 4)
+	if retCode, err := b.readInto(handle, hidGetTime(), buffer); err != nil {
+		return 0, retCode, err
+	}
+	return binary.BigEndian.Uint32(buffer), DONGLE_SUCCESS, nil
+}
+
+func (b *hidBackend) GenRandom(handle DongleHandle, length int) ([]byte, uint32, error) {
+	if length <= 0 || length > RANDOM_MAX_LEN {
+		return nil, DONGLE_INVALID_SIZE, fmt.Errorf("%s: 随机数长度 %d 超出范围 (1-%d)", getErrorDescription(DONGLE_INVALID_SIZE), length, RANDOM_MAX_LEN)
+	}
+	out := make([]byte, length)
+	if retCode, err := b.readInto(handle, hidGenRandom(length), out); err != nil {
+		return nil, retCode, err
+	}
+	return out, DONGLE_SUCCESS, nil
+}
+
+func (b *hidBackend) EccSign(handle DongleHandle, fileID uint16, hash []byte) ([]byte, uint32, error) {
+	return nil, DONGLE_UNKNOWN_ERROR, hidUnsupported(FUNC_ECCSIGN)
+}
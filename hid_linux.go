@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// HIDRAW_SYSFS hidraw 设备在 sysfs 中的目录
+const HIDRAW_SYSFS = "/sys/class/hidraw"
+
+// findHIDRaw 按 USB 厂商ID/产品ID 查找 hidraw 设备节点
+func findHIDRaw(vendor, product uint16) ([]string, error) {
+	entries, err := os.ReadDir(HIDRAW_SYSFS)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		uevent, err := os.ReadFile(filepath.Join(HIDRAW_SYSFS, entry.Name(), "device", "uevent"))
+		if err != nil {
+			continue
+		}
+		vid, pid, ok := parseHIDID(string(uevent))
+		if ok && vid == vendor && pid == product {
+			paths = append(paths, filepath.Join("/dev", entry.Name()))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// parseHIDID 从 HID 设备的 uevent 中解析 HID_ID=总线:厂商ID:产品ID
+func parseHIDID(uevent string) (uint16, uint16, bool) {
+	for _, line := range strings.Split(uevent, "\n") {
+		value, ok := strings.CutPrefix(line, "HID_ID=")
+		if !ok {
+			continue
+		}
+		parts := strings.Split(value, ":")
+		if len(parts) != 3 {
+			return 0, 0, false
+		}
+		vid, err1 := strconv.ParseUint(parts[1], 16, 32)
+		pid, err2 := strconv.ParseUint(parts[2], 16, 32)
+		if err1 != nil || err2 != nil || vid > 0xFFFF || pid > 0xFFFF {
+			return 0, 0, false
+		}
+		return uint16(vid), uint16(pid), true
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// findHIDRaw 非 Linux 平台没有 hidraw
+func findHIDRaw(vendor, product uint16) ([]string, error) {
+	return nil, fmt.Errorf("%s 平台不支持 hidraw", runtime.GOOS)
+}
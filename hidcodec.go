@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// ============ HID 传输协议 ============
+//
+// 纯 Go 传输绕过厂商动态库，直接通过 /dev/hidraw* 与加密锁通信，用于没有
+// 厂商库的架构 (riscv64、musl 等)。协议没有公开文档，以下格式是实验性的
+// 推断，需要用厂商库的 USB 抓包逐项核对；只实现了几个只读操作，其余操作
+// 仍需使用动态库。
+//
+// 一条命令是 APDU 风格的消息：
+//
+//	请求: CLA INS P1 P2 Lc(2) 数据 Le(2)
+//	响应: 数据 SW1 SW2
+//
+// 消息按 64 字节的 HID 报告分片：首包为 序号(1)=0 总长度(2) 数据，后续包为
+// 序号(1) 数据，序号依次递增，最后一包不足部分补 0。
+//
+// TODO: 目前手上没有真实设备的抓包，没有任何一条指令经过确认，
+// hidcodec_test.go 也只有与设备无关的测试。hidTransceive 默认拒绝发送
+// hidConfirmedINS 之外的指令，避免向设备写入猜测的报文；只有指定
+// -hid-unverified 时才发送，用于对照抓包调试。拿到抓包后把确认的指令加入
+// hidConfirmedINS，并在 hidcodec_test.go 中用抓到的报文作为测试数据。
+
+// HID 报告与消息
+const (
+	HID_REPORT_SIZE = 64   // 报告长度，不含报告ID
+	HID_INIT_HEADER = 3    // 首包头：序号、总长度
+	HID_CONT_HEADER = 1    // 后续包头：序号
+	HID_MAX_MESSAGE = 4096 // 单条消息最大长度
+)
+
+// APDU 指令 (推断，均未经抓包确认)
+const (
+	HID_CLA            = 0x80
+	HID_INS_GET_INFO   = 0x10 // 读取设备信息，响应为 DongleInfo
+	HID_INS_READ_FILE  = 0x20 // 读取数据文件，P1P2 为文件ID，数据为 偏移(2)
+	HID_INS_READ_DATA  = 0x22 // 读取数据区，P1P2 为偏移
+	HID_INS_GET_RANDOM = 0x30 // 生成随机数
+	HID_INS_GET_TIME   = 0x32 // 读取锁内 UTC 时间，响应为 4 字节大端秒数
+)
+
+// hidConfirmedINS 经过抓包确认的指令，目前为空
+var hidConfirmedINS = map[byte]bool{}
+
+// HID 状态字，取自 ISO 7816-4，设备是否使用同样的取值未经确认
+const (
+	HID_SW_SUCCESS        = 0x9000
+	HID_SW_WRONG_LENGTH   = 0x6700
+	HID_SW_SECURITY       = 0x6982
+	HID_SW_FILE_NOT_FOUND = 0x6A82
+	HID_SW_WRONG_OFFSET   = 0x6B00
+)
+
+// hidCommand 一条 APDU 命令
+type hidCommand struct {
+	INS  byte
+	P1   byte
+	P2   byte
+	Data []byte
+	Le   int // 期望的响应数据长度
+}
+
+// encode 编码为请求消息
+func (c hidCommand) encode() ([]byte, error) {
+	if len(c.Data) > 0xFFFF || c.Le < 0 || c.Le > 0xFFFF {
+		return nil, fmt.Errorf("%s: 命令长度超出范围", getErrorDescription(DONGLE_INVALID_SIZE))
+	}
+	msg := make([]byte, 0, 8+len(c.Data))
+	msg = append(msg, HID_CLA, c.INS, c.P1, c.P2)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(c.Data)))
+	msg = append(msg, c.Data...)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(c.Le))
+	if len(msg) > HID_MAX_MESSAGE {
+		return nil, fmt.Errorf("%s: 消息长度 %d 超过上限 %d", getErrorDescription(DONGLE_INVALID_SIZE), len(msg), HID_MAX_MESSAGE)
+	}
+	return msg, nil
+}
+
+// decodeHIDResponse 拆分响应消息的数据和状态字
+func decodeHIDResponse(msg []byte) ([]byte, uint16, error) {
+	if len(msg) < 2 {
+		return nil, 0, fmt.Errorf("响应长度 %d 过短", len(msg))
+	}
+	n := len(msg) - 2
+	return msg[:n], binary.BigEndian.Uint16(msg[n:]), nil
+}
+
+// hidStatusCode 把状态字转换为 SDK 返回码
+func hidStatusCode(sw uint16) uint32 {
+	switch {
+	case sw == HID_SW_SUCCESS:
+		return DONGLE_SUCCESS
+	case sw == HID_SW_WRONG_LENGTH:
+		return DONGLE_INVALID_SIZE
+	case sw == HID_SW_SECURITY:
+		return DONGLE_ACCESS_DENIED
+	case sw == HID_SW_FILE_NOT_FOUND:
+		return DONGLE_INVALID_FILEID
+	case sw == HID_SW_WRONG_OFFSET:
+		return DONGLE_INVALID_OFFSET
+	case sw&0xFFF0 == 0x63C0:
+		return DONGLE_INVALID_PASSWORD
+	default:
+		return DONGLE_UNKNOWN_ERROR
+	}
+}
+
+// fragmentHID 把消息切分为 HID 报告
+func fragmentHID(msg []byte) ([][]byte, error) {
+	if len(msg) > HID_MAX_MESSAGE {
+		return nil, fmt.Errorf("消息长度 %d 超过上限 %d", len(msg), HID_MAX_MESSAGE)
+	}
+
+	var reports [][]byte
+	report := make([]byte, HID_REPORT_SIZE)
+	binary.BigEndian.PutUint16(report[1:3], uint16(len(msg)))
+	n := copy(report[HID_INIT_HEADER:], msg)
+	reports = append(reports, report)
+	for seq := 1; n < len(msg); seq++ {
+		if seq > 0xFF {
+			return nil, fmt.Errorf("消息长度 %d 超过报告序号范围", len(msg))
+		}
+		report := make([]byte, HID_REPORT_SIZE)
+		report[0] = byte(seq)
+		n += copy(report[HID_CONT_HEADER:], msg[n:])
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// hidAssembler 把收到的 HID 报告重新组装为消息
+type hidAssembler struct {
+	msg   []byte
+	total int
+	seq   int
+}
+
+// add 加入一个报告，消息完整时返回 true
+func (a *hidAssembler) add(report []byte) (bool, error) {
+	if len(report) != HID_REPORT_SIZE {
+		return false, fmt.Errorf("报告长度 %d，应为 %d", len(report), HID_REPORT_SIZE)
+	}
+	if int(report[0]) != a.seq {
+		return false, fmt.Errorf("报告序号 %d，应为 %d", report[0], a.seq)
+	}
+
+	payload := report[HID_CONT_HEADER:]
+	if a.seq == 0 {
+		a.total = int(binary.BigEndian.Uint16(report[1:3]))
+		if a.total > HID_MAX_MESSAGE {
+			return false, fmt.Errorf("消息长度 %d 超过上限 %d", a.total, HID_MAX_MESSAGE)
+		}
+		a.msg = make([]byte, 0, a.total)
+		payload = report[HID_INIT_HEADER:]
+	}
+	a.seq++
+
+	if remain := a.total - len(a.msg); len(payload) > remain {
+		payload = payload[:remain]
+	}
+	a.msg = append(a.msg, payload...)
+	return len(a.msg) == a.total, nil
+}
+
+// ============ 命令构造 ============
+
+// hidGetInfo 读取设备信息
+func hidGetInfo() hidCommand {
+	return hidCommand{INS: HID_INS_GET_INFO, Le: binary.Size(DongleInfo{})}
+}
+
+// hidCheckOffset 检查偏移量能否用 2 字节编码，超出时截断会读到别处的数据
+func hidCheckOffset(offset int) (uint32, error) {
+	if offset < 0 || offset > 0xFFFF {
+		return DONGLE_INVALID_OFFSET, fmt.Errorf("%s: 偏移量 %d 超出范围 [0, %d]", getErrorDescription(DONGLE_INVALID_OFFSET), offset, 0xFFFF)
+	}
+	return DONGLE_SUCCESS, nil
+}
+
+// hidReadFile 读取数据文件
+func hidReadFile(fileID uint16, offset, length int) (hidCommand, uint32, error) {
+	if retCode, err := hidCheckOffset(offset); err != nil {
+		return hidCommand{}, retCode, err
+	}
+	return hidCommand{
+		INS:  HID_INS_READ_FILE,
+		P1:   byte(fileID >> 8),
+		P2:   byte(fileID),
+		Data: binary.BigEndian.AppendUint16(nil, uint16(offset)),
+		Le:   length,
+	}, DONGLE_SUCCESS, nil
+}
+
+// hidReadData 读取数据区
+func hidReadData(offset, length int) (hidCommand, uint32, error) {
+	if retCode, err := hidCheckOffset(offset); err != nil {
+		return hidCommand{}, retCode, err
+	}
+	return hidCommand{INS: HID_INS_READ_DATA, P1: byte(offset >> 8), P2: byte(offset), Le: length}, DONGLE_SUCCESS, nil
+}
+
+// hidGenRandom 生成随机数
+func hidGenRandom(length int) hidCommand {
+	return hidCommand{INS: HID_INS_GET_RANDOM, Le: length}
+}
+
+// hidGetTime 读取锁内时间
+func hidGetTime() hidCommand {
+	return hidCommand{INS: HID_INS_GET_TIME, Le: 4}
+}
+
+// decodeHIDInfo 解析设备信息响应
+func decodeHIDInfo(data []byte) (DongleInfo, error) {
+	var info DongleInfo
+	if len(data) != binary.Size(info) {
+		return info, fmt.Errorf("设备信息长度 %d，应为 %d", len(data), binary.Size(info))
+	}
+	err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &info)
+	return info, err
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TODO: 指令编码和状态字没有真实设备的抓包可以对照，这里只测试与设备无关的
+// 分片、重组和参数检查，以及未确认的指令不会被发送。拿到抓包后在此加入用
+// 实际报文构造的测试。
+
+func TestHIDFragmentRoundTrip(t *testing.T) {
+	first := HID_REPORT_SIZE - HID_INIT_HEADER
+	cont := HID_REPORT_SIZE - HID_CONT_HEADER
+	cases := []struct {
+		size    int
+		reports int
+	}{
+		{0, 1},
+		{1, 1},
+		{first, 1},
+		{first + 1, 2},
+		{first + cont, 2},
+		{first + cont + 1, 3},
+		{HID_MAX_MESSAGE, 1 + (HID_MAX_MESSAGE-first+cont-1)/cont},
+	}
+	for _, tc := range cases {
+		msg := make([]byte, tc.size)
+		for i := range msg {
+			msg[i] = byte(i*7 + 1)
+		}
+		reports, err := fragmentHID(msg)
+		if err != nil {
+			t.Fatalf("%d 字节: %v", tc.size, err)
+		}
+		if len(reports) != tc.reports {
+			t.Errorf("%d 字节切分为 %d 个报告，期望 %d", tc.size, len(reports), tc.reports)
+		}
+
+		var a hidAssembler
+		for i, report := range reports {
+			if len(report) != HID_REPORT_SIZE || int(report[0]) != i {
+				t.Fatalf("%d 字节: 第 %d 个报告长度 %d 序号 %d", tc.size, i, len(report), report[0])
+			}
+			done, err := a.add(report)
+			if err != nil {
+				t.Fatalf("%d 字节: 第 %d 个报告: %v", tc.size, i, err)
+			}
+			if done != (i == len(reports)-1) {
+				t.Fatalf("%d 字节: 第 %d 个报告 done = %v", tc.size, i, done)
+			}
+		}
+		if !bytes.Equal(a.msg, msg) {
+			t.Errorf("%d 字节: 重组结果不一致", tc.size)
+		}
+
+		// 最后一包不足部分补 0
+		last := reports[len(reports)-1]
+		used := HID_INIT_HEADER + tc.size
+		if len(reports) > 1 {
+			used = HID_CONT_HEADER + (tc.size - first - cont*(len(reports)-2))
+		}
+		if !bytes.Equal(last[used:], make([]byte, HID_REPORT_SIZE-used)) {
+			t.Errorf("%d 字节: 最后一个报告未补 0", tc.size)
+		}
+	}
+
+	if _, err := fragmentHID(make([]byte, HID_MAX_MESSAGE+1)); err == nil {
+		t.Error("超长消息应返回错误")
+	}
+}
+
+func TestHIDAssemblerRejects(t *testing.T) {
+	reports, err := fragmentHID(make([]byte, 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var a hidAssembler
+	if _, err := a.add(reports[1]); err == nil {
+		t.Error("缺少首包时应返回错误")
+	}
+	a = hidAssembler{}
+	if _, err := a.add(reports[0][:10]); err == nil {
+		t.Error("报告长度不足时应返回错误")
+	}
+
+	oversize := make([]byte, HID_REPORT_SIZE)
+	binary.BigEndian.PutUint16(oversize[1:3], HID_MAX_MESSAGE+1)
+	a = hidAssembler{}
+	if _, err := a.add(oversize); err == nil {
+		t.Error("声明的总长度超过上限时应返回错误")
+	}
+
+	if _, _, err := decodeHIDResponse([]byte{0x90}); err == nil {
+		t.Error("不足状态字长度的响应应返回错误")
+	}
+}
+
+// loopbackHID 把收到的请求消息原样作为响应数据返回的 HID 设备
+type loopbackHID struct {
+	writes    [][]byte
+	request   hidAssembler
+	responses [][]byte
+}
+
+func (d *loopbackHID) Write(p []byte) (int, error) {
+	d.writes = append(d.writes, append([]byte(nil), p...))
+	if p[0] != 0 {
+		return 0, errors.New("报告ID应为 0")
+	}
+	done, err := d.request.add(p[1:])
+	if err != nil {
+		return 0, err
+	}
+	if done {
+		resp := append(append([]byte(nil), d.request.msg...), 0x90, 0x00)
+		if d.responses, err = fragmentHID(resp); err != nil {
+			return 0, err
+		}
+		d.request = hidAssembler{}
+	}
+	return len(p), nil
+}
+
+func (d *loopbackHID) Read(p []byte) (int, error) {
+	if len(d.responses) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, d.responses[0])
+	d.responses = d.responses[1:]
+	return n, nil
+}
+
+func (d *loopbackHID) Close() error                      { return nil }
+func (d *loopbackHID) SetReadDeadline(t time.Time) error { return nil }
+
+func TestHIDTransceiveUnconfirmed(t *testing.T) {
+	dev := &loopbackHID{}
+	_, _, err := hidTransceive(dev, hidGetInfo())
+	if err == nil || !strings.Contains(err.Error(), "-hid-unverified") {
+		t.Fatalf("未确认的指令 hidTransceive = %v", err)
+	}
+	if len(dev.writes) != 0 {
+		t.Fatalf("未确认的指令写入了 %d 个报告", len(dev.writes))
+	}
+	if _, _, err := newHIDBackend().Enum(); err == nil || !strings.Contains(err.Error(), "-hid-unverified") {
+		t.Fatalf("未确认的指令 Enum = %v", err)
+	}
+}
+
+func TestHIDTransceiveLoopback(t *testing.T) {
+	*hidUnverified = true
+	defer func() { *hidUnverified = false }()
+
+	dev := &loopbackHID{}
+	cmd := hidCommand{INS: 0x7F, Data: bytes.Repeat([]byte{0xA5}, 150), Le: 16}
+	data, retCode, err := hidTransceive(dev, cmd)
+	if err != nil || retCode != DONGLE_SUCCESS {
+		t.Fatalf("hidTransceive = %08X, %v", retCode, err)
+	}
+	msg, _ := cmd.encode()
+	if !bytes.Equal(data, msg) {
+		t.Errorf("回环数据不一致")
+	}
+	if len(dev.writes) != 3 {
+		t.Errorf("写入 %d 个报告，期望 3", len(dev.writes))
+	}
+	for _, w := range dev.writes {
+		if len(w) != HID_REPORT_SIZE+1 {
+			t.Errorf("写入长度 %d，期望报告ID加 %d 字节", len(w), HID_REPORT_SIZE)
+		}
+	}
+}
+
+func TestHIDReadOffset(t *testing.T) {
+	for _, offset := range []int{0, 0x1234, 0xFFFF} {
+		cmd, retCode, err := hidReadFile(0x0001, offset, 16)
+		if err != nil || retCode != DONGLE_SUCCESS {
+			t.Fatalf("hidReadFile(%#x) = %08X, %v", offset, retCode, err)
+		}
+		if got := int(binary.BigEndian.Uint16(cmd.Data)); got != offset {
+			t.Errorf("hidReadFile(%#x) 编码的偏移量 = %#x", offset, got)
+		}
+		cmd, retCode, err = hidReadData(offset, 16)
+		if err != nil || retCode != DONGLE_SUCCESS {
+			t.Fatalf("hidReadData(%#x) = %08X, %v", offset, retCode, err)
+		}
+		if got := int(cmd.P1)<<8 | int(cmd.P2); got != offset {
+			t.Errorf("hidReadData(%#x) 编码的偏移量 = %#x", offset, got)
+		}
+	}
+
+	// 超出 2 字节的偏移量截断后会读到别处的数据，必须拒绝
+	for _, offset := range []int{-1, 0x10000, 0x10010} {
+		if _, retCode, err := hidReadFile(0x0001, offset, 16); err == nil || retCode != DONGLE_INVALID_OFFSET {
+			t.Errorf("hidReadFile(%#x) = %08X, %v, 期望 DONGLE_INVALID_OFFSET", offset, retCode, err)
+		}
+		if _, retCode, err := hidReadData(offset, 16); err == nil || retCode != DONGLE_INVALID_OFFSET {
+			t.Errorf("hidReadData(%#x) = %08X, %v, 期望 DONGLE_INVALID_OFFSET", offset, retCode, err)
+		}
+	}
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ============ 浮动授权 ============
+//
+// 一把加密锁为多台工作站提供并发席位。席位上限记录在锁内的专用数据文件中，
+// 不占用数据区，文件ID 可以用 -seat-file 修改。守护进程通过双向 TLS 接受租约请求：客户端申请一个有时限的租约，
+// 定时续约作为心跳，超时未续约的租约自动回收。租约状态保存在本地文件，
+// 守护进程重启后未过期的租约继续有效。每次申请和续约都会读取锁内的
+// 席位上限，拔出加密锁后无法续约，所有租约随之过期。
+
+// 席位记录文件
+const (
+	SEAT_FILE_ID      = 0x0E01 // 默认席位记录文件ID
+	SEAT_RECORD_SIZE  = 16     // 记录大小，即文件大小
+	SEAT_RECORD_MAGIC = "RKLS" // 记录标识
+	SEAT_MAX          = 0xFFFF // 席位上限
+)
+
+// 租约默认参数
+const (
+	DEFAULT_LEASE_TTL   = 5 * time.Minute // 租约有效期
+	DEFAULT_LEASE_STATE = "/var/lib/rockey/leases.json"
+)
+
+// 租约错误
+var (
+	ErrNoSeats       = errors.New("没有空闲席位")
+	ErrLeaseNotFound = errors.New("租约不存在或已过期")
+	ErrNoSeatRecord  = errors.New("加密锁中没有席位记录，请先执行 license seats -set")
+)
+
+// encodeSeatRecord 编码席位记录: 标识(4) + 席位数(2) + 保留(2) + CRC32(4) + 保留(4)
+func encodeSeatRecord(seats int) ([]byte, error) {
+	if seats < 0 || seats > SEAT_MAX {
+		return nil, fmt.Errorf("席位数 %d 超出范围 (0-%d)", seats, SEAT_MAX)
+	}
+	record := make([]byte, SEAT_RECORD_SIZE)
+	copy(record, SEAT_RECORD_MAGIC)
+	binary.LittleEndian.PutUint16(record[4:6], uint16(seats))
+	binary.LittleEndian.PutUint32(record[8:12], crc32.ChecksumIEEE(record[:8]))
+	return record, nil
+}
+
+// decodeSeatRecord 解析席位记录
+func decodeSeatRecord(record []byte) (int, error) {
+	if len(record) < SEAT_RECORD_SIZE || string(record[:4]) != SEAT_RECORD_MAGIC {
+		return 0, ErrNoSeatRecord
+	}
+	if crc32.ChecksumIEEE(record[:8]) != binary.LittleEndian.Uint32(record[8:12]) {
+		return 0, fmt.Errorf("席位记录校验失败，记录文件可能已损坏")
+	}
+	return int(binary.LittleEndian.Uint16(record[4:6])), nil
+}
+
+// readSeatLimit 从席位记录文件读取席位上限
+func readSeatLimit(c *deviceConn, fileID uint16) (int, error) {
+	record := make([]byte, SEAT_RECORD_SIZE)
+	if err := c.readDataFile(fileID, record); err != nil {
+		return 0, fmt.Errorf("读取席位记录文件 0x%04X 失败: %v", fileID, err)
+	}
+	return decodeSeatRecord(record)
+}
+
+// writeSeatRecord 写入席位记录，需要开发商权限
+//
+// 记录文件不存在时创建；已存在但大小不符或内容不是席位记录 (也不是全零)
+// 时报错，避免覆盖客户自己的文件。
+func writeSeatRecord(c *deviceConn, fileID uint16, record []byte) error {
+	list, err := c.listFiles()
+	if err != nil {
+		return fmt.Errorf("列举数据文件失败: %v", err)
+	}
+	for _, item := range list {
+		if item.MFileID != fileID {
+			continue
+		}
+		if item.MAttr.MSize != SEAT_RECORD_SIZE {
+			return fmt.Errorf("文件ID 0x%04X 已被占用 (大小 %d)，请用 -seat-file 指定其他文件ID", fileID, item.MAttr.MSize)
+		}
+		current := make([]byte, SEAT_RECORD_SIZE)
+		if err := c.readDataFile(fileID, current); err != nil {
+			return fmt.Errorf("读取席位记录文件失败: %v", err)
+		}
+		if string(current[:4]) != SEAT_RECORD_MAGIC && !bytes.Equal(current, make([]byte, SEAT_RECORD_SIZE)) {
+			return fmt.Errorf("文件ID 0x%04X 不是席位记录文件，请用 -seat-file 指定其他文件ID", fileID)
+		}
+		return c.writeDataFile(fileID, record)
+	}
+	attr := DataFileAttr{MSize: SEAT_RECORD_SIZE, MReadPriv: FILE_PRIV_ANONYMOUS, MWritePriv: FILE_PRIV_ADMIN}
+	return c.createDataFileWithContent(fileID, attr, record)
+}
+
+// ============ 租约管理 ============
+
+// seatLease 一个席位租约
+type seatLease struct {
+	ID      string    `json:"id"`
+	Client  string    `json:"client"` // 客户端证书的 CommonName
+	Granted time.Time `json:"granted"`
+	Expires time.Time `json:"expires"`
+}
+
+// leaseState 持久化的租约状态
+type leaseState struct {
+	Leases []seatLease `json:"leases"`
+}
+
+// leaseManager 租约表，所有修改都立即写入状态文件
+type leaseManager struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	path   string
+	leases map[string]*seatLease
+}
+
+// newLeaseManager 创建租约表并加载上次保存的状态
+func newLeaseManager(path string, ttl time.Duration) (*leaseManager, error) {
+	m := &leaseManager{ttl: ttl, path: path, leases: make(map[string]*seatLease)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state leaseState
+	if _, err := openEnvelope(data, nil, &state); err != nil {
+		return nil, fmt.Errorf("租约状态文件 %s 无效: %v", path, err)
+	}
+	for i := range state.Leases {
+		lease := state.Leases[i]
+		m.leases[lease.ID] = &lease
+	}
+	m.expire(time.Now())
+	return m, nil
+}
+
+// expire 回收过期租约，返回是否有变化，调用方持有锁
+func (m *leaseManager) expire(now time.Time) bool {
+	changed := false
+	for id, lease := range m.leases {
+		if !now.Before(lease.Expires) {
+			logger.Info("租约过期", "lease", id, "client", lease.Client)
+			delete(m.leases, id)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// save 原子地写入状态文件，调用方持有锁
+func (m *leaseManager) save() error {
+	state := leaseState{Leases: m.list()}
+	data, err := sealEnvelope(state, nil)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+// list 按申请时间排序的租约列表，调用方持有锁
+func (m *leaseManager) list() []seatLease {
+	leases := make([]seatLease, 0, len(m.leases))
+	for _, lease := range m.leases {
+		leases = append(leases, *lease)
+	}
+	sort.Slice(leases, func(i, j int) bool { return leases[i].Granted.Before(leases[j].Granted) })
+	return leases
+}
+
+// acquire 在席位未满时分配新租约
+func (m *leaseManager) acquire(client string, seats int) (seatLease, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.expire(now)
+	if len(m.leases) >= seats {
+		return seatLease{}, ErrNoSeats
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return seatLease{}, err
+	}
+	lease := &seatLease{ID: hex.EncodeToString(id), Client: client, Granted: now, Expires: now.Add(m.ttl)}
+	m.leases[lease.ID] = lease
+	if err := m.save(); err != nil {
+		delete(m.leases, lease.ID)
+		return seatLease{}, fmt.Errorf("保存租约状态失败: %v", err)
+	}
+	return *lease, nil
+}
+
+// renew 延长租约有效期，只允许持有者续约
+//
+// 席位上限被调低后，超出部分的租约不再续约，按申请时间先后保留。
+func (m *leaseManager) renew(id, client string, seats int) (seatLease, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	changed := m.expire(now)
+	lease, ok := m.leases[id]
+	if !ok || lease.Client != client {
+		if changed {
+			m.save()
+		}
+		return seatLease{}, ErrLeaseNotFound
+	}
+
+	rank := 0
+	for _, other := range m.leases {
+		if other.Granted.Before(lease.Granted) {
+			rank++
+		}
+	}
+	if rank >= seats {
+		delete(m.leases, id)
+		m.save()
+		return seatLease{}, ErrNoSeats
+	}
+
+	lease.Expires = now.Add(m.ttl)
+	if err := m.save(); err != nil {
+		return seatLease{}, fmt.Errorf("保存租约状态失败: %v", err)
+	}
+	return *lease, nil
+}
+
+// release 归还租约
+func (m *leaseManager) release(id, client string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lease, ok := m.leases[id]
+	if !ok || lease.Client != client {
+		return ErrLeaseNotFound
+	}
+	delete(m.leases, id)
+	return m.save()
+}
+
+// snapshot 回收过期租约后返回当前租约列表
+func (m *leaseManager) snapshot() []seatLease {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.expire(time.Now()) {
+		m.save()
+	}
+	return m.list()
+}
+
+// sweep 定时回收过期租约，直到 ctx 结束
+func (m *leaseManager) sweep(ctx context.Context) {
+	ticker := time.NewTicker(m.ttl / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.snapshot()
+		}
+	}
+}
+
+// ============ 租约接口 ============
+
+// leaseServer 通过双向 TLS 提供租约接口
+type leaseServer struct {
+	session  *Session
+	leases   *leaseManager
+	seatFile uint16 // 席位记录文件ID
+	timeout  time.Duration
+}
+
+// handler 返回租约接口的 HTTP 处理器
+func (srv *leaseServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/leases", srv.handleList)
+	mux.HandleFunc("POST /v1/leases", srv.handleAcquire)
+	mux.HandleFunc("POST /v1/leases/{id}/renew", srv.handleRenew)
+	mux.HandleFunc("DELETE /v1/leases/{id}", srv.handleRelease)
+	return mux
+}
+
+// clientName 返回已验证的客户端证书名称
+func clientName(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return "", false
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName, true
+}
+
+// seats 从加密锁读取席位上限，同时确认锁仍然在线
+func (srv *leaseServer) seats(r *http.Request) (int, error) {
+	ctx, cancel := context.WithTimeout(r.Context(), srv.timeout)
+	defer cancel()
+
+	record := make([]byte, SEAT_RECORD_SIZE)
+	if err := srv.session.ReadFile(ctx, srv.seatFile, 0, record); err != nil {
+		var dongleErr *DongleError
+		if errors.As(err, &dongleErr) && dongleErr.RetCode == DONGLE_INVALID_FILEID {
+			return 0, ErrNoSeatRecord
+		}
+		return 0, err
+	}
+	return decodeSeatRecord(record)
+}
+
+// writeLeaseError 写入租约接口的错误响应
+func writeLeaseError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNoSeats):
+		writeJSON(w, http.StatusConflict, errorResponse{Error: err.Error()})
+	case errors.Is(err, ErrLeaseNotFound):
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+	case errors.Is(err, ErrNoSeatRecord):
+		writeJSON(w, http.StatusServiceUnavailable, errorResponse{Error: err.Error()})
+	default:
+		var dongleErr *DongleError
+		if errors.As(err, &dongleErr) || errors.Is(err, context.DeadlineExceeded) {
+			// 加密锁不可用时不再发放或续约
+			writeJSON(w, http.StatusServiceUnavailable, errorResponse{Error: fmt.Sprintf("加密锁不可用: %v", err)})
+			return
+		}
+		writeError(w, err)
+	}
+}
+
+func (srv *leaseServer) handleList(w http.ResponseWriter, r *http.Request) {
+	seats, err := srv.seats(r)
+	if err != nil {
+		writeLeaseError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"seats": seats, "leases": srv.leases.snapshot()})
+}
+
+func (srv *leaseServer) handleAcquire(w http.ResponseWriter, r *http.Request) {
+	client, ok := clientName(r)
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "需要客户端证书"})
+		return
+	}
+	seats, err := srv.seats(r)
+	if err != nil {
+		writeLeaseError(w, err)
+		return
+	}
+	lease, err := srv.leases.acquire(client, seats)
+	if err != nil {
+		writeLeaseError(w, err)
+		return
+	}
+	logger.Info("发放租约", "lease", lease.ID, "client", client)
+	writeJSON(w, http.StatusOK, lease)
+}
+
+func (srv *leaseServer) handleRenew(w http.ResponseWriter, r *http.Request) {
+	client, ok := clientName(r)
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "需要客户端证书"})
+		return
+	}
+	seats, err := srv.seats(r)
+	if err != nil {
+		writeLeaseError(w, err)
+		return
+	}
+	lease, err := srv.leases.renew(r.PathValue("id"), client, seats)
+	if err != nil {
+		writeLeaseError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, lease)
+}
+
+func (srv *leaseServer) handleRelease(w http.ResponseWriter, r *http.Request) {
+	client, ok := clientName(r)
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "需要客户端证书"})
+		return
+	}
+	if err := srv.leases.release(r.PathValue("id"), client); err != nil {
+		writeLeaseError(w, err)
+		return
+	}
+	logger.Info("归还租约", "lease", r.PathValue("id"), "client", client)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadServerTLS 加载服务端证书，并要求客户端出示由指定 CA 签发的证书
+func loadServerTLS(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("加载服务端证书失败: %v", err)
+	}
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("%s 中没有有效的 CA 证书", caFile)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// ============ 命令行 ============
+
+// runLicenseCommand 执行 license 子命令
+func runLicenseCommand(args []string) error {
+	if len(args) == 0 || args[0] != "seats" {
+		return fmt.Errorf("用法: license seats [-set N] [选项]")
+	}
+
+	fs := flag.NewFlagSet("license seats", flag.ContinueOnError)
+	index := fs.Int("device", 0, "设备序号")
+	set := fs.Int("set", -1, "设置席位上限")
+	pin := fs.String("pin", DEFAULT_ADMIN_PIN, "开发商PIN，设置席位上限时需要")
+	seatFile := fs.String("seat-file", fmt.Sprintf("0x%04X", SEAT_FILE_ID), "席位记录文件ID")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	fileID, err := parseFileID(*seatFile)
+	if err != nil {
+		return fmt.Errorf("-seat-file: %v", err)
+	}
+
+	ctx, stop := commandContext()
+	defer stop()
+
+	conn, err := connectDevice(ctx, *index)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+
+	if *set >= 0 {
+		record, err := encodeSeatRecord(*set)
+		if err != nil {
+			return err
+		}
+		if err := conn.verifyPIN(FLAG_ADMINPIN, *pin); err != nil {
+			return fmt.Errorf("校验开发商PIN失败: %v", err)
+		}
+		if err := writeSeatRecord(conn, fileID, record); err != nil {
+			return fmt.Errorf("写入席位记录失败: %v", err)
+		}
+	}
+
+	seats, err := readSeatLimit(conn, fileID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("席位上限: %d\n", seats)
+	return nil
+}
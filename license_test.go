@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yangmaoqiu/golang/client"
+)
+
+// testPKI 测试用的 CA，签发服务端和客户端证书
+type testPKI struct {
+	dir    string
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	serial int64
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "rockey test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	p := &testPKI{dir: t.TempDir(), caCert: cert, caKey: key, serial: 1}
+	p.write(t, "ca.pem", "CERTIFICATE", der)
+	return p
+}
+
+func (p *testPKI) write(t *testing.T, name, typ string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(p.dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// issue 签发证书，返回证书和私钥文件路径
+func (p *testPKI) issue(t *testing.T, name string, usage x509.ExtKeyUsage) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(p.serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, p.caCert, &key.PublicKey, p.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.write(t, name+".pem", "CERTIFICATE", der), p.write(t, name+".key", "EC PRIVATE KEY", keyDER)
+}
+
+// leaseFixture 使用模拟后端的浮动授权服务
+type leaseFixture struct {
+	pki     *testPKI
+	addr    string
+	sim     *simBackend
+	session *Session
+	leases  *leaseManager
+}
+
+func startLeaseServer(t *testing.T, seats int, ttl time.Duration) *leaseFixture {
+	t.Helper()
+	backend, err := openBackend("sim", 1, seats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := OpenSession(context.Background(), backend, 0, SessionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { session.Close() })
+
+	pki := newTestPKI(t)
+	certFile, keyFile := pki.issue(t, "server", x509.ExtKeyUsageServerAuth)
+	tlsConfig, err := loadServerTLS(certFile, keyFile, filepath.Join(pki.dir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	leases, err := newLeaseManager(filepath.Join(t.TempDir(), "leases.json"), ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &leaseServer{session: session, leases: leases, seatFile: SEAT_FILE_ID, timeout: 5 * time.Second}
+	httpServer := &http.Server{Handler: srv.handler()}
+	go httpServer.Serve(listener)
+	t.Cleanup(func() { httpServer.Close() })
+
+	return &leaseFixture{pki: pki, addr: listener.Addr().String(), sim: backend.(*simBackend), session: session, leases: leases}
+}
+
+// client 创建使用指定证书名称的客户端
+func (f *leaseFixture) client(t *testing.T, name string) *client.LeaseClient {
+	t.Helper()
+	certFile, keyFile := f.pki.issue(t, name, x509.ExtKeyUsageClientAuth)
+	tlsConfig, err := client.LoadTLSConfig(certFile, keyFile, filepath.Join(f.pki.dir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.NewLeaseClient(f.addr, tlsConfig)
+}
+
+// setSeats 修改加密锁中的席位上限
+func (f *leaseFixture) setSeats(t *testing.T, seats int) {
+	t.Helper()
+	record, err := encodeSeatRecord(seats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.sim.SetFile(SEAT_FILE_ID, record)
+}
+
+// leaseStatus 返回客户端错误的 HTTP 状态码
+func leaseStatus(err error) int {
+	var clientErr *client.Error
+	if errors.As(err, &clientErr) {
+		return clientErr.Status
+	}
+	return 0
+}
+
+func TestLeaseAcquireSeatLimit(t *testing.T) {
+	f := startLeaseServer(t, 2, time.Minute)
+	ctx := context.Background()
+	a, b, c := f.client(t, "client-a"), f.client(t, "client-b"), f.client(t, "client-c")
+
+	leaseA, err := a.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaseA.Client != "client-a" {
+		t.Errorf("Client = %s", leaseA.Client)
+	}
+	if _, err := b.Acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Acquire(ctx); leaseStatus(err) != http.StatusConflict {
+		t.Fatalf("席位已满时 Acquire = %v, 期望 409", err)
+	}
+
+	seats, leases, err := c.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seats != 2 || len(leases) != 2 {
+		t.Errorf("Status = %d 席位, %d 个租约", seats, len(leases))
+	}
+
+	// 只有持有者可以归还，归还后其他客户端可以申请
+	if err := c.Release(ctx, leaseA.ID); leaseStatus(err) != http.StatusNotFound {
+		t.Fatalf("归还他人的租约 = %v, 期望 404", err)
+	}
+	if err := a.Release(ctx, leaseA.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Acquire(ctx); err != nil {
+		t.Fatalf("归还后 Acquire = %v", err)
+	}
+}
+
+func TestLeaseConcurrentAcquire(t *testing.T) {
+	f := startLeaseServer(t, 3, time.Minute)
+	ctx := context.Background()
+
+	const clients = 8
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	granted, rejected := 0, 0
+	for i := 0; i < clients; i++ {
+		c := f.client(t, "client-"+string(rune('a'+i)))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Acquire(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				granted++
+			case leaseStatus(err) == http.StatusConflict:
+				rejected++
+			default:
+				t.Errorf("Acquire = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if granted != 3 || rejected != clients-3 {
+		t.Fatalf("发放 %d 个，拒绝 %d 个，期望 3 / %d", granted, rejected, clients-3)
+	}
+}
+
+func TestLeaseRenewAndExpire(t *testing.T) {
+	const ttl = 1500 * time.Millisecond // KeepAlive 至少间隔一秒续约
+	f := startLeaseServer(t, 1, ttl)
+	ctx := context.Background()
+	a, b := f.client(t, "client-a"), f.client(t, "client-b")
+
+	lease, err := a.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Renew(ctx, lease.ID); leaseStatus(err) != http.StatusNotFound {
+		t.Fatalf("续约他人的租约 = %v, 期望 404", err)
+	}
+
+	// 持续续约的租约不会过期
+	keepCtx, stop := context.WithCancel(ctx)
+	errs := a.KeepAlive(keepCtx, lease)
+	time.Sleep(2 * ttl)
+	if _, err := b.Acquire(ctx); leaseStatus(err) != http.StatusConflict {
+		t.Fatalf("续约中的租约被回收: Acquire = %v", err)
+	}
+	renewed, err := a.Renew(ctx, lease.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !renewed.Expires.After(lease.Expires) {
+		t.Errorf("续约后到期时间 %v 未晚于 %v", renewed.Expires, lease.Expires)
+	}
+	stop()
+	for err := range errs {
+		t.Fatalf("KeepAlive: %v", err)
+	}
+
+	// 停止续约后租约过期，席位可以被其他客户端申请
+	time.Sleep(ttl + 200*time.Millisecond)
+	if _, err := a.Renew(ctx, lease.ID); leaseStatus(err) != http.StatusNotFound {
+		t.Fatalf("过期后 Renew = %v, 期望 404", err)
+	}
+	if _, err := b.Acquire(ctx); err != nil {
+		t.Fatalf("过期后 Acquire = %v", err)
+	}
+}
+
+func TestLeaseSeatLimitLowered(t *testing.T) {
+	f := startLeaseServer(t, 2, time.Minute)
+	ctx := context.Background()
+	a, b := f.client(t, "client-a"), f.client(t, "client-b")
+
+	first, err := a.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	second, err := b.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 调低上限后先申请的租约保留，超出部分不再续约
+	f.setSeats(t, 1)
+	if _, err := a.Renew(ctx, first.ID); err != nil {
+		t.Fatalf("保留的租约 Renew = %v", err)
+	}
+	if _, err := b.Renew(ctx, second.ID); leaseStatus(err) != http.StatusConflict {
+		t.Fatalf("超出上限的租约 Renew = %v, 期望 409", err)
+	}
+	if _, err := b.Acquire(ctx); leaseStatus(err) != http.StatusConflict {
+		t.Fatalf("席位已满时 Acquire = %v, 期望 409", err)
+	}
+}
+
+func TestLeaseDongleUnavailable(t *testing.T) {
+	f := startLeaseServer(t, 2, time.Minute)
+	ctx := context.Background()
+	a := f.client(t, "client-a")
+
+	lease, err := a.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 加密锁拔出后不再发放或续约
+	f.sim.Unplug()
+	if _, err := a.Renew(ctx, lease.ID); leaseStatus(err) != http.StatusServiceUnavailable {
+		t.Fatalf("拔出后 Renew = %v, 期望 503", err)
+	}
+	if _, err := a.Acquire(ctx); leaseStatus(err) != http.StatusServiceUnavailable {
+		t.Fatalf("拔出后 Acquire = %v, 期望 503", err)
+	}
+}
+
+// TestLeaseNoSeatFile 席位记录文件不存在时拒绝发放，且数据区不被占用
+func TestLeaseNoSeatFile(t *testing.T) {
+	f := startLeaseServer(t, 0, time.Minute)
+	ctx := context.Background()
+	a := f.client(t, "client-a")
+
+	var clientErr *client.Error
+	if _, err := a.Acquire(ctx); !errors.As(err, &clientErr) || clientErr.Status != http.StatusServiceUnavailable ||
+		clientErr.Message != ErrNoSeatRecord.Error() {
+		t.Fatalf("没有席位记录时 Acquire = %v, 期望 503 %q", err, ErrNoSeatRecord)
+	}
+
+	f.setSeats(t, 1)
+	if _, err := a.Acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	zone := make([]byte, DATA_ZONE_SIZE)
+	if err := f.session.ReadData(ctx, 0, zone); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(zone, make([]byte, DATA_ZONE_SIZE)) {
+		t.Error("席位记录写入了数据区")
+	}
+}
+
+func TestLeaseStatePersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases.json")
+	m, err := newLeaseManager(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lease, err := m.acquire("client-a", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 重启后按状态文件恢复租约
+	restarted, err := newLeaseManager(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restarted.acquire("client-b", 1); !errors.Is(err, ErrNoSeats) {
+		t.Fatalf("重启后 acquire = %v, 期望 ErrNoSeats", err)
+	}
+	if _, err := restarted.renew(lease.ID, "client-a", 1); err != nil {
+		t.Fatalf("重启后 renew = %v", err)
+	}
+}
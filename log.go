@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// ============ 日志 ============
+//
+// 动态库调用的跟踪信息和各辅助函数的提示统一通过 log/slog 输出。
+// 作为库嵌入时默认不输出任何内容，调用方可以用 SetLogger 接入自己的日志；
+// 命令行程序在解析参数后按 -v 和 -log-format 配置输出到标准错误。
+
+// logger 全局日志，默认丢弃所有记录
+var logger = slog.New(discardHandler{})
+
+// SetLogger 设置全局日志，传入 nil 时恢复为不输出
+func SetLogger(l *slog.Logger) {
+	if l == nil {
+		l = slog.New(discardHandler{})
+	}
+	logger = l
+}
+
+// setupLogging 按命令行参数配置日志：默认输出 Info 及以上级别，-v 时输出调用跟踪
+func setupLogging(w io.Writer, verbose bool, format string) error {
+	opts := &slog.HandlerOptions{Level: slog.LevelInfo}
+	if verbose {
+		opts.Level = slog.LevelDebug
+	}
+
+	switch format {
+	case "text":
+		SetLogger(slog.New(slog.NewTextHandler(w, opts)))
+	case "json":
+		SetLogger(slog.New(slog.NewJSONHandler(w, opts)))
+	default:
+		return fmt.Errorf("不支持的日志格式: %s (可选 text, json)", format)
+	}
+	return nil
+}
+
+// traceCall 以 Debug 级别记录一次原生函数调用
+func traceCall(function string, handle DongleHandle, retCode uint32, start time.Time, attrs ...any) {
+	ctx := context.Background()
+	if !logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	args := []any{
+		slog.String("function", function),
+		slog.String("code", fmt.Sprintf("%08X", retCode)),
+		slog.String("desc", getErrorDescription(retCode)),
+		slog.String("handle", fmt.Sprintf("0x%x", uintptr(handle))),
+		slog.Duration("duration", time.Since(start)),
+	}
+	logger.Log(ctx, slog.LevelDebug, "调用", append(args, attrs...)...)
+}
+
+// discardHandler 丢弃所有记录的 slog.Handler
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (d discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return d }
+func (d discardHandler) WithGroup(string) slog.Handler           { return d }
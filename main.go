@@ -1,960 +1,933 @@
-package main
-
-import (
-	"encoding/hex"
-	"flag"
-	"fmt"
-	"os"
-	"os/exec"
-	"path/filepath"
-	"runtime"
-	"unsafe"
-
-	"github.com/ebitengine/purego"
-)
-
-// ============ 常量定义 ============
-
-// 错误码定义
-const (
-	DONGLE_SUCCESS           = 0x00000000 // 成功
-	DONGLE_NOT_FOUND         = 0xF0000001 // 未找到设备
-	DONGLE_INVALID_HANDLE    = 0xF0000002 // 无效句柄
-	DONGLE_INVALID_PARAMETER = 0xF0000003 // 无效参数
-	DONGLE_ACCESS_DENIED     = 0xF0000004 // 访问被拒绝
-	DONGLE_NO_MORE_DEVICE    = 0xF0000005 // 没有更多设备
-	DONGLE_NEED_FIND         = 0xF0000006 // 需要查找设备
-	DONGLE_INVALID_PASSWORD  = 0xF0000007 // 无效密码
-	DONGLE_INVALID_DEVID     = 0xF0000008 // 无效设备ID
-	DONGLE_INVALID_BUFFER    = 0xF0000009 // 无效缓冲区
-	DONGLE_INVALID_FILEID    = 0xF000000A // 无效文件ID
-	DONGLE_INVALID_OFFSET    = 0xF000000B // 无效偏移量
-	DONGLE_INVALID_SIZE      = 0xF000000C // 无效大小
-	DONGLE_UNKNOWN_ERROR     = 0xFFFFFFFF // 未知错误
-)
-
-// 函数名称常量
-const (
-	FUNC_ENUM     = "Dongle_Enum"
-	FUNC_OPEN     = "Dongle_Open"
-	FUNC_READFILE = "Dongle_ReadFile"
-	FUNC_CLOSE    = "Dongle_Close"
-)
-
-// 测试常量
-const (
-	TEST_BUFFER_SIZE = 1024   // 测试缓冲区大小
-	TEST_FILE_ID     = 0x0001 // 测试文件ID
-	TEST_OFFSET      = 0      // 测试偏移量
-)
-
-// ============ 结构体定义 ============
-
-// DongleInfo 设备信息结构体
-type DongleInfo struct {
-	MVer      uint16  // 版本号
-	MType     uint16  // 类型
-	MBirthDay [8]byte // 生产日期
-	MAgent    uint32  // 代理商ID
-	MPID      uint32  // 产品ID
-	MUserID   uint32  // 用户ID
-	MHID      [8]byte // 硬件ID
-	MIsMother uint32  // 是否母锁
-	MDevType  uint32  // 设备类型
-}
-
-// DongleHandle 设备句柄
-type DongleHandle uintptr
-
-// ============ 全局变量 ============
-
-var (
-	// 命令行参数
-	testMode     = flag.Bool("test", false, "运行设备测试")
-	platformTest = flag.Bool("platform", false, "运行平台测试")
-	readTest     = flag.Bool("read-test", false, "运行读取文件参数测试")
-	help         = flag.Bool("h", false, "显示帮助信息")
-	helpLong     = flag.Bool("help", false, "显示帮助信息")
-	diagnoseMode = flag.Bool("diagnose", false, "运行详细诊断模式")
-)
-
-// ============ 辅助函数 ============
-
-// getLibraryPath 根据系统架构返回库文件路径
-func getLibraryPath() string {
-	if runtime.GOOS != "linux" {
-		return ""
-	}
-
-	switch runtime.GOARCH {
-	case "arm64", "aarch64":
-		return "./lib/linux/arm64/libRockeyARM.so.0.3"
-	case "loong64":
-		return "./lib/linux/loong64/libRockeyARM.so.0.3"
-	case "amd64", "x86_64":
-		return "./lib/linux/libRockeyARM.so"
-	default:
-		return "./lib/linux/libRockeyARM.so"
-	}
-}
-
-// loadLibrary 加载动态库
-func loadLibrary(libPath string) (uintptr, error) {
-	// 检查文件是否存在
-	fileInfo, err := os.Stat(libPath)
-	if err != nil {
-		return 0, fmt.Errorf("库文件不存在: %v", err)
-	}
-
-	fmt.Printf("  库文件信息: 大小=%d字节, 权限=%v\n", fileInfo.Size(), fileInfo.Mode())
-
-	// 使用 purego 加载库
-	handle, err := purego.Dlopen(libPath, purego.RTLD_LAZY)
-	if err != nil {
-		return 0, fmt.Errorf("加载库失败: %v", err)
-	}
-
-	fmt.Printf("  库句柄: 0x%x\n", handle)
-
-	return handle, nil
-}
-
-// getProcAddress 获取函数地址
-func getProcAddress(handle uintptr, funcName string) (uintptr, error) {
-	// 使用 purego 获取函数地址
-	addr, err := purego.Dlsym(handle, funcName)
-	if err != nil {
-		// 尝试带下划线的版本
-		underscoreName := "_" + funcName
-		addr, err = purego.Dlsym(handle, underscoreName)
-		if err != nil {
-			return 0, fmt.Errorf("找不到函数 %s: %v (尝试了 %s 和 %s)", funcName, err, funcName, underscoreName)
-		}
-		fmt.Printf("  函数 %s 找到 (使用带下划线版本: %s)\n", funcName, underscoreName)
-	} else {
-		fmt.Printf("  函数 %s 找到\n", funcName)
-	}
-	fmt.Printf("  函数地址: 0x%x\n", addr)
-	return addr, nil
-}
-
-// getErrorDescription 获取错误码描述
-func getErrorDescription(errorCode uint32) string {
-	switch errorCode {
-	case DONGLE_SUCCESS:
-		return "成功"
-	case DONGLE_NOT_FOUND:
-		return "未找到设备"
-	case DONGLE_INVALID_HANDLE:
-		return "无效句柄"
-	case DONGLE_INVALID_PARAMETER:
-		return "无效参数"
-	case DONGLE_ACCESS_DENIED:
-		return "访问被拒绝"
-	case DONGLE_NO_MORE_DEVICE:
-		return "没有更多设备"
-	case DONGLE_NEED_FIND:
-		return "需要查找设备"
-	case DONGLE_INVALID_PASSWORD:
-		return "无效密码"
-	case DONGLE_INVALID_DEVID:
-		return "无效设备ID"
-	case DONGLE_INVALID_BUFFER:
-		return "无效缓冲区"
-	case DONGLE_INVALID_FILEID:
-		return "无效文件ID"
-	case DONGLE_INVALID_OFFSET:
-		return "无效偏移量"
-	case DONGLE_INVALID_SIZE:
-		return "无效大小"
-	case DONGLE_UNKNOWN_ERROR:
-		return "未知错误"
-	default:
-		return fmt.Sprintf("未知错误码: %08X", errorCode)
-	}
-}
-
-// showBinHex 显示二进制数据的十六进制格式
-func showBinHex(data []byte) {
-	// 每行显示 16 字节
-	for i := 0; i < len(data); i += 16 {
-		// 显示十六进制
-		for j := 0; j < 16; j++ {
-			if i+j < len(data) {
-				fmt.Printf("%02X ", data[i+j])
-			} else {
-				fmt.Printf("   ") // 对齐用空格
-			}
-
-			if j == 7 {
-				fmt.Printf("- ")
-			}
-		}
-
-		fmt.Printf("    ")
-
-		// 显示 ASCII 字符
-		for j := 0; j < 16 && i+j < len(data); j++ {
-			b := data[i+j]
-			if b >= 32 && b <= 126 {
-				fmt.Printf("%c", b)
-			} else {
-				fmt.Printf(".")
-			}
-		}
-
-		fmt.Println()
-	}
-	fmt.Println()
-}
-
-// contains 检查字符串是否包含子串
-func contains(s, substr string) bool {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return true
-		}
-	}
-	return false
-}
-
-// ============ 核心功能函数 ============
-
-// enumDevices 枚举设备
-func enumDevices(enumFunc uintptr) ([]DongleInfo, int, uint32, error) {
-	var countLocal int32
-
-	fmt.Printf("  调用枚举函数，地址: 0x%x\n", enumFunc)
-
-	// 使用 purego 的正确方式调用函数
-	// 定义函数原型
-	type EnumFuncType func(infoList unsafe.Pointer, count *int32) uint32
-
-	// 将函数指针转换为可调用的函数
-	var enumFuncGo EnumFuncType
-	purego.RegisterFunc(&enumFuncGo, enumFunc)
-
-	// 第一次调用获取设备数量
-	fmt.Printf("  第一次调用: 获取设备数量\n")
-	fmt.Printf("  参数: infoList=0 (NULL), count=%p\n", unsafe.Pointer(&countLocal))
-
-	retCode := enumFuncGo(nil, &countLocal)
-	fmt.Printf("  返回码: 0x%08X (%s)\n", retCode, getErrorDescription(retCode))
-
-	if retCode != DONGLE_SUCCESS {
-		return nil, 0, retCode, fmt.Errorf(getErrorDescription(retCode))
-	}
-
-	fmt.Printf("  找到设备数量: %d\n", countLocal)
-
-	if countLocal == 0 {
-		return nil, 0, DONGLE_NOT_FOUND, fmt.Errorf(getErrorDescription(DONGLE_NOT_FOUND))
-	}
-
-	// 分配内存存储设备信息
-	count := int(countLocal)
-	keyList := make([]DongleInfo, count)
-
-	fmt.Printf("  分配设备信息数组: %d 个元素\n", count)
-
-	// 第二次调用获取详细信息
-	fmt.Printf("  第二次调用: 获取设备详细信息\n")
-	fmt.Printf("  参数: infoList=%p, count=%p\n", unsafe.Pointer(&keyList[0]), unsafe.Pointer(&countLocal))
-
-	retCode = enumFuncGo(unsafe.Pointer(&keyList[0]), &countLocal)
-	fmt.Printf("  返回码: 0x%08X (%s)\n", retCode, getErrorDescription(retCode))
-
-	if retCode != DONGLE_SUCCESS {
-		return nil, 0, retCode, fmt.Errorf(getErrorDescription(retCode))
-	}
-
-	fmt.Printf("  设备信息获取成功\n")
-	return keyList, count, retCode, nil
-}
-
-// openDevice 打开设备
-func openDevice(openFunc uintptr, index int) (DongleHandle, uint32, error) {
-	var hKeyLocal DongleHandle
-
-	fmt.Printf("  调用打开函数，地址: 0x%x\n", openFunc)
-	fmt.Printf("  参数: handle=%p, index=%d\n", unsafe.Pointer(&hKeyLocal), index)
-
-	// 使用 purego 的正确方式调用函数
-	// 定义函数原型
-	type OpenFuncType func(handle *DongleHandle, index int) uint32
-
-	// 将函数指针转换为可调用的函数
-	var openFuncGo OpenFuncType
-	purego.RegisterFunc(&openFuncGo, openFunc)
-
-	retCode := openFuncGo(&hKeyLocal, index)
-	fmt.Printf("  返回码: 0x%08X (%s)\n", retCode, getErrorDescription(retCode))
-
-	if retCode != DONGLE_SUCCESS {
-		return 0, retCode, fmt.Errorf(getErrorDescription(retCode))
-	}
-
-	fmt.Printf("  设备句柄: 0x%x\n", hKeyLocal)
-	return hKeyLocal, retCode, nil
-}
-
-// readFile 读取文件
-func readFile(readFileFunc uintptr, handle DongleHandle, fileID, offset uintptr, buffer []byte) (uint32, int, error) {
-	if handle == 0 {
-		return DONGLE_INVALID_HANDLE, 0, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
-	}
-
-	if len(buffer) == 0 {
-		return DONGLE_INVALID_BUFFER, 0, fmt.Errorf(getErrorDescription(DONGLE_INVALID_BUFFER))
-	}
-
-	fmt.Printf("  调用读取文件函数，地址: 0x%x\n", readFileFunc)
-	fmt.Printf("  参数: handle=0x%x, fileID=0x%x, offset=0x%x, buffer=%p, size=%d\n",
-		handle, fileID, offset, unsafe.Pointer(&buffer[0]), len(buffer))
-
-	// 使用 purego 的正确方式调用函数
-	// 尝试不同的函数原型
-	// 方式1: 5个参数的函数原型（不包含文件类型）
-	type ReadFileFuncType1 func(handle DongleHandle, fileID uintptr, offset uintptr, buffer unsafe.Pointer, size uintptr) uint32
-
-	// 方式2: 6个参数的函数原型（包含文件类型）
-	type ReadFileFuncType2 func(handle DongleHandle, fileType uintptr, fileID uintptr, offset uintptr, buffer unsafe.Pointer, size uintptr) uint32
-
-	// 先尝试方式1（5个参数）
-	var readFileFuncGo1 ReadFileFuncType1
-	purego.RegisterFunc(&readFileFuncGo1, readFileFunc)
-
-	retCode := readFileFuncGo1(handle, fileID, offset, unsafe.Pointer(&buffer[0]), uintptr(len(buffer)))
-	fmt.Printf("  调用结果 (5参数): 返回码=0x%08X (%s)\n", retCode, getErrorDescription(retCode))
-
-	if retCode == DONGLE_SUCCESS {
-		return retCode, len(buffer), nil
-	}
-
-	// 如果方式1失败，尝试方式2（6个参数）
-	fmt.Printf("  尝试方式2: 6个参数（包含文件类型）\n")
-	var readFileFuncGo2 ReadFileFuncType2
-	purego.RegisterFunc(&readFileFuncGo2, readFileFunc)
-
-	// 假设文件类型为1（数据文件）
-	fileType := uintptr(1)
-	retCode = readFileFuncGo2(handle, fileType, fileID, offset, unsafe.Pointer(&buffer[0]), uintptr(len(buffer)))
-	fmt.Printf("  调用结果 (6参数): 返回码=0x%08X (%s)\n", retCode, getErrorDescription(retCode))
-
-	if retCode == DONGLE_SUCCESS {
-		return retCode, len(buffer), nil
-	}
-
-	return retCode, 0, fmt.Errorf(getErrorDescription(retCode))
-}
-
-// closeDevice 关闭设备
-func closeDevice(closeFunc uintptr, handle DongleHandle) (uint32, error) {
-	if handle == 0 {
-		return DONGLE_SUCCESS, nil // 已经关闭
-	}
-
-	if closeFunc == 0 {
-		return DONGLE_SUCCESS, nil // 没有 Close 函数
-	}
-
-	// 使用 purego 的正确方式调用函数
-	// 定义函数原型
-	type CloseFuncType func(handle DongleHandle) uint32
-
-	// 将函数指针转换为可调用的函数
-	var closeFuncGo CloseFuncType
-	purego.RegisterFunc(&closeFuncGo, closeFunc)
-
-	retCode := closeFuncGo(handle)
-	fmt.Printf("  关闭设备返回码: 0x%08X (%s)\n", retCode, getErrorDescription(retCode))
-
-	if retCode != DONGLE_SUCCESS {
-		return retCode, fmt.Errorf(getErrorDescription(retCode))
-	}
-
-	return retCode, nil
-}
-
-// showDeviceInfo 显示设备信息
-func showDeviceInfo(keyList []DongleInfo, count int) {
-	if count == 0 {
-		fmt.Println("未找到任何设备")
-		return
-	}
-
-	for i := 0; i < count; i++ {
-		fmt.Printf("====== 设备 %d ======\n", i)
-		fmt.Printf("版本号: %04X\n", keyList[i].MVer)
-		fmt.Printf("生产日期: ")
-		showBinHex(keyList[i].MBirthDay[:])
-		fmt.Printf("代理商ID: %08X\n", keyList[i].MAgent)
-		fmt.Printf("产品ID: %08X\n", keyList[i].MPID)
-		fmt.Printf("用户ID: %08X\n", keyList[i].MUserID)
-		fmt.Printf("是否母锁: %08X\n", keyList[i].MIsMother)
-		fmt.Printf("硬件ID: ")
-		showBinHex(keyList[i].MHID[:])
-	}
-
-	fmt.Printf("找到的设备数量: %d\n", count)
-}
-
-// ============ 测试函数 ============
-
-// runPlatformTest 运行平台测试
-func runPlatformTest() {
-	fmt.Println("=== 运行平台测试 ===")
-	fmt.Printf("操作系统: %s\n", runtime.GOOS)
-	fmt.Printf("系统架构: %s\n", runtime.GOARCH)
-	fmt.Printf("编译器: %s\n", runtime.Compiler)
-	fmt.Printf("Go版本: %s\n", runtime.Version())
-
-	// 检查是否在Linux平台
-	if runtime.GOOS != "linux" {
-		fmt.Printf("错误: 此程序仅支持Linux平台，当前平台: %s\n", runtime.GOOS)
-		return
-	}
-
-	// 测试库路径获取
-	fmt.Println("\n=== 库路径测试 ===")
-	libPath := getLibraryPath()
-	fmt.Printf("库文件路径: %s\n", libPath)
-
-	// 检查库文件是否存在
-	if _, err := os.Stat(libPath); err != nil {
-		fmt.Printf("库文件不存在: %v\n", err)
-		fmt.Println("注意: 这可能是正常的，如果没有实际的库文件")
-	} else {
-		fmt.Println("库文件存在")
-	}
-
-	// 尝试加载库
-	fmt.Println("\n=== 动态库加载测试 ===")
-	handle, err := loadLibrary(libPath)
-	if err != nil {
-		fmt.Printf("动态库加载失败: %v\n", err)
-		fmt.Println("注意: 这可能是正常的，如果没有实际的库文件")
-	} else {
-		defer purego.Dlclose(handle)
-		fmt.Println("动态库加载成功")
-
-		// 尝试获取函数符号
-		symbols := []string{
-			FUNC_ENUM,
-			FUNC_OPEN,
-			FUNC_READFILE,
-			FUNC_CLOSE,
-		}
-
-		for _, sym := range symbols {
-			if _, err := getProcAddress(handle, sym); err != nil {
-				fmt.Printf("符号 '%s' 获取失败: %v\n", sym, err)
-			} else {
-				fmt.Printf("符号 '%s' 获取成功\n", sym)
-			}
-		}
-	}
-
-	fmt.Println("\n=== 平台测试完成 ===")
-}
-
-// runDeviceTest 运行设备测试
-func runDeviceTest() {
-	fmt.Println("=== Rockey-ARM 设备测试 ===")
-	fmt.Printf("操作系统: %s, 架构: %s\n", runtime.GOOS, runtime.GOARCH)
-
-	// 检查是否在Linux平台
-	if runtime.GOOS != "linux" {
-		fmt.Printf("错误: 此程序仅支持Linux平台，当前平台: %s\n", runtime.GOOS)
-		return
-	}
-
-	// 显示库文件路径
-	libPath := getLibraryPath()
-	fmt.Printf("库文件路径: %s\n", libPath)
-
-	// 检查当前用户权限
-	fmt.Printf("当前用户: ")
-	cmd := exec.Command("whoami")
-	if output, err := cmd.Output(); err == nil {
-		fmt.Printf("%s", output)
-	} else {
-		fmt.Printf("未知 (错误: %v)\n", err)
-	}
-
-	// 加载库
-	fmt.Println("\n加载动态库...")
-	handle, err := loadLibrary(libPath)
-	if err != nil {
-		fmt.Printf("加载库失败: %v\n", err)
-		return
-	}
-	defer func() {
-		fmt.Println("关闭动态库...")
-		purego.Dlclose(handle)
-	}()
-
-	// 获取函数地址
-	fmt.Println("\n获取函数地址...")
-	enumFunc, err := getProcAddress(handle, FUNC_ENUM)
-	if err != nil {
-		fmt.Printf("获取 %s 失败: %v\n", FUNC_ENUM, err)
-		return
-	}
-
-	openFunc, err := getProcAddress(handle, FUNC_OPEN)
-	if err != nil {
-		fmt.Printf("获取 %s 失败: %v\n", FUNC_OPEN, err)
-		return
-	}
-
-	readFileFunc, err := getProcAddress(handle, FUNC_READFILE)
-	if err != nil {
-		fmt.Printf("获取 %s 失败: %v\n", FUNC_READFILE, err)
-		return
-	}
-
-	closeFunc, err := getProcAddress(handle, FUNC_CLOSE)
-	if err != nil {
-		fmt.Printf("获取 %s 失败: %v (可能是可选的)\n", FUNC_CLOSE, err)
-		closeFunc = 0
-	} else {
-		fmt.Printf("获取 %s 成功\n", FUNC_CLOSE)
-	}
-
-	// 1. 枚举设备
-	fmt.Println("\n1. 枚举设备...")
-	keyList, count, retCode, err := enumDevices(enumFunc)
-	if err != nil {
-		fmt.Printf("设备枚举失败，错误码: %08X - %s\n", retCode, getErrorDescription(retCode))
-		if retCode == DONGLE_NOT_FOUND {
-			fmt.Println("提示: 请确保 Rockey-ARM 加密狗已正确连接到计算机")
-		} else if retCode == DONGLE_UNKNOWN_ERROR {
-			fmt.Println("提示: 未知错误，可能是:")
-			fmt.Println("  1. 动态库版本不兼容")
-			fmt.Println("  2. 函数调用参数不正确")
-			fmt.Println("  3. 系统权限不足")
-			fmt.Println("  4. 设备驱动程序未安装")
-		}
-		return
-	}
-
-	if count == 0 {
-		fmt.Println("未找到任何 Rockey-ARM 设备")
-		fmt.Println("可能的原因:")
-		fmt.Println("  1. 加密狗未连接")
-		fmt.Println("  2. 设备驱动程序未安装")
-		fmt.Println("  3. 用户权限不足（尝试使用 sudo）")
-		fmt.Println("  4. 设备被其他程序占用")
-		return
-	}
-
-	// 显示设备信息
-	showDeviceInfo(keyList, count)
-
-	// 2. 打开第一个设备
-	fmt.Println("\n2. 打开设备...")
-	deviceHandle, retCode, err := openDevice(openFunc, 0)
-	if err != nil {
-		fmt.Printf("打开设备失败，错误码: %08X - %s\n", retCode, getErrorDescription(retCode))
-		return
-	}
-	defer closeDevice(closeFunc, deviceHandle)
-
-	// 3. 读取文件
-	fmt.Println("\n3. 读取文件...")
-	buffer := make([]byte, TEST_BUFFER_SIZE)
-	retCode, dataSize, err := readFile(readFileFunc, deviceHandle, TEST_FILE_ID, TEST_OFFSET, buffer)
-	if err != nil {
-		fmt.Printf("读取文件失败，错误码: %08X - %s\n", retCode, getErrorDescription(retCode))
-		return
-	}
-
-	if retCode == DONGLE_SUCCESS {
-		fmt.Printf("成功读取 %d 字节数据\n", dataSize)
-
-		// 显示前 64 字节
-		if dataSize > 0 && len(buffer) > 0 {
-			displaySize := dataSize
-			if displaySize > 64 {
-				displaySize = 64
-			}
-			fmt.Println("文件内容（前", displaySize, "字节）：")
-			showBinHex(buffer[:displaySize])
-
-			// 显示十六进制字符串
-			fmt.Println("十六进制字符串：")
-			fmt.Println(hex.EncodeToString(buffer[:displaySize]))
-		}
-	}
-
-	fmt.Println("\n=== 设备测试完成 ===")
-}
-
-// runReadFileTest 运行读取文件测试
-func runReadFileTest() {
-	fmt.Println("=== Rockey-ARM 读取文件参数测试 ===")
-	fmt.Printf("操作系统: %s, 架构: %s\n", runtime.GOOS, runtime.GOARCH)
-
-	// 检查是否在Linux平台
-	if runtime.GOOS != "linux" {
-		fmt.Printf("错误: 此程序仅支持Linux平台，当前平台: %s\n", runtime.GOOS)
-		return
-	}
-
-	// 显示库文件路径
-	libPath := getLibraryPath()
-	fmt.Printf("库文件路径: %s\n", libPath)
-
-	// 加载库
-	handle, err := loadLibrary(libPath)
-	if err != nil {
-		fmt.Printf("加载库失败: %v\n", err)
-		return
-	}
-	defer purego.Dlclose(handle)
-
-	// 获取函数地址
-	enumFunc, err := getProcAddress(handle, FUNC_ENUM)
-	if err != nil {
-		fmt.Printf("获取 %s 失败: %v\n", FUNC_ENUM, err)
-		return
-	}
-
-	openFunc, err := getProcAddress(handle, FUNC_OPEN)
-	if err != nil {
-		fmt.Printf("获取 %s 失败: %v\n", FUNC_OPEN, err)
-		return
-	}
-
-	readFileFunc, err := getProcAddress(handle, FUNC_READFILE)
-	if err != nil {
-		fmt.Printf("获取 %s 失败: %v\n", FUNC_READFILE, err)
-		return
-	}
-
-	closeFunc, _ := getProcAddress(handle, FUNC_CLOSE)
-
-	// 枚举设备
-	fmt.Println("\n1. 枚举设备...")
-	_, count, retCode, err := enumDevices(enumFunc)
-	if err != nil {
-		fmt.Printf("设备枚举失败，错误码: %08X - %s\n", retCode, getErrorDescription(retCode))
-		if retCode == DONGLE_NOT_FOUND {
-			fmt.Println("提示: 请确保 Rockey-ARM 加密狗已正确连接到计算机")
-		}
-		return
-	}
-
-	if count == 0 {
-		fmt.Println("未找到任何 Rockey-ARM 设备")
-		return
-	}
-
-	// 打开第一个设备
-	fmt.Println("\n2. 打开设备...")
-	deviceHandle, retCode, err := openDevice(openFunc, 0)
-	if err != nil {
-		fmt.Printf("打开设备失败，错误码: %08X - %s\n", retCode, getErrorDescription(retCode))
-		return
-	}
-	defer closeDevice(closeFunc, deviceHandle)
-
-	// 测试不同的参数组合
-	fmt.Println("\n3. 测试不同的读取文件参数组合...")
-	testCases := []struct {
-		Name   string
-		FileID uintptr
-		Offset uintptr
-	}{
-		{"文件ID=0x0000-偏移=0", 0x0000, 0},
-		{"文件ID=0x0001-偏移=0", 0x0001, 0},
-		{"文件ID=0x0000-偏移=100", 0x0000, 100},
-		{"文件ID=0x0001-偏移=100", 0x0001, 100},
-	}
-
-	bufferSize := TEST_BUFFER_SIZE
-
-	for i, tc := range testCases {
-		fmt.Printf("测试 %d/%d: %s\n", i+1, len(testCases), tc.Name)
-
-		buffer := make([]byte, bufferSize)
-		retCode, dataSize, err := readFile(readFileFunc, deviceHandle, tc.FileID, tc.Offset, buffer)
-
-		success := (retCode == DONGLE_SUCCESS)
-		fmt.Printf("  结果: %s (错误码: %08X)\n",
-			map[bool]string{true: "成功", false: "失败"}[success],
-			retCode)
-
-		if err != nil {
-			fmt.Printf("  错误: %v\n", err)
-		}
-
-		if success && dataSize > 0 {
-			fmt.Printf("  读取 %d 字节数据\n", dataSize)
-
-			// 显示前 32 字节
-			displaySize := dataSize
-			if displaySize > 32 {
-				displaySize = 32
-			}
-			fmt.Println("  数据（前", displaySize, "字节）：")
-			showBinHex(buffer[:displaySize])
-		}
-
-		// 成功则停止测试
-		if success {
-			break
-		}
-	}
-
-	fmt.Println("\n=== 读取文件测试完成 ===")
-}
-
-// runDiagnose 运行详细诊断
-func runDiagnose() {
-	fmt.Println("=== Rockey-ARM 详细诊断模式 ===")
-	fmt.Printf("操作系统: %s, 架构: %s\n", runtime.GOOS, runtime.GOARCH)
-	fmt.Printf("Go版本: %s\n", runtime.Version())
-	fmt.Printf("编译器: %s\n", runtime.Compiler)
-
-	// 检查是否在Linux平台
-	if runtime.GOOS != "linux" {
-		fmt.Printf("错误: 此程序仅支持Linux平台，当前平台: %s\n", runtime.GOOS)
-		return
-	}
-
-	// 1. 系统信息
-	fmt.Println("\n1. 系统信息检查:")
-	fmt.Printf("   当前用户: ")
-	cmd := exec.Command("whoami")
-	if output, err := cmd.Output(); err == nil {
-		fmt.Printf("%s", output)
-	} else {
-		fmt.Printf("未知 (错误: %v)\n", err)
-	}
-
-	// 检查用户组
-	fmt.Printf("   用户组: ")
-	cmd = exec.Command("groups")
-	if output, err := cmd.Output(); err == nil {
-		groups := string(output)
-		// 检查是否有USB相关权限
-		if contains(groups, "plugdev") || contains(groups, "usb") || contains(groups, "dialout") {
-			fmt.Printf("%s (包含USB权限组)\n", groups)
-		} else {
-			fmt.Printf("%s (可能缺少USB权限)\n", groups)
-		}
-	} else {
-		fmt.Printf("未知 (错误: %v)\n", err)
-	}
-
-	// 2. 库文件检查
-	fmt.Println("\n2. 库文件检查:")
-	libPath := getLibraryPath()
-	fmt.Printf("   库文件路径: %s\n", libPath)
-
-	// 检查文件是否存在
-	fileInfo, err := os.Stat(libPath)
-	if err != nil {
-		fmt.Printf("   ✗ 库文件不存在: %v\n", err)
-		fmt.Println("   请确保库文件位于以下位置之一:")
-		fmt.Println("     - ./lib/linux/arm64/libRockeyARM.so.0.3 (ARM64)")
-		fmt.Println("     - ./lib/linux/loong64/libRockeyARM.so.0.3 (Loong64)")
-		fmt.Println("     - ./lib/linux/libRockeyARM.so (x86_64)")
-		return
-	}
-
-	fmt.Printf("   ✓ 库文件存在\n")
-	fmt.Printf("     文件大小: %d 字节\n", fileInfo.Size())
-	fmt.Printf("     文件权限: %v\n", fileInfo.Mode())
-	fmt.Printf("     修改时间: %v\n", fileInfo.ModTime())
-
-	// 3. 动态库加载测试
-	fmt.Println("\n3. 动态库加载测试:")
-	handle, err := loadLibrary(libPath)
-	if err != nil {
-		fmt.Printf("   ✗ 动态库加载失败: %v\n", err)
-		fmt.Println("   可能的原因:")
-		fmt.Println("     - 库文件损坏")
-		fmt.Println("     - 缺少依赖库")
-		fmt.Println("     - 架构不匹配")
-		fmt.Println("     - 文件权限问题")
-		return
-	}
-	defer purego.Dlclose(handle)
-	fmt.Printf("   ✓ 动态库加载成功\n")
-	fmt.Printf("     库句柄: 0x%x\n", handle)
-
-	// 4. 函数符号检查
-	fmt.Println("\n4. 函数符号检查:")
-	symbols := []string{
-		"Dongle_Enum",
-		"Dongle_Open",
-		"Dongle_ReadFile",
-		"Dongle_Close",
-		"Dongle_Write",
-		"Dongle_Seed",
-		"Dongle_Generate",
-		"Dongle_WriteFile",
-	}
-
-	for _, sym := range symbols {
-		addr, err := getProcAddress(handle, sym)
-		if err != nil {
-			fmt.Printf("   ✗ %s: 未找到 (%v)\n", sym, err)
-		} else {
-			fmt.Printf("   ✓ %s: 找到 (地址: 0x%x)\n", sym, addr)
-		}
-	}
-
-	// 5. 设备文件检查
-	fmt.Println("\n5. 设备文件检查:")
-	fmt.Println("   检查USB设备:")
-	cmd = exec.Command("lsusb")
-	if output, err := cmd.Output(); err == nil {
-		lsusbOutput := string(output)
-		if len(lsusbOutput) > 0 {
-			fmt.Printf("   USB设备列表:\n%s", lsusbOutput)
-
-			// 检查是否有类似加密狗的设备
-			if contains(lsusbOutput, "Rockey") || contains(lsusbOutput, "Feitian") || contains(lsusbOutput, "HID") {
-				fmt.Println("   ✓ 发现可能的加密狗设备")
-			} else {
-				fmt.Println("   ⚠ 未发现明显的加密狗设备")
-			}
-		} else {
-			fmt.Println("   无USB设备")
-		}
-	} else {
-		fmt.Printf("   无法执行lsusb: %v\n", err)
-		fmt.Println("   尝试安装lsusb: sudo apt-get install usbutils")
-	}
-
-	// 检查设备文件权限
-	fmt.Println("\n   检查设备文件权限:")
-	devicePatterns := []string{
-		"/dev/usb/hiddev*",
-		"/dev/bus/usb/*/*",
-		"/dev/hidraw*",
-		"/dev/ttyUSB*",
-		"/dev/ttyACM*",
-	}
-
-	foundDevices := false
-	for _, pattern := range devicePatterns {
-		if matches, err := filepath.Glob(pattern); err == nil && len(matches) > 0 {
-			for _, device := range matches {
-				if info, err := os.Stat(device); err == nil {
-					fmt.Printf("     %s: 权限 %v\n", device, info.Mode())
-					foundDevices = true
-				}
-			}
-		}
-	}
-
-	if !foundDevices {
-		fmt.Println("     未找到相关设备文件")
-	}
-
-	// 6. 内核模块检查
-	fmt.Println("\n6. 内核模块检查:")
-	cmd = exec.Command("lsmod")
-	if output, err := cmd.Output(); err == nil {
-		lsmodOutput := string(output)
-		if contains(lsmodOutput, "usbhid") || contains(lsmodOutput, "hid") || contains(lsmodOutput, "usb") {
-			fmt.Println("   ✓ USB/HID相关内核模块已加载")
-		} else {
-			fmt.Println("   ⚠ USB/HID相关内核模块可能未加载")
-		}
-	} else {
-		fmt.Printf("   无法检查内核模块: %v\n", err)
-	}
-
-	// 7. 权限建议
-	fmt.Println("\n7. 权限建议:")
-	fmt.Println("   如果遇到权限问题，可以尝试:")
-	fmt.Println("     - 使用sudo运行程序: sudo ./rockey-test -test")
-	fmt.Println("     - 将用户添加到相关组: sudo usermod -a -G plugdev $USER")
-	fmt.Println("     - 创建udev规则: sudo nano /etc/udev/rules.d/99-rockey.rules")
-	fmt.Println("       添加: SUBSYSTEM==\"usb\", ATTR{idVendor}==\"****\", ATTR{idProduct}==\"****\", MODE=\"0666\"")
-	fmt.Println("     - 重新登录使组更改生效")
-
-	// 8. 测试建议
-	fmt.Println("\n8. 测试建议:")
-	fmt.Println("   如果诊断通过但设备测试失败，请尝试:")
-	fmt.Println("     - 重新插拔加密狗")
-	fmt.Println("     - 检查加密狗指示灯")
-	fmt.Println("     - 在其他电脑上测试加密狗")
-	fmt.Println("     - 检查库文件版本是否与硬件匹配")
-	fmt.Println("     - 查看系统日志: dmesg | tail -20")
-
-	fmt.Println("\n=== 诊断完成 ===")
-}
-
-// printHelp 显示帮助信息
-func printHelp() {
-	fmt.Println("Rockey-ARM 测试程序 (Linux版)")
-	fmt.Println()
-	fmt.Println("用法:")
-	fmt.Println("  rockey_test [选项]")
-	fmt.Println()
-	fmt.Println("选项:")
-	fmt.Println("  -test          运行设备测试（测试加密狗功能）")
-	fmt.Println("  -platform      运行平台测试（测试Linux平台兼容性）")
-	fmt.Println("  -read-test     运行读取文件参数测试（测试不同参数组合）")
-	fmt.Println("  -diagnose      运行详细诊断模式")
-	fmt.Println("  -h, -help     显示帮助信息")
-	fmt.Println()
-	fmt.Println("描述:")
-	fmt.Println("  这是一个Linux平台的Rockey-ARM加密狗测试程序。")
-	fmt.Println("  使用purego纯Go实现动态库加载，无需CGO。")
-	fmt.Println()
-	fmt.Println("支持的架构:")
-	fmt.Println("  - x86_64/amd64")
-	fmt.Println("  - arm64/aarch64")
-	fmt.Println("  - loong64")
-	fmt.Println()
-	fmt.Println("构建:")
-	fmt.Println("  go build -o rockey-test rockey_test.go")
-	fmt.Println("  CGO_ENABLED=0 go build -o rockey-test-static rockey_test.go  # 纯Go静态构建")
-}
-
-// ============ 主函数 ============
-
-func main() {
-	flag.Parse()
-
-	// 显示帮助信息
-	if *help || *helpLong {
-		printHelp()
-		return
-	}
-
-	// 运行详细诊断
-	if *diagnoseMode {
-		runDiagnose()
-		return
-	}
-
-	// 运行平台测试
-	if *platformTest {
-		runPlatformTest()
-		return
-	}
-
-	// 运行读取文件测试
-	if *readTest {
-		runReadFileTest()
-		return
-	}
-
-	// 运行设备测试
-	if *testMode {
-		runDeviceTest()
-		return
-	}
-
-	// 默认显示帮助
-	printHelp()
-}
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// ============ 常量定义 ============
+
+// 错误码定义
+const (
+	DONGLE_SUCCESS           = 0x00000000 // 成功
+	DONGLE_NOT_FOUND         = 0xF0000001 // 未找到设备
+	DONGLE_INVALID_HANDLE    = 0xF0000002 // 无效句柄
+	DONGLE_INVALID_PARAMETER = 0xF0000003 // 无效参数
+	DONGLE_ACCESS_DENIED     = 0xF0000004 // 访问被拒绝
+	DONGLE_NO_MORE_DEVICE    = 0xF0000005 // 没有更多设备
+	DONGLE_NEED_FIND         = 0xF0000006 // 需要查找设备
+	DONGLE_INVALID_PASSWORD  = 0xF0000007 // 无效密码
+	DONGLE_INVALID_DEVID     = 0xF0000008 // 无效设备ID
+	DONGLE_INVALID_BUFFER    = 0xF0000009 // 无效缓冲区
+	DONGLE_INVALID_FILEID    = 0xF000000A // 无效文件ID
+	DONGLE_INVALID_OFFSET    = 0xF000000B // 无效偏移量
+	DONGLE_INVALID_SIZE      = 0xF000000C // 无效大小
+	DONGLE_UNKNOWN_ERROR     = 0xFFFFFFFF // 未知错误
+)
+
+// 函数名称常量
+const (
+	FUNC_ENUM     = "Dongle_Enum"
+	FUNC_OPEN     = "Dongle_Open"
+	FUNC_READFILE = "Dongle_ReadFile"
+	FUNC_CLOSE    = "Dongle_Close"
+
+	FUNC_READDATA         = "Dongle_ReadData"
+	FUNC_WRITEDATA        = "Dongle_WriteData"
+	FUNC_READSHAREMEMORY  = "Dongle_ReadShareMemory"
+	FUNC_WRITESHAREMEMORY = "Dongle_WriteShareMemory"
+
+	FUNC_VERIFYPIN       = "Dongle_VerifyPIN"
+	FUNC_DOWNLOADEXEFILE = "Dongle_DownloadExeFile"
+	FUNC_RUNEXEFILE      = "Dongle_RunExeFile"
+
+	FUNC_CHANGEPIN             = "Dongle_ChangePIN"
+	FUNC_SETUSERID             = "Dongle_SetUserID"
+	FUNC_SETDEADLINE           = "Dongle_SetDeadline"
+	FUNC_LIMITSEEDCOUNT        = "Dongle_LimitSeedCount"
+	FUNC_CREATEFILE            = "Dongle_CreateFile"
+	FUNC_WRITEFILE             = "Dongle_WriteFile"
+	FUNC_REQUESTINIT           = "Dongle_RequestInit"
+	FUNC_GETINITDATAFROMMOTHER = "Dongle_GetInitDataFromMother"
+	FUNC_INITSON               = "Dongle_InitSon"
+
+	FUNC_LISTFILE    = "Dongle_ListFile"
+	FUNC_DELETEFILE  = "Dongle_DeleteFile"
+	FUNC_GETDEADLINE = "Dongle_GetDeadline"
+	FUNC_GETUTCTIME  = "Dongle_GetUTCTime"
+
+	FUNC_SEED                       = "Dongle_Seed"
+	FUNC_MAKEUPDATEPACKETFROMMOTHER = "Dongle_MakeUpdatePacketFromMother"
+	FUNC_UPDATE                     = "Dongle_Update"
+
+	FUNC_GENRANDOM = "Dongle_GenRandom"
+	FUNC_ECCSIGN   = "Dongle_EccSign"
+	FUNC_HASH      = "Dongle_HASH"
+)
+
+// 测试常量
+const (
+	TEST_BUFFER_SIZE = 1024   // 测试缓冲区大小
+	TEST_FILE_ID     = 0x0001 // 测试文件ID
+	TEST_OFFSET      = 0      // 测试偏移量
+)
+
+// ============ 结构体定义 ============
+
+// DongleInfo 设备信息结构体
+type DongleInfo struct {
+	MVer      uint16  // 版本号
+	MType     uint16  // 类型
+	MBirthDay [8]byte // 生产日期
+	MAgent    uint32  // 代理商ID
+	MPID      uint32  // 产品ID
+	MUserID   uint32  // 用户ID
+	MHID      [8]byte // 硬件ID
+	MIsMother uint32  // 是否母锁
+	MDevType  uint32  // 设备类型
+}
+
+// HID 返回硬件ID的十六进制字符串
+func (d DongleInfo) HID() string {
+	return fmt.Sprintf("%X", d.MHID[:])
+}
+
+// DongleHandle 设备句柄
+type DongleHandle uintptr
+
+// ============ 全局变量 ============
+
+var (
+	// 命令行参数
+	testMode      = flag.Bool("test", false, "运行设备测试")
+	platformTest  = flag.Bool("platform", false, "运行平台测试")
+	readTest      = flag.Bool("read-test", false, "运行读取文件参数测试")
+	help          = flag.Bool("h", false, "显示帮助信息")
+	helpLong      = flag.Bool("help", false, "显示帮助信息")
+	diagnoseMode  = flag.Bool("diagnose", false, "运行详细诊断模式")
+	callTimeout   = flag.Duration("timeout", 30*time.Second, "单次原生调用的超时时间")
+	verbose       = flag.Bool("v", false, "输出动态库调用跟踪日志")
+	logFormat     = flag.String("log-format", "text", "日志格式: text 或 json")
+	tracePath     = flag.String("trace", "", "把 -test/-read-test 的动态库调用记录到跟踪文件")
+	traceSecrets  = flag.Bool("trace-secrets", false, "跟踪文件中记录种子码输入输出、数据区写入和读出的原文，默认只记录摘要")
+	replayPath    = flag.String("replay", "", "用跟踪文件代替动态库运行 -test/-read-test")
+	sandbox       = flag.Bool("sandbox", false, "在辅助进程中加载动态库运行 -test/-read-test")
+	extraUSBIDs   = flag.String("usb-id", "", "额外识别为加密锁的 USB ID (厂商ID:产品ID，十六进制，逗号分隔)")
+	hidMode       = flag.Bool("hid", false, "不使用动态库，通过纯Go HID 传输运行 -test/-read-test (实验性)")
+	hidUnverified = flag.Bool("hid-unverified", false, "允许 -hid 发送未经抓包确认的指令")
+)
+
+// ============ 辅助函数 ============
+
+// getLibraryPath 根据系统架构返回库文件路径
+func getLibraryPath() string {
+	if runtime.GOOS != "linux" {
+		return ""
+	}
+
+	switch runtime.GOARCH {
+	case "arm64", "aarch64":
+		return "./lib/linux/arm64/libRockeyARM.so.0.3"
+	case "loong64":
+		return "./lib/linux/loong64/libRockeyARM.so.0.3"
+	case "amd64", "x86_64":
+		return "./lib/linux/libRockeyARM.so"
+	default:
+		return "./lib/linux/libRockeyARM.so"
+	}
+}
+
+// loadLibrary 加载动态库
+func loadLibrary(libPath string) (uintptr, error) {
+	// 检查文件是否存在
+	fileInfo, err := os.Stat(libPath)
+	if err != nil {
+		return 0, fmt.Errorf("库文件不存在: %v", err)
+	}
+
+	// 使用 purego 加载库
+	start := time.Now()
+	handle, err := purego.Dlopen(libPath, purego.RTLD_LAZY)
+	if err != nil {
+		return 0, fmt.Errorf("加载库失败: %v", err)
+	}
+
+	logger.Debug("已加载动态库", "path", libPath, "size", fileInfo.Size(), "mode", fileInfo.Mode().String(),
+		"handle", fmt.Sprintf("0x%x", handle), "duration", time.Since(start))
+
+	return handle, nil
+}
+
+// getProcAddress 获取函数地址
+func getProcAddress(handle uintptr, funcName string) (uintptr, error) {
+	// 使用 purego 获取函数地址
+	addr, err := purego.Dlsym(handle, funcName)
+	if err != nil {
+		// 尝试带下划线的版本
+		underscoreName := "_" + funcName
+		addr, err = purego.Dlsym(handle, underscoreName)
+		if err != nil {
+			return 0, fmt.Errorf("找不到函数 %s: %v (尝试了 %s 和 %s)", funcName, err, funcName, underscoreName)
+		}
+		logger.Debug("找到函数", "function", funcName, "symbol", underscoreName, "addr", fmt.Sprintf("0x%x", addr))
+	} else {
+		logger.Debug("找到函数", "function", funcName, "addr", fmt.Sprintf("0x%x", addr))
+	}
+	return addr, nil
+}
+
+// getErrorDescription 获取错误码描述
+func getErrorDescription(errorCode uint32) string {
+	switch errorCode {
+	case DONGLE_SUCCESS:
+		return "成功"
+	case DONGLE_NOT_FOUND:
+		return "未找到设备"
+	case DONGLE_INVALID_HANDLE:
+		return "无效句柄"
+	case DONGLE_INVALID_PARAMETER:
+		return "无效参数"
+	case DONGLE_ACCESS_DENIED:
+		return "访问被拒绝"
+	case DONGLE_NO_MORE_DEVICE:
+		return "没有更多设备"
+	case DONGLE_NEED_FIND:
+		return "需要查找设备"
+	case DONGLE_INVALID_PASSWORD:
+		return "无效密码"
+	case DONGLE_INVALID_DEVID:
+		return "无效设备ID"
+	case DONGLE_INVALID_BUFFER:
+		return "无效缓冲区"
+	case DONGLE_INVALID_FILEID:
+		return "无效文件ID"
+	case DONGLE_INVALID_OFFSET:
+		return "无效偏移量"
+	case DONGLE_INVALID_SIZE:
+		return "无效大小"
+	case DONGLE_UNKNOWN_ERROR:
+		return "未知错误"
+	default:
+		return fmt.Sprintf("未知错误码: %08X", errorCode)
+	}
+}
+
+// showBinHex 显示二进制数据的十六进制格式
+func showBinHex(data []byte) {
+	// 每行显示 16 字节
+	for i := 0; i < len(data); i += 16 {
+		// 显示十六进制
+		for j := 0; j < 16; j++ {
+			if i+j < len(data) {
+				fmt.Printf("%02X ", data[i+j])
+			} else {
+				fmt.Printf("   ") // 对齐用空格
+			}
+
+			if j == 7 {
+				fmt.Printf("- ")
+			}
+		}
+
+		fmt.Printf("    ")
+
+		// 显示 ASCII 字符
+		for j := 0; j < 16 && i+j < len(data); j++ {
+			b := data[i+j]
+			if b >= 32 && b <= 126 {
+				fmt.Printf("%c", b)
+			} else {
+				fmt.Printf(".")
+			}
+		}
+
+		fmt.Println()
+	}
+	fmt.Println()
+}
+
+// ============ 核心功能函数 ============
+
+// enumDevices 枚举设备
+func enumDevices(enumFunc uintptr) ([]DongleInfo, int, uint32, error) {
+	var countLocal int32
+
+	// 使用 purego 的正确方式调用函数
+	// 定义函数原型
+	type EnumFuncType func(infoList unsafe.Pointer, count *int32) uint32
+
+	// 将函数指针转换为可调用的函数
+	var enumFuncGo EnumFuncType
+	purego.RegisterFunc(&enumFuncGo, enumFunc)
+
+	// 第一次调用获取设备数量
+	start := time.Now()
+	retCode := enumFuncGo(nil, &countLocal)
+	traceCall(FUNC_ENUM, 0, retCode, start, "count", countLocal)
+
+	if retCode != DONGLE_SUCCESS {
+		return nil, 0, retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+
+	if countLocal == 0 {
+		return nil, 0, DONGLE_NOT_FOUND, fmt.Errorf(getErrorDescription(DONGLE_NOT_FOUND))
+	}
+
+	// 分配内存存储设备信息
+	count := int(countLocal)
+	keyList := make([]DongleInfo, count)
+
+	// 第二次调用获取详细信息
+	start = time.Now()
+	retCode = enumFuncGo(unsafe.Pointer(&keyList[0]), &countLocal)
+	traceCall(FUNC_ENUM, 0, retCode, start, "count", countLocal)
+
+	if retCode != DONGLE_SUCCESS {
+		return nil, 0, retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+
+	return keyList, count, retCode, nil
+}
+
+// openDevice 打开设备
+func openDevice(openFunc uintptr, index int) (DongleHandle, uint32, error) {
+	var hKeyLocal DongleHandle
+
+	// 使用 purego 的正确方式调用函数
+	// 定义函数原型
+	type OpenFuncType func(handle *DongleHandle, index int) uint32
+
+	// 将函数指针转换为可调用的函数
+	var openFuncGo OpenFuncType
+	purego.RegisterFunc(&openFuncGo, openFunc)
+
+	start := time.Now()
+	retCode := openFuncGo(&hKeyLocal, index)
+	traceCall(FUNC_OPEN, hKeyLocal, retCode, start, "index", index)
+
+	if retCode != DONGLE_SUCCESS {
+		return 0, retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+
+	return hKeyLocal, retCode, nil
+}
+
+// readFile 读取文件
+func readFile(readFileFunc uintptr, handle DongleHandle, fileID, offset uintptr, buffer []byte) (uint32, int, error) {
+	if handle == 0 {
+		return DONGLE_INVALID_HANDLE, 0, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+
+	if len(buffer) == 0 {
+		return DONGLE_INVALID_BUFFER, 0, fmt.Errorf(getErrorDescription(DONGLE_INVALID_BUFFER))
+	}
+
+	// 使用 purego 的正确方式调用函数
+	// 尝试不同的函数原型
+	// 方式1: 5个参数的函数原型（不包含文件类型）
+	type ReadFileFuncType1 func(handle DongleHandle, fileID uintptr, offset uintptr, buffer unsafe.Pointer, size uintptr) uint32
+
+	// 方式2: 6个参数的函数原型（包含文件类型）
+	type ReadFileFuncType2 func(handle DongleHandle, fileType uintptr, fileID uintptr, offset uintptr, buffer unsafe.Pointer, size uintptr) uint32
+
+	// 先尝试方式1（5个参数）
+	var readFileFuncGo1 ReadFileFuncType1
+	purego.RegisterFunc(&readFileFuncGo1, readFileFunc)
+
+	start := time.Now()
+	retCode := readFileFuncGo1(handle, fileID, offset, unsafe.Pointer(&buffer[0]), uintptr(len(buffer)))
+	traceCall(FUNC_READFILE, handle, retCode, start, "args", 5, "file_id", fmt.Sprintf("0x%04X", fileID), "offset", offset, "size", len(buffer))
+
+	if retCode == DONGLE_SUCCESS {
+		return retCode, len(buffer), nil
+	}
+
+	// 如果方式1失败，尝试方式2（6个参数）
+	var readFileFuncGo2 ReadFileFuncType2
+	purego.RegisterFunc(&readFileFuncGo2, readFileFunc)
+
+	// 假设文件类型为1（数据文件）
+	fileType := uintptr(1)
+	start = time.Now()
+	retCode = readFileFuncGo2(handle, fileType, fileID, offset, unsafe.Pointer(&buffer[0]), uintptr(len(buffer)))
+	traceCall(FUNC_READFILE, handle, retCode, start, "args", 6, "file_id", fmt.Sprintf("0x%04X", fileID), "offset", offset, "size", len(buffer))
+
+	if retCode == DONGLE_SUCCESS {
+		return retCode, len(buffer), nil
+	}
+
+	return retCode, 0, fmt.Errorf(getErrorDescription(retCode))
+}
+
+// closeDevice 关闭设备
+func closeDevice(closeFunc uintptr, handle DongleHandle) (uint32, error) {
+	if handle == 0 {
+		return DONGLE_SUCCESS, nil // 已经关闭
+	}
+
+	if closeFunc == 0 {
+		return DONGLE_SUCCESS, nil // 没有 Close 函数
+	}
+
+	// 使用 purego 的正确方式调用函数
+	// 定义函数原型
+	type CloseFuncType func(handle DongleHandle) uint32
+
+	// 将函数指针转换为可调用的函数
+	var closeFuncGo CloseFuncType
+	purego.RegisterFunc(&closeFuncGo, closeFunc)
+
+	start := time.Now()
+	retCode := closeFuncGo(handle)
+	traceCall(FUNC_CLOSE, handle, retCode, start)
+
+	if retCode != DONGLE_SUCCESS {
+		return retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+
+	return retCode, nil
+}
+
+// deviceConn 已打开的设备连接，供子命令复用
+type deviceConn struct {
+	ctx       context.Context // 调用使用的 ctx，每次原生调用另有 -timeout 限制
+	lib       uintptr         // 动态库句柄
+	handle    DongleHandle    // 设备句柄
+	closeFunc uintptr         // Dongle_Close 地址，可能为 0
+	info      DongleInfo      // 设备信息
+}
+
+// connectDevice 加载动态库，枚举并打开指定序号的设备
+func connectDevice(ctx context.Context, index int) (*deviceConn, error) {
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("此程序仅支持Linux平台，当前平台: %s", runtime.GOOS)
+	}
+
+	lib, err := loadLibrary(getLibraryPath())
+	if err != nil {
+		return nil, err
+	}
+
+	enumFunc, err := getProcAddress(lib, FUNC_ENUM)
+	if err != nil {
+		purego.Dlclose(lib)
+		return nil, err
+	}
+	openFunc, err := getProcAddress(lib, FUNC_OPEN)
+	if err != nil {
+		purego.Dlclose(lib)
+		return nil, err
+	}
+	closeFunc, _ := getProcAddress(lib, FUNC_CLOSE)
+
+	var keyList []DongleInfo
+	var count int
+	if ctxErr := callNative(ctx, FUNC_ENUM, func() { keyList, count, _, err = enumDevices(enumFunc) }); ctxErr != nil {
+		return nil, ctxErr
+	}
+	if err != nil {
+		purego.Dlclose(lib)
+		return nil, fmt.Errorf("设备枚举失败: %v", err)
+	}
+	if index < 0 || index >= count {
+		purego.Dlclose(lib)
+		return nil, fmt.Errorf("设备序号 %d 超出范围 (共 %d 个设备)", index, count)
+	}
+
+	var handle DongleHandle
+	if ctxErr := callNative(ctx, FUNC_OPEN, func() { handle, _, err = openDevice(openFunc, index) }); ctxErr != nil {
+		return nil, ctxErr
+	}
+	if err != nil {
+		purego.Dlclose(lib)
+		return nil, fmt.Errorf("打开设备失败: %v", err)
+	}
+
+	return &deviceConn{ctx: ctx, lib: lib, handle: handle, closeFunc: closeFunc, info: keyList[index]}, nil
+}
+
+// listDevices 枚举所有已连接的设备
+func listDevices(ctx context.Context) ([]DongleInfo, error) {
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("此程序仅支持Linux平台，当前平台: %s", runtime.GOOS)
+	}
+
+	lib, err := loadLibrary(getLibraryPath())
+	if err != nil {
+		return nil, err
+	}
+	defer unloadLibrary(lib)
+
+	enumFunc, err := getProcAddress(lib, FUNC_ENUM)
+	if err != nil {
+		return nil, err
+	}
+	var keyList []DongleInfo
+	var retCode uint32
+	if ctxErr := callNative(ctx, FUNC_ENUM, func() { keyList, _, retCode, err = enumDevices(enumFunc) }); ctxErr != nil {
+		return nil, ctxErr
+	}
+	if err != nil {
+		if retCode == DONGLE_NOT_FOUND {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("设备枚举失败: %v", err)
+	}
+	return keyList, nil
+}
+
+// proc 获取函数地址
+func (c *deviceConn) proc(funcName string) (uintptr, error) {
+	return getProcAddress(c.lib, funcName)
+}
+
+// call 执行一次使用该连接的原生调用，超时或 ctx 结束时返回 ctx 的错误
+func (c *deviceConn) call(name string, fn func() error) error {
+	var err error
+	if ctxErr := callNative(c.ctx, name, func() { err = fn() }); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// close 关闭设备并卸载动态库
+//
+// 命令被取消后仍然需要关闭设备；有调用超时未返回时不卸载动态库。
+func (c *deviceConn) close() {
+	if err := callNative(context.WithoutCancel(c.ctx), FUNC_CLOSE, func() { closeDevice(c.closeFunc, c.handle) }); err != nil {
+		logger.Warn("关闭设备失败", "err", err)
+	}
+	unloadLibrary(c.lib)
+}
+
+// showDeviceInfo 显示设备信息
+func showDeviceInfo(keyList []DongleInfo, count int) {
+	if count == 0 {
+		fmt.Println("未找到任何设备")
+		return
+	}
+
+	for i := 0; i < count; i++ {
+		fmt.Printf("====== 设备 %d ======\n", i)
+		fmt.Printf("版本号: %04X\n", keyList[i].MVer)
+		fmt.Printf("生产日期: ")
+		showBinHex(keyList[i].MBirthDay[:])
+		fmt.Printf("代理商ID: %08X\n", keyList[i].MAgent)
+		fmt.Printf("产品ID: %08X\n", keyList[i].MPID)
+		fmt.Printf("用户ID: %08X\n", keyList[i].MUserID)
+		fmt.Printf("是否母锁: %08X\n", keyList[i].MIsMother)
+		fmt.Printf("硬件ID: ")
+		showBinHex(keyList[i].MHID[:])
+	}
+
+	fmt.Printf("找到的设备数量: %d\n", count)
+}
+
+// ============ 测试函数 ============
+
+// runPlatformTest 运行平台测试
+func runPlatformTest() {
+	fmt.Println("=== 运行平台测试 ===")
+	fmt.Printf("操作系统: %s\n", runtime.GOOS)
+	fmt.Printf("系统架构: %s\n", runtime.GOARCH)
+	fmt.Printf("编译器: %s\n", runtime.Compiler)
+	fmt.Printf("Go版本: %s\n", runtime.Version())
+
+	// 检查是否在Linux平台
+	if runtime.GOOS != "linux" {
+		fmt.Printf("错误: 此程序仅支持Linux平台，当前平台: %s\n", runtime.GOOS)
+		return
+	}
+
+	// 测试库路径获取
+	fmt.Println("\n=== 库路径测试 ===")
+	libPath := getLibraryPath()
+	fmt.Printf("库文件路径: %s\n", libPath)
+
+	// 检查库文件是否存在
+	if _, err := os.Stat(libPath); err != nil {
+		fmt.Printf("库文件不存在: %v\n", err)
+		fmt.Println("注意: 这可能是正常的，如果没有实际的库文件")
+	} else {
+		fmt.Println("库文件存在")
+	}
+
+	// 尝试加载库
+	fmt.Println("\n=== 动态库加载测试 ===")
+	handle, err := loadLibrary(libPath)
+	if err != nil {
+		fmt.Printf("动态库加载失败: %v\n", err)
+		fmt.Println("注意: 这可能是正常的，如果没有实际的库文件")
+	} else {
+		defer purego.Dlclose(handle)
+		fmt.Println("动态库加载成功")
+
+		// 尝试获取函数符号
+		symbols := []string{
+			FUNC_ENUM,
+			FUNC_OPEN,
+			FUNC_READFILE,
+			FUNC_CLOSE,
+		}
+
+		for _, sym := range symbols {
+			if _, err := getProcAddress(handle, sym); err != nil {
+				fmt.Printf("符号 '%s' 获取失败: %v\n", sym, err)
+			} else {
+				fmt.Printf("符号 '%s' 获取成功\n", sym)
+			}
+		}
+	}
+
+	fmt.Println("\n=== 平台测试完成 ===")
+}
+
+// runDeviceTest 运行设备测试
+func runDeviceTest() {
+	fmt.Println("=== Rockey-ARM 设备测试 ===")
+	fmt.Printf("操作系统: %s, 架构: %s\n", runtime.GOOS, runtime.GOARCH)
+
+	// 检查是否在Linux平台，回放跟踪文件时不需要动态库
+	if runtime.GOOS != "linux" && *replayPath == "" {
+		fmt.Printf("错误: 此程序仅支持Linux平台，当前平台: %s\n", runtime.GOOS)
+		return
+	}
+
+	// 显示库文件路径
+	libPath := getLibraryPath()
+	fmt.Printf("库文件路径: %s\n", libPath)
+
+	// 检查当前用户权限
+	userName, _ := currentUser()
+	fmt.Printf("当前用户: %s\n", userName)
+
+	ctx, stop := commandContext()
+	defer stop()
+
+	// 加载库并获取函数地址
+	fmt.Println("\n加载动态库...")
+	backend, cleanup, err := openTestBackend(FUNC_ENUM, FUNC_OPEN, FUNC_READFILE)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	defer func() {
+		fmt.Println("关闭动态库...")
+		cleanup()
+	}()
+
+	// 1. 枚举设备
+	fmt.Println("\n1. 枚举设备...")
+	var keyList []DongleInfo
+	var retCode uint32
+	if ctxErr := callNative(ctx, FUNC_ENUM, func() { keyList, retCode, err = backend.Enum() }); ctxErr != nil {
+		fmt.Printf("%v\n", ctxErr)
+		return
+	}
+	if err != nil {
+		fmt.Printf("设备枚举失败，错误码: %08X - %s\n", retCode, getErrorDescription(retCode))
+		if retCode == DONGLE_NOT_FOUND {
+			fmt.Println("提示: 请确保 Rockey-ARM 加密狗已正确连接到计算机")
+		} else if retCode == DONGLE_UNKNOWN_ERROR {
+			fmt.Println("提示: 未知错误，可能是:")
+			fmt.Println("  1. 动态库版本不兼容")
+			fmt.Println("  2. 函数调用参数不正确")
+			fmt.Println("  3. 系统权限不足")
+			fmt.Println("  4. 设备驱动程序未安装")
+		}
+		return
+	}
+
+	count := len(keyList)
+	if count == 0 {
+		fmt.Println("未找到任何 Rockey-ARM 设备")
+		fmt.Println("可能的原因:")
+		fmt.Println("  1. 加密狗未连接")
+		fmt.Println("  2. 设备驱动程序未安装")
+		fmt.Println("  3. 用户权限不足（尝试使用 sudo）")
+		fmt.Println("  4. 设备被其他程序占用")
+		return
+	}
+
+	// 显示设备信息
+	showDeviceInfo(keyList, count)
+
+	// 2. 打开第一个设备
+	fmt.Println("\n2. 打开设备...")
+	var deviceHandle DongleHandle
+	if ctxErr := callNative(ctx, FUNC_OPEN, func() { deviceHandle, retCode, err = backend.Open(0) }); ctxErr != nil {
+		fmt.Printf("%v\n", ctxErr)
+		return
+	}
+	if err != nil {
+		fmt.Printf("打开设备失败，错误码: %08X - %s\n", retCode, getErrorDescription(retCode))
+		return
+	}
+	defer callNative(context.WithoutCancel(ctx), FUNC_CLOSE, func() { backend.Close(deviceHandle) })
+
+	// 3. 读取文件
+	fmt.Println("\n3. 读取文件...")
+	buffer := make([]byte, TEST_BUFFER_SIZE)
+	if ctxErr := callNative(ctx, FUNC_READFILE, func() {
+		retCode, err = backend.ReadFile(deviceHandle, TEST_FILE_ID, TEST_OFFSET, buffer)
+	}); ctxErr != nil {
+		fmt.Printf("%v\n", ctxErr)
+		return
+	}
+	if err != nil {
+		fmt.Printf("读取文件失败，错误码: %08X - %s\n", retCode, getErrorDescription(retCode))
+		return
+	}
+
+	if retCode == DONGLE_SUCCESS {
+		dataSize := len(buffer)
+		fmt.Printf("成功读取 %d 字节数据\n", dataSize)
+
+		// 显示前 64 字节
+		if dataSize > 0 {
+			displaySize := dataSize
+			if displaySize > 64 {
+				displaySize = 64
+			}
+			fmt.Println("文件内容（前", displaySize, "字节）：")
+			showBinHex(buffer[:displaySize])
+
+			// 显示十六进制字符串
+			fmt.Println("十六进制字符串：")
+			fmt.Println(hex.EncodeToString(buffer[:displaySize]))
+		}
+	}
+
+	fmt.Println("\n=== 设备测试完成 ===")
+}
+
+// runReadFileTest 运行读取文件测试
+func runReadFileTest() {
+	fmt.Println("=== Rockey-ARM 读取文件参数测试 ===")
+	fmt.Printf("操作系统: %s, 架构: %s\n", runtime.GOOS, runtime.GOARCH)
+
+	// 检查是否在Linux平台，回放跟踪文件时不需要动态库
+	if runtime.GOOS != "linux" && *replayPath == "" {
+		fmt.Printf("错误: 此程序仅支持Linux平台，当前平台: %s\n", runtime.GOOS)
+		return
+	}
+
+	// 显示库文件路径
+	libPath := getLibraryPath()
+	fmt.Printf("库文件路径: %s\n", libPath)
+
+	ctx, stop := commandContext()
+	defer stop()
+
+	// 加载库并获取函数地址
+	backend, cleanup, err := openTestBackend(FUNC_ENUM, FUNC_OPEN, FUNC_READFILE)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	defer cleanup()
+
+	// 枚举设备
+	fmt.Println("\n1. 枚举设备...")
+	var keyList []DongleInfo
+	var retCode uint32
+	if ctxErr := callNative(ctx, FUNC_ENUM, func() { keyList, retCode, err = backend.Enum() }); ctxErr != nil {
+		fmt.Printf("%v\n", ctxErr)
+		return
+	}
+	if err != nil {
+		fmt.Printf("设备枚举失败，错误码: %08X - %s\n", retCode, getErrorDescription(retCode))
+		if retCode == DONGLE_NOT_FOUND {
+			fmt.Println("提示: 请确保 Rockey-ARM 加密狗已正确连接到计算机")
+		}
+		return
+	}
+
+	if len(keyList) == 0 {
+		fmt.Println("未找到任何 Rockey-ARM 设备")
+		return
+	}
+
+	// 打开第一个设备
+	fmt.Println("\n2. 打开设备...")
+	var deviceHandle DongleHandle
+	if ctxErr := callNative(ctx, FUNC_OPEN, func() { deviceHandle, retCode, err = backend.Open(0) }); ctxErr != nil {
+		fmt.Printf("%v\n", ctxErr)
+		return
+	}
+	if err != nil {
+		fmt.Printf("打开设备失败，错误码: %08X - %s\n", retCode, getErrorDescription(retCode))
+		return
+	}
+	defer callNative(context.WithoutCancel(ctx), FUNC_CLOSE, func() { backend.Close(deviceHandle) })
+
+	// 测试不同的参数组合，更多组合使用 read-matrix 命令
+	fmt.Println("\n3. 测试不同的读取文件参数组合...")
+	results, err := runReadMatrix(ctx, backend, deviceHandle, defaultReadMatrixConfig())
+	if err != nil {
+		fmt.Printf("错误: %v\n", err)
+		return
+	}
+	fmt.Println()
+	printReadMatrix(os.Stdout, results)
+
+	fmt.Println("\n=== 读取文件测试完成 ===")
+}
+
+// printHelp 显示帮助信息
+func printHelp() {
+	fmt.Println("Rockey-ARM 测试程序 (Linux版)")
+	fmt.Println()
+	fmt.Println("用法:")
+	fmt.Println("  rockey_test [选项]")
+	fmt.Println("  rockey_test <命令> [选项]")
+	fmt.Println()
+	fmt.Println("选项:")
+	fmt.Println("  -test          运行设备测试（测试加密狗功能）")
+	fmt.Println("  -platform      运行平台测试（测试Linux平台兼容性）")
+	fmt.Println("  -read-test     运行读取文件参数测试（测试不同参数组合）")
+	fmt.Println("  -diagnose      运行详细诊断模式")
+	fmt.Println("  -v             输出动态库调用跟踪日志（标准错误）")
+	fmt.Println("  -log-format    日志格式: text 或 json")
+	fmt.Println("  -trace 文件    记录 -test/-read-test 的动态库调用（PIN 不记录，种子码和数据区写入只记录摘要）")
+	fmt.Println("  -trace-secrets 跟踪文件中记录种子码输入输出、数据区写入和读出的原文")
+	fmt.Println("  -replay 文件   回放跟踪文件，在没有加密锁的机器上重现 -test/-read-test")
+	fmt.Println("  -sandbox       在辅助进程中加载动态库，库崩溃时不影响本程序")
+	fmt.Println("  -usb-id 列表   额外识别为加密锁的 USB ID，例如 096e:0201")
+	fmt.Println("  -hid           不使用动态库，直接通过 /dev/hidraw 访问加密锁（实验性）")
+	fmt.Println("  -hid-unverified 允许 -hid 发送未经抓包确认的指令，只在确认是加密锁的设备上使用")
+	fmt.Println("  -h, -help     显示帮助信息")
+	fmt.Println()
+	fmt.Println("命令:")
+	for _, c := range commands {
+		fmt.Printf("  %-26s %s\n", c.usage, c.desc)
+	}
+	fmt.Println()
+	fmt.Println("描述:")
+	fmt.Println("  这是一个Linux平台的Rockey-ARM加密狗测试程序。")
+	fmt.Println("  使用purego纯Go实现动态库加载，无需CGO。")
+	fmt.Println()
+	fmt.Println("支持的架构:")
+	fmt.Println("  - x86_64/amd64")
+	fmt.Println("  - arm64/aarch64")
+	fmt.Println("  - loong64")
+	fmt.Println()
+	fmt.Println("构建:")
+	fmt.Println("  go build -o rockey-test rockey_test.go")
+	fmt.Println("  CGO_ENABLED=0 go build -o rockey-test-static rockey_test.go  # 纯Go静态构建")
+}
+
+// ============ 子命令 ============
+
+// command 子命令定义
+type command struct {
+	name  string                    // 命令名
+	usage string                    // 用法
+	desc  string                    // 说明
+	run   func(args []string) error // 执行函数
+}
+
+// commands 子命令列表
+var commands = []command{
+	{"diagnose", "diagnose [-format text|json|junit] [-only 检查] [-skip 检查]", "按顺序执行诊断检查，输出通过/警告/失败结果", runDiagnoseCommand},
+	{"data", "data dump|patch [选项]", "导出/修改数据区内容", runDataCommand},
+	{"sharemem", "sharemem dump|patch [选项]", "导出/修改共享内存内容", runShareMemCommand},
+	{"exe", "exe download|run|list [选项]", "下载/运行锁内可执行程序", runExeCommand},
+	{"provision", "provision -profile 文件", "用母锁批量初始化子锁", runProvisionCommand},
+	{"apply", "apply [选项] 配置文件", "按配置对比并更新加密锁状态", runApplyCommand},
+	{"backup", "backup [选项] -o 归档文件", "备份加密锁中可读取的内容", runBackupCommand},
+	{"restore", "restore [选项] 归档文件", "将备份恢复到空白加密锁", runRestoreCommand},
+	{"update", "update make|apply|verify [选项]", "生成/应用远程升级包，验证回执", runUpdateCommand},
+	{"watch", "watch [选项]", "监视设备插拔，以 NDJSON 输出事件", runWatchCommand},
+	{"serve", "serve [选项]", "持有设备并通过 Unix 套接字提供授权检查接口", runServeCommand},
+	{"license", "license seats [-set N] [选项]", "查看/设置浮动授权席位上限", runLicenseCommand},
+	{"read-matrix", "read-matrix [-config 文件] [-file-ids 列表] [-offsets 列表] [-sizes 列表] [-types 列表] [-first] [-o 文件] [-compare 文件]", "扫描 ReadFile 参数组合并输出返回码矩阵", runReadMatrixCommand},
+	{"udev", "udev [-dry-run] [-o 文件] [选项]", "按已插入的加密锁生成 udev 规则并检查访问权限", runUdevCommand},
+	{"support-bundle", "support-bundle [-hash-ids] [-o 文件]", "收集诊断信息打包为 tar.gz，供技术支持分析", runSupportBundleCommand},
+	{"conformance", "conformance [-write] [-pin PIN] [-ecc-file ID] [-dir 目录]", "按脚本逐项检查加密锁接口返回码，结果按库版本和架构保存", runConformanceCommand},
+	{"soak", "soak [-backend 名称] [-workers 负载] [-duration 时长] [-iterations 次数] [-replug 间隔]", "并发长时间压力测试，统计耗时分位数、返回码和资源泄漏", runSoakCommand},
+	{"bench", "bench [-fake 桩库] [-target data|file] [-sizes 列表] [-only ffi|device]", "测量函数注册和调用开销，以及不同缓冲区大小的设备读取吞吐", runBenchCommand},
+}
+
+// runCommand 执行子命令
+func runCommand(args []string) error {
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:])
+		}
+	}
+	return fmt.Errorf("未知命令: %s", args[0])
+}
+
+// ============ 主函数 ============
+
+func main() {
+	// 辅助进程不解析全局参数
+	if len(os.Args) > 1 && os.Args[1] == HELPER_COMMAND {
+		os.Exit(runNativeHelper(os.Args[2:]))
+	}
+
+	flag.Parse()
+
+	if err := setupLogging(os.Stderr, *verbose, *logFormat); err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		os.Exit(2)
+	}
+	if err := addDongleIDs(*extraUSBIDs); err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		os.Exit(2)
+	}
+
+	// 执行子命令
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Args()); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// 显示帮助信息
+	if *help || *helpLong {
+		printHelp()
+		return
+	}
+
+	// 运行详细诊断
+	if *diagnoseMode {
+		if err := runDiagnoseCommand(nil); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// 运行平台测试
+	if *platformTest {
+		runPlatformTest()
+		return
+	}
+
+	// 运行读取文件测试
+	if *readTest {
+		runReadFileTest()
+		return
+	}
+
+	// 运行设备测试
+	if *testMode {
+		runDeviceTest()
+		return
+	}
+
+	// 默认显示帮助
+	printHelp()
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ============ 监控指标 ============
+//
+// 守护进程和 watch 命令可以选择在 /metrics 上以 Prometheus 文本格式输出
+// 设备健康状态：各硬件ID是否在线、锁内时钟偏差、期限剩余时间，以及各函数
+// 的调用次数、各返回码次数、耗时分布和距离上次成功调用的时间。
+// 格式很简单，这里直接生成文本，不引入客户端库。
+
+// latencyBuckets 耗时直方图的区间上界
+var latencyBuckets = []time.Duration{
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// DEFAULT_PROBE_INTERVAL 健康检查间隔
+const DEFAULT_PROBE_INTERVAL = 30 * time.Second
+
+// opRecorder 按函数名累计调用统计
+type opRecorder struct {
+	mu      sync.Mutex
+	metrics map[string]*OpMetrics
+}
+
+// newOpRecorder 创建统计表
+func newOpRecorder() *opRecorder {
+	return &opRecorder{metrics: make(map[string]*OpMetrics)}
+}
+
+// record 更新指定函数的统计
+func (r *opRecorder) record(name string, update func(m *OpMetrics)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.metrics[name]
+	if !ok {
+		m = &OpMetrics{RetCodes: make(map[uint32]uint64), Latency: make([]uint64, len(latencyBuckets)+1)}
+		r.metrics[name] = m
+	}
+	update(m)
+}
+
+// observe 记录一次已完成的调用
+func (r *opRecorder) observe(name string, start time.Time, elapsed time.Duration, retCode uint32, err error) {
+	r.record(name, func(m *OpMetrics) {
+		code := errorCode(retCode, err)
+		m.Calls++
+		if err != nil {
+			m.Errors++
+		} else {
+			m.LastSuccess = start.Add(elapsed)
+		}
+		m.TotalTime += elapsed
+		if elapsed > m.MaxTime {
+			m.MaxTime = elapsed
+		}
+		m.LastRetCode = code
+		m.LastCall = start
+		m.RetCodes[code]++
+		m.Latency[sort.Search(len(latencyBuckets), func(i int) bool { return elapsed <= latencyBuckets[i] })]++
+	})
+}
+
+// snapshot 返回统计的副本
+func (r *opRecorder) snapshot() map[string]OpMetrics {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snapshot := make(map[string]OpMetrics, len(r.metrics))
+	for name, m := range r.metrics {
+		c := *m
+		c.RetCodes = make(map[uint32]uint64, len(m.RetCodes))
+		for code, n := range m.RetCodes {
+			c.RetCodes[code] = n
+		}
+		c.Latency = append([]uint64(nil), m.Latency...)
+		snapshot[name] = c
+	}
+	return snapshot
+}
+
+// healthMetrics 设备健康状态
+type healthMetrics struct {
+	stats func() map[string]OpMetrics // 调用统计来源
+
+	mu       sync.Mutex
+	present  map[string]bool          // 硬件ID -> 是否在线
+	drift    map[string]time.Duration // 硬件ID -> 锁内时钟减本机时钟
+	deadline map[string]time.Duration // 硬件ID -> 期限剩余时间，无期限的锁不出现
+}
+
+// newHealthMetrics 创建健康状态
+func newHealthMetrics(stats func() map[string]OpMetrics) *healthMetrics {
+	return &healthMetrics{
+		stats:    stats,
+		present:  make(map[string]bool),
+		drift:    make(map[string]time.Duration),
+		deadline: make(map[string]time.Duration),
+	}
+}
+
+// setDevices 用一次枚举结果更新在线状态，曾经出现过的锁保留为离线
+func (h *healthMetrics) setDevices(keyList []DongleInfo) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for hid := range h.present {
+		h.present[hid] = false
+	}
+	for _, info := range keyList {
+		h.present[info.HID()] = true
+	}
+}
+
+// applyEvent 用设备事件更新在线状态
+func (h *healthMetrics) applyEvent(ev DeviceEvent) {
+	if ev.Err != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.present[ev.Info.HID()] = ev.Type != DeviceRemoved
+}
+
+// setClock 记录锁内时钟与期限
+func (h *healthMetrics) setClock(hid string, drift time.Duration, remaining time.Duration, hasDeadline bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.drift[hid] = drift
+	if hasDeadline {
+		h.deadline[hid] = remaining
+	} else {
+		delete(h.deadline, hid)
+	}
+}
+
+// probe 通过会话检查设备在线状态、锁内时钟和期限
+func (h *healthMetrics) probe(ctx context.Context, session *Session, timeout time.Duration) {
+	call := func(fn func(ctx context.Context) error) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return fn(ctx)
+	}
+
+	var keyList []DongleInfo
+	if err := call(func(ctx context.Context) (err error) {
+		keyList, err = session.Enum(ctx)
+		return err
+	}); err != nil {
+		keyList = nil
+	}
+	h.setDevices(keyList)
+
+	hid := session.Info().HID()
+	var dongleTime time.Time
+	var deadline uint32
+	if err := call(func(ctx context.Context) (err error) {
+		dongleTime, err = session.GetUTCTime(ctx)
+		return err
+	}); err != nil {
+		return
+	}
+	// 锁内时钟只有秒级精度
+	drift := time.Duration(dongleTime.Unix()-time.Now().Unix()) * time.Second
+	if err := call(func(ctx context.Context) (err error) {
+		deadline, err = session.GetDeadline(ctx)
+		return err
+	}); err != nil {
+		return
+	}
+	remaining, ok := deadlineRemaining(deadline, dongleTime)
+	h.setClock(hid, drift, remaining, ok)
+}
+
+// probeLoop 定时执行健康检查，直到 ctx 结束
+func (h *healthMetrics) probeLoop(ctx context.Context, session *Session, interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		h.probe(ctx, session, timeout)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ServeHTTP 以 Prometheus 文本格式输出指标
+func (h *healthMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	h.mu.Lock()
+	writeGauge(out, "rockey_device_present", "加密锁是否在线 (按硬件ID)", "hid", boolValues(h.present))
+	writeGauge(out, "rockey_clock_drift_seconds", "锁内时钟减本机时钟", "hid", durationValues(h.drift))
+	writeGauge(out, "rockey_deadline_remaining_seconds", "使用期限剩余时间", "hid", durationValues(h.deadline))
+	h.mu.Unlock()
+
+	if h.stats == nil {
+		return
+	}
+	stats := h.stats()
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	now := time.Now()
+	fmt.Fprintln(out, "# HELP rockey_calls_total 函数调用次数，按返回码区分")
+	fmt.Fprintln(out, "# TYPE rockey_calls_total counter")
+	for _, name := range names {
+		codes := make([]uint32, 0, len(stats[name].RetCodes))
+		for code := range stats[name].RetCodes {
+			codes = append(codes, code)
+		}
+		sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+		for _, code := range codes {
+			fmt.Fprintf(out, "rockey_calls_total{function=%s,code=\"%08X\"} %d\n", strconv.Quote(name), code, stats[name].RetCodes[code])
+		}
+	}
+
+	fmt.Fprintln(out, "# HELP rockey_timeouts_total 原生调用超时被放弃的次数")
+	fmt.Fprintln(out, "# TYPE rockey_timeouts_total counter")
+	for _, name := range names {
+		fmt.Fprintf(out, "rockey_timeouts_total{function=%s} %d\n", strconv.Quote(name), stats[name].TimedOut)
+	}
+
+	fmt.Fprintln(out, "# HELP rockey_reconnects_total 句柄失效后重连的次数")
+	fmt.Fprintln(out, "# TYPE rockey_reconnects_total counter")
+	for _, name := range names {
+		fmt.Fprintf(out, "rockey_reconnects_total{function=%s} %d\n", strconv.Quote(name), stats[name].Reconnects)
+	}
+
+	fmt.Fprintln(out, "# HELP rockey_last_success_age_seconds 距离上次成功调用的时间")
+	fmt.Fprintln(out, "# TYPE rockey_last_success_age_seconds gauge")
+	for _, name := range names {
+		if last := stats[name].LastSuccess; !last.IsZero() {
+			fmt.Fprintf(out, "rockey_last_success_age_seconds{function=%s} %s\n", strconv.Quote(name), formatFloat(now.Sub(last).Seconds()))
+		}
+	}
+
+	fmt.Fprintln(out, "# HELP rockey_call_duration_seconds 函数调用耗时")
+	fmt.Fprintln(out, "# TYPE rockey_call_duration_seconds histogram")
+	for _, name := range names {
+		m := stats[name]
+		label := strconv.Quote(name)
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += m.Latency[i]
+			fmt.Fprintf(out, "rockey_call_duration_seconds_bucket{function=%s,le=\"%s\"} %d\n", label, formatFloat(bound.Seconds()), cumulative)
+		}
+		fmt.Fprintf(out, "rockey_call_duration_seconds_bucket{function=%s,le=\"+Inf\"} %d\n", label, m.Calls)
+		fmt.Fprintf(out, "rockey_call_duration_seconds_sum{function=%s} %s\n", label, formatFloat(m.TotalTime.Seconds()))
+		fmt.Fprintf(out, "rockey_call_duration_seconds_count{function=%s} %d\n", label, m.Calls)
+	}
+}
+
+// writeGauge 输出按单个标签区分的 gauge
+func writeGauge(out *bufio.Writer, name, help, label string, values map[string]float64) {
+	fmt.Fprintf(out, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(out, "# TYPE %s gauge\n", name)
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(out, "%s{%s=%s} %s\n", name, label, strconv.Quote(key), formatFloat(values[key]))
+	}
+}
+
+// boolValues 转换为 0/1
+func boolValues(m map[string]bool) map[string]float64 {
+	values := make(map[string]float64, len(m))
+	for k, v := range m {
+		if v {
+			values[k] = 1
+		} else {
+			values[k] = 0
+		}
+	}
+	return values
+}
+
+// durationValues 转换为秒
+func durationValues(m map[string]time.Duration) map[string]float64 {
+	values := make(map[string]float64, len(m))
+	for k, v := range m {
+		values[k] = v.Seconds()
+	}
+	return values
+}
+
+// formatFloat 按 Prometheus 文本格式输出浮点数
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// serveMetrics 在 addr 上提供 /metrics，直到 ctx 结束
+func serveMetrics(ctx context.Context, addr string, h http.Handler) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("监听 %s 失败: %v", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", h)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go server.Serve(listener)
+	logger.Info("监控指标已启动", "url", fmt.Sprintf("http://%s/metrics", listener.Addr()))
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// SO_PEERGROUPS 对端进程的附加组 (Linux 4.13 起)，syscall 包中没有定义
+const SO_PEERGROUPS = 59
+
+// peerCredentials 通过 SO_PEERCRED 和 SO_PEERGROUPS 获取 Unix 套接字对端进程的身份
+//
+// 两者都是内核在 connect 时记录的凭据，与对端进程之后是否退出、PID 是否被
+// 复用无关。附加组读取失败时返回错误，连接按无身份处理而被拒绝。
+func peerCredentials(conn net.Conn) (peerCred, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return peerCred{}, fmt.Errorf("不是 Unix 套接字连接")
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return peerCred{}, err
+	}
+
+	var cred *syscall.Ucred
+	var groups []uint32
+	var credErr, groupsErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+		groups, groupsErr = getsockoptPeerGroups(int(fd))
+	}); err != nil {
+		return peerCred{}, err
+	}
+	if credErr != nil {
+		return peerCred{}, fmt.Errorf("获取对端身份失败: %v", credErr)
+	}
+	if groupsErr != nil {
+		return peerCred{}, fmt.Errorf("获取对端附加组失败: %v", groupsErr)
+	}
+	return peerCred{PID: cred.Pid, UID: cred.Uid, GID: cred.Gid, Groups: groups}, nil
+}
+
+// getsockoptPeerGroups 读取 SO_PEERGROUPS，缓冲区不足时按内核返回的长度重试
+func getsockoptPeerGroups(fd int) ([]uint32, error) {
+	groups := make([]uint32, 16)
+	for {
+		size := uint32(len(groups) * 4)
+		var p unsafe.Pointer
+		if len(groups) > 0 {
+			p = unsafe.Pointer(&groups[0])
+		}
+		_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, uintptr(fd), syscall.SOL_SOCKET, SO_PEERGROUPS,
+			uintptr(p), uintptr(unsafe.Pointer(&size)), 0)
+		switch errno {
+		case 0:
+			return groups[:size/4], nil
+		case syscall.ERANGE:
+			if int(size/4) <= len(groups) {
+				return nil, errno
+			}
+			groups = make([]uint32, size/4)
+		default:
+			return nil, errno
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// peerCredentials 非 Linux 平台不支持 SO_PEERCRED，拒绝所有连接
+func peerCredentials(conn net.Conn) (peerCred, error) {
+	return peerCred{}, fmt.Errorf("%s 平台不支持 SO_PEERCRED", runtime.GOOS)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ebitengine/purego"
+)
+
+// ============ PIN 校验 ============
+
+// PIN 类型
+const (
+	FLAG_USERPIN  = 0 // 用户PIN
+	FLAG_ADMINPIN = 1 // 开发商PIN
+)
+
+// 出厂默认 PIN
+const (
+	DEFAULT_USER_PIN  = "12345678"
+	DEFAULT_ADMIN_PIN = "FFFFFFFFFFFFFFFFFFFFFFFF"
+)
+
+// verifyPIN 校验PIN，返回剩余重试次数
+func verifyPIN(verifyPINFunc uintptr, handle DongleHandle, flags int, pin string) (uint32, int, error) {
+	if handle == 0 {
+		return DONGLE_INVALID_HANDLE, 0, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+	if pin == "" {
+		return DONGLE_INVALID_PASSWORD, 0, fmt.Errorf(getErrorDescription(DONGLE_INVALID_PASSWORD))
+	}
+
+	// 函数原型: DWORD Dongle_VerifyPIN(DONGLE_HANDLE hDongle, int nFlags, char* pPIN, int* pRemainCount)
+	type VerifyPINFuncType func(handle DongleHandle, flags int32, pin string, remainCount *int32) uint32
+
+	var verifyPINFuncGo VerifyPINFuncType
+	purego.RegisterFunc(&verifyPINFuncGo, verifyPINFunc)
+
+	var remain int32
+	start := time.Now()
+	retCode := verifyPINFuncGo(handle, int32(flags), pin, &remain)
+	traceCall(FUNC_VERIFYPIN, handle, retCode, start, "flags", flags, "remain", remain)
+
+	if retCode != DONGLE_SUCCESS {
+		return retCode, int(remain), fmt.Errorf("%s (剩余重试次数: %d)", getErrorDescription(retCode), remain)
+	}
+	return retCode, int(remain), nil
+}
+
+// verifyPIN 在已打开的设备上校验PIN
+func (c *deviceConn) verifyPIN(flags int, pin string) error {
+	verifyPINFunc, err := c.proc(FUNC_VERIFYPIN)
+	if err != nil {
+		return err
+	}
+	return c.call(FUNC_VERIFYPIN, func() (err error) {
+		_, _, err = verifyPIN(verifyPINFunc, c.handle, flags, pin)
+		return
+	})
+}
+
+// changePIN 修改PIN，tryCount 为新PIN允许的最大重试次数
+func changePIN(changePINFunc uintptr, handle DongleHandle, flags int, oldPIN, newPIN string, tryCount int) (uint32, error) {
+	if handle == 0 {
+		return DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+	if oldPIN == "" || newPIN == "" {
+		return DONGLE_INVALID_PASSWORD, fmt.Errorf(getErrorDescription(DONGLE_INVALID_PASSWORD))
+	}
+	if tryCount < 1 || tryCount > 255 {
+		return DONGLE_INVALID_PARAMETER, fmt.Errorf("%s: 重试次数 %d 超出范围 (1-255)", getErrorDescription(DONGLE_INVALID_PARAMETER), tryCount)
+	}
+
+	// 函数原型: DWORD Dongle_ChangePIN(DONGLE_HANDLE hDongle, int nFlags, char* pOldPIN, char* pNewPIN, int nTryCount)
+	type ChangePINFuncType func(handle DongleHandle, flags int32, oldPIN string, newPIN string, tryCount int32) uint32
+
+	var changePINFuncGo ChangePINFuncType
+	purego.RegisterFunc(&changePINFuncGo, changePINFunc)
+
+	start := time.Now()
+	retCode := changePINFuncGo(handle, int32(flags), oldPIN, newPIN, int32(tryCount))
+	traceCall(FUNC_CHANGEPIN, handle, retCode, start, "flags", flags)
+
+	if retCode != DONGLE_SUCCESS {
+		return retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+	return retCode, nil
+}
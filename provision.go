@@ -0,0 +1,597 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+	"gopkg.in/yaml.v3"
+)
+
+// ============ 母锁/子锁初始化 ============
+
+// 初始化数据大小
+const (
+	INIT_REQUEST_SIZE  = 16   // 子锁初始化请求长度
+	INIT_DATA_MAX_SIZE = 4096 // 母锁生成的初始化数据最大长度
+)
+
+// requestInit 子锁生成初始化请求
+func requestInit(requestInitFunc uintptr, handle DongleHandle) ([]byte, uint32, error) {
+	if handle == 0 {
+		return nil, DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+
+	// 函数原型: DWORD Dongle_RequestInit(DONGLE_HANDLE hDongle, BYTE* pRequest)
+	type RequestInitFuncType func(handle DongleHandle, request unsafe.Pointer) uint32
+
+	var requestInitFuncGo RequestInitFuncType
+	purego.RegisterFunc(&requestInitFuncGo, requestInitFunc)
+
+	request := make([]byte, INIT_REQUEST_SIZE)
+	start := time.Now()
+	retCode := requestInitFuncGo(handle, unsafe.Pointer(&request[0]))
+	traceCall(FUNC_REQUESTINIT, handle, retCode, start)
+
+	if retCode != DONGLE_SUCCESS {
+		return nil, retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+	return request, retCode, nil
+}
+
+// getInitDataFromMother 母锁根据子锁请求生成初始化数据
+func getInitDataFromMother(getInitDataFunc uintptr, handle DongleHandle, request []byte) ([]byte, uint32, error) {
+	if handle == 0 {
+		return nil, DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+	if len(request) != INIT_REQUEST_SIZE {
+		return nil, DONGLE_INVALID_BUFFER, fmt.Errorf("%s: 初始化请求必须为 %d 字节", getErrorDescription(DONGLE_INVALID_BUFFER), INIT_REQUEST_SIZE)
+	}
+
+	// 函数原型: DWORD Dongle_GetInitDataFromMother(DONGLE_HANDLE hDongle, BYTE* pRequest, BYTE* pInitData, int* pDataLen)
+	type GetInitDataFuncType func(handle DongleHandle, request unsafe.Pointer, initData unsafe.Pointer, dataLen *int32) uint32
+
+	var getInitDataFuncGo GetInitDataFuncType
+	purego.RegisterFunc(&getInitDataFuncGo, getInitDataFunc)
+
+	initData := make([]byte, INIT_DATA_MAX_SIZE)
+	dataLen := int32(len(initData))
+	start := time.Now()
+	retCode := getInitDataFuncGo(handle, unsafe.Pointer(&request[0]), unsafe.Pointer(&initData[0]), &dataLen)
+	traceCall(FUNC_GETINITDATAFROMMOTHER, handle, retCode, start, "len", dataLen)
+
+	if retCode != DONGLE_SUCCESS {
+		return nil, retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+	if dataLen <= 0 || int(dataLen) > len(initData) {
+		return nil, DONGLE_INVALID_SIZE, fmt.Errorf("%s: 初始化数据长度 %d", getErrorDescription(DONGLE_INVALID_SIZE), dataLen)
+	}
+	return initData[:dataLen], retCode, nil
+}
+
+// initSon 使用母锁生成的初始化数据初始化子锁
+func initSon(initSonFunc uintptr, handle DongleHandle, initData []byte) (uint32, error) {
+	if handle == 0 {
+		return DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+	if len(initData) == 0 {
+		return DONGLE_INVALID_BUFFER, fmt.Errorf(getErrorDescription(DONGLE_INVALID_BUFFER))
+	}
+
+	// 函数原型: DWORD Dongle_InitSon(DONGLE_HANDLE hDongle, BYTE* pInitData, int nDataLen)
+	type InitSonFuncType func(handle DongleHandle, initData unsafe.Pointer, dataLen int32) uint32
+
+	var initSonFuncGo InitSonFuncType
+	purego.RegisterFunc(&initSonFuncGo, initSonFunc)
+
+	start := time.Now()
+	retCode := initSonFuncGo(handle, unsafe.Pointer(&initData[0]), int32(len(initData)))
+	traceCall(FUNC_INITSON, handle, retCode, start)
+
+	if retCode != DONGLE_SUCCESS {
+		return retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+	return retCode, nil
+}
+
+// ============ 初始化配置 ============
+
+// provisionProfile 子锁初始化配置
+type provisionProfile struct {
+	Name          string        `json:"name" yaml:"name"`                       // 配置名称
+	MotherPIN     string        `json:"mother_pin" yaml:"mother_pin"`           // 母锁开发商PIN，为空则不校验
+	AdminPIN      string        `json:"admin_pin" yaml:"admin_pin"`             // 子锁初始化后的开发商PIN
+	NewAdminPIN   string        `json:"new_admin_pin" yaml:"new_admin_pin"`     // 新开发商PIN，为空则不修改
+	AdminTryCount int           `json:"admin_try_count" yaml:"admin_try_count"` // 开发商PIN重试次数
+	UserPIN       string        `json:"user_pin" yaml:"user_pin"`               // 子锁当前用户PIN，默认为出厂PIN
+	NewUserPIN    string        `json:"new_user_pin" yaml:"new_user_pin"`       // 新用户PIN，为空则不修改
+	UserTryCount  int           `json:"user_try_count" yaml:"user_try_count"`   // 用户PIN重试次数
+	UserID        *uint32       `json:"user_id" yaml:"user_id"`                 // 用户ID，为空则不设置
+	Deadline      string        `json:"deadline" yaml:"deadline"`               // 期限，见 parseDeadline，为空则不设置
+	SeedLimit     *int          `json:"seed_limit" yaml:"seed_limit"`           // 种子码运算次数，-1 表示不限制，为空则不设置
+	Files         []profileFile `json:"files" yaml:"files"`                     // 需要创建的数据文件
+	PruneFiles    bool          `json:"prune_files" yaml:"prune_files"`         // 删除配置中未列出的数据文件
+
+	baseDir string // 配置文件所在目录，用于解析 content_file
+}
+
+// profileFile 配置中的数据文件
+type profileFile struct {
+	ID          uint16 `json:"id" yaml:"id"`                     // 文件ID
+	Size        int    `json:"size" yaml:"size"`                 // 文件大小，为 0 时取内容长度
+	ReadPriv    int    `json:"read_priv" yaml:"read_priv"`       // 读权限
+	WritePriv   int    `json:"write_priv" yaml:"write_priv"`     // 写权限
+	Content     string `json:"content" yaml:"content"`           // 文件内容（十六进制）
+	ContentFile string `json:"content_file" yaml:"content_file"` // 文件内容路径，相对于配置文件
+}
+
+// decodeConfig 读取配置文件，按扩展名识别 YAML 或 JSON 格式
+func decodeConfig(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(v)
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(v)
+	}
+	if err != nil {
+		return fmt.Errorf("解析配置 %s 失败: %v", path, err)
+	}
+	return nil
+}
+
+// loadProfile 读取初始化配置
+func loadProfile(path string) (*provisionProfile, error) {
+	profile := &provisionProfile{}
+	if err := decodeConfig(path, profile); err != nil {
+		return nil, err
+	}
+	profile.baseDir = filepath.Dir(path)
+
+	if err := profile.validate(); err != nil {
+		return nil, fmt.Errorf("配置 %s 无效: %v", path, err)
+	}
+	return profile, nil
+}
+
+// validate 检查配置并填充默认值
+func (p *provisionProfile) validate() error {
+	if p.Name == "" {
+		p.Name = "default"
+	}
+	if p.AdminPIN == "" {
+		p.AdminPIN = DEFAULT_ADMIN_PIN
+	}
+	if p.UserPIN == "" {
+		p.UserPIN = DEFAULT_USER_PIN
+	}
+	if p.AdminTryCount == 0 {
+		p.AdminTryCount = 15
+	}
+	if p.UserTryCount == 0 {
+		p.UserTryCount = 15
+	}
+	if p.Deadline != "" {
+		if _, err := parseDeadline(p.Deadline); err != nil {
+			return err
+		}
+	}
+	if p.SeedLimit != nil && *p.SeedLimit < SEED_COUNT_UNLIMITED {
+		return fmt.Errorf("无效的种子码次数: %d", *p.SeedLimit)
+	}
+
+	seen := make(map[uint16]bool)
+	for i := range p.Files {
+		f := &p.Files[i]
+		if seen[f.ID] {
+			return fmt.Errorf("文件ID 0x%04X 重复", f.ID)
+		}
+		seen[f.ID] = true
+
+		content, err := f.content(p.baseDir)
+		if err != nil {
+			return fmt.Errorf("文件 0x%04X: %v", f.ID, err)
+		}
+		if f.Size == 0 {
+			f.Size = len(content)
+		}
+		if f.Size <= 0 || f.Size > DATA_FILE_MAX_SIZE || len(content) > f.Size {
+			return fmt.Errorf("文件 0x%04X: 大小 %d 无效 (内容 %d 字节)", f.ID, f.Size, len(content))
+		}
+		if f.ReadPriv > FILE_PRIV_ADMIN || f.WritePriv > FILE_PRIV_ADMIN || f.ReadPriv < 0 || f.WritePriv < 0 {
+			return fmt.Errorf("文件 0x%04X: 无效的访问权限", f.ID)
+		}
+	}
+	return nil
+}
+
+// content 返回文件内容
+func (f *profileFile) content(baseDir string) ([]byte, error) {
+	switch {
+	case f.Content != "" && f.ContentFile != "":
+		return nil, fmt.Errorf("content 和 content_file 不能同时指定")
+	case f.Content != "":
+		return parseHexInput(f.Content)
+	case f.ContentFile != "":
+		path := f.ContentFile
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, path)
+		}
+		return os.ReadFile(path)
+	default:
+		return nil, nil
+	}
+}
+
+// ============ 审计记录 ============
+
+// 审计状态
+const (
+	AUDIT_OK     = "ok"
+	AUDIT_FAILED = "failed"
+)
+
+// auditRecord 每把子锁的初始化审计记录
+type auditRecord struct {
+	Time    time.Time `json:"time"`            // 完成时间
+	HID     string    `json:"hid"`             // 子锁硬件ID
+	Index   int       `json:"index"`           // 设备序号
+	Mother  string    `json:"mother"`          // 母锁硬件ID
+	Profile string    `json:"profile"`         // 配置名称
+	Steps   []string  `json:"steps"`           // 本次完成的步骤
+	Status  string    `json:"status"`          // 结果
+	Error   string    `json:"error,omitempty"` // 错误信息
+}
+
+// auditProgress 一把子锁在审计记录中的进度
+type auditProgress struct {
+	ok    bool            // 是否已成功初始化
+	steps map[string]bool // 各次尝试完成的步骤之和
+}
+
+// loadAudit 读取审计文件，返回处理过的子锁硬件ID及其进度，只合并同名配置的步骤
+func loadAudit(path, profile string) (map[string]*auditProgress, error) {
+	done := make(map[string]*auditProgress)
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue // 忽略中断时写入的不完整行
+		}
+		progress := done[rec.HID]
+		if progress == nil {
+			progress = &auditProgress{steps: make(map[string]bool)}
+			done[rec.HID] = progress
+		}
+		progress.ok = progress.ok || rec.Status == AUDIT_OK
+		if rec.Profile == profile {
+			for _, step := range rec.Steps {
+				progress.steps[step] = true
+			}
+		}
+	}
+	return done, scanner.Err()
+}
+
+// appendAudit 追加一条审计记录
+func appendAudit(path string, rec *auditRecord) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// ============ 初始化流程 ============
+
+// 母子锁初始化的步骤名
+const (
+	STEP_REQUEST_INIT = "request-init"
+	STEP_INIT_DATA    = "init-data-from-mother"
+	STEP_INIT_SON     = "init-son"
+)
+
+// provisionChild 用母锁初始化一把子锁并应用配置，跳过 completed 中已完成的步骤
+func provisionChild(mother, child *deviceConn, profile *provisionProfile, rec *auditRecord, completed map[string]bool) error {
+	step := func(name string) { rec.Steps = append(rec.Steps, name) }
+
+	// 1. 子锁请求 -> 母锁生成初始化数据 -> 子锁初始化
+	//
+	// 初始化数据不落盘，子锁初始化完成前中断时三步都要重做
+	if !completed[STEP_INIT_SON] {
+		if err := initChild(mother, child, step); err != nil {
+			return err
+		}
+	}
+
+	// 2. 应用配置中的用户ID、文件、期限和PIN，已修改的PIN 按新PIN 校验
+	plan, err := planProfile(child, profile, completed, true)
+	if err != nil {
+		return err
+	}
+	for _, change := range plan.changes {
+		if completed[change.step] {
+			continue
+		}
+		if err := change.apply(); err != nil {
+			return fmt.Errorf("%s 失败: %v", change.summary, err)
+		}
+		step(change.step)
+	}
+
+	return nil
+}
+
+// initChild 用母锁生成的初始化数据初始化子锁
+func initChild(mother, child *deviceConn, step func(name string)) error {
+	requestInitFunc, err := child.proc(FUNC_REQUESTINIT)
+	if err != nil {
+		return err
+	}
+	var request []byte
+	err = child.call(FUNC_REQUESTINIT, func() (err error) {
+		request, _, err = requestInit(requestInitFunc, child.handle)
+		return
+	})
+	if err != nil {
+		return fmt.Errorf("请求初始化失败: %v", err)
+	}
+	step(STEP_REQUEST_INIT)
+
+	getInitDataFunc, err := mother.proc(FUNC_GETINITDATAFROMMOTHER)
+	if err != nil {
+		return err
+	}
+	var initData []byte
+	err = mother.call(FUNC_GETINITDATAFROMMOTHER, func() (err error) {
+		initData, _, err = getInitDataFromMother(getInitDataFunc, mother.handle, request)
+		return
+	})
+	if err != nil {
+		return fmt.Errorf("母锁生成初始化数据失败: %v", err)
+	}
+	step(STEP_INIT_DATA)
+
+	initSonFunc, err := child.proc(FUNC_INITSON)
+	if err != nil {
+		return err
+	}
+	err = child.call(FUNC_INITSON, func() (err error) {
+		_, err = initSon(initSonFunc, child.handle, initData)
+		return
+	})
+	if err != nil {
+		return fmt.Errorf("初始化子锁失败: %v", err)
+	}
+	step(STEP_INIT_SON)
+	return nil
+}
+
+// checkMother 确认母锁仍按硬件ID在位，避免母锁被拔出或换成其他锁后继续初始化
+func checkMother(ctx context.Context, mother *deviceConn) error {
+	keyList, err := listDevices(ctx)
+	if err != nil {
+		return err
+	}
+	hid := mother.info.HID()
+	for _, info := range keyList {
+		if info.HID() == hid && info.MIsMother != 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("母锁 (HID %s) 已不在位", hid)
+}
+
+// FACTORY_PID 出厂状态的产品ID
+const FACTORY_PID = 0xFFFFFFFF
+
+// checkBlank 检查子锁是否处于出厂状态：产品ID 未设置、开发商PIN 为默认值、没有数据文件
+//
+// 先检查不消耗重试次数的产品ID，只有产品ID 为出厂值时才校验默认PIN，
+// 避免误插的客户锁被重新初始化或被消耗PIN重试次数。
+func checkBlank(ctx context.Context, index int, info DongleInfo) error {
+	if info.MPID != FACTORY_PID {
+		return fmt.Errorf("产品ID 为 %08X，不是空白锁", info.MPID)
+	}
+
+	conn, err := connectDevice(ctx, index)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+	if conn.info.HID() != info.HID() {
+		return fmt.Errorf("设备 %d 的硬件ID已变化，设备可能被拔插", index)
+	}
+	if err := conn.verifyPIN(FLAG_ADMINPIN, DEFAULT_ADMIN_PIN); err != nil {
+		return fmt.Errorf("开发商PIN 不是出厂默认值: %v", err)
+	}
+	list, err := conn.listFiles()
+	if err != nil {
+		return fmt.Errorf("列举文件失败: %v", err)
+	}
+	if len(list) > 0 {
+		return fmt.Errorf("已有 %d 个数据文件，不是空白锁", len(list))
+	}
+	return nil
+}
+
+// ============ 命令行 ============
+
+// runProvisionCommand 执行 provision 子命令
+func runProvisionCommand(args []string) error {
+	fs := flag.NewFlagSet("provision", flag.ContinueOnError)
+	profilePath := fs.String("profile", "", "初始化配置文件 (JSON 或 YAML)")
+	auditPath := fs.String("audit", "provision-audit.jsonl", "审计记录文件，用于断点续做")
+	motherIndex := fs.Int("mother", -1, "母锁设备序号，默认自动选择")
+	dryRun := fs.Bool("dry-run", false, "只列出待初始化的子锁")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *profilePath == "" {
+		return fmt.Errorf("用法: provision -profile 文件 [-audit 文件] [-mother N] [-dry-run]")
+	}
+
+	profile, err := loadProfile(*profilePath)
+	if err != nil {
+		return err
+	}
+	done, err := loadAudit(*auditPath, profile.Name)
+	if err != nil {
+		return fmt.Errorf("读取审计记录失败: %v", err)
+	}
+
+	ctx, stop := commandContext()
+	defer stop()
+
+	keyList, err := listDevices(ctx)
+	if err != nil {
+		return err
+	}
+
+	// 选择母锁
+	if *motherIndex < 0 {
+		for i, info := range keyList {
+			if info.MIsMother != 0 {
+				*motherIndex = i
+				break
+			}
+		}
+	}
+	if *motherIndex < 0 || *motherIndex >= len(keyList) {
+		return fmt.Errorf("未找到母锁")
+	}
+	if keyList[*motherIndex].MIsMother == 0 {
+		return fmt.Errorf("设备 %d 不是母锁", *motherIndex)
+	}
+
+	// 待初始化的子锁：非母锁、未在审计记录中成功，并且处于出厂状态或上次初始化中断
+	var pending []int
+	for i, info := range keyList {
+		if i == *motherIndex || info.MIsMother != 0 {
+			continue
+		}
+		progress := done[info.HID()]
+		if progress != nil && progress.ok {
+			fmt.Printf("跳过设备 %d (HID %s): 已初始化\n", i, info.HID())
+			continue
+		}
+		// 已完成部分步骤的子锁不再是出厂状态，从第一个未完成的步骤继续
+		if progress == nil || len(progress.steps) == 0 {
+			if err := checkBlank(ctx, i, info); err != nil {
+				fmt.Printf("跳过设备 %d (HID %s): %v\n", i, info.HID(), err)
+				continue
+			}
+		}
+		pending = append(pending, i)
+	}
+
+	fmt.Printf("母锁: 设备 %d (HID %s), 待初始化子锁: %d 把\n", *motherIndex, keyList[*motherIndex].HID(), len(pending))
+	if *dryRun || len(pending) == 0 {
+		for _, i := range pending {
+			fmt.Printf("  设备 %d (HID %s)\n", i, keyList[i].HID())
+		}
+		return nil
+	}
+
+	mother, err := connectDevice(ctx, *motherIndex)
+	if err != nil {
+		return fmt.Errorf("打开母锁失败: %v", err)
+	}
+	defer mother.close()
+
+	if profile.MotherPIN != "" {
+		if err := mother.verifyPIN(FLAG_ADMINPIN, profile.MotherPIN); err != nil {
+			return fmt.Errorf("校验母锁PIN失败: %v", err)
+		}
+	}
+
+	failed := 0
+	for n, i := range pending {
+		fmt.Printf("\n[%d/%d] 初始化设备 %d (HID %s)...\n", n+1, len(pending), i, keyList[i].HID())
+		if err := checkMother(ctx, mother); err != nil {
+			return fmt.Errorf("初始化中止，可重新运行以继续: %v", err)
+		}
+		var completed map[string]bool
+		if progress := done[keyList[i].HID()]; progress != nil && len(progress.steps) > 0 {
+			completed = progress.steps
+			fmt.Printf("  从上次中断处继续 (已完成 %d 步)\n", len(completed))
+		}
+
+		rec := &auditRecord{
+			HID:     keyList[i].HID(),
+			Index:   i,
+			Mother:  mother.info.HID(),
+			Profile: profile.Name,
+		}
+
+		child, err := connectDevice(ctx, i)
+		if err == nil {
+			if child.info.HID() != rec.HID {
+				err = fmt.Errorf("设备 %d 的硬件ID已变化，设备可能被拔插", i)
+			} else {
+				err = provisionChild(mother, child, profile, rec, completed)
+			}
+			child.close()
+		}
+
+		rec.Time = time.Now().UTC()
+		rec.Status = AUDIT_OK
+		if err != nil {
+			rec.Status = AUDIT_FAILED
+			rec.Error = err.Error()
+			failed++
+			fmt.Printf("  ✗ 失败: %v\n", err)
+		} else {
+			fmt.Printf("  ✓ 完成\n")
+		}
+		if err := appendAudit(*auditPath, rec); err != nil {
+			return fmt.Errorf("写入审计记录失败: %v", err)
+		}
+		if isCallAborted(err) {
+			return fmt.Errorf("初始化中止，可重新运行以继续: %v", err)
+		}
+	}
+
+	fmt.Printf("\n初始化完成: 成功 %d, 失败 %d (审计记录: %s)\n", len(pending)-failed, failed, *auditPath)
+	if failed > 0 {
+		return fmt.Errorf("%d 把子锁初始化失败，可重新运行以继续", failed)
+	}
+	return nil
+}
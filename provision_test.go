@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadAudit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	records := []*auditRecord{
+		{HID: "A", Profile: "p", Steps: []string{STEP_REQUEST_INIT, STEP_INIT_DATA, STEP_INIT_SON}, Status: AUDIT_FAILED},
+		{HID: "A", Profile: "p", Steps: []string{"file=0001"}, Status: AUDIT_FAILED},
+		{HID: "A", Profile: "other", Steps: []string{"deadline=2030-01-01"}, Status: AUDIT_FAILED},
+		{HID: "B", Profile: "p", Status: AUDIT_FAILED},
+		{HID: "C", Profile: "p", Steps: []string{STEP_REQUEST_INIT}, Status: AUDIT_OK},
+	}
+	for _, rec := range records {
+		if err := appendAudit(path, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 中断时写入的不完整行
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"hid":"D","steps":[`)
+	f.Close()
+
+	done, err := loadAudit(path, "p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 3 {
+		t.Fatalf("读到 %d 把锁的记录, 期望 3", len(done))
+	}
+
+	// 各次尝试的步骤合并，其他配置的步骤不计入
+	a := done["A"]
+	if a.ok || len(a.steps) != 4 || !a.steps[STEP_INIT_SON] || !a.steps["file=0001"] || a.steps["deadline=2030-01-01"] {
+		t.Errorf("A: ok = %v, steps = %v", a.ok, a.steps)
+	}
+	if b := done["B"]; b.ok || len(b.steps) != 0 {
+		t.Errorf("B: ok = %v, steps = %v", b.ok, b.steps)
+	}
+	if c := done["C"]; !c.ok {
+		t.Errorf("C: ok = %v", c.ok)
+	}
+
+	missing, err := loadAudit(filepath.Join(t.TempDir(), "missing.jsonl"), "p")
+	if err != nil || len(missing) != 0 {
+		t.Errorf("审计文件不存在时 = %v, %v", missing, err)
+	}
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ============ 读取参数扫描 ============
+//
+// 不同版本的动态库对 Dongle_ReadFile 的原型和参数约束并不一致。这里按
+// 文件类型 × 偏移 × 缓冲区大小 × 文件ID 的笛卡尔积逐一调用，输出每组参数
+// 的返回码矩阵，并可以保存下来与其他库版本的结果对比。
+//
+// 文件类型为 auto 时使用后端默认的 ReadFile (先 5 参数原型，失败后以数据
+// 文件类型尝试 6 参数原型)；为文件类型名称或数字时只调用 6 参数原型。
+
+// READ_MATRIX_MAX_CASES 单次扫描的最大参数组合数
+const READ_MATRIX_MAX_CASES = 100000
+
+// READ_MATRIX_AUTO 使用后端默认原型的文件类型
+const READ_MATRIX_AUTO = "auto"
+
+// readMatrixConfig 扫描参数，每项为逗号分隔的列表或范围
+//
+// 数值支持十进制和 0x 十六进制，范围写作 "起始-结束" 或 "起始-结束:步长"，
+// 例如 file_ids: ["0x0000-0x0003", "0x1000"]。
+type readMatrixConfig struct {
+	FileIDs       []string `json:"file_ids" yaml:"file_ids"`               // 文件ID
+	Offsets       []string `json:"offsets" yaml:"offsets"`                 // 偏移
+	Sizes         []string `json:"sizes" yaml:"sizes"`                     // 缓冲区大小
+	FileTypes     []string `json:"file_types" yaml:"file_types"`           // auto 或文件类型 (data/rsa/ecc/key/exe 或数字)
+	StopOnSuccess bool     `json:"stop_on_success" yaml:"stop_on_success"` // 第一次成功后停止
+}
+
+// defaultReadMatrixConfig -read-test 使用的默认参数
+func defaultReadMatrixConfig() readMatrixConfig {
+	return readMatrixConfig{
+		FileIDs:       []string{"0x0000", "0x0001"},
+		Offsets:       []string{"0", "100"},
+		Sizes:         []string{strconv.Itoa(TEST_BUFFER_SIZE)},
+		FileTypes:     []string{READ_MATRIX_AUTO},
+		StopOnSuccess: true,
+	}
+}
+
+// loadReadMatrixConfig 读取参数文件 (YAML 或 JSON)，未给出的项使用默认值，
+// 拼错的项名报错而不是被忽略
+func loadReadMatrixConfig(path string) (readMatrixConfig, error) {
+	cfg := defaultReadMatrixConfig()
+	cfg.StopOnSuccess = false
+	if err := decodeConfig(path, &cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// parseSweep 展开列表和范围
+func parseSweep(items []string, min, max int64) ([]int, error) {
+	var values []int
+	for _, item := range items {
+		for _, part := range strings.Split(item, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			rangePart, stepPart, hasStep := strings.Cut(part, ":")
+			if rangePart == "" {
+				return nil, fmt.Errorf("无效的取值: %s", part)
+			}
+			step := int64(1)
+			if hasStep {
+				var err error
+				if step, err = strconv.ParseInt(stepPart, 0, 64); err != nil || step <= 0 {
+					return nil, fmt.Errorf("无效的步长: %s", part)
+				}
+			}
+			// 以 "-" 分隔起止，开头的负号不是分隔符
+			lowPart, highPart, isRange := strings.Cut(rangePart[1:], "-")
+			lowPart = rangePart[:1] + lowPart
+			if !isRange {
+				highPart = lowPart
+			}
+			low, err1 := strconv.ParseInt(lowPart, 0, 64)
+			high, err2 := strconv.ParseInt(highPart, 0, 64)
+			if err1 != nil || err2 != nil || low > high {
+				return nil, fmt.Errorf("无效的取值: %s", part)
+			}
+			if low < min || high > max {
+				return nil, fmt.Errorf("取值 %s 超出范围 [%d, %d]", part, min, max)
+			}
+			for v := low; v <= high; v += step {
+				values = append(values, int(v))
+				if len(values) > READ_MATRIX_MAX_CASES {
+					return nil, fmt.Errorf("取值过多: %s", part)
+				}
+			}
+		}
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("取值列表为空")
+	}
+	return values, nil
+}
+
+// parseFileTypes 解析文件类型，auto 表示后端默认原型，对应值为 -1
+func parseFileTypes(items []string) ([]int, error) {
+	var types []int
+	for _, item := range items {
+		for _, name := range strings.Split(item, ",") {
+			name = strings.TrimSpace(name)
+			switch {
+			case name == "":
+			case name == READ_MATRIX_AUTO:
+				types = append(types, -1)
+			default:
+				t, err := parseFileType(name)
+				if err != nil {
+					n, numErr := strconv.ParseInt(name, 0, 32)
+					if numErr != nil {
+						return nil, err
+					}
+					t = int(n)
+				}
+				types = append(types, t)
+			}
+		}
+	}
+	if len(types) == 0 {
+		return nil, fmt.Errorf("文件类型列表为空")
+	}
+	return types, nil
+}
+
+// fileTypeLabel 文件类型的显示名称
+func fileTypeLabel(fileType int) string {
+	if fileType < 0 {
+		return READ_MATRIX_AUTO
+	}
+	if name, ok := fileTypeNames[fileType]; ok {
+		return name
+	}
+	return strconv.Itoa(fileType)
+}
+
+// readMatrixCase 一组读取参数
+type readMatrixCase struct {
+	FileType string `json:"file_type"` // 文件类型
+	FileID   uint16 `json:"file_id"`   // 文件ID
+	Offset   int    `json:"offset"`    // 偏移
+	Size     int    `json:"size"`      // 缓冲区大小
+}
+
+// readMatrixResult 一组参数的结果
+type readMatrixResult struct {
+	readMatrixCase
+	RetCode string `json:"ret_code"`        // 返回码
+	Error   string `json:"error,omitempty"` // 错误描述
+}
+
+// readMatrix 扫描结果，可保存为 JSON 与其他库版本对比
+type readMatrix struct {
+	Created       time.Time          `json:"created"`        // 扫描时间
+	Library       string             `json:"library"`        // 库文件路径
+	LibrarySHA256 string             `json:"library_sha256"` // 库文件校验和
+	Config        readMatrixConfig   `json:"config"`         // 扫描参数
+	Results       []readMatrixResult `json:"results"`        // 结果
+}
+
+// expandReadMatrix 展开参数的笛卡尔积，文件ID变化最快
+func expandReadMatrix(cfg readMatrixConfig) ([]readMatrixCase, []int, error) {
+	fileIDs, err := parseSweep(cfg.FileIDs, 0, 0xFFFF)
+	if err != nil {
+		return nil, nil, fmt.Errorf("文件ID: %v", err)
+	}
+	offsets, err := parseSweep(cfg.Offsets, 0, DATA_FILE_MAX_SIZE)
+	if err != nil {
+		return nil, nil, fmt.Errorf("偏移: %v", err)
+	}
+	sizes, err := parseSweep(cfg.Sizes, 1, DATA_FILE_MAX_SIZE+1)
+	if err != nil {
+		return nil, nil, fmt.Errorf("缓冲区大小: %v", err)
+	}
+	types, err := parseFileTypes(cfg.FileTypes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	total := len(types) * len(offsets) * len(sizes) * len(fileIDs)
+	if total > READ_MATRIX_MAX_CASES {
+		return nil, nil, fmt.Errorf("参数组合 %d 个，超过上限 %d", total, READ_MATRIX_MAX_CASES)
+	}
+	cases := make([]readMatrixCase, 0, total)
+	caseTypes := make([]int, 0, total)
+	for _, t := range types {
+		for _, offset := range offsets {
+			for _, size := range sizes {
+				for _, id := range fileIDs {
+					cases = append(cases, readMatrixCase{FileType: fileTypeLabel(t), FileID: uint16(id), Offset: offset, Size: size})
+					caseTypes = append(caseTypes, t)
+				}
+			}
+		}
+	}
+	return cases, caseTypes, nil
+}
+
+// runReadMatrix 在已打开的设备上执行扫描
+func runReadMatrix(ctx context.Context, backend Backend, handle DongleHandle, cfg readMatrixConfig) ([]readMatrixResult, error) {
+	cases, types, err := expandReadMatrix(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []readMatrixResult
+	for i, tc := range cases {
+		buffer := make([]byte, tc.Size)
+		var retCode uint32
+		var err error
+		if types[i] < 0 {
+			if ctxErr := callNative(ctx, FUNC_READFILE, func() { retCode, err = backend.ReadFile(handle, tc.FileID, tc.Offset, buffer) }); ctxErr != nil {
+				return results, ctxErr
+			}
+		} else {
+			reader, ok := backend.(typedFileReader)
+			if !ok {
+				return results, errTypedReadUnsupported
+			}
+			if ctxErr := callNative(ctx, FUNC_READFILE, func() { retCode, err = reader.ReadFileTyped(handle, types[i], tc.FileID, tc.Offset, buffer) }); ctxErr != nil {
+				return results, ctxErr
+			}
+			if err == errTypedReadUnsupported {
+				return results, err
+			}
+		}
+
+		r := readMatrixResult{readMatrixCase: tc, RetCode: fmt.Sprintf("%08X", retCode)}
+		if err != nil {
+			r.Error = err.Error()
+		}
+		results = append(results, r)
+		fmt.Printf("测试 %d/%d: 类型 %s 文件ID 0x%04X 偏移 %d 大小 %d → %s %s\n",
+			i+1, len(cases), tc.FileType, tc.FileID, tc.Offset, tc.Size, r.RetCode, getErrorDescription(retCode))
+
+		if err == nil && retCode == DONGLE_SUCCESS && cfg.StopOnSuccess {
+			displaySize := len(buffer)
+			if displaySize > 32 {
+				displaySize = 32
+			}
+			fmt.Println("  数据（前", displaySize, "字节）：")
+			showBinHex(buffer[:displaySize])
+			break
+		}
+	}
+	return results, nil
+}
+
+// printReadMatrix 以表格输出返回码：行为 类型/文件ID，列为 偏移/大小
+func printReadMatrix(w io.Writer, results []readMatrixResult) {
+	type column struct{ offset, size int }
+	var rows []string
+	var cols []column
+	seen := make(map[column]bool)
+	cells := make(map[string]map[column]string)
+	for _, r := range results {
+		row := fmt.Sprintf("%s 0x%04X", r.FileType, r.FileID)
+		col := column{r.Offset, r.Size}
+		if cells[row] == nil {
+			cells[row] = make(map[column]string)
+			rows = append(rows, row)
+		}
+		if !seen[col] {
+			seen[col] = true
+			cols = append(cols, col)
+		}
+		cells[row][col] = r.RetCode
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "类型 文件ID")
+	for _, c := range cols {
+		fmt.Fprintf(tw, "\t%d/%d", c.offset, c.size)
+	}
+	fmt.Fprintln(tw)
+	for _, row := range rows {
+		fmt.Fprint(tw, row)
+		for _, c := range cols {
+			code, ok := cells[row][c]
+			if !ok {
+				code = "-"
+			}
+			fmt.Fprintf(tw, "\t%s", code)
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+}
+
+// String 参数组合的描述
+func (c readMatrixCase) String() string {
+	return fmt.Sprintf("类型 %s 文件ID 0x%04X 偏移 %d 大小 %d", c.FileType, c.FileID, c.Offset, c.Size)
+}
+
+// compareReadMatrix 对比两次扫描，返回返回码不同或只在一侧出现的参数组合
+func compareReadMatrix(old, cur []readMatrixResult) []string {
+	previous := make(map[readMatrixCase]string, len(old))
+	for _, r := range old {
+		previous[r.readMatrixCase] = r.RetCode
+	}
+	current := make(map[readMatrixCase]bool, len(cur))
+	var diffs []string
+	for _, r := range cur {
+		current[r.readMatrixCase] = true
+		code, ok := previous[r.readMatrixCase]
+		switch {
+		case !ok:
+			diffs = append(diffs, fmt.Sprintf("%s: 之前未扫描 → %s", r.readMatrixCase, r.RetCode))
+		case code != r.RetCode:
+			diffs = append(diffs, fmt.Sprintf("%s: %s → %s", r.readMatrixCase, code, r.RetCode))
+		}
+	}
+	for _, r := range old {
+		if !current[r.readMatrixCase] {
+			diffs = append(diffs, fmt.Sprintf("%s: %s → 本次未扫描", r.readMatrixCase, r.RetCode))
+		}
+	}
+	return diffs
+}
+
+// fileSHA256 计算文件校验和，失败时返回空字符串
+func fileSHA256(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// runReadMatrixCommand 按参数文件或命令行参数扫描 ReadFile
+func runReadMatrixCommand(args []string) error {
+	fs := flag.NewFlagSet("read-matrix", flag.ContinueOnError)
+	configPath := fs.String("config", "", "参数文件 (YAML 或 JSON)")
+	fileIDs := fs.String("file-ids", "", "文件ID列表或范围，例如 0-3,0x1000")
+	offsets := fs.String("offsets", "", "偏移列表或范围，例如 0-512:128")
+	sizes := fs.String("sizes", "", "缓冲区大小列表或范围")
+	fileTypes := fs.String("types", "", "文件类型: auto, data, rsa, ecc, key, exe 或数字")
+	first := fs.Bool("first", false, "第一次成功后停止")
+	output := fs.String("o", "", "保存结果矩阵 (JSON)")
+	comparePath := fs.String("compare", "", "与之前保存的结果矩阵对比")
+	index := fs.Int("device", 0, "设备序号")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg := defaultReadMatrixConfig()
+	cfg.StopOnSuccess = false
+	if *configPath != "" {
+		var err error
+		if cfg, err = loadReadMatrixConfig(*configPath); err != nil {
+			return err
+		}
+	}
+	// 命令行参数覆盖参数文件
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "file-ids":
+			cfg.FileIDs = []string{*fileIDs}
+		case "offsets":
+			cfg.Offsets = []string{*offsets}
+		case "sizes":
+			cfg.Sizes = []string{*sizes}
+		case "types":
+			cfg.FileTypes = []string{*fileTypes}
+		case "first":
+			cfg.StopOnSuccess = *first
+		}
+	})
+	if _, _, err := expandReadMatrix(cfg); err != nil {
+		return err
+	}
+
+	var previous *readMatrix
+	if *comparePath != "" {
+		data, err := os.ReadFile(*comparePath)
+		if err != nil {
+			return err
+		}
+		previous = &readMatrix{}
+		if err := json.Unmarshal(data, previous); err != nil {
+			return fmt.Errorf("解析 %s 失败: %v", *comparePath, err)
+		}
+	}
+
+	backend, cleanup, err := openTestBackend(FUNC_ENUM, FUNC_OPEN, FUNC_READFILE)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	ctx, stop := commandContext()
+	defer stop()
+
+	var keyList []DongleInfo
+	var retCode uint32
+	if ctxErr := callNative(ctx, FUNC_ENUM, func() { keyList, retCode, err = backend.Enum() }); ctxErr != nil {
+		return ctxErr
+	}
+	if err != nil {
+		return fmt.Errorf("设备枚举失败，错误码: %08X - %s", retCode, getErrorDescription(retCode))
+	}
+	if *index < 0 || *index >= len(keyList) {
+		return fmt.Errorf("设备序号 %d 超出范围，共 %d 个设备", *index, len(keyList))
+	}
+	var handle DongleHandle
+	if ctxErr := callNative(ctx, FUNC_OPEN, func() { handle, retCode, err = backend.Open(*index) }); ctxErr != nil {
+		return ctxErr
+	}
+	if err != nil {
+		return fmt.Errorf("打开设备失败，错误码: %08X - %s", retCode, getErrorDescription(retCode))
+	}
+	defer callNative(context.WithoutCancel(ctx), FUNC_CLOSE, func() { backend.Close(handle) })
+
+	results, err := runReadMatrix(ctx, backend, handle, cfg)
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	printReadMatrix(os.Stdout, results)
+
+	matrix := readMatrix{
+		Created:       time.Now(),
+		Library:       getLibraryPath(),
+		LibrarySHA256: fileSHA256(getLibraryPath()),
+		Config:        cfg,
+		Results:       results,
+	}
+	if *output != "" {
+		data, err := json.MarshalIndent(matrix, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*output, append(data, '\n'), 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("\n结果矩阵已保存: %s\n", *output)
+	}
+
+	if previous != nil {
+		fmt.Printf("\n与 %s 对比 (库 %s → %s):\n", *comparePath, shortHash(previous.LibrarySHA256), shortHash(matrix.LibrarySHA256))
+		diffs := compareReadMatrix(previous.Results, results)
+		if len(diffs) == 0 {
+			fmt.Println("  返回码全部一致")
+		}
+		for _, d := range diffs {
+			fmt.Printf("  %s\n", d)
+		}
+	}
+	return nil
+}
+
+// shortHash 校验和的前 12 位
+func shortHash(sum string) string {
+	if len(sum) > 12 {
+		return sum[:12]
+	}
+	if sum == "" {
+		return "未知"
+	}
+	return sum
+}
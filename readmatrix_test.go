@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadReadMatrixConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	cfg, err := loadReadMatrixConfig(write("ok.yaml", "file_ids: [\"0x1000-0x1002\"]\nsizes: [\"16\"]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.FileIDs) != 1 || cfg.FileIDs[0] != "0x1000-0x1002" || cfg.Offsets == nil || cfg.StopOnSuccess {
+		t.Errorf("cfg = %+v", cfg)
+	}
+
+	// 拼错的项名不能被静默忽略，否则会用默认值扫描
+	if _, err := loadReadMatrixConfig(write("typo.yaml", "file_id: [\"0x1000\"]\n")); err == nil {
+		t.Error("YAML 中的未知项应报错")
+	}
+	if _, err := loadReadMatrixConfig(write("typo.json", `{"offset": ["0"]}`)); err == nil {
+		t.Error("JSON 中的未知项应报错")
+	}
+}
+
+func TestCompareReadMatrix(t *testing.T) {
+	result := func(fileID uint16, code string) readMatrixResult {
+		return readMatrixResult{readMatrixCase: readMatrixCase{FileType: READ_MATRIX_AUTO, FileID: fileID, Size: 16}, RetCode: code}
+	}
+	old := []readMatrixResult{result(1, "00000000"), result(2, "F0000003"), result(3, "00000000")}
+	cur := []readMatrixResult{result(1, "00000000"), result(2, "00000000"), result(4, "00000000")}
+
+	diffs := compareReadMatrix(old, cur)
+	want := []string{"0x0002 偏移 0 大小 16: F0000003 → 00000000", "0x0004 偏移 0 大小 16: 之前未扫描", "0x0003 偏移 0 大小 16: 00000000 → 本次未扫描"}
+	if len(diffs) != len(want) {
+		t.Fatalf("diffs = %q", diffs)
+	}
+	for i, w := range want {
+		if !strings.Contains(diffs[i], w) {
+			t.Errorf("diffs[%d] = %q, 期望包含 %q", i, diffs[i], w)
+		}
+	}
+	if diffs := compareReadMatrix(old, old); len(diffs) != 0 {
+		t.Errorf("相同结果的 diffs = %q", diffs)
+	}
+}
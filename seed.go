@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// ============ 种子码运算 ============
+
+// 种子码长度
+const (
+	SEED_MAX_LEN    = 250 // 种子码最大长度
+	SEED_OUTPUT_LEN = 16  // 种子码运算结果长度
+)
+
+// seed 使用种子码运算，相同产品的加密锁对相同种子码得到相同结果
+func seed(seedFunc uintptr, handle DongleHandle, seedData []byte) ([]byte, uint32, error) {
+	if handle == 0 {
+		return nil, DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+	if len(seedData) == 0 || len(seedData) > SEED_MAX_LEN {
+		return nil, DONGLE_INVALID_SIZE, fmt.Errorf("%s: 种子码长度 %d 超出范围 (1-%d)", getErrorDescription(DONGLE_INVALID_SIZE), len(seedData), SEED_MAX_LEN)
+	}
+
+	// 函数原型: DWORD Dongle_Seed(DONGLE_HANDLE hDongle, BYTE* pSeed, int nSeedLen, BYTE* pOutData)
+	type SeedFuncType func(handle DongleHandle, seed unsafe.Pointer, seedLen int32, out unsafe.Pointer) uint32
+
+	var seedFuncGo SeedFuncType
+	purego.RegisterFunc(&seedFuncGo, seedFunc)
+
+	out := make([]byte, SEED_OUTPUT_LEN)
+	start := time.Now()
+	retCode := seedFuncGo(handle, unsafe.Pointer(&seedData[0]), int32(len(seedData)), unsafe.Pointer(&out[0]))
+	traceCall(FUNC_SEED, handle, retCode, start)
+
+	if retCode != DONGLE_SUCCESS {
+		return nil, retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+	return out, retCode, nil
+}
+
+// seed 在已打开的设备上进行种子码运算
+func (c *deviceConn) seed(seedData []byte) ([]byte, error) {
+	seedFunc, err := c.proc(FUNC_SEED)
+	if err != nil {
+		return nil, err
+	}
+	var out []byte
+	err = c.call(FUNC_SEED, func() (err error) {
+		out, _, err = seed(seedFunc, c.handle, seedData)
+		return
+	})
+	return out, err
+}
@@ -0,0 +1,532 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ============ 授权守护进程 ============
+//
+// 同一台机器上的多个进程都需要检查加密锁，但设备只适合由一个进程持有。
+// serve 命令打开设备并通过 Unix 套接字提供 HTTP/JSON 接口，按对端进程的
+// uid/gid (SO_PEERCRED、SO_PEERGROUPS) 控制访问。二进制数据统一用十六进制
+// 字符串表示，客户端见 client 包。
+//
+// 签名接口只允许使用 -sign-keys 列出的私钥文件。升级回执的签名私钥
+// (RECEIPT_KEY_FILE_ID) 不能加入，否则本机任何有权访问的进程都能伪造回执。
+
+// DEFAULT_SOCKET_PATH 守护进程默认的套接字路径
+const DEFAULT_SOCKET_PATH = "/run/rockey/rockey.sock"
+
+// SERVE_MAX_BODY 请求体最大长度
+const SERVE_MAX_BODY = 64 * 1024
+
+// peerCred 对端进程身份
+type peerCred struct {
+	PID    int32
+	UID    uint32
+	GID    uint32
+	Groups []uint32 // 附加组
+}
+
+// peerCredKey 请求上下文中保存对端身份的键
+type peerCredKey struct{}
+
+// 接口请求与响应
+type (
+	readRequest struct {
+		Zone   string `json:"zone"` // file 或 data，默认 file
+		FileID uint16 `json:"file_id"`
+		Offset int    `json:"offset"`
+		Length int    `json:"length"`
+	}
+	seedRequest struct {
+		Data string `json:"data"`
+	}
+	randomRequest struct {
+		Length int `json:"length"`
+	}
+	signRequest struct {
+		FileID uint16 `json:"file_id"`
+		Hash   string `json:"hash"`
+	}
+	errorResponse struct {
+		Error   string `json:"error"`
+		RetCode string `json:"ret_code,omitempty"`
+	}
+)
+
+// licenseServer 授权守护进程
+type licenseServer struct {
+	session   *Session
+	timeout   time.Duration   // 单个请求的设备操作超时
+	allowUIDs map[uint32]bool // 允许访问的 uid
+	allowGIDs map[uint32]bool // 允许访问的 gid
+	signKeys  map[uint16]bool // 允许签名的私钥文件ID
+}
+
+// handler 返回带访问控制的 HTTP 处理器
+func (srv *licenseServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/devices", srv.handleDevices)
+	mux.HandleFunc("GET /v1/info", srv.handleInfo)
+	mux.HandleFunc("POST /v1/read", srv.handleRead)
+	mux.HandleFunc("POST /v1/seed", srv.handleSeed)
+	mux.HandleFunc("POST /v1/random", srv.handleRandom)
+	mux.HandleFunc("POST /v1/sign", srv.handleSign)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cred, ok := r.Context().Value(peerCredKey{}).(peerCred)
+		if !ok || !srv.allowed(cred) {
+			logger.Warn("拒绝访问", "pid", cred.PID, "uid", cred.UID, "gid", cred.GID, "method", r.Method, "path", r.URL.Path)
+			writeJSON(w, http.StatusForbidden, errorResponse{Error: "访问被拒绝"})
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, SERVE_MAX_BODY)
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// httpServer 返回 Unix 套接字上的 HTTP 服务，每个连接的上下文中保存对端身份
+func (srv *licenseServer) httpServer() *http.Server {
+	return &http.Server{
+		Handler:           srv.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			cred, err := peerCredentials(conn)
+			if err != nil {
+				logger.Warn("无法获取对端身份", "err", err)
+				return ctx
+			}
+			return context.WithValue(ctx, peerCredKey{}, cred)
+		},
+	}
+}
+
+// allowed 判断对端进程是否有权访问，root 与守护进程自身的用户总是允许
+//
+// 主组和附加组中任一在 allowGIDs 中即允许。
+func (srv *licenseServer) allowed(cred peerCred) bool {
+	if cred.UID == 0 || cred.UID == uint32(os.Getuid()) {
+		return true
+	}
+	if srv.allowUIDs[cred.UID] || srv.allowGIDs[cred.GID] {
+		return true
+	}
+	for _, gid := range cred.Groups {
+		if srv.allowGIDs[gid] {
+			return true
+		}
+	}
+	return false
+}
+
+// context 为设备操作加上超时
+func (srv *licenseServer) context(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), srv.timeout)
+}
+
+func (srv *licenseServer) handleDevices(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := srv.context(r)
+	defer cancel()
+
+	keyList, err := srv.session.Enum(ctx)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	devices := make([]*dongleInfoJSON, len(keyList))
+	for i, info := range keyList {
+		devices[i] = newDongleInfoJSON(info)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"devices": devices})
+}
+
+func (srv *licenseServer) handleInfo(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, newDongleInfoJSON(srv.session.Info()))
+}
+
+func (srv *licenseServer) handleRead(w http.ResponseWriter, r *http.Request) {
+	var req readRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Length <= 0 || req.Length > TEST_BUFFER_SIZE {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("读取长度 %d 超出范围 (1-%d)", req.Length, TEST_BUFFER_SIZE)})
+		return
+	}
+
+	ctx, cancel := srv.context(r)
+	defer cancel()
+
+	buffer := make([]byte, req.Length)
+	var err error
+	switch req.Zone {
+	case "", "file":
+		err = srv.session.ReadFile(ctx, req.FileID, req.Offset, buffer)
+	case "data":
+		err = srv.session.ReadData(ctx, req.Offset, buffer)
+	default:
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("未知区域: %s", req.Zone)})
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"data": hex.EncodeToString(buffer)})
+}
+
+func (srv *licenseServer) handleSeed(w http.ResponseWriter, r *http.Request) {
+	var req seedRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	data, err := parseHexInput(req.Data)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+
+	ctx, cancel := srv.context(r)
+	defer cancel()
+
+	result, err := srv.session.Seed(ctx, data)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"result": hex.EncodeToString(result)})
+}
+
+func (srv *licenseServer) handleRandom(w http.ResponseWriter, r *http.Request) {
+	var req randomRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	ctx, cancel := srv.context(r)
+	defer cancel()
+
+	data, err := srv.session.GenRandom(ctx, req.Length)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"data": hex.EncodeToString(data)})
+}
+
+func (srv *licenseServer) handleSign(w http.ResponseWriter, r *http.Request) {
+	var req signRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if !srv.signKeys[req.FileID] {
+		writeJSON(w, http.StatusForbidden, errorResponse{Error: fmt.Sprintf("不允许使用私钥文件 0x%04X 签名", req.FileID)})
+		return
+	}
+	hash, err := parseHexInput(req.Hash)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+
+	ctx, cancel := srv.context(r)
+	defer cancel()
+
+	signature, err := srv.session.EccSign(ctx, req.FileID, hash)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"signature": hex.EncodeToString(signature)})
+}
+
+// readJSON 解析请求体，失败时写入错误响应
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("无效的请求: %v", err)})
+		return false
+	}
+	return true
+}
+
+// writeError 按错误类型写入错误响应
+func writeError(w http.ResponseWriter, err error) {
+	var dongleErr *DongleError
+	switch {
+	case errors.As(err, &dongleErr):
+		status := http.StatusBadGateway
+		switch dongleErr.RetCode {
+		case DONGLE_INVALID_PARAMETER, DONGLE_INVALID_FILEID, DONGLE_INVALID_OFFSET, DONGLE_INVALID_SIZE:
+			status = http.StatusBadRequest
+		case DONGLE_ACCESS_DENIED:
+			status = http.StatusForbidden
+		}
+		writeJSON(w, status, errorResponse{Error: err.Error(), RetCode: fmt.Sprintf("%08X", dongleErr.RetCode)})
+	case errors.Is(err, context.DeadlineExceeded):
+		writeJSON(w, http.StatusGatewayTimeout, errorResponse{Error: "设备操作超时，设备可能已被拔出或无响应"})
+	case errors.Is(err, ErrSessionClosed):
+		writeJSON(w, http.StatusServiceUnavailable, errorResponse{Error: err.Error()})
+	default:
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+	}
+}
+
+// writeJSON 写入 JSON 响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// parseIDList 解析逗号分隔的 uid/gid 列表
+func parseIDList(s string) (map[uint32]bool, error) {
+	ids := make(map[uint32]bool)
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("无效的 ID: %s", field)
+		}
+		ids[uint32(id)] = true
+	}
+	return ids, nil
+}
+
+// parseSignKeys 解析允许签名的私钥文件ID列表，拒绝升级回执的签名私钥
+func parseSignKeys(s string) (map[uint16]bool, error) {
+	keys := make(map[uint16]bool)
+	for _, field := range strings.Split(s, ",") {
+		if strings.TrimSpace(field) == "" {
+			continue
+		}
+		id, err := parseFileID(field)
+		if err != nil {
+			return nil, err
+		}
+		if id == RECEIPT_KEY_FILE_ID {
+			return nil, fmt.Errorf("私钥文件 0x%04X 用于签名升级回执，不能通过守护进程签名", id)
+		}
+		keys[id] = true
+	}
+	return keys, nil
+}
+
+// listenUnix 监听 Unix 套接字，清理上次异常退出遗留的套接字文件
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s 已存在且不是套接字", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s 已有守护进程在监听", path)
+		}
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("监听 %s 失败: %v", path, err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// openBackend 按名称创建后端，模拟后端按 simSeats 预置席位记录
+func openBackend(name string, simCount, simSeats int) (Backend, error) {
+	switch name {
+	case "native":
+		return newNativeBackend(getLibraryPath())
+	case "helper":
+		return newHelperBackend(getLibraryPath(), *callTimeout)
+	case "hid":
+		return newHIDBackend(), nil
+	case "sim":
+		sim := newSimBackend(simCount)
+		if simSeats > 0 {
+			record, err := encodeSeatRecord(simSeats)
+			if err != nil {
+				return nil, err
+			}
+			sim.SetFile(SEAT_FILE_ID, record)
+		}
+		return sim, nil
+	default:
+		return nil, fmt.Errorf("未知后端: %s (可选 native、helper、hid、sim)", name)
+	}
+}
+
+// runServeCommand 启动授权守护进程
+func runServeCommand(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	socket := fs.String("socket", DEFAULT_SOCKET_PATH, "Unix 套接字路径，为空时不监听")
+	mode := fs.Uint("mode", 0660, "套接字文件权限")
+	deviceIndex := fs.Int("device", 0, "设备序号")
+	backendName := fs.String("backend", "native", "后端: native、helper (动态库在辅助进程中运行)、hid (纯Go HID 传输，实验性) 或 sim")
+	simCount := fs.Int("sim-count", 1, "模拟后端的设备数量")
+	simSeats := fs.Int("sim-seats", 0, "模拟后端预置的浮动授权席位数，写入默认席位记录文件")
+	pin := fs.String("pin", "", "启动时校验的用户PIN，签名等操作需要")
+	allowUID := fs.String("allow-uid", "", "额外允许访问的 uid，逗号分隔")
+	allowGID := fs.String("allow-gid", "", "额外允许访问的 gid，逗号分隔")
+	signKeysFlag := fs.String("sign-keys", "", "允许通过 /v1/sign 签名的 ECC 私钥文件ID，逗号分隔，为空时不允许签名")
+	listen := fs.String("listen", "", "浮动授权服务的 TCP 监听地址，为空时不启用")
+	certFile := fs.String("cert", "", "浮动授权服务的服务端证书")
+	keyFile := fs.String("key", "", "浮动授权服务的服务端私钥")
+	clientCA := fs.String("client-ca", "", "签发客户端证书的 CA")
+	leaseTTL := fs.Duration("lease-ttl", DEFAULT_LEASE_TTL, "租约有效期，客户端需在到期前续约")
+	statePath := fs.String("state", DEFAULT_LEASE_STATE, "租约状态文件")
+	seatFile := fs.String("seat-file", fmt.Sprintf("0x%04X", SEAT_FILE_ID), "席位记录文件ID")
+	metricsAddr := fs.String("metrics", "", "在该地址上提供 Prometheus /metrics，为空时不启用")
+	probeInterval := fs.Duration("probe-interval", DEFAULT_PROBE_INTERVAL, "监控指标的健康检查间隔")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	allowUIDs, err := parseIDList(*allowUID)
+	if err != nil {
+		return err
+	}
+	allowGIDs, err := parseIDList(*allowGID)
+	if err != nil {
+		return err
+	}
+	signKeys, err := parseSignKeys(*signKeysFlag)
+	if err != nil {
+		return err
+	}
+	seatFileID, err := parseFileID(*seatFile)
+	if err != nil {
+		return fmt.Errorf("-seat-file: %v", err)
+	}
+
+	if *socket == "" && *listen == "" {
+		return fmt.Errorf("-socket 与 -listen 至少指定一个")
+	}
+
+	var tlsConfig *tls.Config
+	var leases *leaseManager
+	if *listen != "" {
+		if *certFile == "" || *keyFile == "" || *clientCA == "" {
+			return fmt.Errorf("浮动授权服务需要 -cert、-key 和 -client-ca")
+		}
+		if *leaseTTL < time.Second {
+			return fmt.Errorf("租约有效期过短: %v", *leaseTTL)
+		}
+		if tlsConfig, err = loadServerTLS(*certFile, *keyFile, *clientCA); err != nil {
+			return err
+		}
+		if leases, err = newLeaseManager(*statePath, *leaseTTL); err != nil {
+			return err
+		}
+	}
+
+	backend, err := openBackend(*backendName, *simCount, *simSeats)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	openCtx, cancel := context.WithTimeout(ctx, *callTimeout)
+	defer cancel()
+	session, err := OpenSession(openCtx, backend, *deviceIndex, SessionOptions{
+		Reconnect:   true,
+		RememberPIN: true,
+		OnReconnect: func(ev ReconnectEvent) {
+			if ev.Err != nil {
+				logger.Warn("重连失败", "op", ev.Op, "hid", ev.HID, "attempt", ev.Attempt, "delay", ev.Delay, "err", ev.Err)
+			} else {
+				logger.Info("重连成功", "op", ev.Op, "hid", ev.HID, "attempt", ev.Attempt)
+			}
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("打开设备失败: %v", err)
+	}
+	defer session.Close()
+
+	if *pin != "" {
+		if err := session.VerifyPIN(openCtx, FLAG_USERPIN, *pin); err != nil {
+			return fmt.Errorf("校验用户PIN失败: %v", err)
+		}
+	}
+
+	if *metricsAddr != "" {
+		health := newHealthMetrics(session.Metrics)
+		if err := serveMetrics(ctx, *metricsAddr, health); err != nil {
+			return err
+		}
+		go health.probeLoop(ctx, session, *probeInterval, *callTimeout)
+	}
+
+	var servers []*http.Server
+	serveErr := make(chan error, 2)
+
+	if *socket != "" {
+		listener, err := listenUnix(*socket, os.FileMode(*mode))
+		if err != nil {
+			return err
+		}
+		defer os.Remove(*socket)
+
+		srv := &licenseServer{session: session, timeout: *callTimeout, allowUIDs: allowUIDs, allowGIDs: allowGIDs, signKeys: signKeys}
+		httpServer := srv.httpServer()
+		servers = append(servers, httpServer)
+		go func() { serveErr <- httpServer.Serve(listener) }()
+		logger.Info("守护进程已启动", "socket", *socket, "hid", session.Info().HID())
+	}
+
+	if *listen != "" {
+		listener, err := tls.Listen("tcp", *listen, tlsConfig)
+		if err != nil {
+			return fmt.Errorf("监听 %s 失败: %v", *listen, err)
+		}
+
+		srv := &leaseServer{session: session, leases: leases, seatFile: seatFileID, timeout: *callTimeout}
+		httpServer := &http.Server{Handler: srv.handler(), ReadHeaderTimeout: 10 * time.Second}
+		servers = append(servers, httpServer)
+		go func() { serveErr <- httpServer.Serve(listener) }()
+		go leases.sweep(ctx)
+		logger.Info("浮动授权服务已启动", "listen", *listen, "lease_ttl", *leaseTTL)
+	}
+
+	select {
+	case <-ctx.Done():
+	case err := <-serveErr:
+		if err != nil && err != http.ErrServerClosed {
+			return err
+		}
+	}
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelShutdown()
+	for _, httpServer := range servers {
+		httpServer.Shutdown(shutdownCtx)
+	}
+	logger.Info("守护进程已退出")
+	return nil
+}
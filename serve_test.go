@@ -0,0 +1,216 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yangmaoqiu/golang/client"
+)
+
+// startSimDaemon 在临时目录的 Unix 套接字上启动使用模拟后端的守护进程
+func startSimDaemon(t *testing.T, backend Backend) (*Session, *client.Client) {
+	t.Helper()
+	session, err := OpenSession(context.Background(), backend, 0, SessionOptions{Reconnect: true, RememberPIN: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { session.Close() })
+
+	socket := filepath.Join(t.TempDir(), "rockey.sock")
+	listener, err := listenUnix(socket, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &licenseServer{session: session, timeout: 5 * time.Second, signKeys: map[uint16]bool{0x0001: true}}
+	httpServer := srv.httpServer()
+	go httpServer.Serve(listener)
+	t.Cleanup(func() { httpServer.Close() })
+
+	return session, client.New(socket)
+}
+
+func TestServeSim(t *testing.T) {
+	backend := newSimBackend(2)
+	session, c := startSimDaemon(t, backend)
+	ctx := context.Background()
+
+	info, err := c.Info(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.HID != session.Info().HID() {
+		t.Errorf("Info().HID = %s, 期望 %s", info.HID, session.Info().HID())
+	}
+
+	devices, err := c.Devices(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 2 {
+		t.Errorf("Devices() 返回 %d 个设备，期望 2", len(devices))
+	}
+
+	content, err := c.ReadFile(ctx, TEST_FILE_ID, 0, len("simulated dongle 0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "simulated dongle 0\n" {
+		t.Errorf("ReadFile = %q", content)
+	}
+
+	random, err := c.Random(ctx, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(random) != 16 {
+		t.Errorf("Random 返回 %d 字节", len(random))
+	}
+
+	// 同一产品的锁种子码结果相同
+	result, err := c.Seed(ctx, []byte("challenge"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := OpenSession(ctx, backend, 1, SessionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	expected, err := other.Seed(ctx, []byte("challenge"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result, expected) {
+		t.Errorf("同一产品的两把锁种子码结果不同: %X / %X", result, expected)
+	}
+
+	// 签名需要用户权限
+	hash := sha256.Sum256([]byte("abc"))
+	_, err = c.Sign(ctx, 0x0001, hash[:])
+	var clientErr *client.Error
+	if !errors.As(err, &clientErr) || clientErr.Status != http.StatusForbidden || clientErr.RetCode != DONGLE_ACCESS_DENIED {
+		t.Fatalf("未校验PIN时 Sign = %v, 期望 403 / %08X", err, DONGLE_ACCESS_DENIED)
+	}
+	if err := session.VerifyPIN(ctx, FLAG_USERPIN, DEFAULT_USER_PIN); err != nil {
+		t.Fatal(err)
+	}
+	signature, err := c.Sign(ctx, 0x0001, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signature) != ECC_SIGNATURE_SIZE {
+		t.Errorf("签名长度 %d", len(signature))
+	}
+
+	// 未列入 -sign-keys 的私钥文件，包括升级回执的私钥，都不能签名
+	for _, fileID := range []uint16{RECEIPT_KEY_FILE_ID, 0x0002} {
+		_, err = c.Sign(ctx, fileID, hash[:])
+		if !errors.As(err, &clientErr) || clientErr.Status != http.StatusForbidden {
+			t.Errorf("Sign(0x%04X) = %v, 期望 403", fileID, err)
+		}
+	}
+}
+
+func TestParseSignKeys(t *testing.T) {
+	keys, err := parseSignKeys("1, 0x0002,")
+	if err != nil || len(keys) != 2 || !keys[1] || !keys[2] {
+		t.Errorf("parseSignKeys = %v, %v", keys, err)
+	}
+	if _, err := parseSignKeys(fmt.Sprintf("1,0x%04X", RECEIPT_KEY_FILE_ID)); err == nil {
+		t.Error("允许了升级回执的签名私钥")
+	}
+}
+
+func TestServeReconnect(t *testing.T) {
+	backend := newSimBackend(1)
+	session, c := startSimDaemon(t, backend)
+	ctx := context.Background()
+
+	if err := session.VerifyPIN(ctx, FLAG_USERPIN, DEFAULT_USER_PIN); err != nil {
+		t.Fatal(err)
+	}
+	backend.Reset()
+
+	// 复位后句柄失效，守护进程重连并恢复PIN后请求成功
+	hash := sha256.Sum256([]byte("abc"))
+	if _, err := c.Sign(ctx, 0x0001, hash[:]); err != nil {
+		t.Fatalf("复位后 Sign = %v", err)
+	}
+	if m := session.Metrics()[FUNC_ECCSIGN]; m.Reconnects != 1 {
+		t.Errorf("Reconnects = %d", m.Reconnects)
+	}
+}
+
+func TestServeAllowed(t *testing.T) {
+	srv := &licenseServer{allowUIDs: map[uint32]bool{1001: true}, allowGIDs: map[uint32]bool{2001: true}}
+	cases := []struct {
+		name string
+		cred peerCred
+		want bool
+	}{
+		{"root", peerCred{UID: 0, GID: 0}, true},
+		{"allowed-uid", peerCred{UID: 1001, GID: 100}, true},
+		{"allowed-primary-gid", peerCred{UID: 1002, GID: 2001}, true},
+		{"allowed-supplementary-gid", peerCred{UID: 1002, GID: 100, Groups: []uint32{27, 2001}}, true},
+		{"denied", peerCred{UID: 1002, GID: 100, Groups: []uint32{27}}, false},
+	}
+	for _, tc := range cases {
+		if got := srv.allowed(tc.cred); got != tc.want {
+			t.Errorf("%s: allowed = %v, 期望 %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPeerCredentials(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "peer.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	peer, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	cred, err := peerCredentials(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred.PID != int32(os.Getpid()) || cred.UID != uint32(os.Getuid()) || cred.GID != uint32(os.Getgid()) {
+		t.Errorf("peerCredentials = %+v", cred)
+	}
+	want, err := os.Getgroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[uint32]bool)
+	for _, gid := range cred.Groups {
+		got[gid] = true
+	}
+	for _, gid := range want {
+		if !got[uint32(gid)] {
+			t.Errorf("附加组 %v 中缺少 %d", cred.Groups, gid)
+		}
+	}
+	if len(cred.Groups) != len(want) {
+		t.Errorf("附加组 %v, 期望 %v", cred.Groups, want)
+	}
+}
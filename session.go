@@ -0,0 +1,670 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// ============ 会话 ============
+//
+// 厂商动态库没有声明线程安全，而 purego 调用可能落在任意系统线程上。
+// Session 持有一个设备句柄，所有调用都排队交给一个锁定系统线程的
+// 工作 goroutine 串行执行。排队中的操作可以通过 context 取消或超时。
+//
+// 已经进入原生调用的操作无法中断。调用方的 context 结束时，如果操作仍在
+// 原生调用中，调用方立即返回，当前工作 goroutine 连同它的线程和句柄一起
+// 被放弃。动态库可能仍在卡住的调用中持有内部状态，因此在它返回之前不再
+// 发起任何原生调用：排队的操作继续等待，直到自己的 context 结束；被放弃的
+// 工作 goroutine 在原生调用返回后关闭自己的句柄，再启动新的工作 goroutine
+// 重新枚举并打开设备接手后续操作。
+//
+// USB 复位后原来的句柄会失效，之后的调用都返回 DONGLE_INVALID_HANDLE。
+// 启用重连时，工作 goroutine 按硬件ID重新枚举并打开同一把锁，按需重新
+// 校验缓存的 PIN，然后重试失败的操作，重试间隔按指数退避且有上限。
+
+// ErrSessionClosed 会话已关闭
+var ErrSessionClosed = errors.New("会话已关闭")
+
+// DongleError 设备返回的错误，携带操作名和返回码
+type DongleError struct {
+	Op      string
+	RetCode uint32
+	Err     error
+}
+
+func (e *DongleError) Error() string { return e.Err.Error() }
+
+func (e *DongleError) Unwrap() error { return e.Err }
+
+// dongleError 设备返回失败码时包装为 DongleError，其他错误原样返回
+func dongleError(op string, retCode uint32, err error) error {
+	if err != nil && retCode != DONGLE_SUCCESS {
+		return &DongleError{Op: op, RetCode: retCode, Err: err}
+	}
+	return err
+}
+
+// SESSION_QUEUE_SIZE 会话操作队列长度
+const SESSION_QUEUE_SIZE = 64
+
+// 重连默认参数
+const (
+	RECONNECT_MAX_ATTEMPTS = 5                      // 最大重连次数
+	RECONNECT_BASE_DELAY   = 100 * time.Millisecond // 首次重连前的等待时间
+	RECONNECT_MAX_DELAY    = 5 * time.Second        // 重连等待时间上限
+)
+
+// SessionOptions 会话选项
+type SessionOptions struct {
+	Reconnect   bool          // 句柄失效时自动重连并重试
+	MaxAttempts int           // 单个操作的最大重连次数，0 使用默认值
+	BaseDelay   time.Duration // 首次重连前的等待时间，0 使用默认值
+	MaxDelay    time.Duration // 重连等待时间上限，0 使用默认值
+	RememberPIN bool          // 缓存校验成功的 PIN，重连后重新校验
+
+	// OnReconnect 每次重连尝试后在工作 goroutine 中调用，不能阻塞或调用会话方法
+	OnReconnect func(ReconnectEvent)
+}
+
+// ReconnectEvent 重连事件
+type ReconnectEvent struct {
+	Time    time.Time
+	Op      string        // 触发重连的操作
+	HID     string        // 目标设备的硬件ID
+	Attempt int           // 第几次尝试
+	Delay   time.Duration // 尝试前的等待时间
+	Err     error         // 为空表示重连成功
+}
+
+// delay 第 attempt 次重连前的等待时间
+func (o SessionOptions) delay(attempt int) time.Duration {
+	base, max := o.BaseDelay, o.MaxDelay
+	if base <= 0 {
+		base = RECONNECT_BASE_DELAY
+	}
+	if max <= 0 {
+		max = RECONNECT_MAX_DELAY
+	}
+	d := base
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// maxAttempts 单个操作的最大重连次数
+func (o SessionOptions) maxAttempts() int {
+	if o.MaxAttempts <= 0 {
+		return RECONNECT_MAX_ATTEMPTS
+	}
+	return o.MaxAttempts
+}
+
+// needsReconnect 判断返回码是否表示句柄已失效
+func needsReconnect(retCode uint32) bool {
+	return retCode == DONGLE_INVALID_HANDLE || retCode == DONGLE_NEED_FIND
+}
+
+// OpMetrics 单个操作的统计
+type OpMetrics struct {
+	Calls       uint64            // 执行次数
+	Errors      uint64            // 失败次数
+	Canceled    uint64            // 排队中被取消的次数
+	TimedOut    uint64            // 原生调用未及时返回而被放弃的次数
+	Reconnects  uint64            // 句柄失效后重连的次数
+	TotalTime   time.Duration     // 累计耗时
+	MaxTime     time.Duration     // 最大耗时
+	LastRetCode uint32            // 最近一次返回码
+	LastCall    time.Time         // 最近一次执行时间
+	LastSuccess time.Time         // 最近一次成功的时间
+	RetCodes    map[uint32]uint64 // 各返回码出现次数
+	Latency     []uint64          // 各耗时区间的次数，区间上界见 latencyBuckets，最后一项为溢出
+}
+
+// sessionOp 排队的操作
+type sessionOp struct {
+	ctx  context.Context
+	name string
+	fn   func(b Backend, h DongleHandle) (uint32, error)
+	done chan error // 带缓冲，工作 goroutine 不会阻塞
+}
+
+// Session 串行访问单个设备的会话
+type Session struct {
+	backend Backend
+	index   int
+	opts    SessionOptions
+
+	ops       chan *sessionOp
+	quit      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+	stopOnce  sync.Once
+
+	mu         sync.Mutex
+	info       DongleInfo
+	opened     bool           // info 是否有效，此后按硬件ID重新打开
+	pins       map[int]string // 校验成功的 PIN，按 flags 缓存
+	generation uint64         // 当前工作 goroutine 的代数
+	running    *sessionOp     // 当前工作 goroutine 正在执行的操作
+	stalled    bool           // 被放弃的原生调用尚未返回，不能发起新的调用
+
+	stats *opRecorder // 各操作统计
+}
+
+// OpenSession 在专用线程上打开指定序号的设备并创建会话
+func OpenSession(ctx context.Context, backend Backend, index int, opts SessionOptions) (*Session, error) {
+	s := &Session{
+		backend: backend,
+		index:   index,
+		opts:    opts,
+		pins:    make(map[int]string),
+		ops:     make(chan *sessionOp, SESSION_QUEUE_SIZE),
+		quit:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		stats:   newOpRecorder(),
+	}
+
+	openOp := &sessionOp{ctx: ctx, name: FUNC_OPEN}
+	s.running = openOp
+	opened := make(chan error, 1)
+	go s.worker(0, opened)
+	select {
+	case err := <-opened:
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case <-ctx.Done():
+		// 打开卡住时放弃第一个工作 goroutine，它返回后关闭句柄并结束会话
+		s.abandon(openOp)
+		s.closeOnce.Do(func() { close(s.quit) })
+		return nil, ctx.Err()
+	}
+}
+
+// open 枚举并打开会话对应的设备
+//
+// 首次按序号打开，之后按硬件ID查找同一把锁，设备重新插拔后序号可能改变。
+func (s *Session) open() (DongleHandle, error) {
+	keyList, retCode, err := s.backend.Enum()
+	if err != nil {
+		return 0, dongleError(FUNC_ENUM, retCode, err)
+	}
+
+	s.mu.Lock()
+	opened, hid := s.opened, s.info.HID()
+	s.mu.Unlock()
+
+	index := s.index
+	if opened {
+		index = -1
+		for i, info := range keyList {
+			if info.HID() == hid {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return 0, fmt.Errorf("%s: 硬件ID %s", getErrorDescription(DONGLE_NOT_FOUND), hid)
+		}
+	} else if index < 0 || index >= len(keyList) {
+		return 0, fmt.Errorf("设备序号 %d 超出范围 (共 %d 个设备)", index, len(keyList))
+	}
+
+	handle, retCode, err := s.backend.Open(index)
+	if err != nil {
+		return 0, dongleError(FUNC_OPEN, retCode, err)
+	}
+
+	s.mu.Lock()
+	s.index = index
+	s.info = keyList[index]
+	s.opened = true
+	s.mu.Unlock()
+	return handle, nil
+}
+
+// reconnect 关闭失效的句柄，等待后重新打开设备并恢复 PIN 状态
+func (s *Session) reconnect(op *sessionOp, old DongleHandle, attempt int) (DongleHandle, error) {
+	delay := s.opts.delay(attempt)
+	ev := ReconnectEvent{Op: op.name, HID: s.Info().HID(), Attempt: attempt, Delay: delay}
+	defer func() {
+		ev.Time = time.Now()
+		if s.opts.OnReconnect != nil {
+			s.opts.OnReconnect(ev)
+		}
+	}()
+
+	if old != 0 {
+		s.backend.Close(old)
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-op.ctx.Done():
+		ev.Err = op.ctx.Err()
+		return 0, ev.Err
+	case <-s.quit:
+		ev.Err = ErrSessionClosed
+		return 0, ev.Err
+	}
+
+	handle, err := s.open()
+	if err != nil {
+		ev.Err = err
+		return 0, err
+	}
+	s.record(op.name, func(m *OpMetrics) { m.Reconnects++ })
+
+	if err := s.restorePINs(handle); err != nil {
+		ev.Err = err
+		return handle, err
+	}
+	return handle, nil
+}
+
+// restorePINs 在新打开的句柄上重新校验缓存的 PIN
+func (s *Session) restorePINs(handle DongleHandle) error {
+	s.mu.Lock()
+	pins := make(map[int]string, len(s.pins))
+	for flags, pin := range s.pins {
+		pins[flags] = pin
+	}
+	s.mu.Unlock()
+
+	for flags, pin := range pins {
+		if _, _, err := s.backend.VerifyPIN(handle, flags, pin); err != nil {
+			// 缓存的 PIN 已不正确，继续重试只会消耗剩余次数
+			s.mu.Lock()
+			delete(s.pins, flags)
+			s.mu.Unlock()
+			return fmt.Errorf("重新校验PIN失败: %v", err)
+		}
+	}
+	return nil
+}
+
+// current 判断工作 goroutine 是否仍是当前代
+func (s *Session) current(gen uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.generation == gen
+}
+
+// worker 工作 goroutine，锁定系统线程后串行执行所有操作
+//
+// opened 非空时立即打开设备并报告结果，否则在第一个操作到来时再打开。
+func (s *Session) worker(gen uint64, opened chan<- error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	var handle DongleHandle
+	if opened != nil {
+		var err error
+		handle, err = s.open()
+		s.mu.Lock()
+		abandoned := s.generation != gen
+		if !abandoned {
+			s.running = nil
+		}
+		s.mu.Unlock()
+		if abandoned {
+			// 打开期间已被放弃
+			if err != nil {
+				handle = 0
+			}
+			s.handover(handle)
+			return
+		}
+		opened <- err
+		if err != nil {
+			s.stop()
+			return
+		}
+	}
+
+	for {
+		select {
+		case op := <-s.ops:
+			if handle == 0 {
+				var err error
+				if handle, err = s.reopen(gen, op); !s.current(gen) {
+					// 重新打开期间已被放弃
+					s.handover(handle)
+					return
+				}
+				if err != nil {
+					s.record(op.name, func(m *OpMetrics) { m.Errors++ })
+					op.done <- err
+					continue
+				}
+			}
+			handle = s.run(gen, handle, op)
+			if !s.current(gen) {
+				// 执行期间已被放弃
+				s.handover(handle)
+				return
+			}
+		case <-s.quit:
+			// 取消仍在排队的操作
+			for {
+				select {
+				case op := <-s.ops:
+					op.done <- ErrSessionClosed
+				default:
+					if handle != 0 {
+						s.backend.Close(handle)
+					}
+					s.stop()
+					return
+				}
+			}
+		}
+	}
+}
+
+// reopen 在第一个操作到来时重新打开设备并恢复 PIN 状态
+//
+// 与 run 一样把 op 记为正在执行，打开卡住时调用方可以放弃当前工作 goroutine。
+// PIN 校验失败时仍返回新打开的句柄。
+func (s *Session) reopen(gen uint64, op *sessionOp) (DongleHandle, error) {
+	s.mu.Lock()
+	s.running = op
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		if s.generation == gen {
+			s.running = nil
+		}
+		s.mu.Unlock()
+	}()
+
+	handle, err := s.open()
+	if err != nil {
+		return 0, fmt.Errorf("重新打开设备失败: %v", err)
+	}
+	return handle, s.restorePINs(handle)
+}
+
+// run 执行一个操作并记录统计，返回之后使用的句柄
+//
+// 重连失败时返回 0，下一个操作到来时再重新打开。
+func (s *Session) run(gen uint64, handle DongleHandle, op *sessionOp) DongleHandle {
+	if err := op.ctx.Err(); err != nil {
+		s.record(op.name, func(m *OpMetrics) { m.Canceled++ })
+		op.done <- err
+		return handle
+	}
+
+	s.mu.Lock()
+	s.running = op
+	s.mu.Unlock()
+
+	start := time.Now()
+	retCode, err := op.fn(s.backend, handle)
+	if s.opts.Reconnect {
+		for attempt := 1; err != nil && needsReconnect(retCode) && attempt <= s.opts.maxAttempts(); attempt++ {
+			var rerr error
+			handle, rerr = s.reconnect(op, handle, attempt)
+			if rerr != nil {
+				if handle != 0 || op.ctx.Err() != nil || rerr == ErrSessionClosed {
+					// PIN 校验失败、取消或关闭时不再重试
+					err = rerr
+					break
+				}
+				continue
+			}
+			retCode, err = op.fn(s.backend, handle)
+		}
+	}
+	elapsed := time.Since(start)
+
+	s.mu.Lock()
+	if s.generation == gen {
+		s.running = nil
+	}
+	s.mu.Unlock()
+
+	s.stats.observe(op.name, start, elapsed, retCode, err)
+	op.done <- dongleError(op.name, retCode, err)
+	return handle
+}
+
+// abandon 操作仍在原生调用中时放弃当前工作 goroutine
+//
+// 卡住的调用返回之前不启动新的工作 goroutine，同时计入 stuckCalls，
+// 期间 callNative 直接返回错误，动态库也不会被卸载。
+func (s *Session) abandon(op *sessionOp) {
+	s.mu.Lock()
+	if s.running != op {
+		s.mu.Unlock()
+		return
+	}
+	s.running = nil
+	s.generation++
+	s.stalled = true
+	stuckCalls.Add(1)
+	s.mu.Unlock()
+
+	s.record(op.name, func(m *OpMetrics) { m.TimedOut++ })
+}
+
+// handover 被放弃的工作 goroutine 在卡住的调用返回后关闭句柄，启动新的工作 goroutine
+func (s *Session) handover(handle DongleHandle) {
+	if handle != 0 {
+		s.backend.Close(handle)
+	}
+
+	s.mu.Lock()
+	s.stalled = false
+	stuckCalls.Add(-1)
+	gen := s.generation
+	s.mu.Unlock()
+
+	select {
+	case <-s.quit:
+		// 会话已关闭，没有工作 goroutine 负责结束会话
+		s.stop()
+	default:
+		go s.worker(gen, nil)
+	}
+}
+
+// Stalled 判断是否有被放弃的原生调用尚未返回
+func (s *Session) Stalled() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stalled
+}
+
+// stop 标记会话已结束
+func (s *Session) stop() {
+	s.stopOnce.Do(func() { close(s.stopped) })
+}
+
+// record 更新操作统计
+func (s *Session) record(name string, update func(m *OpMetrics)) {
+	s.stats.record(name, update)
+}
+
+// Do 将操作排队到工作 goroutine 执行并等待结果
+//
+// ctx 结束时立即返回 ctx.Err()；操作若已进入原生调用，执行它的线程被隔离。
+func (s *Session) Do(ctx context.Context, name string, fn func(b Backend, h DongleHandle) (uint32, error)) error {
+	op := &sessionOp{ctx: ctx, name: name, fn: fn, done: make(chan error, 1)}
+
+	select {
+	case s.ops <- op:
+	case <-s.quit:
+		return ErrSessionClosed
+	case <-ctx.Done():
+		s.record(name, func(m *OpMetrics) { m.Canceled++ })
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-op.done:
+		return err
+	case <-ctx.Done():
+		s.abandon(op)
+		return ctx.Err()
+	case <-s.stopped:
+		// 会话关闭前可能已经执行完毕
+		select {
+		case err := <-op.done:
+			return err
+		default:
+			return ErrSessionClosed
+		}
+	}
+}
+
+// Info 返回最近一次打开时的设备信息
+func (s *Session) Info() DongleInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.info
+}
+
+// ReadFile 读取文件
+func (s *Session) ReadFile(ctx context.Context, fileID uint16, offset int, buffer []byte) error {
+	return s.Do(ctx, FUNC_READFILE, func(b Backend, h DongleHandle) (uint32, error) {
+		return b.ReadFile(h, fileID, offset, buffer)
+	})
+}
+
+// ReadData 读取数据区
+func (s *Session) ReadData(ctx context.Context, offset int, buffer []byte) error {
+	return s.Do(ctx, FUNC_READDATA, func(b Backend, h DongleHandle) (uint32, error) {
+		return b.ReadData(h, offset, buffer)
+	})
+}
+
+// WriteData 写入数据区
+func (s *Session) WriteData(ctx context.Context, offset int, data []byte) error {
+	return s.Do(ctx, FUNC_WRITEDATA, func(b Backend, h DongleHandle) (uint32, error) {
+		return b.WriteData(h, offset, data)
+	})
+}
+
+// VerifyPIN 校验PIN，启用 RememberPIN 时成功后缓存以便重连后恢复
+func (s *Session) VerifyPIN(ctx context.Context, flags int, pin string) error {
+	return s.Do(ctx, FUNC_VERIFYPIN, func(b Backend, h DongleHandle) (uint32, error) {
+		_, retCode, err := b.VerifyPIN(h, flags, pin)
+		if err == nil && s.opts.RememberPIN {
+			s.mu.Lock()
+			s.pins[flags] = pin
+			s.mu.Unlock()
+		}
+		return retCode, err
+	})
+}
+
+// Seed 种子码运算
+func (s *Session) Seed(ctx context.Context, seedData []byte) ([]byte, error) {
+	var out []byte
+	err := s.Do(ctx, FUNC_SEED, func(b Backend, h DongleHandle) (uint32, error) {
+		result, retCode, err := b.Seed(h, seedData)
+		out = result
+		return retCode, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetDeadline 读取期限
+func (s *Session) GetDeadline(ctx context.Context) (uint32, error) {
+	var deadline uint32
+	err := s.Do(ctx, FUNC_GETDEADLINE, func(b Backend, h DongleHandle) (uint32, error) {
+		value, retCode, err := b.GetDeadline(h)
+		deadline = value
+		return retCode, err
+	})
+	return deadline, err
+}
+
+// GetUTCTime 读取锁内时钟
+func (s *Session) GetUTCTime(ctx context.Context) (time.Time, error) {
+	var utcTime uint32
+	err := s.Do(ctx, FUNC_GETUTCTIME, func(b Backend, h DongleHandle) (uint32, error) {
+		value, retCode, err := b.GetUTCTime(h)
+		utcTime = value
+		return retCode, err
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(utcTime), 0), nil
+}
+
+// GenRandom 生成硬件随机数
+func (s *Session) GenRandom(ctx context.Context, length int) ([]byte, error) {
+	var out []byte
+	err := s.Do(ctx, FUNC_GENRANDOM, func(b Backend, h DongleHandle) (uint32, error) {
+		result, retCode, err := b.GenRandom(h, length)
+		out = result
+		return retCode, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// EccSign 使用锁内 ECC 私钥签名
+func (s *Session) EccSign(ctx context.Context, fileID uint16, hash []byte) ([]byte, error) {
+	var out []byte
+	err := s.Do(ctx, FUNC_ECCSIGN, func(b Backend, h DongleHandle) (uint32, error) {
+		result, retCode, err := b.EccSign(h, fileID, hash)
+		out = result
+		return retCode, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Enum 在会话线程上枚举所有设备
+func (s *Session) Enum(ctx context.Context) ([]DongleInfo, error) {
+	var keyList []DongleInfo
+	err := s.Do(ctx, FUNC_ENUM, func(b Backend, h DongleHandle) (uint32, error) {
+		result, retCode, err := b.Enum()
+		keyList = result
+		return retCode, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keyList, nil
+}
+
+// Metrics 返回各操作统计的快照
+func (s *Session) Metrics() map[string]OpMetrics {
+	return s.stats.snapshot()
+}
+
+// Close 关闭会话，取消排队中的操作并关闭设备
+//
+// 有被放弃的原生调用尚未返回时不等待，句柄在调用返回后关闭。
+func (s *Session) Close() error {
+	s.closeOnce.Do(func() { close(s.quit) })
+	if s.Stalled() {
+		s.stop()
+		return nil
+	}
+	<-s.stopped
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingBackend 在 block 打开时让 ReadData 一直阻塞，模拟卡住的原生调用
+type blockingBackend struct {
+	Backend
+	block   chan struct{}
+	entered chan struct{}
+	calls   atomic.Int32
+}
+
+func (b *blockingBackend) ReadData(handle DongleHandle, offset int, buffer []byte) (uint32, error) {
+	if b.calls.Add(1) == 1 {
+		close(b.entered)
+		<-b.block
+	}
+	return b.Backend.ReadData(handle, offset, buffer)
+}
+
+// blockingOpenBackend 第二次 Open 一直阻塞到 block 关闭，模拟重新打开时卡住
+type blockingOpenBackend struct {
+	*simBackend
+	block   chan struct{}
+	entered chan struct{}
+	opens   atomic.Int32
+}
+
+func (b *blockingOpenBackend) Open(index int) (DongleHandle, uint32, error) {
+	if b.opens.Add(1) == 2 {
+		close(b.entered)
+		<-b.block
+	}
+	return b.simBackend.Open(index)
+}
+
+// TestSessionAbandonBlocksNewCalls 卡住的调用返回前不发起新的原生调用
+func TestSessionAbandonBlocksNewCalls(t *testing.T) {
+	backend := &blockingBackend{Backend: newSimBackend(1), block: make(chan struct{}), entered: make(chan struct{})}
+	s, err := OpenSession(context.Background(), backend, 0, SessionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	buffer := make([]byte, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.ReadData(ctx, 0, buffer) }()
+	<-backend.entered
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("ReadData = %v, 期望 context.Canceled", err)
+	}
+	if !s.Stalled() {
+		t.Fatal("放弃卡住的调用后 Stalled() 应为 true")
+	}
+
+	// 卡住期间的操作只排队，不进入动态库
+	short, cancelShort := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelShort()
+	if err := s.ReadData(short, 0, buffer); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("卡住期间 ReadData = %v, 期望 DeadlineExceeded", err)
+	}
+	if n := backend.calls.Load(); n != 1 {
+		t.Fatalf("卡住期间发起了 %d 次 ReadData", n)
+	}
+	if err := callNative(context.Background(), FUNC_READDATA, func() {}); err == nil {
+		t.Fatal("卡住期间 callNative 应直接失败")
+	}
+
+	// 卡住的调用返回后由新的工作 goroutine 接手
+	close(backend.block)
+	if err := s.ReadData(context.Background(), 0, buffer); err != nil {
+		t.Fatalf("恢复后 ReadData = %v", err)
+	}
+	if s.Stalled() {
+		t.Fatal("恢复后 Stalled() 应为 false")
+	}
+	if n := stuckCalls.Load(); n != 0 {
+		t.Fatalf("stuckCalls = %d", n)
+	}
+	if m := s.Metrics()[FUNC_READDATA]; m.TimedOut != 1 || m.Canceled != 1 {
+		t.Fatalf("TimedOut = %d, Canceled = %d", m.TimedOut, m.Canceled)
+	}
+}
+
+// TestSessionCloseWhileStalled 卡住时关闭会话不等待调用返回
+func TestSessionCloseWhileStalled(t *testing.T) {
+	backend := &blockingBackend{Backend: newSimBackend(1), block: make(chan struct{}), entered: make(chan struct{})}
+	s, err := OpenSession(context.Background(), backend, 0, SessionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.ReadData(ctx, 0, make([]byte, 16)) }()
+	<-backend.entered
+	cancel()
+	<-done
+
+	closed := make(chan struct{})
+	go func() {
+		s.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close 等待了卡住的调用")
+	}
+	if err := s.ReadData(context.Background(), 0, make([]byte, 16)); err != ErrSessionClosed {
+		t.Fatalf("关闭后 ReadData = %v", err)
+	}
+
+	close(backend.block)
+	deadline := time.Now().Add(time.Second)
+	for stuckCalls.Load() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := stuckCalls.Load(); n != 0 {
+		t.Fatalf("调用返回后 stuckCalls = %d", n)
+	}
+}
+
+// TestSessionAbandonStuckReopen 第一个操作触发的重新打开卡住时同样可以放弃
+func TestSessionAbandonStuckReopen(t *testing.T) {
+	backend := &blockingOpenBackend{simBackend: newSimBackend(1), block: make(chan struct{}), entered: make(chan struct{})}
+	s, err := OpenSession(context.Background(), backend, 0, SessionOptions{Reconnect: true, MaxAttempts: 1, BaseDelay: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// 拔出期间重连失败，工作 goroutine 不再持有句柄
+	buffer := make([]byte, 16)
+	backend.Unplug()
+	if err := s.ReadData(context.Background(), 0, buffer); err == nil {
+		t.Fatal("拔出后 ReadData 应失败")
+	}
+	backend.Replug()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.ReadData(ctx, 0, buffer) }()
+	<-backend.entered
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("ReadData = %v, 期望 context.Canceled", err)
+	}
+	if !s.Stalled() {
+		t.Fatal("放弃卡住的 Open 后 Stalled() 应为 true")
+	}
+
+	close(backend.block)
+	if err := s.ReadData(context.Background(), 0, buffer); err != nil {
+		t.Fatalf("恢复后 ReadData = %v", err)
+	}
+	if n := stuckCalls.Load(); n != 0 {
+		t.Fatalf("stuckCalls = %d", n)
+	}
+	if m := s.Metrics()[FUNC_READDATA]; m.TimedOut != 1 {
+		t.Fatalf("TimedOut = %d", m.TimedOut)
+	}
+	// 卡住的 Open 返回的句柄由被放弃的工作 goroutine 关闭
+	backend.mu.Lock()
+	handles := len(backend.handles)
+	backend.mu.Unlock()
+	if handles != 1 {
+		t.Fatalf("打开的句柄 %d 个, 期望 1", handles)
+	}
+}
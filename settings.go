@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ebitengine/purego"
+)
+
+// ============ 用户ID / 期限 / 种子码次数 ============
+
+// 期限取值
+const (
+	DEADLINE_NONE      = 0xFFFFFFFF // 取消期限限制
+	DEADLINE_MAX_HOURS = 65535      // 小于等于该值时表示可用小时数
+)
+
+// SEED_COUNT_UNLIMITED 种子码运算次数不限制
+const SEED_COUNT_UNLIMITED = -1
+
+// setUserID 设置用户ID，需要开发商权限
+func setUserID(setUserIDFunc uintptr, handle DongleHandle, userID uint32) (uint32, error) {
+	if handle == 0 {
+		return DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+
+	// 函数原型: DWORD Dongle_SetUserID(DONGLE_HANDLE hDongle, DWORD dwUserID)
+	type SetUserIDFuncType func(handle DongleHandle, userID uint32) uint32
+
+	var setUserIDFuncGo SetUserIDFuncType
+	purego.RegisterFunc(&setUserIDFuncGo, setUserIDFunc)
+
+	start := time.Now()
+	retCode := setUserIDFuncGo(handle, userID)
+	traceCall(FUNC_SETUSERID, handle, retCode, start)
+
+	if retCode != DONGLE_SUCCESS {
+		return retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+	return retCode, nil
+}
+
+// setDeadline 设置使用期限，需要开发商权限
+//
+// deadline 取值: 1-65535 表示可用小时数，更大的值表示截止时间（UTC秒），
+// DEADLINE_NONE 表示取消期限限制。
+func setDeadline(setDeadlineFunc uintptr, handle DongleHandle, deadline uint32) (uint32, error) {
+	if handle == 0 {
+		return DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+	if deadline == 0 {
+		return DONGLE_INVALID_PARAMETER, fmt.Errorf("%s: 期限不能为 0", getErrorDescription(DONGLE_INVALID_PARAMETER))
+	}
+
+	// 函数原型: DWORD Dongle_SetDeadline(DONGLE_HANDLE hDongle, DWORD dwTime)
+	type SetDeadlineFuncType func(handle DongleHandle, deadline uint32) uint32
+
+	var setDeadlineFuncGo SetDeadlineFuncType
+	purego.RegisterFunc(&setDeadlineFuncGo, setDeadlineFunc)
+
+	start := time.Now()
+	retCode := setDeadlineFuncGo(handle, deadline)
+	traceCall(FUNC_SETDEADLINE, handle, retCode, start)
+
+	if retCode != DONGLE_SUCCESS {
+		return retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+	return retCode, nil
+}
+
+// limitSeedCount 限制种子码运算次数，SEED_COUNT_UNLIMITED 表示不限制
+func limitSeedCount(limitSeedCountFunc uintptr, handle DongleHandle, count int) (uint32, error) {
+	if handle == 0 {
+		return DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+	if count < SEED_COUNT_UNLIMITED {
+		return DONGLE_INVALID_PARAMETER, fmt.Errorf("%s: 种子码次数 %d", getErrorDescription(DONGLE_INVALID_PARAMETER), count)
+	}
+
+	// 函数原型: DWORD Dongle_LimitSeedCount(DONGLE_HANDLE hDongle, int nCount)
+	type LimitSeedCountFuncType func(handle DongleHandle, count int32) uint32
+
+	var limitSeedCountFuncGo LimitSeedCountFuncType
+	purego.RegisterFunc(&limitSeedCountFuncGo, limitSeedCountFunc)
+
+	start := time.Now()
+	retCode := limitSeedCountFuncGo(handle, int32(count))
+	traceCall(FUNC_LIMITSEEDCOUNT, handle, retCode, start, "count", count)
+
+	if retCode != DONGLE_SUCCESS {
+		return retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+	return retCode, nil
+}
+
+// parseDeadline 解析期限配置
+//
+// 支持 "none"（取消期限）、"720h"（可用小时数）和 RFC3339 格式的截止时间。
+func parseDeadline(s string) (uint32, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "" || s == "none":
+		return DEADLINE_NONE, nil
+	case strings.HasSuffix(s, "h"):
+		hours, err := strconv.Atoi(strings.TrimSuffix(s, "h"))
+		if err != nil || hours < 1 || hours > DEADLINE_MAX_HOURS {
+			return 0, fmt.Errorf("无效的小时数: %s (范围 1-%d)", s, DEADLINE_MAX_HOURS)
+		}
+		return uint32(hours), nil
+	default:
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return 0, fmt.Errorf("无效的截止时间: %s (应为 RFC3339 格式)", s)
+		}
+		if t.Unix() <= DEADLINE_MAX_HOURS || t.Unix() >= DEADLINE_NONE {
+			return 0, fmt.Errorf("截止时间超出范围: %s", s)
+		}
+		return uint32(t.Unix()), nil
+	}
+}
+
+// getDeadline 读取使用期限，返回值含义同 setDeadline
+func getDeadline(getDeadlineFunc uintptr, handle DongleHandle) (uint32, uint32, error) {
+	if handle == 0 {
+		return 0, DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+
+	// 函数原型: DWORD Dongle_GetDeadline(DONGLE_HANDLE hDongle, DWORD* pdwTime)
+	type GetDeadlineFuncType func(handle DongleHandle, deadline *uint32) uint32
+
+	var getDeadlineFuncGo GetDeadlineFuncType
+	purego.RegisterFunc(&getDeadlineFuncGo, getDeadlineFunc)
+
+	var deadline uint32
+	start := time.Now()
+	retCode := getDeadlineFuncGo(handle, &deadline)
+	traceCall(FUNC_GETDEADLINE, handle, retCode, start)
+
+	if retCode != DONGLE_SUCCESS {
+		return 0, retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+	return deadline, retCode, nil
+}
+
+// getUTCTime 读取锁内时钟的 UTC 时间戳
+func getUTCTime(getUTCTimeFunc uintptr, handle DongleHandle) (uint32, uint32, error) {
+	if handle == 0 {
+		return 0, DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+
+	// 函数原型: DWORD Dongle_GetUTCTime(DONGLE_HANDLE hDongle, DWORD* pdwUTCTime)
+	type GetUTCTimeFuncType func(handle DongleHandle, utcTime *uint32) uint32
+
+	var getUTCTimeFuncGo GetUTCTimeFuncType
+	purego.RegisterFunc(&getUTCTimeFuncGo, getUTCTimeFunc)
+
+	var utcTime uint32
+	start := time.Now()
+	retCode := getUTCTimeFuncGo(handle, &utcTime)
+	traceCall(FUNC_GETUTCTIME, handle, retCode, start)
+
+	if retCode != DONGLE_SUCCESS {
+		return 0, retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+	return utcTime, retCode, nil
+}
+
+// deadlineRemaining 期限剩余时间，无期限时 ok 为 false
+//
+// 小时数形式的期限按剩余可用小时计算，时间戳形式以 now 为当前时间。
+func deadlineRemaining(deadline uint32, now time.Time) (time.Duration, bool) {
+	switch {
+	case deadline == DEADLINE_NONE:
+		return 0, false
+	case deadline <= DEADLINE_MAX_HOURS:
+		return time.Duration(deadline) * time.Hour, true
+	default:
+		return time.Unix(int64(deadline), 0).Sub(now), true
+	}
+}
+
+// formatDeadline 格式化期限值
+func formatDeadline(deadline uint32) string {
+	switch {
+	case deadline == DEADLINE_NONE:
+		return "none"
+	case deadline <= DEADLINE_MAX_HOURS:
+		return fmt.Sprintf("%dh", deadline)
+	default:
+		return time.Unix(int64(deadline), 0).UTC().Format(time.RFC3339)
+	}
+}
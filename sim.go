@@ -0,0 +1,350 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ============ 模拟后端 ============
+//
+// 在内存中模拟若干把加密锁，用于没有硬件时调试守护进程和客户端。
+// 行为只覆盖 Backend 接口，返回码与真实设备保持一致，但不模拟
+// 文件读写权限和锁内程序。
+
+// SIM_PIN_TRY_COUNT 模拟设备的 PIN 最大重试次数
+const SIM_PIN_TRY_COUNT = 15
+
+// simDevice 一把模拟加密锁
+type simDevice struct {
+	info     DongleInfo
+	data     []byte
+	files    map[uint16][]byte
+	eccKeys  map[uint16]*ecdsa.PrivateKey
+	deadline uint32
+	clock    time.Duration // 锁内时钟相对本机时钟的偏差
+
+	pins     [2]string // 用户PIN、开发商PIN
+	remain   [2]int    // 剩余重试次数
+	verified int       // 已校验的最高权限，-1 表示未校验
+}
+
+// simBackend 模拟后端
+type simBackend struct {
+	mu      sync.Mutex
+	devices []*simDevice
+	handles map[DongleHandle]*simDevice
+	next    DongleHandle
+	removed bool // 模拟设备已被拔出
+}
+
+// newSimBackend 创建包含 count 把模拟锁的后端
+//
+// 每把锁的硬件ID由序号确定，多次运行结果一致；种子码结果由产品ID确定，
+// 与真实设备一样同一产品的锁结果相同。签名私钥在首次使用时随机生成。
+func newSimBackend(count int) *simBackend {
+	b := &simBackend{handles: make(map[DongleHandle]*simDevice), next: 1}
+	for i := 0; i < count; i++ {
+		d := &simDevice{
+			data:     make([]byte, DATA_ZONE_SIZE),
+			files:    make(map[uint16][]byte),
+			eccKeys:  make(map[uint16]*ecdsa.PrivateKey),
+			deadline: DEADLINE_NONE,
+			pins:     [2]string{DEFAULT_USER_PIN, DEFAULT_ADMIN_PIN},
+			remain:   [2]int{SIM_PIN_TRY_COUNT, SIM_PIN_TRY_COUNT},
+			verified: -1,
+		}
+		d.info.MVer = 0x0100
+		d.info.MPID = 0xFFFFFFFF
+		copy(d.info.MBirthDay[:], "20240101")
+		binary.BigEndian.PutUint64(d.info.MHID[:], 0x53494D0000000000|uint64(i+1))
+
+		// 模拟出厂时写入的示例文件
+		d.files[TEST_FILE_ID] = []byte(fmt.Sprintf("simulated dongle %d\n", i))
+		b.devices = append(b.devices, d)
+	}
+	return b
+}
+
+// simSeedKey 模拟种子密钥，由产品ID确定
+func simSeedKey(pid uint32) []byte {
+	key := sha256.Sum256([]byte(fmt.Sprintf("rockey-sim-seed-%08X", pid)))
+	return key[:]
+}
+
+// Reset 模拟 USB 复位，所有已打开的句柄失效
+func (b *simBackend) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dropHandles()
+}
+
+// Unplug 模拟拔出所有设备，已打开的句柄失效，重新插入前枚举和打开都找不到设备
+func (b *simBackend) Unplug() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.removed = true
+	b.dropHandles()
+}
+
+// Replug 模拟重新插入设备，需要重新打开才能使用
+func (b *simBackend) Replug() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.removed = false
+}
+
+// dropHandles 使所有句柄失效并清除PIN校验状态，调用方持有锁
+func (b *simBackend) dropHandles() {
+	for h, d := range b.handles {
+		d.verified = -1
+		delete(b.handles, h)
+	}
+}
+
+// SetFile 直接在所有模拟设备上创建或覆盖数据文件，用于准备调试数据
+func (b *simBackend) SetFile(fileID uint16, content []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, d := range b.devices {
+		d.files[fileID] = append([]byte(nil), content...)
+	}
+}
+
+// device 查找句柄对应的设备，调用方持有锁
+func (b *simBackend) device(handle DongleHandle) (*simDevice, uint32, error) {
+	d, ok := b.handles[handle]
+	if !ok {
+		return nil, DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+	return d, DONGLE_SUCCESS, nil
+}
+
+// simError 构造模拟设备的错误返回
+func simError(retCode uint32) (uint32, error) {
+	return retCode, fmt.Errorf(getErrorDescription(retCode))
+}
+
+func (b *simBackend) Enum() ([]DongleInfo, uint32, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.devices) == 0 || b.removed {
+		retCode, err := simError(DONGLE_NOT_FOUND)
+		return nil, retCode, err
+	}
+	keyList := make([]DongleInfo, len(b.devices))
+	for i, d := range b.devices {
+		keyList[i] = d.info
+	}
+	return keyList, DONGLE_SUCCESS, nil
+}
+
+func (b *simBackend) Open(index int) (DongleHandle, uint32, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if index < 0 || index >= len(b.devices) || b.removed {
+		retCode, err := simError(DONGLE_NOT_FOUND)
+		return 0, retCode, err
+	}
+	h := b.next
+	b.next++
+	b.handles[h] = b.devices[index]
+	return h, DONGLE_SUCCESS, nil
+}
+
+func (b *simBackend) Close(handle DongleHandle) (uint32, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, retCode, err := b.device(handle); err != nil {
+		return retCode, err
+	}
+	delete(b.handles, handle)
+	return DONGLE_SUCCESS, nil
+}
+
+func (b *simBackend) ReadFile(handle DongleHandle, fileID uint16, offset int, buffer []byte) (uint32, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	d, retCode, err := b.device(handle)
+	if err != nil {
+		return retCode, err
+	}
+	content, ok := d.files[fileID]
+	if !ok {
+		return simError(DONGLE_INVALID_FILEID)
+	}
+	if offset < 0 || offset+len(buffer) > len(content) {
+		return simError(DONGLE_INVALID_OFFSET)
+	}
+	copy(buffer, content[offset:])
+	return DONGLE_SUCCESS, nil
+}
+
+func (b *simBackend) ReadData(handle DongleHandle, offset int, buffer []byte) (uint32, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	d, retCode, err := b.device(handle)
+	if err != nil {
+		return retCode, err
+	}
+	if retCode, err := checkRegion(int64(offset), len(buffer), len(d.data)); err != nil {
+		return retCode, err
+	}
+	copy(buffer, d.data[offset:])
+	return DONGLE_SUCCESS, nil
+}
+
+func (b *simBackend) WriteData(handle DongleHandle, offset int, data []byte) (uint32, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	d, retCode, err := b.device(handle)
+	if err != nil {
+		return retCode, err
+	}
+	if retCode, err := checkRegion(int64(offset), len(data), len(d.data)); err != nil {
+		return retCode, err
+	}
+	// 前半部分需要用户权限，后半部分需要开发商权限
+	need := FLAG_USERPIN
+	if offset+len(data) > DATA_ZONE_USER_SIZE {
+		need = FLAG_ADMINPIN
+	}
+	if d.verified < need {
+		return simError(DONGLE_ACCESS_DENIED)
+	}
+	copy(d.data[offset:], data)
+	return DONGLE_SUCCESS, nil
+}
+
+func (b *simBackend) VerifyPIN(handle DongleHandle, flags int, pin string) (int, uint32, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	d, retCode, err := b.device(handle)
+	if err != nil {
+		return 0, retCode, err
+	}
+	if flags != FLAG_USERPIN && flags != FLAG_ADMINPIN {
+		retCode, err := simError(DONGLE_INVALID_PARAMETER)
+		return 0, retCode, err
+	}
+	if d.remain[flags] <= 0 {
+		retCode, err := simError(DONGLE_ACCESS_DENIED)
+		return 0, retCode, err
+	}
+	if pin != d.pins[flags] {
+		d.remain[flags]--
+		retCode, err := simError(DONGLE_INVALID_PASSWORD)
+		return d.remain[flags], retCode, err
+	}
+	d.remain[flags] = SIM_PIN_TRY_COUNT
+	if flags > d.verified {
+		d.verified = flags
+	}
+	return d.remain[flags], DONGLE_SUCCESS, nil
+}
+
+func (b *simBackend) Seed(handle DongleHandle, seedData []byte) ([]byte, uint32, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	d, retCode, err := b.device(handle)
+	if err != nil {
+		return nil, retCode, err
+	}
+	if len(seedData) == 0 || len(seedData) > SEED_MAX_LEN {
+		retCode, err := simError(DONGLE_INVALID_SIZE)
+		return nil, retCode, err
+	}
+	mac := hmac.New(sha256.New, simSeedKey(d.info.MPID))
+	mac.Write(seedData)
+	return mac.Sum(nil)[:SEED_OUTPUT_LEN], DONGLE_SUCCESS, nil
+}
+
+func (b *simBackend) GetDeadline(handle DongleHandle) (uint32, uint32, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	d, retCode, err := b.device(handle)
+	if err != nil {
+		return 0, retCode, err
+	}
+	return d.deadline, DONGLE_SUCCESS, nil
+}
+
+func (b *simBackend) GetUTCTime(handle DongleHandle) (uint32, uint32, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	d, retCode, err := b.device(handle)
+	if err != nil {
+		return 0, retCode, err
+	}
+	return uint32(time.Now().Add(d.clock).Unix()), DONGLE_SUCCESS, nil
+}
+
+func (b *simBackend) GenRandom(handle DongleHandle, length int) ([]byte, uint32, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, retCode, err := b.device(handle); err != nil {
+		return nil, retCode, err
+	}
+	if length <= 0 || length > RANDOM_MAX_LEN {
+		retCode, err := simError(DONGLE_INVALID_SIZE)
+		return nil, retCode, err
+	}
+	out := make([]byte, length)
+	if _, err := rand.Read(out); err != nil {
+		return nil, DONGLE_UNKNOWN_ERROR, err
+	}
+	return out, DONGLE_SUCCESS, nil
+}
+
+func (b *simBackend) EccSign(handle DongleHandle, fileID uint16, hash []byte) ([]byte, uint32, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	d, retCode, err := b.device(handle)
+	if err != nil {
+		return nil, retCode, err
+	}
+	if d.verified < FLAG_USERPIN {
+		retCode, err := simError(DONGLE_ACCESS_DENIED)
+		return nil, retCode, err
+	}
+	if len(hash) == 0 || len(hash) > ECC_HASH_MAX_LEN {
+		retCode, err := simError(DONGLE_INVALID_SIZE)
+		return nil, retCode, err
+	}
+
+	key, ok := d.eccKeys[fileID]
+	if !ok {
+		// 私钥文件在第一次使用时生成
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, DONGLE_UNKNOWN_ERROR, err
+		}
+		d.eccKeys[fileID] = key
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash)
+	if err != nil {
+		return nil, DONGLE_UNKNOWN_ERROR, err
+	}
+	out := make([]byte, ECC_SIGNATURE_SIZE)
+	r.FillBytes(out[:ECC_SIGNATURE_SIZE/2])
+	s.FillBytes(out[ECC_SIGNATURE_SIZE/2:])
+	return out, DONGLE_SUCCESS, nil
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// ============ 长时间压力测试 ============
+//
+// 现场故障往往在连续运行数天后才出现，单次 -test 无法复现。soak 命令按配置
+// 启动多个并发工作者，各自通过一个会话打开设备并循环执行读文件、随机数、
+// 种子码运算，统计各调用的耗时分位数和返回码，并定期采样进程常驻内存和文件
+// 描述符数量，用于发现句柄和内存泄漏。使用模拟后端时可以定期模拟拔出/插入
+// 设备，检查工作者能否自动恢复。
+//
+// 所有调用都经过 Session，与 serve 等长期运行的命令走同一条路径。种子码运算
+// 会消耗真实加密锁的运算次数，只有模拟后端默认启用 seed 负载。
+
+// 压力测试默认值
+const (
+	SOAK_SAMPLE_SIZE     = 4096                     // 每个调用保留的耗时样本数
+	SOAK_REOPEN_INTERVAL = 500 * time.Millisecond   // 重新打开设备的间隔
+	SOAK_SEED            = "rockey-soak"            // 种子码运算使用的种子
+	SOAK_RANDOM_LEN      = 16                       // 每次生成的随机数长度
+	SOAK_READ_SIZE       = 16                       // read 负载默认读取的字节数，模拟设备的示例文件较短
+	SOAK_WORKERS         = "read=2,random=1"        // 默认负载
+	SOAK_SIM_WORKERS     = "read=2,random=1,seed=1" // 模拟后端的默认负载
+)
+
+// soakWorkloads 支持的负载类型
+var soakWorkloads = []string{"read", "random", "seed"}
+
+// soakOpStats 一种调用的统计
+type soakOpStats struct {
+	calls    uint64
+	errors   uint64
+	expected uint64            // 设备被拔出期间的失败，不计入错误率
+	retCodes map[uint32]uint64 // 各返回码出现次数
+	samples  []time.Duration   // 耗时样本 (蓄水池抽样)
+	max      time.Duration
+}
+
+// soakStats 所有调用的统计
+type soakStats struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+	ops map[string]*soakOpStats
+}
+
+// observe 记录一次调用
+func (s *soakStats) observe(name string, elapsed time.Duration, retCode uint32, err error, expected bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	op, ok := s.ops[name]
+	if !ok {
+		op = &soakOpStats{retCodes: make(map[uint32]uint64)}
+		s.ops[name] = op
+	}
+	op.calls++
+	op.retCodes[errorCode(retCode, err)]++
+	if err != nil {
+		if expected {
+			op.expected++
+		} else {
+			op.errors++
+		}
+	}
+	if elapsed > op.max {
+		op.max = elapsed
+	}
+	// 运行数天时不能保留全部耗时，按蓄水池抽样保留固定数量的样本
+	if len(op.samples) < SOAK_SAMPLE_SIZE {
+		op.samples = append(op.samples, elapsed)
+	} else if i := s.rnd.Int63n(int64(op.calls)); i < SOAK_SAMPLE_SIZE {
+		op.samples[i] = elapsed
+	}
+}
+
+// totals 返回总调用次数和意外失败次数
+func (s *soakStats) totals() (uint64, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var calls, failures uint64
+	for _, op := range s.ops {
+		calls += op.calls
+		failures += op.errors
+	}
+	return calls, failures
+}
+
+// percentile 返回已排序样本的 p 分位数
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(p*float64(len(sorted)-1)+0.5)]
+}
+
+// parseSoakWorkers 解析 "read=2,random=1,seed=1" 形式的负载配置
+func parseSoakWorkers(spec string) (map[string]int, error) {
+	workers := make(map[string]int)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, count, ok := strings.Cut(part, "=")
+		if !ok {
+			count = "1"
+		}
+		known := false
+		for _, w := range soakWorkloads {
+			known = known || w == name
+		}
+		if !known {
+			return nil, fmt.Errorf("未知负载: %s (可选 %s)", name, strings.Join(soakWorkloads, "、"))
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("无效的工作者数量: %s", part)
+		}
+		workers[name] += n
+	}
+	if len(workers) == 0 {
+		return nil, fmt.Errorf("没有配置任何负载")
+	}
+	return workers, nil
+}
+
+// ============ 执行 ============
+
+// soakOptions 压力测试选项
+type soakOptions struct {
+	device     int
+	iterations int           // 每个工作者的调用次数，0 表示不限
+	interval   time.Duration // 每个工作者两次调用之间的间隔
+	fileID     uint16
+	readSize   int
+	replug     time.Duration // 模拟拔插的间隔，0 表示不模拟
+	replugDown time.Duration // 模拟拔出的持续时间
+}
+
+// soakRun 一次压力测试的运行状态
+type soakRun struct {
+	backend Backend
+	opts    soakOptions
+	stats   *soakStats
+
+	removed    atomic.Bool   // 模拟设备处于拔出状态
+	generation atomic.Uint64 // 模拟拔出的次数
+	stuck      atomic.Int64  // 调用未返回而被放弃的工作者数量
+	seedMu     sync.Mutex
+	seedResult []byte // 第一次种子码运算的结果，之后的结果必须相同
+	mismatches atomic.Int64
+}
+
+// call 以全局超时执行一次会话调用并记录统计，调用卡住时返回 false
+//
+// 测试结束不会打断进行中的调用。会话在上次模拟拔出之前打开的，失败属于预期。
+func (s *soakRun) call(name string, gen uint64, session *Session, fn func(ctx context.Context) error) (error, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), *callTimeout)
+	defer cancel()
+	start := time.Now()
+	err := fn(ctx)
+	elapsed := time.Since(start)
+	// 打开时超时或会话中有被放弃的原生调用
+	if errors.Is(err, context.DeadlineExceeded) && (session == nil || session.Stalled()) {
+		s.stats.observe(name, elapsed, DONGLE_UNKNOWN_ERROR, err, false)
+		return err, false
+	}
+	retCode := uint32(DONGLE_SUCCESS)
+	var dongleErr *DongleError
+	if errors.As(err, &dongleErr) {
+		retCode = dongleErr.RetCode
+	}
+	expected := s.removed.Load() || gen != s.generation.Load()
+	s.stats.observe(name, elapsed, retCode, err, expected)
+	return err, true
+}
+
+// open 打开会话，失败时按固定间隔重试直到成功或测试结束
+//
+// 会话不自动重连，失败由工作者关闭会话后重新打开，这样每次失败都计入统计。
+func (s *soakRun) open(ctx context.Context) (*Session, uint64, bool) {
+	for {
+		gen := s.generation.Load()
+		var session *Session
+		err, ok := s.call(FUNC_OPEN, gen, nil, func(callCtx context.Context) error {
+			var err error
+			session, err = OpenSession(callCtx, s.backend, s.opts.device, SessionOptions{})
+			return err
+		})
+		if !ok {
+			s.stuck.Add(1)
+			return nil, 0, false
+		}
+		if err == nil {
+			return session, gen, true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, 0, false
+		case <-time.After(SOAK_REOPEN_INTERVAL):
+		}
+	}
+}
+
+// close 关闭会话并记录统计
+func (s *soakRun) close(session *Session, gen uint64) {
+	s.call(FUNC_CLOSE, gen, session, func(context.Context) error { return session.Close() })
+}
+
+// operation 返回负载对应的调用名和调用函数
+func (s *soakRun) operation(workload string) (string, func(ctx context.Context, session *Session) error) {
+	switch workload {
+	case "random":
+		return FUNC_GENRANDOM, func(ctx context.Context, session *Session) error {
+			_, err := session.GenRandom(ctx, SOAK_RANDOM_LEN)
+			return err
+		}
+	case "seed":
+		return FUNC_SEED, func(ctx context.Context, session *Session) error {
+			out, err := session.Seed(ctx, []byte(SOAK_SEED))
+			if err == nil {
+				s.checkSeed(out)
+			}
+			return err
+		}
+	default:
+		buffer := make([]byte, s.opts.readSize)
+		return FUNC_READFILE, func(ctx context.Context, session *Session) error {
+			return session.ReadFile(ctx, s.opts.fileID, 0, buffer)
+		}
+	}
+}
+
+// checkSeed 同一把锁对同一种子码的运算结果必须一致
+func (s *soakRun) checkSeed(out []byte) {
+	s.seedMu.Lock()
+	defer s.seedMu.Unlock()
+	if s.seedResult == nil {
+		s.seedResult = append([]byte(nil), out...)
+	} else if !bytes.Equal(out, s.seedResult) {
+		s.mismatches.Add(1)
+	}
+}
+
+// worker 循环执行一种负载，失败后关闭会话并重新打开
+func (s *soakRun) worker(ctx context.Context, workload string) {
+	name, op := s.operation(workload)
+	session, gen, ok := s.open(ctx)
+	if !ok {
+		return
+	}
+	for i := 0; s.opts.iterations == 0 || i < s.opts.iterations; i++ {
+		if ctx.Err() != nil {
+			break
+		}
+		err, ok := s.call(name, gen, session, func(callCtx context.Context) error { return op(callCtx, session) })
+		if !ok {
+			// 卡住的调用返回后会话才关闭句柄，放弃这个工作者
+			s.stuck.Add(1)
+			session.Close()
+			return
+		}
+		if err != nil {
+			s.close(session, gen)
+			if session, gen, ok = s.open(ctx); !ok {
+				return
+			}
+		}
+		if s.opts.interval > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(s.opts.interval):
+			}
+		}
+	}
+	s.close(session, gen)
+}
+
+// start 按负载配置启动工作者，全部结束后关闭返回的通道
+func (s *soakRun) start(ctx context.Context, workers map[string]int) <-chan struct{} {
+	var wg sync.WaitGroup
+	for _, workload := range soakWorkloads {
+		for i := 0; i < workers[workload]; i++ {
+			wg.Add(1)
+			go func(workload string) {
+				defer wg.Done()
+				s.worker(ctx, workload)
+			}(workload)
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// replugLoop 定期模拟拔出和插入模拟设备
+func (s *soakRun) replugLoop(ctx context.Context, sim *simBackend) {
+	ticker := time.NewTicker(s.opts.replug)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// 先拔出再增加计数，拔出前打开的句柄一定属于旧的计数
+		s.removed.Store(true)
+		sim.Unplug()
+		s.generation.Add(1)
+		logger.Info("模拟拔出设备", "count", s.generation.Load())
+		select {
+		case <-ctx.Done():
+		case <-time.After(s.opts.replugDown):
+		}
+		sim.Replug()
+		s.removed.Store(false)
+		logger.Info("模拟插入设备")
+	}
+}
+
+// soakUsage 进程资源采样
+type soakUsage struct {
+	rss int64
+	fds int
+}
+
+// ============ 命令 ============
+
+// runSoakCommand 按配置执行长时间并发压力测试并输出通过/失败结论
+func runSoakCommand(args []string) error {
+	fs := flag.NewFlagSet("soak", flag.ContinueOnError)
+	backendName := fs.String("backend", "native", "后端: native、helper、hid 或 sim")
+	simCount := fs.Int("sim-count", 1, "模拟后端的设备数量")
+	device := fs.Int("device", 0, "设备序号")
+	workersSpec := fs.String("workers", "", "各负载的并发工作者数量，负载可选 read、random、seed (默认 "+SOAK_WORKERS+"，模拟后端为 "+SOAK_SIM_WORKERS+")")
+	duration := fs.Duration("duration", time.Hour, "测试时长，0 表示不限 (需要 -iterations 或 Ctrl+C 结束)")
+	iterations := fs.Int("iterations", 0, "每个工作者的调用次数，0 表示不限")
+	interval := fs.Duration("interval", 0, "每个工作者两次调用之间的间隔")
+	fileID := fs.String("file-id", fmt.Sprintf("0x%04X", TEST_FILE_ID), "read 负载读取的文件ID")
+	readSize := fs.Int("read-size", SOAK_READ_SIZE, "read 负载每次读取的字节数")
+	report := fs.Duration("report", time.Minute, "输出进度和采样内存的间隔")
+	replug := fs.Duration("replug", 0, "模拟拔插的间隔，仅模拟后端，0 表示不模拟")
+	replugDown := fs.Duration("replug-down", 2*time.Second, "每次模拟拔出的持续时间")
+	maxErrorRate := fs.Float64("max-error-rate", 0, "允许的意外失败比例 (0-1)")
+	maxRSSGrowth := fs.Int64("max-rss-growth", 64, "允许的常驻内存增长 (MiB)")
+	maxFDGrowth := fs.Int("max-fd-growth", 0, "允许的文件描述符增长")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *workersSpec == "" {
+		*workersSpec = SOAK_WORKERS
+		if *backendName == "sim" {
+			*workersSpec = SOAK_SIM_WORKERS
+		}
+	}
+	workers, err := parseSoakWorkers(*workersSpec)
+	if err != nil {
+		return err
+	}
+	if workers["seed"] > 0 && *backendName != "sim" {
+		logger.Warn("seed 负载会反复执行种子码运算，设置了运算次数限制的加密锁会被耗尽", "workers", workers["seed"])
+	}
+	id, err := parseFileID(*fileID)
+	if err != nil {
+		return err
+	}
+	if *duration == 0 && *iterations == 0 {
+		fmt.Println("未设置 -duration 和 -iterations，按 Ctrl+C 结束测试")
+	}
+	if *report <= 0 {
+		return fmt.Errorf("-report 必须大于 0")
+	}
+
+	backend, err := openBackend(*backendName, *simCount, 0)
+	if err != nil {
+		return err
+	}
+	if u, ok := backend.(interface{ Unload() }); ok {
+		defer u.Unload()
+	}
+	sim, isSim := backend.(*simBackend)
+	if *replug > 0 && !isSim {
+		return fmt.Errorf("-replug 只能用于模拟后端 (-backend sim)")
+	}
+
+	s := &soakRun{
+		backend: backend,
+		opts: soakOptions{
+			device:     *device,
+			iterations: *iterations,
+			interval:   *interval,
+			fileID:     id,
+			readSize:   *readSize,
+			replug:     *replug,
+			replugDown: *replugDown,
+		},
+		stats: &soakStats{rnd: rand.New(rand.NewSource(time.Now().UnixNano())), ops: make(map[string]*soakOpStats)},
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	// 打开设备前的文件描述符数量作为基线，内存以第一次采样为基线
+	var samples []soakUsage
+	baseRSS, baseFDs, usageErr := processUsage()
+	if usageErr != nil {
+		logger.Warn("无法统计进程资源，跳过泄漏检查", "err", usageErr)
+	}
+
+	fmt.Printf("压力测试: 后端 %s, 设备 %d, 负载 %s, 时长 %v\n", *backendName, *device, *workersSpec, *duration)
+	started := time.Now()
+	done := s.start(ctx, workers)
+	if isSim && *replug > 0 {
+		go s.replugLoop(ctx, sim)
+	}
+
+	ticker := time.NewTicker(*report)
+	defer ticker.Stop()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		case <-ticker.C:
+			calls, failures := s.stats.totals()
+			line := fmt.Sprintf("[%v] 调用 %d, 意外失败 %d", time.Since(started).Round(time.Second), calls, failures)
+			if rss, fds, err := processUsage(); err == nil {
+				samples = append(samples, soakUsage{rss, fds})
+				line += fmt.Sprintf(", 内存 %.1f MiB, 文件描述符 %d", float64(rss)/(1<<20), fds)
+			}
+			fmt.Println(line)
+		}
+	}
+	stop()
+	elapsed := time.Since(started)
+
+	// ---- 汇总 ----
+	fmt.Printf("\n运行时间 %v\n\n", elapsed.Round(time.Millisecond))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "调用\t次数\t意外失败\t拔出期间失败\tp50\tp90\tp99\t最大\t返回码")
+	var failures []string
+	var names []string
+	s.stats.mu.Lock()
+	for name := range s.stats.ops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var calls, errCount uint64
+	for _, name := range names {
+		op := s.stats.ops[name]
+		calls += op.calls
+		errCount += op.errors
+		sorted := append([]time.Duration(nil), op.samples...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		var codes []string
+		for code, n := range op.retCodes {
+			codes = append(codes, fmt.Sprintf("%08X×%d", code, n))
+		}
+		sort.Strings(codes)
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%v\t%v\t%v\t%v\t%s\n", name, op.calls, op.errors, op.expected,
+			percentile(sorted, 0.50), percentile(sorted, 0.90), percentile(sorted, 0.99), op.max, strings.Join(codes, " "))
+	}
+	s.stats.mu.Unlock()
+	w.Flush()
+
+	if calls == 0 {
+		failures = append(failures, "没有完成任何调用")
+	} else if rate := float64(errCount) / float64(calls); rate > *maxErrorRate {
+		failures = append(failures, fmt.Sprintf("意外失败比例 %.4f%% 超过 %.4f%%", rate*100, *maxErrorRate*100))
+	}
+	if n := s.stuck.Load(); n > 0 {
+		failures = append(failures, fmt.Sprintf("%d 个工作者的调用在 %v 内未返回", n, *callTimeout))
+	}
+	if n := s.mismatches.Load(); n > 0 {
+		failures = append(failures, fmt.Sprintf("种子码运算结果不一致 %d 次", n))
+	}
+	if isSim && *replug > 0 {
+		fmt.Printf("\n模拟拔插 %d 次\n", s.generation.Load())
+	}
+
+	if usageErr == nil && s.stuck.Load() == 0 {
+		rss, fds, err := processUsage()
+		if err == nil {
+			// 文件描述符在所有句柄关闭后与打开前比较
+			fmt.Printf("\n文件描述符: 开始 %d, 结束 %d\n", baseFDs, fds)
+			if fds-baseFDs > *maxFDGrowth {
+				failures = append(failures, fmt.Sprintf("文件描述符增加 %d，可能有句柄泄漏", fds-baseFDs))
+			}
+			// 常驻内存在第一次采样前会随运行时初始化增长，有采样时以第一次采样为基线
+			if len(samples) > 0 {
+				baseRSS = samples[0].rss
+			}
+			fmt.Printf("常驻内存: 基线 %.1f MiB, 结束 %.1f MiB\n", float64(baseRSS)/(1<<20), float64(rss)/(1<<20))
+			if growth := rss - baseRSS; growth > *maxRSSGrowth<<20 {
+				failures = append(failures, fmt.Sprintf("常驻内存增长 %.1f MiB，可能有内存泄漏", float64(growth)/(1<<20)))
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		fmt.Println("\n结论: 失败")
+		for _, f := range failures {
+			fmt.Printf("  - %s\n", f)
+		}
+		return fmt.Errorf("压力测试未通过")
+	}
+	fmt.Println("\n结论: 通过")
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// processUsage 读取本进程的常驻内存 (字节) 和打开的文件描述符数量
+func processUsage() (int64, int, error) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var rss int64 = -1
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// VmRSS:	   12345 kB
+		if value, ok := strings.CutPrefix(scanner.Text(), "VmRSS:"); ok {
+			kb, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimSpace(value), " kB"), 10, 64)
+			if err != nil {
+				return 0, 0, fmt.Errorf("无法解析 VmRSS: %q", value)
+			}
+			rss = kb * 1024
+		}
+	}
+	if rss < 0 {
+		return 0, 0, fmt.Errorf("/proc/self/status 中没有 VmRSS")
+	}
+
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, 0, err
+	}
+	// 读取目录本身占用一个描述符
+	return rss, len(entries) - 1, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// processUsage 非 Linux 平台不统计内存和文件描述符
+func processUsage() (int64, int, error) {
+	return 0, 0, fmt.Errorf("%s 平台不支持统计进程资源", runtime.GOOS)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serialBackend 检查同一句柄上的调用是否重叠的模拟后端
+type serialBackend struct {
+	*simBackend
+	mu       sync.Mutex
+	busy     map[DongleHandle]bool
+	overlaps atomic.Int64
+}
+
+// enter 标记句柄开始调用，返回结束时调用的函数
+func (b *serialBackend) enter(handle DongleHandle) func() {
+	b.mu.Lock()
+	if b.busy[handle] {
+		b.overlaps.Add(1)
+	}
+	b.busy[handle] = true
+	b.mu.Unlock()
+	time.Sleep(100 * time.Microsecond)
+	return func() {
+		b.mu.Lock()
+		delete(b.busy, handle)
+		b.mu.Unlock()
+	}
+}
+
+func (b *serialBackend) ReadFile(handle DongleHandle, fileID uint16, offset int, buffer []byte) (uint32, error) {
+	defer b.enter(handle)()
+	return b.simBackend.ReadFile(handle, fileID, offset, buffer)
+}
+
+func (b *serialBackend) GenRandom(handle DongleHandle, length int) ([]byte, uint32, error) {
+	defer b.enter(handle)()
+	return b.simBackend.GenRandom(handle, length)
+}
+
+func (b *serialBackend) Seed(handle DongleHandle, seedData []byte) ([]byte, uint32, error) {
+	defer b.enter(handle)()
+	return b.simBackend.Seed(handle, seedData)
+}
+
+func newTestSoakRun(backend Backend, opts soakOptions) *soakRun {
+	if opts.fileID == 0 {
+		opts.fileID = TEST_FILE_ID
+	}
+	if opts.readSize == 0 {
+		opts.readSize = SOAK_READ_SIZE
+	}
+	return &soakRun{
+		backend: backend,
+		opts:    opts,
+		stats:   &soakStats{rnd: rand.New(rand.NewSource(1)), ops: make(map[string]*soakOpStats)},
+	}
+}
+
+func TestSoakSessions(t *testing.T) {
+	sim := newSimBackend(1)
+	backend := &serialBackend{simBackend: sim, busy: make(map[DongleHandle]bool)}
+	s := newTestSoakRun(backend, soakOptions{iterations: 50})
+
+	workers, err := parseSoakWorkers(SOAK_SIM_WORKERS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-s.start(context.Background(), workers)
+
+	calls, failures := s.stats.totals()
+	if want := uint64(50 * 4); calls < want || failures != 0 {
+		t.Errorf("调用 %d 次 (期望至少 %d), 意外失败 %d", calls, want, failures)
+	}
+	if n := backend.overlaps.Load(); n != 0 {
+		t.Errorf("同一句柄上有 %d 次并发调用", n)
+	}
+	if s.stuck.Load() != 0 || s.mismatches.Load() != 0 {
+		t.Errorf("卡住 %d, 种子码不一致 %d", s.stuck.Load(), s.mismatches.Load())
+	}
+	if op := s.stats.ops[FUNC_SEED]; op == nil || op.calls != 50 {
+		t.Errorf("种子码运算统计 %+v", op)
+	}
+	if n := len(sim.handles); n != 0 {
+		t.Errorf("测试结束后还有 %d 个句柄未关闭", n)
+	}
+}
+
+func TestSoakReplug(t *testing.T) {
+	sim := newSimBackend(1)
+	s := newTestSoakRun(sim, soakOptions{
+		interval:   time.Millisecond,
+		replug:     50 * time.Millisecond,
+		replugDown: 20 * time.Millisecond,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 400*time.Millisecond)
+	defer cancel()
+	done := s.start(ctx, map[string]int{"read": 2, "random": 1})
+	go s.replugLoop(ctx, sim)
+	<-done
+
+	if s.generation.Load() == 0 {
+		t.Fatal("没有模拟拔出")
+	}
+	var expected uint64
+	for name, op := range s.stats.ops {
+		if op.errors != 0 {
+			t.Errorf("%s 意外失败 %d 次, 返回码 %v", name, op.errors, op.retCodes)
+		}
+		expected += op.expected
+	}
+	if expected == 0 {
+		t.Error("拔出期间没有失败，工作者没有经历拔插")
+	}
+	if s.stuck.Load() != 0 {
+		t.Errorf("%d 个工作者卡住", s.stuck.Load())
+	}
+}
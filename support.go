@@ -0,0 +1,543 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============ 支持包 ============
+//
+// 诊断失败时把支持团队需要的信息一次性打包为 tar.gz：诊断结果、动态库
+// ELF 分析、sysfs 中的 USB 信息、脱敏后的环境信息、内核日志、程序版本和
+// 一次 枚举/打开/关闭 的调用跟踪。
+//
+// 开启 -hash-ids 后，序列号、硬件ID、主机名等可以识别具体设备或主机的标识
+// 在输出它们的字段中替换为等长的 HMAC-SHA256 十六进制前缀，不对文件内容做
+// 全局替换。HMAC 密钥每个支持包随机生成且不保存：同一支持包内同一设备的
+// 替换值一致，不同支持包之间无法关联，也无法通过枚举硬件ID 反查。跟踪文件中
+// 的硬件ID 同样替换，仍然可以回放。
+//
+// 环境变量按白名单输出，其余只保留名称。
+
+// 支持包参数
+const (
+	SUPPORT_DMESG_LINES = 200       // 内核日志行数
+	SUPPORT_SYSFS_MAX   = 64 * 1024 // 单个 sysfs 属性的最大长度
+	SUPPORT_KEY_SIZE    = 32        // -hash-ids 的 HMAC 密钥长度
+)
+
+// supportEnvAllowed 输出值的环境变量，影响动态库加载、区域设置和 Go 运行时
+var supportEnvAllowed = map[string]bool{
+	"PATH":            true,
+	"LD_LIBRARY_PATH": true,
+	"LD_PRELOAD":      true,
+	"LANG":            true,
+	"LANGUAGE":        true,
+	"LC_ALL":          true,
+	"LC_CTYPE":        true,
+	"LC_MESSAGES":     true,
+	"TZ":              true,
+	"TERM":            true,
+	"SHELL":           true,
+	"GODEBUG":         true,
+	"GOMAXPROCS":      true,
+	"GOTRACEBACK":     true,
+}
+
+// supportSerialLine 内核日志中 USB 设备的序列号
+var supportSerialLine = regexp.MustCompile(`(SerialNumber: )(\S+)`)
+
+// supportFile 支持包中的一个文件
+type supportFile struct {
+	name string
+	data []byte
+}
+
+// supportBundle 在内存中收集支持包内容
+type supportBundle struct {
+	prefix string // 归档内的目录名
+	key    []byte // -hash-ids 的 HMAC 密钥，为空时不替换标识
+	files  []supportFile
+	errs   []string // 收集失败的项目
+}
+
+// newSupportBundle 创建支持包，hashIDs 为 true 时生成本支持包的 HMAC 密钥
+func newSupportBundle(prefix string, hashIDs bool) (*supportBundle, error) {
+	b := &supportBundle{prefix: prefix}
+	if hashIDs {
+		b.key = make([]byte, SUPPORT_KEY_SIZE)
+		if _, err := rand.Read(b.key); err != nil {
+			return nil, fmt.Errorf("生成随机密钥失败: %v", err)
+		}
+	}
+	return b, nil
+}
+
+// add 加入一个文件
+func (b *supportBundle) add(name string, data []byte) {
+	b.files = append(b.files, supportFile{name: name, data: data})
+}
+
+// addJSON 以缩进 JSON 加入一个文件
+func (b *supportBundle) addJSON(name string, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		b.fail(name, err)
+		return
+	}
+	b.add(name, append(data, '\n'))
+}
+
+// fail 记录收集失败的项目，写入 errors.txt
+func (b *supportBundle) fail(item string, err error) {
+	b.errs = append(b.errs, fmt.Sprintf("%s: %v", item, err))
+}
+
+// mac 标识的 HMAC-SHA256，十六进制标识不区分大小写
+func (b *supportBundle) mac(id string) []byte {
+	m := hmac.New(sha256.New, b.key)
+	m.Write([]byte(strings.ToUpper(id)))
+	return m.Sum(nil)
+}
+
+// id 返回标识在支持包中的输出值，-hash-ids 时替换为等长的 HMAC 十六进制前缀，
+// 保持十六进制标识的大小写
+func (b *supportBundle) id(id string) string {
+	if b.key == nil || id == "" {
+		return id
+	}
+	h := hex.EncodeToString(b.mac(id))
+	for len(h) < len(id) {
+		h += h
+	}
+	h = h[:len(id)]
+	if id != strings.ToLower(id) {
+		h = strings.ToUpper(h)
+	}
+	return h
+}
+
+// hid 返回硬件ID 在支持包中的输出值，与 id(info.HID()) 一致
+func (b *supportBundle) hid(hid [8]byte) [8]byte {
+	if b.key == nil {
+		return hid
+	}
+	var out [8]byte
+	copy(out[:], b.mac(fmt.Sprintf("%X", hid[:])))
+	return out
+}
+
+// redactTrace 替换跟踪文件中枚举结果的硬件ID，其他记录原样保留
+func (b *supportBundle) redactTrace(data []byte) ([]byte, error) {
+	if b.key == nil {
+		return data, nil
+	}
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	for i, line := range bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")) {
+		var rec traceRecord
+		if i == 0 || json.Unmarshal(line, &rec) != nil || rec.Function != FUNC_ENUM || rec.Output == "" {
+			out.Write(line)
+			out.WriteByte('\n')
+			continue
+		}
+		keyList, err := decodeDongleInfos(rec.Output)
+		if err != nil {
+			return nil, err
+		}
+		for j := range keyList {
+			keyList[j].MHID = b.hid(keyList[j].MHID)
+		}
+		rec.Output = encodeDongleInfos(keyList)
+		if err := enc.Encode(rec); err != nil {
+			return nil, err
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// write 以 tar.gz 写出所有文件
+func (b *supportBundle) write(w io.Writer) error {
+	if len(b.errs) > 0 {
+		b.add("errors.txt", []byte(strings.Join(b.errs, "\n")+"\n"))
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now().Truncate(time.Second)
+	for _, f := range b.files {
+		data := f.data
+		hdr := &tar.Header{
+			Name:    b.prefix + "/" + f.name,
+			Mode:    0o644,
+			Size:    int64(len(data)),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// ============ 收集 ============
+
+// collectVersion 程序版本和构建信息
+func collectVersion(b *supportBundle) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		buf.WriteString("构建信息不可用\n")
+	} else {
+		fmt.Fprintf(&buf, "path: %s\n", info.Path)
+		fmt.Fprintf(&buf, "module: %s %s\n", info.Main.Path, info.Main.Version)
+		for _, s := range info.Settings {
+			fmt.Fprintf(&buf, "build %s=%s\n", s.Key, s.Value)
+		}
+		for _, dep := range info.Deps {
+			fmt.Fprintf(&buf, "dep %s %s %s\n", dep.Path, dep.Version, dep.Sum)
+		}
+	}
+	b.add("version.txt", buf.Bytes())
+}
+
+// lockedBuffer 可以并发写入的缓冲区，超时未返回的调用之后仍可能写入跟踪
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (l *lockedBuffer) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Write(p)
+}
+
+func (l *lockedBuffer) Bytes() []byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]byte(nil), l.buf.Bytes()...)
+}
+
+// collectTrace 记录一次 枚举/打开/关闭 的调用跟踪，调用超时时返回 false
+func collectTrace(b *supportBundle) bool {
+	backend, cleanup, err := openTestBackend(FUNC_ENUM, FUNC_OPEN, FUNC_CLOSE)
+	if err != nil {
+		b.fail("trace.ndjson", err)
+		return true
+	}
+	// 支持包会发给外部，不受 -trace-secrets 影响
+	var buf lockedBuffer
+	traced, err := newTracingBackend(backend, &buf, getLibraryPath(), false)
+	if err != nil {
+		cleanup()
+		b.fail("trace.ndjson", err)
+		return true
+	}
+
+	call := func(name string, fn func()) bool {
+		if err := callNative(context.Background(), name, fn); err != nil {
+			b.fail("trace.ndjson", err)
+			return false
+		}
+		return true
+	}
+
+	// 超时的调用仍持有动态库，不能卸载
+	defer func() {
+		data, err := b.redactTrace(buf.Bytes())
+		if err != nil {
+			b.fail("trace.ndjson", err)
+			return
+		}
+		b.add("trace.ndjson", data)
+	}()
+	var keyList []DongleInfo
+	if !call(FUNC_ENUM, func() { keyList, _, err = traced.Enum() }) {
+		return false
+	}
+	if err == nil && len(keyList) > 0 {
+		var handle DongleHandle
+		if !call(FUNC_OPEN, func() { handle, _, err = traced.Open(0) }) {
+			return false
+		}
+		if err == nil && !call(FUNC_CLOSE, func() { traced.Close(handle) }) {
+			return false
+		}
+	}
+	cleanup()
+	return true
+}
+
+// collectUSB sysfs 中的 USB 设备，加密锁的原始属性单独保存
+func collectUSB(b *supportBundle) {
+	devices, err := scanUSB(SYSFS_USB_DEVICES, "/dev")
+	if err != nil {
+		b.fail("usb.txt", err)
+		return
+	}
+
+	var buf bytes.Buffer
+	for _, dev := range devices {
+		mark := " "
+		if dev.IsDongle() {
+			mark = "*"
+		}
+		fmt.Fprintf(&buf, "%s %s %04X:%04X 总线 %03d 端口 %s 设备号 %03d %s %s\n",
+			mark, dev.Name, dev.Vendor, dev.Product, dev.Bus, dev.Port, dev.DevNum, dev.Manufacturer, dev.ProductName)
+		if dev.Serial != "" {
+			fmt.Fprintf(&buf, "    序列号: %s\n", b.id(dev.Serial))
+		}
+		fmt.Fprintf(&buf, "    驱动: %s\n", dev.Drivers())
+		fmt.Fprintf(&buf, "    设备节点: %s\n", dev.DevNode)
+		for _, node := range dev.HIDRaw {
+			fmt.Fprintf(&buf, "    hidraw: %s\n", node)
+		}
+		if dev.IsDongle() {
+			collectSysfsAttrs(b, filepath.Join(SYSFS_USB_DEVICES, dev.Name), "sysfs/"+dev.Name)
+		}
+	}
+	b.add("usb.txt", buf.Bytes())
+}
+
+// collectSysfsAttrs 保存 sysfs 目录中可读的属性文件
+func collectSysfsAttrs(b *supportBundle, dir, name string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		b.fail(name, err)
+		return
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil || len(data) > SUPPORT_SYSFS_MAX {
+			continue // 只写属性或过大的文件
+		}
+		if entry.Name() == "serial" {
+			data = []byte(b.id(strings.TrimSpace(string(data))) + "\n")
+		}
+		b.add(name+"/"+entry.Name(), data)
+	}
+}
+
+// libraryReport 动态库的 ELF 分析结果
+type libraryReport struct {
+	Path    string   `json:"path"`              // 路径
+	Size    int64    `json:"size"`              // 大小
+	SHA256  string   `json:"sha256"`            // 文件校验和
+	Class   string   `json:"class"`             // 32/64 位
+	Machine string   `json:"machine"`           // 架构
+	OSABI   string   `json:"osabi"`             // ABI
+	Type    string   `json:"type"`              // 文件类型
+	BuildID string   `json:"build_id"`          // GNU build-id
+	SONAME  []string `json:"soname,omitempty"`  // 库名
+	RunPath []string `json:"runpath,omitempty"` // RPATH/RUNPATH
+	Needed  []string `json:"needed"`            // 依赖库
+	Symbols []string `json:"symbols"`           // 导出的 Dongle_* 函数
+}
+
+// analyzeLibrary 分析动态库的 ELF 信息
+func analyzeLibrary(path string) (*libraryReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sum := sha256.Sum256(data)
+	report := &libraryReport{
+		Path:    path,
+		Size:    int64(len(data)),
+		SHA256:  hex.EncodeToString(sum[:]),
+		Class:   f.Class.String(),
+		Machine: f.Machine.String(),
+		OSABI:   f.OSABI.String(),
+		Type:    f.Type.String(),
+		BuildID: elfBuildID(f),
+	}
+	report.SONAME, _ = f.DynString(elf.DT_SONAME)
+	rpath, _ := f.DynString(elf.DT_RPATH)
+	runpath, _ := f.DynString(elf.DT_RUNPATH)
+	report.RunPath = append(rpath, runpath...)
+	report.Needed, _ = f.ImportedLibraries()
+	symbols, _ := f.DynamicSymbols()
+	for _, sym := range symbols {
+		if strings.HasPrefix(sym.Name, "Dongle_") && sym.Section != elf.SHN_UNDEF {
+			report.Symbols = append(report.Symbols, sym.Name)
+		}
+	}
+	sort.Strings(report.Symbols)
+	return report, nil
+}
+
+// elfBuildID 读取 .note.gnu.build-id
+func elfBuildID(f *elf.File) string {
+	sec := f.Section(".note.gnu.build-id")
+	if sec == nil {
+		return ""
+	}
+	data, err := sec.Data()
+	if err != nil || len(data) < 12 {
+		return ""
+	}
+	// 注释格式: namesz(4) descsz(4) type(4) name(按 4 字节对齐) desc
+	namesz := int(f.ByteOrder.Uint32(data[0:4]))
+	descsz := int(f.ByteOrder.Uint32(data[4:8]))
+	off := 12 + (namesz+3)&^3
+	if off+descsz > len(data) {
+		return ""
+	}
+	return hex.EncodeToString(data[off : off+descsz])
+}
+
+// collectEnvironment 系统和进程环境，白名单以外的环境变量只保留名称
+func collectEnvironment(b *supportBundle) {
+	var buf bytes.Buffer
+	if hostname, err := os.Hostname(); err == nil {
+		fmt.Fprintf(&buf, "hostname: %s\n", b.id(hostname))
+	}
+	if kernel := readKernelVersion(); kernel != "" {
+		fmt.Fprintf(&buf, "kernel: %s\n", kernel)
+	}
+	if data, err := os.ReadFile("/etc/os-release"); err == nil {
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			fmt.Fprintf(&buf, "os-release: %s\n", line)
+		}
+	}
+	userName, groups := currentUser()
+	fmt.Fprintf(&buf, "user: %s (uid %d, euid %d)\n", userName, os.Getuid(), os.Geteuid())
+	fmt.Fprintf(&buf, "groups: %s\n", strings.Join(groups, " "))
+	if wd, err := os.Getwd(); err == nil {
+		fmt.Fprintf(&buf, "cwd: %s\n", wd)
+	}
+	fmt.Fprintf(&buf, "args: %s\n", strings.Join(os.Args, " "))
+
+	buf.WriteString("\n环境变量:\n")
+	buf.WriteString(formatEnvironment(os.Environ()))
+	b.add("environment.txt", buf.Bytes())
+}
+
+// formatEnvironment 按名称排序输出环境变量，只有白名单中的变量保留值
+func formatEnvironment(env []string) string {
+	env = append([]string(nil), env...)
+	sort.Strings(env)
+	var buf strings.Builder
+	for _, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		if !supportEnvAllowed[key] {
+			value = TRACE_REDACTED
+		}
+		fmt.Fprintf(&buf, "%s=%s\n", key, value)
+	}
+	return buf.String()
+}
+
+// collectKernelLog 内核日志的最后部分
+func collectKernelLog(b *supportBundle) {
+	lines, err := readKernelLog(SUPPORT_DMESG_LINES)
+	if err != nil {
+		b.fail("dmesg.txt", err)
+		return
+	}
+	for i, line := range lines {
+		lines[i] = supportSerialLine.ReplaceAllStringFunc(line, func(m string) string {
+			parts := supportSerialLine.FindStringSubmatch(m)
+			return parts[1] + b.id(parts[2])
+		})
+	}
+	b.add("dmesg.txt", []byte(strings.Join(lines, "\n")+"\n"))
+}
+
+// runSupportBundleCommand 生成支持包
+func runSupportBundleCommand(args []string) error {
+	fs := flag.NewFlagSet("support-bundle", flag.ContinueOnError)
+	stamp := time.Now().Format("20060102-150405")
+	output := fs.String("o", "rockey-support-"+stamp+".tar.gz", "输出文件")
+	hashIDs := fs.Bool("hash-ids", false, "把序列号、硬件ID、主机名替换为本支持包专用的 HMAC 前缀")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	b, err := newSupportBundle("rockey-support-"+stamp, *hashIDs)
+	if err != nil {
+		return err
+	}
+	collectVersion(b)
+	fmt.Println("记录枚举/打开/关闭调用...")
+	completed := collectTrace(b)
+	fmt.Println("收集 USB 设备信息...")
+	collectUSB(b)
+
+	fmt.Println("运行诊断检查...")
+	skip := make(map[string]bool)
+	if !completed {
+		// 动态库中仍有卡住的调用，不再访问设备
+		skip[CHECK_ENUMERATION] = true
+	}
+	var diag bytes.Buffer
+	if err := writeChecksJSON(&diag, runChecksWith(&diagEnv{displayID: b.id}, diagnosticChecks, nil, skip)); err != nil {
+		b.fail("diagnose.json", err)
+	} else {
+		b.add("diagnose.json", diag.Bytes())
+	}
+
+	if report, err := analyzeLibrary(getLibraryPath()); err != nil {
+		b.fail("library.json", err)
+	} else {
+		b.addJSON("library.json", report)
+	}
+	collectEnvironment(b)
+	collectKernelLog(b)
+
+	f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := b.write(f); err != nil {
+		f.Close()
+		return fmt.Errorf("写入 %s 失败: %v", *output, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	fmt.Printf("已生成支持包: %s (%d 个文件", *output, len(b.files))
+	if len(b.errs) > 0 {
+		fmt.Printf("，%d 项未能收集，见 errors.txt", len(b.errs))
+	}
+	fmt.Println(")")
+	return nil
+}
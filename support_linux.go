@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// KERNEL_LOG_DEVICE 内核日志设备
+const KERNEL_LOG_DEVICE = "/dev/kmsg"
+
+// readKernelLog 读取内核日志的最后 lines 行，格式与 dmesg 相同
+//
+// 开启 kernel.dmesg_restrict 时普通用户无权读取，返回错误。
+func readKernelLog(lines int) ([]string, error) {
+	// 非阻塞读取，读完已有记录后返回 EAGAIN 而不是等待新日志
+	fd, err := syscall.Open(KERNEL_LOG_DEVICE, syscall.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: KERNEL_LOG_DEVICE, Err: err}
+	}
+	f := os.NewFile(uintptr(fd), KERNEL_LOG_DEVICE)
+	defer f.Close()
+
+	var tail []string
+	buf := make([]byte, 8192) // 每次 read 返回一条记录
+	for {
+		n, err := syscall.Read(fd, buf)
+		if err == syscall.EPIPE {
+			continue // 记录在读取前被覆盖
+		}
+		if err != nil || n <= 0 {
+			break
+		}
+		tail = append(tail, formatKernelRecord(string(buf[:n])))
+		if len(tail) > lines {
+			tail = tail[1:]
+		}
+	}
+	return tail, nil
+}
+
+// formatKernelRecord 把 "优先级,序号,微秒,标志;消息" 转换为 "[秒] 消息"
+func formatKernelRecord(record string) string {
+	header, message, ok := strings.Cut(record, ";")
+	if !ok {
+		return strings.TrimSpace(record)
+	}
+	// 续行 (以空格开头的 KEY=VALUE) 不输出
+	message, _, _ = strings.Cut(message, "\n")
+	fields := strings.Split(header, ",")
+	if len(fields) < 3 {
+		return message
+	}
+	usec, err := strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return message
+	}
+	return fmt.Sprintf("[%5d.%06d] %s", usec/1e6, usec%1e6, message)
+}
+
+// readKernelVersion 读取内核版本信息
+func readKernelVersion() string {
+	f, err := os.Open("/proc/version")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	line, _ := bufio.NewReader(f).ReadString('\n')
+	return strings.TrimSpace(line)
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// readKernelLog 非 Linux 平台不读取内核日志
+func readKernelLog(lines int) ([]string, error) {
+	return nil, fmt.Errorf("%s 平台不支持读取内核日志", runtime.GOOS)
+}
+
+// readKernelVersion 非 Linux 平台没有 /proc/version
+func readKernelVersion() string {
+	return ""
+}
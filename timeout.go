@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/ebitengine/purego"
+)
+
+// ============ 调用超时 ============
+//
+// 加密锁在读写过程中被拔出时，部分原生调用可能永远不返回。原生调用无法
+// 被中断，这里把调用放到单独锁定系统线程的 goroutine 中执行：超时后调用方
+// 立即返回 context.DeadlineExceeded，卡住的线程被隔离，不再参与后续调用。
+// 命令行工具的原生调用都经过 callNative，超时作为普通错误返回。Backend
+// 接口的方法不接收 ctx，超时由调用方 (callNative 或 Session) 施加。
+
+// runIsolated 在独立线程上执行 fn，ctx 结束前未返回则放弃等待
+func runIsolated(ctx context.Context, fn func()) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		// 卡住的调用会一直占用这个线程，不能让它回到调度器的线程池
+		runtime.LockOSThread()
+		fn()
+		close(done)
+		runtime.UnlockOSThread()
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stuckCalls 超时后仍未返回的原生调用数
+var stuckCalls atomic.Int32
+
+// callNative 以全局超时执行一次原生调用，超时或 ctx 结束时返回 ctx 的错误
+//
+// 超时的调用仍然持有动态库和设备句柄，在它返回之前继续调用或卸载动态库
+// 都不安全：之后的 callNative 直接返回错误，deviceConn.close 不再卸载动态库。
+// 调用方按普通错误返回，已注册的 defer 清理照常执行。
+func callNative(ctx context.Context, name string, fn func()) error {
+	if n := stuckCalls.Load(); n > 0 {
+		return fmt.Errorf("%s: 之前有 %d 个原生调用超时未返回，不再调用动态库", name, n)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *callTimeout)
+	defer cancel()
+
+	// 0: 未开始, 1: 执行中, 2: 已返回, 3: 已放弃等待
+	var state atomic.Int32
+	err := runIsolated(ctx, func() {
+		if !state.CompareAndSwap(0, 1) {
+			return
+		}
+		fn()
+		if !state.CompareAndSwap(1, 2) {
+			stuckCalls.Add(-1)
+		}
+	})
+	if err == nil {
+		return nil
+	}
+	if state.CompareAndSwap(0, 3) {
+		// 调用还没有开始
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if !state.CompareAndSwap(1, 3) {
+		// 调用恰好在超时时返回，结果有效
+		return nil
+	}
+	stuckCalls.Add(1)
+	return fmt.Errorf("%s 在 %v 内未返回，设备可能已被拔出或无响应: %w", name, *callTimeout, err)
+}
+
+// isCallAborted 判断错误是否为调用超时或 ctx 被取消，而不是动态库返回的错误
+//
+// 忽略单项读取失败的循环遇到这类错误时必须停止，之后的调用都会失败。
+func isCallAborted(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
+
+// unloadLibrary 卸载动态库，有原生调用超时未返回时保留
+func unloadLibrary(lib uintptr) {
+	if n := stuckCalls.Load(); n > 0 {
+		logger.Warn("有原生调用超时未返回，不卸载动态库", "stuck", n)
+		return
+	}
+	purego.Dlclose(lib)
+}
+
+// commandContext 返回子命令使用的 ctx，收到 SIGINT/SIGTERM 时取消
+func commandContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestCallNativeStuck 超时的调用返回 ctx 错误，返回前拒绝新的调用
+func TestCallNativeStuck(t *testing.T) {
+	saved := *callTimeout
+	*callTimeout = 20 * time.Millisecond
+	defer func() { *callTimeout = saved }()
+
+	release := make(chan struct{})
+	returned := make(chan struct{})
+	err := callNative(context.Background(), "stuck", func() {
+		<-release
+		close(returned)
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("callNative = %v, 期望 DeadlineExceeded", err)
+	}
+	if !isCallAborted(err) {
+		t.Errorf("isCallAborted(%v) = false", err)
+	}
+
+	called := false
+	if err := callNative(context.Background(), "next", func() { called = true }); err == nil || called {
+		t.Fatalf("卡住的调用返回前不应执行新的调用 (err=%v, called=%v)", err, called)
+	}
+
+	close(release)
+	<-returned
+	deadline := time.Now().Add(time.Second)
+	for stuckCalls.Load() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := callNative(context.Background(), "next", func() { called = true }); err != nil || !called {
+		t.Fatalf("卡住的调用返回后应恢复 (err=%v, called=%v)", err, called)
+	}
+}
+
+// TestCallNativeCanceled 已取消的 ctx 不执行调用，也不计为卡住
+func TestCallNativeCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	called := false
+	err := callNative(ctx, "canceled", func() { called = true })
+	if !errors.Is(err, context.Canceled) || called {
+		t.Fatalf("callNative = %v, called = %v", err, called)
+	}
+	if n := stuckCalls.Load(); n != 0 {
+		t.Fatalf("stuckCalls = %d", n)
+	}
+}
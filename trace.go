@@ -0,0 +1,586 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============ 调用跟踪与回放 ============
+//
+// 客户现场的问题往往无法在本地复现。tracingBackend 把每次对后端的调用按
+// NDJSON 记录到跟踪文件：函数名、参数与输入缓冲区、返回码、输出缓冲区和
+// 耗时，PIN 等敏感参数只记录为占位符。种子码的输入输出、数据区写入的内容
+// 以及数据区和数据文件读出的内容默认也不记录原文：输入只记录 SHA-256 摘要，
+// 种子码结果和读出的内容记录为占位符，需要完整记录时使用 -trace-secrets。
+// 跟踪文件会被附加到工单和支持包中，种子码结果和授权数据泄露后可以被用来
+// 仿冒加密锁。replayBackend 读取跟踪文件代替动态库，按原顺序校验每次调用并
+// 返回记录的结果，从而在没有硬件的机器上重新运行 -test 或 -read-test；
+// 读取类调用的回放需要采集时使用 -trace-secrets。
+
+// 跟踪文件格式
+const (
+	TRACE_VERSION     = 1         // 跟踪文件版本
+	TRACE_REDACTED    = "***"     // 敏感参数的占位符
+	TRACE_DIGEST      = "sha256:" // 只记录摘要的输入缓冲区前缀
+	TRACE_MAX_LINE    = 4 << 20   // 单条记录的最大长度
+	TRACE_MAX_RECORDS = 1_000_000 // 回放时最多读取的记录数
+)
+
+// traceHeader 跟踪文件首行，记录采集环境
+type traceHeader struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	GOOS    string    `json:"goos"`
+	GOARCH  string    `json:"goarch"`
+	Library string    `json:"library,omitempty"`
+	Secrets bool      `json:"secrets,omitempty"` // 是否记录了种子码、数据区写入和读出的原文
+}
+
+// traceRecord 一次调用的记录
+type traceRecord struct {
+	Seq      int             `json:"seq"`
+	Time     time.Time       `json:"time"`
+	Function string          `json:"function"`
+	Handle   DongleHandle    `json:"handle,omitempty"` // 传入的设备句柄
+	Args     json.RawMessage `json:"args,omitempty"`   // 标量参数
+	Input    string          `json:"input,omitempty"`  // 输入缓冲区 (十六进制)
+	Output   string          `json:"output,omitempty"` // 输出缓冲区 (十六进制)
+	Value    uint64          `json:"value,omitempty"`  // 标量输出：句柄、剩余次数、时间等
+	RetCode  string          `json:"ret_code"`
+	Error    string          `json:"error,omitempty"`
+	Duration time.Duration   `json:"duration_ns"`
+}
+
+// traceArgs 把键值对编码为参数记录，键按字母序排列，便于回放时逐字节比较
+func traceArgs(kv ...interface{}) json.RawMessage {
+	args := make(map[string]interface{}, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		args[kv[i].(string)] = kv[i+1]
+	}
+	data, _ := json.Marshal(args)
+	return data
+}
+
+// traceDigest 返回缓冲区的摘要记录
+func traceDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return TRACE_DIGEST + hex.EncodeToString(sum[:])
+}
+
+// traceInputMatches 判断记录的输入缓冲区与实际输入是否一致，记录可以是原文或摘要
+func traceInputMatches(recorded string, input []byte) bool {
+	if strings.HasPrefix(recorded, TRACE_DIGEST) {
+		return recorded == traceDigest(input)
+	}
+	return recorded == hex.EncodeToString(input)
+}
+
+// encodeDongleInfos 把枚举结果编码为十六进制
+func encodeDongleInfos(keyList []DongleInfo) string {
+	if len(keyList) == 0 {
+		return ""
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, keyList)
+	return hex.EncodeToString(buf.Bytes())
+}
+
+// decodeDongleInfos 解析 encodeDongleInfos 的输出
+func decodeDongleInfos(s string) ([]DongleInfo, error) {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	size := binary.Size(DongleInfo{})
+	if len(data)%size != 0 {
+		return nil, fmt.Errorf("设备信息长度 %d 不是 %d 的整数倍", len(data), size)
+	}
+	keyList := make([]DongleInfo, len(data)/size)
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, keyList); err != nil {
+		return nil, err
+	}
+	return keyList, nil
+}
+
+// ============ 跟踪 ============
+
+// tracingBackend 记录每次调用的后端包装
+type tracingBackend struct {
+	backend Backend
+
+	secrets bool // 记录种子码、数据区写入和读出的原文
+
+	mu  sync.Mutex
+	out *json.Encoder
+	seq int
+	err error // 第一次写入失败的错误
+}
+
+// newTracingBackend 包装 backend，把跟踪记录写入 w，secrets 为 false 时种子码、数据区写入和读出只记录摘要或占位符
+func newTracingBackend(backend Backend, w io.Writer, library string, secrets bool) (*tracingBackend, error) {
+	out := json.NewEncoder(w)
+	header := traceHeader{
+		Version: TRACE_VERSION,
+		Created: time.Now(),
+		GOOS:    runtime.GOOS,
+		GOARCH:  runtime.GOARCH,
+		Library: library,
+		Secrets: secrets,
+	}
+	if err := out.Encode(header); err != nil {
+		return nil, fmt.Errorf("写入跟踪文件失败: %v", err)
+	}
+	return &tracingBackend{backend: backend, secrets: secrets, out: out}, nil
+}
+
+// secretInput 编码敏感的输入缓冲区
+func (t *tracingBackend) secretInput(data []byte) string {
+	if t.secrets {
+		return hex.EncodeToString(data)
+	}
+	return traceDigest(data)
+}
+
+// secretOutput 编码敏感的输出缓冲区，回放时无法从摘要还原，只记录占位符
+func (t *tracingBackend) secretOutput(data []byte) string {
+	if t.secrets || len(data) == 0 {
+		return hex.EncodeToString(data)
+	}
+	return TRACE_REDACTED
+}
+
+// Err 返回写入跟踪文件时遇到的第一个错误
+func (t *tracingBackend) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// record 补全并写入一条记录
+func (t *tracingBackend) record(rec traceRecord, start time.Time, retCode uint32, err error) {
+	rec.Time = start
+	rec.Duration = time.Since(start)
+	rec.RetCode = fmt.Sprintf("%08X", retCode)
+	if err != nil {
+		rec.Error = err.Error()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.seq++
+	rec.Seq = t.seq
+	if t.err == nil {
+		t.err = t.out.Encode(rec)
+	}
+}
+
+func (t *tracingBackend) Enum() ([]DongleInfo, uint32, error) {
+	start := time.Now()
+	keyList, retCode, err := t.backend.Enum()
+	t.record(traceRecord{Function: FUNC_ENUM, Output: encodeDongleInfos(keyList)}, start, retCode, err)
+	return keyList, retCode, err
+}
+
+func (t *tracingBackend) Open(index int) (DongleHandle, uint32, error) {
+	start := time.Now()
+	handle, retCode, err := t.backend.Open(index)
+	t.record(traceRecord{Function: FUNC_OPEN, Args: traceArgs("index", index), Value: uint64(handle)}, start, retCode, err)
+	return handle, retCode, err
+}
+
+func (t *tracingBackend) Close(handle DongleHandle) (uint32, error) {
+	start := time.Now()
+	retCode, err := t.backend.Close(handle)
+	t.record(traceRecord{Function: FUNC_CLOSE, Handle: handle}, start, retCode, err)
+	return retCode, err
+}
+
+func (t *tracingBackend) ReadFile(handle DongleHandle, fileID uint16, offset int, buffer []byte) (uint32, error) {
+	start := time.Now()
+	retCode, err := t.backend.ReadFile(handle, fileID, offset, buffer)
+	rec := traceRecord{Function: FUNC_READFILE, Handle: handle, Args: traceArgs("file_id", fileID, "offset", offset, "size", len(buffer))}
+	if err == nil {
+		rec.Output = t.secretOutput(buffer)
+	}
+	t.record(rec, start, retCode, err)
+	return retCode, err
+}
+
+func (t *tracingBackend) ReadFileTyped(handle DongleHandle, fileType int, fileID uint16, offset int, buffer []byte) (uint32, error) {
+	reader, ok := t.backend.(typedFileReader)
+	if !ok {
+		return DONGLE_UNKNOWN_ERROR, errTypedReadUnsupported
+	}
+	start := time.Now()
+	retCode, err := reader.ReadFileTyped(handle, fileType, fileID, offset, buffer)
+	rec := traceRecord{Function: FUNC_READFILE, Handle: handle, Args: traceArgs("file_type", fileType, "file_id", fileID, "offset", offset, "size", len(buffer))}
+	if err == nil {
+		rec.Output = t.secretOutput(buffer)
+	}
+	t.record(rec, start, retCode, err)
+	return retCode, err
+}
+
+func (t *tracingBackend) ReadData(handle DongleHandle, offset int, buffer []byte) (uint32, error) {
+	start := time.Now()
+	retCode, err := t.backend.ReadData(handle, offset, buffer)
+	rec := traceRecord{Function: FUNC_READDATA, Handle: handle, Args: traceArgs("offset", offset, "size", len(buffer))}
+	if err == nil {
+		rec.Output = t.secretOutput(buffer)
+	}
+	t.record(rec, start, retCode, err)
+	return retCode, err
+}
+
+func (t *tracingBackend) WriteData(handle DongleHandle, offset int, data []byte) (uint32, error) {
+	start := time.Now()
+	retCode, err := t.backend.WriteData(handle, offset, data)
+	rec := traceRecord{Function: FUNC_WRITEDATA, Handle: handle, Args: traceArgs("offset", offset), Input: t.secretInput(data)}
+	t.record(rec, start, retCode, err)
+	return retCode, err
+}
+
+func (t *tracingBackend) VerifyPIN(handle DongleHandle, flags int, pin string) (int, uint32, error) {
+	start := time.Now()
+	remain, retCode, err := t.backend.VerifyPIN(handle, flags, pin)
+	rec := traceRecord{Function: FUNC_VERIFYPIN, Handle: handle, Args: traceArgs("flags", flags, "pin", TRACE_REDACTED), Value: uint64(remain)}
+	t.record(rec, start, retCode, err)
+	return remain, retCode, err
+}
+
+func (t *tracingBackend) Seed(handle DongleHandle, seedData []byte) ([]byte, uint32, error) {
+	start := time.Now()
+	out, retCode, err := t.backend.Seed(handle, seedData)
+	rec := traceRecord{Function: FUNC_SEED, Handle: handle, Input: t.secretInput(seedData), Output: t.secretOutput(out)}
+	t.record(rec, start, retCode, err)
+	return out, retCode, err
+}
+
+func (t *tracingBackend) GetDeadline(handle DongleHandle) (uint32, uint32, error) {
+	start := time.Now()
+	deadline, retCode, err := t.backend.GetDeadline(handle)
+	t.record(traceRecord{Function: FUNC_GETDEADLINE, Handle: handle, Value: uint64(deadline)}, start, retCode, err)
+	return deadline, retCode, err
+}
+
+func (t *tracingBackend) GetUTCTime(handle DongleHandle) (uint32, uint32, error) {
+	start := time.Now()
+	utcTime, retCode, err := t.backend.GetUTCTime(handle)
+	t.record(traceRecord{Function: FUNC_GETUTCTIME, Handle: handle, Value: uint64(utcTime)}, start, retCode, err)
+	return utcTime, retCode, err
+}
+
+func (t *tracingBackend) GenRandom(handle DongleHandle, length int) ([]byte, uint32, error) {
+	start := time.Now()
+	out, retCode, err := t.backend.GenRandom(handle, length)
+	rec := traceRecord{Function: FUNC_GENRANDOM, Handle: handle, Args: traceArgs("length", length), Output: hex.EncodeToString(out)}
+	t.record(rec, start, retCode, err)
+	return out, retCode, err
+}
+
+func (t *tracingBackend) EccSign(handle DongleHandle, fileID uint16, hash []byte) ([]byte, uint32, error) {
+	start := time.Now()
+	out, retCode, err := t.backend.EccSign(handle, fileID, hash)
+	rec := traceRecord{Function: FUNC_ECCSIGN, Handle: handle, Args: traceArgs("file_id", fileID), Input: hex.EncodeToString(hash), Output: hex.EncodeToString(out)}
+	t.record(rec, start, retCode, err)
+	return out, retCode, err
+}
+
+// ============ 回放 ============
+
+// replayBackend 按跟踪文件回放调用结果的后端
+type replayBackend struct {
+	Header traceHeader
+
+	mu      sync.Mutex
+	records []traceRecord
+	next    int
+}
+
+// loadReplayBackend 读取跟踪文件
+func loadReplayBackend(path string) (*replayBackend, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("打开跟踪文件失败: %v", err)
+	}
+	defer f.Close()
+	return readReplay(f)
+}
+
+// readReplay 解析跟踪文件内容
+func readReplay(r io.Reader) (*replayBackend, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), TRACE_MAX_LINE)
+
+	replay := &replayBackend{}
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("读取跟踪文件失败: %v", err)
+		}
+		return nil, fmt.Errorf("跟踪文件为空")
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &replay.Header); err != nil {
+		return nil, fmt.Errorf("解析跟踪文件头失败: %v", err)
+	}
+	if replay.Header.Version != TRACE_VERSION {
+		return nil, fmt.Errorf("不支持的跟踪文件版本: %d", replay.Header.Version)
+	}
+
+	for line := 2; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		if len(replay.records) >= TRACE_MAX_RECORDS {
+			return nil, fmt.Errorf("跟踪文件超过 %d 条记录", TRACE_MAX_RECORDS)
+		}
+		var rec traceRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("解析跟踪文件第 %d 行失败: %v", line, err)
+		}
+		replay.records = append(replay.records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取跟踪文件失败: %v", err)
+	}
+	return replay, nil
+}
+
+// Remaining 返回尚未回放的记录数
+func (r *replayBackend) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.records) - r.next
+}
+
+// take 取出下一条记录，调用与记录不一致时返回错误
+func (r *replayBackend) take(function string, handle DongleHandle, args json.RawMessage, input []byte) (traceRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.next >= len(r.records) {
+		return traceRecord{}, fmt.Errorf("跟踪记录已用完: 第 %d 次调用 %s 没有对应记录", r.next+1, function)
+	}
+	rec := r.records[r.next]
+	if rec.Function != function || rec.Handle != handle || !bytes.Equal(rec.Args, args) || !traceInputMatches(rec.Input, input) {
+		return traceRecord{}, fmt.Errorf("调用与跟踪记录不一致: 第 %d 条记录为 %s(handle=0x%x, %s)，实际调用 %s(handle=0x%x, %s)",
+			rec.Seq, rec.Function, rec.Handle, string(rec.Args), function, handle, string(args))
+	}
+	r.next++
+	return rec, nil
+}
+
+// result 还原记录的返回码和错误
+func (rec traceRecord) result() (uint32, error) {
+	code, err := strconv.ParseUint(rec.RetCode, 16, 32)
+	if err != nil {
+		return DONGLE_UNKNOWN_ERROR, fmt.Errorf("第 %d 条记录的返回码无效: %q", rec.Seq, rec.RetCode)
+	}
+	if rec.Error != "" {
+		return uint32(code), errors.New(rec.Error)
+	}
+	return uint32(code), nil
+}
+
+// output 还原记录的输出缓冲区
+func (rec traceRecord) output() ([]byte, error) {
+	if rec.Output == "" {
+		return nil, nil
+	}
+	if rec.Output == TRACE_REDACTED {
+		return nil, fmt.Errorf("第 %d 条记录的 %s 结果未记录原文，采集时使用 -trace-secrets 才能回放", rec.Seq, rec.Function)
+	}
+	out, err := hex.DecodeString(rec.Output)
+	if err != nil {
+		return nil, fmt.Errorf("第 %d 条记录的输出无效: %v", rec.Seq, err)
+	}
+	return out, nil
+}
+
+// replayBytes 回放输出缓冲区由后端分配的调用
+func (r *replayBackend) replayBytes(function string, handle DongleHandle, args json.RawMessage, input []byte) ([]byte, uint32, error) {
+	rec, err := r.take(function, handle, args, input)
+	if err != nil {
+		return nil, DONGLE_UNKNOWN_ERROR, err
+	}
+	out, err := rec.output()
+	if err != nil {
+		return nil, DONGLE_UNKNOWN_ERROR, err
+	}
+	retCode, err := rec.result()
+	return out, retCode, err
+}
+
+// replayInto 回放输出到调用方缓冲区的调用
+func (r *replayBackend) replayInto(function string, handle DongleHandle, args json.RawMessage, buffer []byte) (uint32, error) {
+	out, retCode, err := r.replayBytes(function, handle, args, nil)
+	copy(buffer, out)
+	return retCode, err
+}
+
+// replayValue 回放返回标量的调用
+func (r *replayBackend) replayValue(function string, handle DongleHandle, args json.RawMessage) (uint64, uint32, error) {
+	rec, err := r.take(function, handle, args, nil)
+	if err != nil {
+		return 0, DONGLE_UNKNOWN_ERROR, err
+	}
+	retCode, err := rec.result()
+	return rec.Value, retCode, err
+}
+
+func (r *replayBackend) Enum() ([]DongleInfo, uint32, error) {
+	rec, err := r.take(FUNC_ENUM, 0, nil, nil)
+	if err != nil {
+		return nil, DONGLE_UNKNOWN_ERROR, err
+	}
+	var keyList []DongleInfo
+	if rec.Output != "" {
+		if keyList, err = decodeDongleInfos(rec.Output); err != nil {
+			return nil, DONGLE_UNKNOWN_ERROR, fmt.Errorf("第 %d 条记录的设备信息无效: %v", rec.Seq, err)
+		}
+	}
+	retCode, err := rec.result()
+	return keyList, retCode, err
+}
+
+func (r *replayBackend) Open(index int) (DongleHandle, uint32, error) {
+	handle, retCode, err := r.replayValue(FUNC_OPEN, 0, traceArgs("index", index))
+	return DongleHandle(handle), retCode, err
+}
+
+func (r *replayBackend) Close(handle DongleHandle) (uint32, error) {
+	_, retCode, err := r.replayValue(FUNC_CLOSE, handle, nil)
+	return retCode, err
+}
+
+func (r *replayBackend) ReadFile(handle DongleHandle, fileID uint16, offset int, buffer []byte) (uint32, error) {
+	return r.replayInto(FUNC_READFILE, handle, traceArgs("file_id", fileID, "offset", offset, "size", len(buffer)), buffer)
+}
+
+func (r *replayBackend) ReadFileTyped(handle DongleHandle, fileType int, fileID uint16, offset int, buffer []byte) (uint32, error) {
+	return r.replayInto(FUNC_READFILE, handle, traceArgs("file_type", fileType, "file_id", fileID, "offset", offset, "size", len(buffer)), buffer)
+}
+
+func (r *replayBackend) ReadData(handle DongleHandle, offset int, buffer []byte) (uint32, error) {
+	return r.replayInto(FUNC_READDATA, handle, traceArgs("offset", offset, "size", len(buffer)), buffer)
+}
+
+func (r *replayBackend) WriteData(handle DongleHandle, offset int, data []byte) (uint32, error) {
+	_, retCode, err := r.replayBytes(FUNC_WRITEDATA, handle, traceArgs("offset", offset), data)
+	return retCode, err
+}
+
+func (r *replayBackend) VerifyPIN(handle DongleHandle, flags int, pin string) (int, uint32, error) {
+	remain, retCode, err := r.replayValue(FUNC_VERIFYPIN, handle, traceArgs("flags", flags, "pin", TRACE_REDACTED))
+	return int(remain), retCode, err
+}
+
+func (r *replayBackend) Seed(handle DongleHandle, seedData []byte) ([]byte, uint32, error) {
+	return r.replayBytes(FUNC_SEED, handle, nil, seedData)
+}
+
+func (r *replayBackend) GetDeadline(handle DongleHandle) (uint32, uint32, error) {
+	deadline, retCode, err := r.replayValue(FUNC_GETDEADLINE, handle, nil)
+	return uint32(deadline), retCode, err
+}
+
+func (r *replayBackend) GetUTCTime(handle DongleHandle) (uint32, uint32, error) {
+	utcTime, retCode, err := r.replayValue(FUNC_GETUTCTIME, handle, nil)
+	return uint32(utcTime), retCode, err
+}
+
+func (r *replayBackend) GenRandom(handle DongleHandle, length int) ([]byte, uint32, error) {
+	return r.replayBytes(FUNC_GENRANDOM, handle, traceArgs("length", length), nil)
+}
+
+func (r *replayBackend) EccSign(handle DongleHandle, fileID uint16, hash []byte) ([]byte, uint32, error) {
+	return r.replayBytes(FUNC_ECCSIGN, handle, traceArgs("file_id", fileID), hash)
+}
+
+// ============ 测试后端 ============
+
+// openTestBackend 按 -trace/-replay/-sandbox/-hid 创建测试使用的后端，required 为必须存在的函数
+//
+// 返回的 cleanup 负责关闭跟踪文件并卸载动态库。
+func openTestBackend(required ...string) (Backend, func(), error) {
+	if *replayPath != "" {
+		replay, err := loadReplayBackend(*replayPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		fmt.Printf("回放跟踪文件: %s (采集于 %s, %s/%s)\n", *replayPath,
+			replay.Header.Created.Format(time.RFC3339), replay.Header.GOOS, replay.Header.GOARCH)
+		cleanup := func() {
+			if n := replay.Remaining(); n > 0 {
+				logger.Warn("跟踪文件中还有未回放的记录", "remaining", n)
+			}
+		}
+		return replay, cleanup, nil
+	}
+
+	libPath := getLibraryPath()
+	var backend Backend
+	var unload func()
+	if *hidMode {
+		backend, unload = newHIDBackend(), func() {}
+	} else if *sandbox {
+		helper, err := newHelperBackend(libPath, *callTimeout)
+		if err != nil {
+			return nil, nil, err
+		}
+		backend, unload = helper, helper.Unload
+	} else {
+		native, err := newNativeBackend(libPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("加载库失败: %v", err)
+		}
+		for _, name := range required {
+			if _, err := native.proc(name); err != nil {
+				native.Unload()
+				return nil, nil, fmt.Errorf("获取 %s 失败: %v", name, err)
+			}
+		}
+		backend, unload = native, native.Unload
+	}
+	if *tracePath == "" {
+		return backend, unload, nil
+	}
+
+	f, err := os.Create(*tracePath)
+	if err != nil {
+		unload()
+		return nil, nil, fmt.Errorf("创建跟踪文件失败: %v", err)
+	}
+	traced, err := newTracingBackend(backend, f, libPath, *traceSecrets)
+	if err != nil {
+		f.Close()
+		unload()
+		return nil, nil, err
+	}
+	fmt.Printf("记录跟踪文件: %s\n", *tracePath)
+	if *traceSecrets {
+		logger.Warn("跟踪文件包含种子码结果、数据区写入和读出的原文，请勿对外发送", "path", *tracePath)
+	}
+	cleanup := func() {
+		if err := traced.Err(); err != nil {
+			logger.Warn("写入跟踪文件失败", "path", *tracePath, "err", err)
+		}
+		if err := f.Close(); err != nil {
+			logger.Warn("关闭跟踪文件失败", "path", *tracePath, "err", err)
+		}
+		unload()
+	}
+	return traced, cleanup, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// traceSession 在跟踪后端上执行一组包含敏感数据的调用，返回跟踪文件、种子码结果和读出的文件内容
+func traceSession(t *testing.T, secrets bool, seedData, written []byte) ([]byte, []byte, []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	traced, err := newTracingBackend(newSimBackend(1), &buf, "", secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle, _, err := traced.Open(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := traced.VerifyPIN(handle, FLAG_USERPIN, DEFAULT_USER_PIN); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := traced.WriteData(handle, 0, written); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := traced.ReadData(handle, 0, make([]byte, len(written))); err != nil {
+		t.Fatal(err)
+	}
+	file := make([]byte, 16)
+	if _, err := traced.ReadFile(handle, TEST_FILE_ID, 0, file); err != nil {
+		t.Fatal(err)
+	}
+	result, _, err := traced.Seed(handle, seedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := traced.Close(handle); err != nil {
+		t.Fatal(err)
+	}
+	if err := traced.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), result, file
+}
+
+func TestTraceRedactsSecrets(t *testing.T) {
+	seedData := []byte("seed challenge")
+	written := []byte("license record")
+	trace, result, file := traceSession(t, false, seedData, written)
+
+	for _, secret := range [][]byte{seedData, written, result, file, []byte(DEFAULT_USER_PIN)} {
+		if bytes.Contains(trace, []byte(hex.EncodeToString(secret))) || bytes.Contains(trace, secret) {
+			t.Errorf("跟踪文件包含敏感数据 %q", secret)
+		}
+	}
+
+	// 摘要仍能校验调用顺序，写入其他内容时报告不一致
+	replay, err := readReplay(bytes.NewReader(trace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replay.Header.Secrets {
+		t.Error("Header.Secrets = true")
+	}
+	handle, _, err := replay.Open(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := replay.VerifyPIN(handle, FLAG_USERPIN, "any"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := replay.WriteData(handle, 0, []byte("other record!!")); err == nil || !strings.Contains(err.Error(), "不一致") {
+		t.Fatalf("写入不同内容时 WriteData = %v", err)
+	}
+	if _, err := replay.WriteData(handle, 0, written); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := replay.ReadData(handle, 0, make([]byte, len(written))); err == nil || !strings.Contains(err.Error(), "-trace-secrets") {
+		t.Fatalf("脱敏的 ReadData 回放 = %v", err)
+	}
+	if _, err := replay.ReadFile(handle, TEST_FILE_ID, 0, make([]byte, len(file))); err == nil || !strings.Contains(err.Error(), "-trace-secrets") {
+		t.Fatalf("脱敏的 ReadFile 回放 = %v", err)
+	}
+	if _, _, err := replay.Seed(handle, seedData); err == nil || !strings.Contains(err.Error(), "-trace-secrets") {
+		t.Fatalf("脱敏的 Seed 回放 = %v", err)
+	}
+}
+
+func TestTraceSecretsReplay(t *testing.T) {
+	seedData := []byte("seed challenge")
+	written := []byte("license record")
+	trace, result, file := traceSession(t, true, seedData, written)
+	if !bytes.Contains(trace, []byte(hex.EncodeToString(written))) {
+		t.Error("-trace-secrets 时跟踪文件中没有写入的原文")
+	}
+
+	replay, err := readReplay(bytes.NewReader(trace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !replay.Header.Secrets {
+		t.Error("Header.Secrets = false")
+	}
+	handle, _, err := replay.Open(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := replay.VerifyPIN(handle, FLAG_USERPIN, "any"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := replay.WriteData(handle, 0, written); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, len(written))
+	if _, err := replay.ReadData(handle, 0, data); err != nil || !bytes.Equal(data, written) {
+		t.Errorf("回放的数据区内容 %q, %v", data, err)
+	}
+	content := make([]byte, len(file))
+	if _, err := replay.ReadFile(handle, TEST_FILE_ID, 0, content); err != nil || !bytes.Equal(content, file) {
+		t.Errorf("回放的文件内容 %q, 期望 %q, %v", content, file, err)
+	}
+	replayed, _, err := replay.Seed(handle, seedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(replayed, result) {
+		t.Errorf("回放的种子码结果 %X, 期望 %X", replayed, result)
+	}
+	if _, err := replay.Close(handle); err != nil {
+		t.Fatal(err)
+	}
+	if n := replay.Remaining(); n != 0 {
+		t.Errorf("Remaining = %d", n)
+	}
+}
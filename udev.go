@@ -0,0 +1,191 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ============ udev 规则 ============
+//
+// 根据 sysfs 中实际插入的加密锁生成 udev 规则，同时给 USB 设备节点和 hidraw
+// 节点设置权限。TAG+="uaccess" 由 systemd-logind 在 73-seat-late.rules 中
+// 处理，规则文件编号必须小于 73 才能生效，因此默认使用 70-rockey.rules。
+// 规则总是同时匹配厂商ID和产品ID，只放开 knownDongleIDs 中的型号，不影响
+// 同一厂商的 ePass、FIDO 等令牌。
+
+// 规则默认值
+const (
+	UDEV_RULES_PATH = "/etc/udev/rules.d/70-rockey.rules"
+	UDEV_GROUP      = "plugdev"
+	UDEV_MODE       = "0660"
+)
+
+// udevRules 生成规则文件内容
+func udevRules(ids []usbID, group, mode string) string {
+	var b strings.Builder
+	fmt.Fprintln(&b, "# Rockey 加密锁访问规则，由 rockey-test udev 生成")
+	fmt.Fprintln(&b, "# 修改后执行: udevadm control --reload-rules && udevadm trigger")
+	for _, id := range ids {
+		match := fmt.Sprintf("ATTR{idVendor}==\"%04x\", ATTR{idProduct}==\"%04x\"", id.Vendor, id.Product)
+		parentMatch := fmt.Sprintf("ATTRS{idVendor}==\"%04x\", ATTRS{idProduct}==\"%04x\"", id.Vendor, id.Product)
+		perm := fmt.Sprintf("MODE=\"%s\", GROUP=\"%s\", TAG+=\"uaccess\"", mode, group)
+		fmt.Fprintf(&b, "\n# %s\n", id.Name)
+		fmt.Fprintf(&b, "SUBSYSTEM==\"usb\", ENV{DEVTYPE}==\"usb_device\", %s, %s\n", match, perm)
+		fmt.Fprintf(&b, "SUBSYSTEM==\"hidraw\", KERNEL==\"hidraw*\", %s, %s\n", parentMatch, perm)
+	}
+	return b.String()
+}
+
+// detectDongleIDs 通过 sysRoot 下的 sysfs 查找已插入加密锁的厂商ID和产品ID
+func detectDongleIDs(sysRoot string) ([]usbID, error) {
+	devices, err := scanUSB(sysRoot, "/dev")
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[[2]uint16]bool)
+	var ids []usbID
+	for _, dev := range devices {
+		key := [2]uint16{dev.Vendor, dev.Product}
+		if !dev.IsDongle() || seen[key] {
+			continue
+		}
+		seen[key] = true
+		name := dev.Known
+		if dev.ProductName != "" {
+			name += " " + dev.ProductName
+		}
+		ids = append(ids, usbID{Vendor: dev.Vendor, Product: dev.Product, Name: name})
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if ids[i].Vendor != ids[j].Vendor {
+			return ids[i].Vendor < ids[j].Vendor
+		}
+		return ids[i].Product < ids[j].Product
+	})
+	return ids, nil
+}
+
+// udevAccessProblems 检查规则生效后当前用户能否读写设备，返回缺少的条件
+//
+// 通过 sudo 运行时检查调用 sudo 的用户。uaccess 只对本地座席上的登录会话
+// 生效，SSH 会话和服务账户不能依赖它，因此这里只按组和其他用户权限判断。
+func udevAccessProblems(group, mode string) []string {
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return []string{fmt.Sprintf("无效的权限: %s", mode)}
+	}
+	if perm&0o006 == 0o006 {
+		return nil
+	}
+
+	// 目标用户，active 为该用户当前会话的组 (sudo 时无法得知，为 nil)
+	var u *user.User
+	var active []int
+	if sudoUser := os.Getenv("SUDO_USER"); os.Geteuid() == 0 && sudoUser != "" {
+		if u, err = user.Lookup(sudoUser); err != nil {
+			return []string{fmt.Sprintf("无法查找用户 %s: %v", sudoUser, err)}
+		}
+	} else if os.Geteuid() == 0 {
+		return nil
+	} else {
+		if u, err = user.Current(); err != nil {
+			return []string{fmt.Sprintf("无法获取当前用户: %v", err)}
+		}
+		gids, _ := os.Getgroups()
+		active = append([]int{os.Getgid()}, gids...)
+	}
+
+	if perm&0o060 != 0o060 {
+		return []string{fmt.Sprintf("权限 %s 没有给组 %s 读写权限，用户 %s 只能依赖 uaccess (本地登录会话)", mode, group, u.Username)}
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return []string{fmt.Sprintf("组 %s 不存在，请先创建: sudo groupadd %s", group, group)}
+	}
+
+	// /etc/group 中登记的组
+	member := u.Gid == g.Gid
+	if ids, err := u.GroupIds(); err == nil {
+		for _, id := range ids {
+			if id == g.Gid {
+				member = true
+			}
+		}
+	}
+	if !member {
+		return []string{fmt.Sprintf("用户 %s 不在组 %s 中: sudo usermod -a -G %s %s，然后重新登录", u.Username, group, group, u.Username)}
+	}
+
+	// 登记了但当前会话没有该组，说明加入后还没有重新登录
+	if active != nil {
+		gid, _ := strconv.Atoi(g.Gid)
+		for _, id := range active {
+			if id == gid {
+				return nil
+			}
+		}
+		return []string{fmt.Sprintf("用户 %s 已加入组 %s，但当前会话尚未生效，请重新登录", u.Username, group)}
+	}
+	return nil
+}
+
+// runUdevCommand 生成并安装 udev 规则
+func runUdevCommand(args []string) error {
+	fs := flag.NewFlagSet("udev", flag.ContinueOnError)
+	output := fs.String("o", UDEV_RULES_PATH, "规则文件路径")
+	dryRun := fs.Bool("dry-run", false, "只输出规则，不写入文件")
+	group := fs.String("group", UDEV_GROUP, "设备节点的属组")
+	mode := fs.String("mode", UDEV_MODE, "设备节点的权限 (八进制)")
+	all := fs.Bool("all", false, "为所有已知型号生成规则，不检测已插入的设备")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if perm, err := strconv.ParseUint(*mode, 8, 32); err != nil || perm > 0o777 {
+		return fmt.Errorf("无效的权限: %s", *mode)
+	}
+
+	// 与诊断使用同一份 knownDongleIDs，其他型号用全局参数 -usb-id 补充
+	ids := knownDongleIDs
+	if !*all {
+		detected, err := detectDongleIDs(SYSFS_USB_DEVICES)
+		if err != nil {
+			return fmt.Errorf("扫描USB设备失败: %v", err)
+		}
+		if len(detected) == 0 {
+			return fmt.Errorf("未检测到加密锁，请插入后重试，或用 -all 为所有已知型号生成规则 (其他型号用 -usb-id 指定)")
+		}
+		ids = detected
+	}
+
+	rules := udevRules(ids, *group, *mode)
+	if *dryRun {
+		fmt.Print(rules)
+	} else {
+		if err := os.WriteFile(*output, []byte(rules), 0o644); err != nil {
+			if os.IsPermission(err) {
+				return fmt.Errorf("写入 %s 失败: %v (需要 root 权限，可使用 sudo)", *output, err)
+			}
+			return err
+		}
+		fmt.Printf("已写入 %s\n", *output)
+		fmt.Println("执行以下命令使规则生效，然后重新插拔加密锁:")
+		fmt.Println("  sudo udevadm control --reload-rules && sudo udevadm trigger")
+	}
+
+	// 规则生效后的访问检查输出到标准错误，不影响 -dry-run 的规则输出
+	problems := udevAccessProblems(*group, *mode)
+	if len(problems) == 0 {
+		fmt.Fprintln(os.Stderr, "✓ 规则生效后当前用户可以访问加密锁")
+		return nil
+	}
+	fmt.Fprintln(os.Stderr, "⚠ 规则生效后当前用户仍可能无法访问加密锁:")
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "  - %s\n", p)
+	}
+	return nil
+}
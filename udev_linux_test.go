@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUdevRulesKnownIDs(t *testing.T) {
+	sysRoot, _ := writeFakeSysfs(t, t.TempDir(), []fakeUSBDevice{
+		{name: "1-1", attrs: map[string]string{"idVendor": "096e", "idProduct": "0006", "busnum": "1", "devnum": "002", "product": "ROCKEY-ARM"}},
+		{name: "1-2", attrs: map[string]string{"idVendor": "096e", "idProduct": "0006", "busnum": "1", "devnum": "003"}},
+		{name: "1-3", attrs: map[string]string{"idVendor": "096e", "idProduct": "0858", "busnum": "1", "devnum": "004", "product": "FIDO U2F"}},
+	})
+
+	ids, err := detectDongleIDs(sysRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0].Vendor != ROCKEY_USB_VENDOR || ids[0].Product != 0x0006 {
+		t.Fatalf("detectDongleIDs = %+v", ids)
+	}
+
+	rules := udevRules(ids, UDEV_GROUP, UDEV_MODE)
+	for _, line := range strings.Split(rules, "\n") {
+		if strings.HasPrefix(line, "SUBSYSTEM==") && !strings.Contains(line, "idProduct}==\"0006\"") {
+			t.Errorf("规则没有限定产品ID: %s", line)
+		}
+	}
+	if strings.Contains(rules, "0858") {
+		t.Error("规则包含同一厂商的 FIDO 令牌")
+	}
+
+	// -all 使用的 knownDongleIDs 同样限定产品ID
+	for _, line := range strings.Split(udevRules(knownDongleIDs, UDEV_GROUP, UDEV_MODE), "\n") {
+		if strings.HasPrefix(line, "SUBSYSTEM==") && !strings.Contains(line, "idProduct}==") {
+			t.Errorf("规则没有限定产品ID: %s", line)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// NETLINK_KOBJECT_UEVENT 内核 uevent 的 netlink 协议号
+const NETLINK_KOBJECT_UEVENT = 15
+
+// listenUEvents 监听内核 uevent，匹配的 USB 设备增删时发出通知
+//
+// netlink 套接字不支持 shutdown，阻塞中的 recvfrom 无法被其他线程唤醒。
+// 这里使用非阻塞套接字并交给 os.File 管理，读取由运行时的网络轮询器等待，
+// ctx 结束时关闭文件即可让读取返回。
+func listenUEvents(ctx context.Context, vendor, product uint16) (<-chan struct{}, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, fmt.Errorf("创建 netlink 套接字失败: %v", err)
+	}
+	addr := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Pid: 0, Groups: 1}
+	if err := syscall.Bind(fd, addr); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("绑定 netlink 套接字失败: %v", err)
+	}
+
+	file := os.NewFile(uintptr(fd), "netlink-uevent")
+
+	trigger := make(chan struct{}, 1)
+	go func() {
+		<-ctx.Done()
+		file.Close()
+	}()
+	go func() {
+		buf := make([]byte, 16*1024)
+		for {
+			n, err := file.Read(buf)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				// 接收缓冲区溢出时丢失了部分事件，继续接收
+				if errors.Is(err, syscall.ENOBUFS) {
+					continue
+				}
+				logger.Warn("接收 uevent 失败，仅使用轮询", "err", err)
+				return
+			}
+			if matchUEvent(buf[:n], vendor, product) {
+				select {
+				case trigger <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return trigger, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+)
+
+// listenUEvents 非 Linux 平台没有 uevent，只能轮询
+func listenUEvents(ctx context.Context, vendor, product uint16) (<-chan struct{}, error) {
+	return nil, fmt.Errorf("%s 平台不支持 uevent", runtime.GOOS)
+}
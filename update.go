@@ -0,0 +1,623 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// ============ 远程升级 ============
+//
+// 开发商一侧用母锁把变更（新文件、期限、种子码次数等）生成 SDK 升级包，
+// 升级包由母锁中的升级私钥签名加密，只能被对应产品（或指定硬件ID）的子锁接受。
+// 多个升级包与说明打包为一个带校验和的文件；客户一侧逐个应用后生成回执，
+// 回执由子锁中的 ECC 私钥文件（发行时写入，不可导出）对回执摘要签名，
+// 开发商用自己保存的对应公钥验证。不使用种子码：同一产品的所有锁种子码
+// 结果相同，客户可以用自己的锁伪造回执，而且每次运算都会消耗种子码次数。
+//
+// 回执只在客户无法用回执私钥对任意内容签名时可信：回执私钥文件不能被客户
+// 一侧的签名接口使用 (serve 的 /v1/sign 拒绝 RECEIPT_KEY_FILE_ID)，
+// 产品代码也不能对它调用 Dongle_EccSign。验证时必须指定升级包，回执中的
+// 升级包摘要与之一致，并且所有操作都成功才算升级完成。
+
+// 升级功能类型
+const (
+	UPDATE_FUNC_CREATEFILE    = 1 // 创建文件
+	UPDATE_FUNC_WRITEFILE     = 2 // 写文件
+	UPDATE_FUNC_DELETEFILE    = 3 // 删除文件
+	UPDATE_FUNC_FILELIC       = 4 // 设置文件授权
+	UPDATE_FUNC_SEEDCOUNT     = 5 // 设置种子码运算次数
+	UPDATE_FUNC_DOWNLOADEXE   = 6 // 下载可执行文件
+	UPDATE_FUNC_UNLOCKUSERPIN = 7 // 解锁用户PIN
+	UPDATE_FUNC_DEADLINE      = 8 // 设置期限
+)
+
+// UPDATE_FORMAT_VERSION 升级包格式版本
+const UPDATE_FORMAT_VERSION = 1
+
+// UPDATE_PACKET_OVERHEAD 升级包相对原始数据的最大额外长度
+const UPDATE_PACKET_OVERHEAD = 1024
+
+// RECEIPT_KEY_FILE_ID 默认的回执签名私钥文件ID，只能用于回执签名
+const RECEIPT_KEY_FILE_ID = 0x0D00
+
+// makeUpdatePacketFromMother 使用母锁生成升级包，hid 为 nil 时不绑定硬件ID
+func makeUpdatePacketFromMother(makeFunc uintptr, handle DongleHandle, hid []byte, function int, fileType int, fileID uint16, offset int, buffer []byte) ([]byte, uint32, error) {
+	if handle == 0 {
+		return nil, DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+	if hid != nil && len(hid) != len(DongleInfo{}.MHID) {
+		return nil, DONGLE_INVALID_DEVID, fmt.Errorf("%s: 硬件ID必须为 %d 字节", getErrorDescription(DONGLE_INVALID_DEVID), len(DongleInfo{}.MHID))
+	}
+
+	// 函数原型: DWORD Dongle_MakeUpdatePacketFromMother(DONGLE_HANDLE hDongle, char* pHID, int nFunc, int nFileType,
+	//                                                  WORD wFileID, int nOffset, BYTE* pBuffer, int nBufferLen,
+	//                                                  BYTE* pOutData, int* pOutDataLen)
+	type MakeUpdatePacketFuncType func(handle DongleHandle, hid unsafe.Pointer, function int32, fileType int32,
+		fileID uint16, offset int32, buffer unsafe.Pointer, bufferLen int32, out unsafe.Pointer, outLen *int32) uint32
+
+	var makeFuncGo MakeUpdatePacketFuncType
+	purego.RegisterFunc(&makeFuncGo, makeFunc)
+
+	var hidPtr, bufferPtr unsafe.Pointer
+	if hid != nil {
+		hidPtr = unsafe.Pointer(&hid[0])
+	}
+	if len(buffer) > 0 {
+		bufferPtr = unsafe.Pointer(&buffer[0])
+	}
+
+	out := make([]byte, len(buffer)+UPDATE_PACKET_OVERHEAD)
+	outLen := int32(len(out))
+	start := time.Now()
+	retCode := makeFuncGo(handle, hidPtr, int32(function), int32(fileType), fileID, int32(offset),
+		bufferPtr, int32(len(buffer)), unsafe.Pointer(&out[0]), &outLen)
+	traceCall(FUNC_MAKEUPDATEPACKETFROMMOTHER, handle, retCode, start, "len", outLen)
+
+	if retCode != DONGLE_SUCCESS {
+		return nil, retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+	if outLen <= 0 || int(outLen) > len(out) {
+		return nil, DONGLE_INVALID_SIZE, fmt.Errorf("%s: 升级包长度 %d", getErrorDescription(DONGLE_INVALID_SIZE), outLen)
+	}
+	return out[:outLen], retCode, nil
+}
+
+// update 在子锁上应用升级包
+func update(updateFunc uintptr, handle DongleHandle, packet []byte) (uint32, error) {
+	if handle == 0 {
+		return DONGLE_INVALID_HANDLE, fmt.Errorf(getErrorDescription(DONGLE_INVALID_HANDLE))
+	}
+	if len(packet) == 0 {
+		return DONGLE_INVALID_BUFFER, fmt.Errorf(getErrorDescription(DONGLE_INVALID_BUFFER))
+	}
+
+	// 函数原型: DWORD Dongle_Update(DONGLE_HANDLE hDongle, BYTE* pUpdateData, int nDataLen)
+	type UpdateFuncType func(handle DongleHandle, data unsafe.Pointer, dataLen int32) uint32
+
+	var updateFuncGo UpdateFuncType
+	purego.RegisterFunc(&updateFuncGo, updateFunc)
+
+	start := time.Now()
+	retCode := updateFuncGo(handle, unsafe.Pointer(&packet[0]), int32(len(packet)))
+	traceCall(FUNC_UPDATE, handle, retCode, start, "size", len(packet))
+
+	if retCode != DONGLE_SUCCESS {
+		return retCode, fmt.Errorf(getErrorDescription(retCode))
+	}
+	return retCode, nil
+}
+
+// parseHID 解析十六进制硬件ID，为空时返回 nil
+func parseHID(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	hid, err := hex.DecodeString(s)
+	if err != nil || len(hid) != len(DongleInfo{}.MHID) {
+		return nil, fmt.Errorf("无效的硬件ID: %s (应为 %d 位十六进制)", s, 2*len(DongleInfo{}.MHID))
+	}
+	return hid, nil
+}
+
+// ============ 升级描述 / 升级包 / 回执 ============
+
+// updateSpec 升级描述，由开发商编写
+type updateSpec struct {
+	Name          string        `json:"name" yaml:"name"`                       // 升级名称
+	TargetHID     string        `json:"target_hid" yaml:"target_hid"`           // 目标子锁硬件ID，为空则适用于同一产品的所有子锁
+	Files         []profileFile `json:"files" yaml:"files"`                     // 新建并写入的文件
+	WriteFiles    []profileFile `json:"write_files" yaml:"write_files"`         // 覆盖写入已有文件
+	DeleteFiles   []uint16      `json:"delete_files" yaml:"delete_files"`       // 删除的文件
+	Deadline      string        `json:"deadline" yaml:"deadline"`               // 新期限，见 parseDeadline
+	SeedLimit     *int          `json:"seed_limit" yaml:"seed_limit"`           // 新种子码运算次数
+	UnlockUserPIN bool          `json:"unlock_user_pin" yaml:"unlock_user_pin"` // 解锁用户PIN
+}
+
+// updateOp 升级包中的一项操作
+type updateOp struct {
+	Summary  string `json:"summary"`  // 描述
+	Function int    `json:"function"` // 升级功能类型
+	FileID   uint16 `json:"file_id"`  // 文件ID
+	Packet   []byte `json:"packet"`   // SDK 升级包（已加密）
+}
+
+// updatePackage 升级包文件
+type updatePackage struct {
+	Version   int        `json:"version"`    // 格式版本
+	Created   time.Time  `json:"created"`    // 创建时间
+	Name      string     `json:"name"`       // 升级名称
+	TargetHID string     `json:"target_hid"` // 目标硬件ID
+	Mother    string     `json:"mother"`     // 生成升级包的母锁硬件ID
+	Ops       []updateOp `json:"ops"`        // 操作列表
+}
+
+// updateResult 单项操作结果
+type updateResult struct {
+	Summary string `json:"summary"`         // 描述
+	RetCode uint32 `json:"ret_code"`        // 返回码
+	Error   string `json:"error,omitempty"` // 错误信息
+}
+
+// updateReceipt 升级回执，由客户一侧生成
+type updateReceipt struct {
+	Package   string         `json:"package"`    // 升级包 SHA-256
+	Name      string         `json:"name"`       // 升级名称
+	HID       string         `json:"hid"`        // 子锁硬件ID
+	PID       uint32         `json:"pid"`        // 子锁产品ID
+	AppliedAt time.Time      `json:"applied_at"` // 应用时间
+	Results   []updateResult `json:"results"`    // 操作结果
+	Deadline  uint32         `json:"deadline"`   // 应用后的期限
+	KeyFile   uint16         `json:"key_file"`   // 签名用的 ECC 私钥文件ID
+	Signature []byte         `json:"signature"`  // 子锁对回执摘要的 ECC 签名 (r||s)
+}
+
+// challenge 回执摘要，即不含签名的回执内容的 SHA-256
+func (r *updateReceipt) challenge() []byte {
+	c := *r
+	c.Signature = nil
+	data, _ := json.Marshal(&c)
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// buildUpdatePackage 使用母锁根据升级描述生成升级包
+func buildUpdatePackage(mother *deviceConn, spec *updateSpec, baseDir string) (*updatePackage, error) {
+	hid, err := parseHID(spec.TargetHID)
+	if err != nil {
+		return nil, err
+	}
+	makeFunc, err := mother.proc(FUNC_MAKEUPDATEPACKETFROMMOTHER)
+	if err != nil {
+		return nil, err
+	}
+
+	pkg := &updatePackage{
+		Version:   UPDATE_FORMAT_VERSION,
+		Created:   time.Now().UTC(),
+		Name:      spec.Name,
+		TargetHID: spec.TargetHID,
+		Mother:    mother.info.HID(),
+	}
+	add := func(summary string, function int, fileType int, fileID uint16, buffer []byte) error {
+		var packet []byte
+		err := mother.call(FUNC_MAKEUPDATEPACKETFROMMOTHER, func() (err error) {
+			packet, _, err = makeUpdatePacketFromMother(makeFunc, mother.handle, hid, function, fileType, fileID, 0, buffer)
+			return
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %v", summary, err)
+		}
+		pkg.Ops = append(pkg.Ops, updateOp{Summary: summary, Function: function, FileID: fileID, Packet: packet})
+		return nil
+	}
+
+	for _, id := range spec.DeleteFiles {
+		if err := add(fmt.Sprintf("删除文件 0x%04X", id), UPDATE_FUNC_DELETEFILE, FILE_DATA, id, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range spec.Files {
+		content, err := f.content(baseDir)
+		if err != nil {
+			return nil, fmt.Errorf("文件 0x%04X: %v", f.ID, err)
+		}
+		size := f.Size
+		if size == 0 {
+			size = len(content)
+		}
+		if size <= 0 || size > DATA_FILE_MAX_SIZE || len(content) > size {
+			return nil, fmt.Errorf("文件 0x%04X: 大小 %d 无效 (内容 %d 字节)", f.ID, size, len(content))
+		}
+		var attr bytes.Buffer
+		binary.Write(&attr, binary.LittleEndian, DataFileAttr{MSize: uint32(size), MReadPriv: uint16(f.ReadPriv), MWritePriv: uint16(f.WritePriv)})
+		if err := add(fmt.Sprintf("创建文件 0x%04X (大小 %d)", f.ID, size), UPDATE_FUNC_CREATEFILE, FILE_DATA, f.ID, attr.Bytes()); err != nil {
+			return nil, err
+		}
+		if len(content) > 0 {
+			if err := add(fmt.Sprintf("写入文件 0x%04X (%d 字节)", f.ID, len(content)), UPDATE_FUNC_WRITEFILE, FILE_DATA, f.ID, content); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, f := range spec.WriteFiles {
+		content, err := f.content(baseDir)
+		if err != nil {
+			return nil, fmt.Errorf("文件 0x%04X: %v", f.ID, err)
+		}
+		if len(content) == 0 || len(content) > DATA_FILE_MAX_SIZE {
+			return nil, fmt.Errorf("文件 0x%04X: 内容长度 %d 无效", f.ID, len(content))
+		}
+		if err := add(fmt.Sprintf("写入文件 0x%04X (%d 字节)", f.ID, len(content)), UPDATE_FUNC_WRITEFILE, FILE_DATA, f.ID, content); err != nil {
+			return nil, err
+		}
+	}
+
+	if spec.SeedLimit != nil {
+		if *spec.SeedLimit < SEED_COUNT_UNLIMITED {
+			return nil, fmt.Errorf("无效的种子码次数: %d", *spec.SeedLimit)
+		}
+		buffer := make([]byte, 4)
+		binary.LittleEndian.PutUint32(buffer, uint32(int32(*spec.SeedLimit)))
+		if err := add(fmt.Sprintf("种子码次数 -> %d", *spec.SeedLimit), UPDATE_FUNC_SEEDCOUNT, 0, 0, buffer); err != nil {
+			return nil, err
+		}
+	}
+
+	if spec.Deadline != "" {
+		deadline, err := parseDeadline(spec.Deadline)
+		if err != nil {
+			return nil, err
+		}
+		buffer := make([]byte, 4)
+		binary.LittleEndian.PutUint32(buffer, deadline)
+		if err := add("期限 -> "+formatDeadline(deadline), UPDATE_FUNC_DEADLINE, 0, 0, buffer); err != nil {
+			return nil, err
+		}
+	}
+
+	if spec.UnlockUserPIN {
+		if err := add("解锁用户PIN", UPDATE_FUNC_UNLOCKUSERPIN, 0, 0, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(pkg.Ops) == 0 {
+		return nil, fmt.Errorf("升级描述中没有任何操作")
+	}
+	return pkg, nil
+}
+
+// applyUpdatePackage 在子锁上依次应用升级包，遇到错误即停止
+//
+// 回执用 keyFile 指定的 ECC 私钥文件签名，调用前需要校验用户PIN。
+func applyUpdatePackage(c *deviceConn, pkg *updatePackage, digest string, keyFile uint16) (*updateReceipt, error) {
+	if pkg.TargetHID != "" && pkg.TargetHID != c.info.HID() {
+		return nil, fmt.Errorf("升级包的目标硬件ID为 %s，当前加密锁为 %s", pkg.TargetHID, c.info.HID())
+	}
+
+	updateFunc, err := c.proc(FUNC_UPDATE)
+	if err != nil {
+		return nil, err
+	}
+
+	receipt := &updateReceipt{
+		Package: digest,
+		Name:    pkg.Name,
+		HID:     c.info.HID(),
+		PID:     c.info.MPID,
+		KeyFile: keyFile,
+	}
+
+	var applyErr error
+	for i, op := range pkg.Ops {
+		logger.Info("应用升级操作", "step", i+1, "total", len(pkg.Ops), "summary", op.Summary)
+		var retCode uint32
+		err := c.call(FUNC_UPDATE, func() (err error) {
+			retCode, err = update(updateFunc, c.handle, op.Packet)
+			return
+		})
+		if isCallAborted(err) {
+			// 无法确定该项是否已生效，加密锁也无法再签名回执
+			return nil, fmt.Errorf("%s: %v", op.Summary, err)
+		}
+		result := updateResult{Summary: op.Summary, RetCode: retCode}
+		if err != nil {
+			result.Error = err.Error()
+			applyErr = fmt.Errorf("%s 失败: %v", op.Summary, err)
+		}
+		receipt.Results = append(receipt.Results, result)
+		if applyErr != nil {
+			break
+		}
+	}
+	receipt.AppliedAt = time.Now().UTC()
+
+	receipt.Deadline, _ = c.deadline()
+
+	eccSignFunc, err := c.proc(FUNC_ECCSIGN)
+	if err != nil {
+		return receipt, err
+	}
+	var signature []byte
+	err = c.call(FUNC_ECCSIGN, func() (err error) {
+		signature, _, err = eccSign(eccSignFunc, c.handle, keyFile, receipt.challenge())
+		return
+	})
+	if err != nil {
+		return receipt, fmt.Errorf("生成回执签名失败: %v", err)
+	}
+	receipt.Signature = signature
+	return receipt, applyErr
+}
+
+// parseEccPublicKey 解析回执验证公钥
+//
+// 支持 PEM 格式的 PKIX 公钥，或十六进制的 X||Y 坐标（64 字节，可带 04 前缀），
+// 后者即 SDK 生成密钥对时返回的 ECCSM2_PUBLIC_KEY 中的坐标。
+func parseEccPublicKey(data []byte) (*ecdsa.PublicKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("不是 ECC 公钥")
+		}
+		return key, nil
+	}
+
+	raw, err := hex.DecodeString(strings.Join(strings.Fields(string(data)), ""))
+	if err != nil {
+		return nil, fmt.Errorf("公钥既不是 PEM 也不是十六进制: %v", err)
+	}
+	if len(raw) == ECC_SIGNATURE_SIZE+1 && raw[0] == 0x04 {
+		raw = raw[1:]
+	}
+	if len(raw) != ECC_SIGNATURE_SIZE {
+		return nil, fmt.Errorf("公钥坐标长度 %d，应为 %d 字节", len(raw), ECC_SIGNATURE_SIZE)
+	}
+	key := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(raw[:ECC_SIGNATURE_SIZE/2]),
+		Y:     new(big.Int).SetBytes(raw[ECC_SIGNATURE_SIZE/2:]),
+	}
+	if !key.Curve.IsOnCurve(key.X, key.Y) {
+		return nil, fmt.Errorf("公钥坐标不在 P-256 曲线上")
+	}
+	return key, nil
+}
+
+// verifyReceipt 使用开发商保存的公钥验证回执签名
+func verifyReceipt(pub *ecdsa.PublicKey, receipt *updateReceipt) error {
+	if len(receipt.Signature) != ECC_SIGNATURE_SIZE {
+		return fmt.Errorf("回执签名长度 %d，应为 %d 字节", len(receipt.Signature), ECC_SIGNATURE_SIZE)
+	}
+	r := new(big.Int).SetBytes(receipt.Signature[:ECC_SIGNATURE_SIZE/2])
+	sig := new(big.Int).SetBytes(receipt.Signature[ECC_SIGNATURE_SIZE/2:])
+	if !ecdsa.Verify(pub, receipt.challenge(), r, sig) {
+		return fmt.Errorf("回执签名不匹配，回执可能被篡改")
+	}
+	return nil
+}
+
+// checkReceiptResults 检查回执中的操作是否全部成功
+//
+// 返回码为成功但记录了错误的操作同样视为失败。ops 为升级包中的操作数，
+// 回执中的操作少于 ops 说明应用中途停止。
+func checkReceiptResults(receipt *updateReceipt, ops int) error {
+	failed := 0
+	for _, r := range receipt.Results {
+		if r.RetCode != DONGLE_SUCCESS || r.Error != "" {
+			failed++
+		}
+	}
+	switch {
+	case failed > 0:
+		return fmt.Errorf("升级未完成: %d 项操作失败", failed)
+	case len(receipt.Results) < ops:
+		return fmt.Errorf("升级未完成: 只应用了 %d/%d 项操作", len(receipt.Results), ops)
+	}
+	return nil
+}
+
+// ============ 命令行 ============
+
+// runUpdateCommand 执行 update 子命令
+func runUpdateCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("用法: update make|apply|verify [选项]")
+	}
+	action := args[0]
+
+	fs := flag.NewFlagSet("update "+action, flag.ContinueOnError)
+	index := fs.Int("device", 0, "设备序号")
+
+	switch action {
+	case "make":
+		specPath := fs.String("spec", "", "升级描述文件 (YAML/JSON)")
+		output := fs.String("o", "update.pkg", "输出的升级包文件")
+		pin := fs.String("pin", "", "母锁开发商PIN，为空则不校验")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *specPath == "" {
+			return fmt.Errorf("用法: update make -spec 文件 [-o 升级包] [-device 母锁序号] [-pin PIN]")
+		}
+		spec := &updateSpec{}
+		if err := decodeConfig(*specPath, spec); err != nil {
+			return err
+		}
+
+		ctx, stop := commandContext()
+		defer stop()
+
+		mother, err := connectDevice(ctx, *index)
+		if err != nil {
+			return err
+		}
+		defer mother.close()
+		if mother.info.MIsMother == 0 {
+			return fmt.Errorf("设备 %d 不是母锁", *index)
+		}
+		if *pin != "" {
+			if err := mother.verifyPIN(FLAG_ADMINPIN, *pin); err != nil {
+				return fmt.Errorf("校验母锁PIN失败: %v", err)
+			}
+		}
+
+		pkg, err := buildUpdatePackage(mother, spec, filepath.Dir(*specPath))
+		if err != nil {
+			return err
+		}
+		data, err := sealEnvelope(pkg, nil)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*output, data, 0644); err != nil {
+			return err
+		}
+		target := pkg.TargetHID
+		if target == "" {
+			target = "同一产品的所有子锁"
+		}
+		fmt.Printf("已生成升级包 %s: %d 项操作, 目标 %s\n", *output, len(pkg.Ops), target)
+		return nil
+
+	case "apply":
+		output := fs.String("o", "receipt.json", "输出的回执文件")
+		userPIN := fs.String("pin", DEFAULT_USER_PIN, "用户PIN，回执签名需要用户权限")
+		keyFile := fs.String("key-file", fmt.Sprintf("0x%04X", RECEIPT_KEY_FILE_ID), "回执签名用的 ECC 私钥文件ID")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return fmt.Errorf("用法: update apply [-device N] [-pin 用户PIN] [-key-file ID] [-o 回执] 升级包")
+		}
+		keyFileID, err := parseFileID(*keyFile)
+		if err != nil {
+			return fmt.Errorf("-key-file: %v", err)
+		}
+		data, err := os.ReadFile(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		pkg := &updatePackage{}
+		digest, err := openEnvelope(data, nil, pkg)
+		if err != nil {
+			return fmt.Errorf("升级包无效: %v", err)
+		}
+		if pkg.Version != UPDATE_FORMAT_VERSION {
+			return fmt.Errorf("不支持的升级包版本: %d", pkg.Version)
+		}
+
+		ctx, stop := commandContext()
+		defer stop()
+
+		conn, err := connectDevice(ctx, *index)
+		if err != nil {
+			return err
+		}
+		defer conn.close()
+
+		if err := conn.verifyPIN(FLAG_USERPIN, *userPIN); err != nil {
+			return fmt.Errorf("校验用户PIN失败: %v", err)
+		}
+
+		receipt, applyErr := applyUpdatePackage(conn, pkg, digest, keyFileID)
+		if receipt != nil {
+			data, err := sealEnvelope(receipt, nil)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(*output, data, 0644); err != nil {
+				return err
+			}
+			fmt.Printf("已写入回执 %s，请将其发回开发商\n", *output)
+		}
+		return applyErr
+
+	case "verify":
+		pubPath := fs.String("pubkey", "", "回执签名私钥对应的公钥文件 (PEM 或十六进制 X||Y)")
+		pkgPath := fs.String("package", "", "回执对应的升级包文件")
+		digestFlag := fs.String("digest", "", "回执对应的升级包 SHA-256，没有升级包文件时使用")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 || *pubPath == "" || (*pkgPath == "") == (*digestFlag == "") {
+			return fmt.Errorf("用法: update verify -pubkey 公钥文件 -package 升级包|-digest SHA-256 回执")
+		}
+
+		// 回执必须与开发商发出的升级包对应，否则旧回执可以冒充新升级的结果
+		digest, ops := strings.ToLower(strings.TrimSpace(*digestFlag)), -1
+		if *pkgPath != "" {
+			pkgData, err := os.ReadFile(*pkgPath)
+			if err != nil {
+				return err
+			}
+			pkg := &updatePackage{}
+			if digest, err = openEnvelope(pkgData, nil, pkg); err != nil {
+				return fmt.Errorf("升级包无效: %v", err)
+			}
+			ops = len(pkg.Ops)
+		}
+		pubData, err := os.ReadFile(*pubPath)
+		if err != nil {
+			return err
+		}
+		pub, err := parseEccPublicKey(pubData)
+		if err != nil {
+			return fmt.Errorf("公钥无效: %v", err)
+		}
+		data, err := os.ReadFile(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		receipt := &updateReceipt{}
+		if _, err := openEnvelope(data, nil, receipt); err != nil {
+			return fmt.Errorf("回执无效: %v", err)
+		}
+
+		if err := verifyReceipt(pub, receipt); err != nil {
+			return err
+		}
+		if receipt.Package != digest {
+			return fmt.Errorf("回执对应的升级包为 %s，不是指定的升级包 %s", receipt.Package, digest)
+		}
+		fmt.Printf("回执有效: HID %s, 升级 %s, 应用于 %s, 期限 %s\n", receipt.HID, receipt.Name,
+			receipt.AppliedAt.Format(time.RFC3339), formatDeadline(receipt.Deadline))
+		for _, r := range receipt.Results {
+			status := "成功"
+			switch {
+			case r.RetCode != DONGLE_SUCCESS:
+				status = fmt.Sprintf("失败 0x%08X (%s)", r.RetCode, getErrorDescription(r.RetCode))
+			case r.Error != "":
+				status = fmt.Sprintf("失败: %s", r.Error)
+			}
+			fmt.Printf("  %s: %s\n", r.Summary, status)
+		}
+		return checkReceiptResults(receipt, ops)
+
+	default:
+		return fmt.Errorf("未知操作: update %s", action)
+	}
+}
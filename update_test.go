@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestCheckReceiptResults(t *testing.T) {
+	ok := updateResult{Summary: "创建文件", RetCode: DONGLE_SUCCESS}
+	cases := []struct {
+		name    string
+		results []updateResult
+		ops     int
+		wantErr bool
+	}{
+		{"全部成功", []updateResult{ok, ok}, 2, false},
+		{"只有摘要时不检查操作数", []updateResult{ok}, -1, false},
+		{"返回码失败", []updateResult{ok, {Summary: "写文件", RetCode: DONGLE_INVALID_FILEID}}, 2, true},
+		{"返回码成功但有错误", []updateResult{ok, {Summary: "写文件", Error: "调用超时"}}, 2, true},
+		{"中途停止", []updateResult{ok}, 2, true},
+	}
+	for _, tc := range cases {
+		err := checkReceiptResults(&updateReceipt{Results: tc.results}, tc.ops)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// ============ USB 设备扫描 ============
+//
+// 诊断时不再调用 lsusb、lsmod、groups、whoami 等外部命令（精简容器里往往
+// 没有），而是直接读取 sysfs：遍历 /sys/bus/usb/devices，按已知的厂商ID/
+// 产品ID 识别加密锁，给出总线端口路径、序列号、接口驱动绑定以及 /dev 下
+// 的设备节点和权限。
+//
+// 飞天诚信在同一厂商ID下还有 ePass、FIDO 等令牌，只按厂商ID匹配会把它们
+// 当作加密锁，udev 规则也会把它们的权限一并放开。因此只收录核实过的产品ID，
+// 其他型号用全局参数 -usb-id 补充。
+
+// SYSFS_USB_DEVICES USB 设备在 sysfs 中的目录
+const SYSFS_USB_DEVICES = "/sys/bus/usb/devices"
+
+// usbID 已知的加密锁 USB ID
+type usbID struct {
+	Vendor  uint16
+	Product uint16
+	Name    string
+}
+
+// knownDongleIDs 识别为加密锁的 USB ID，来自 usb.ids 中飞天诚信的登记
+var knownDongleIDs = []usbID{
+	{ROCKEY_USB_VENDOR, 0x0006, "飞天诚信 Rockey HID 加密锁"},
+}
+
+// matchDongleID 返回匹配的已知 ID
+func matchDongleID(vendor, product uint16) (usbID, bool) {
+	for _, id := range knownDongleIDs {
+		if id.Vendor == vendor && id.Product == product {
+			return id, true
+		}
+	}
+	return usbID{}, false
+}
+
+// addDongleIDs 把 厂商ID:产品ID 列表 (十六进制，逗号分隔) 加入 knownDongleIDs
+func addDongleIDs(list string) error {
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		vendor, product, ok := strings.Cut(item, ":")
+		if !ok {
+			return fmt.Errorf("无效的 USB ID: %s，格式为 厂商ID:产品ID", item)
+		}
+		vid, err := parseUSBID(vendor)
+		if err != nil {
+			return err
+		}
+		pid, err := parseUSBID(product)
+		if err != nil {
+			return err
+		}
+		if pid == 0 {
+			return fmt.Errorf("无效的 USB ID: %s，必须指定产品ID", item)
+		}
+		if _, ok := matchDongleID(vid, pid); !ok {
+			knownDongleIDs = append(knownDongleIDs, usbID{vid, pid, "-usb-id 指定"})
+		}
+	}
+	return nil
+}
+
+// usbNode /dev 下的设备节点
+type usbNode struct {
+	Path     string      // 节点路径
+	Mode     os.FileMode // 权限
+	UID      int         // 属主，未知时为 -1
+	GID      int         // 属组，未知时为 -1
+	Readable bool        // 当前进程可读
+	Writable bool        // 当前进程可写
+	Err      error       // 无法访问节点时的错误
+}
+
+// usbInterface USB 接口及其驱动绑定
+type usbInterface struct {
+	Name   string // sysfs 名称，例如 1-1.2:1.0
+	Class  uint8  // 接口类 (0x03 为 HID)
+	Driver string // 绑定的驱动，未绑定时为空
+}
+
+// usbDevice sysfs 中的一个 USB 设备
+type usbDevice struct {
+	Name         string // sysfs 名称，例如 1-1.2
+	Bus          int    // 总线号
+	Port         string // 端口路径，例如 1.2
+	DevNum       int    // 设备号
+	Vendor       uint16
+	Product      uint16
+	Manufacturer string
+	ProductName  string
+	Serial       string
+	Known        string // 匹配的已知加密锁名称，不是加密锁时为空
+	Interfaces   []usbInterface
+	DevNode      usbNode   // /dev/bus/usb 下的节点
+	HIDRaw       []usbNode // 对应的 /dev/hidraw* 节点
+}
+
+// IsDongle 是否为已知的加密锁
+func (d usbDevice) IsDongle() bool {
+	return d.Known != ""
+}
+
+// Drivers 返回接口驱动绑定的描述
+func (d usbDevice) Drivers() string {
+	var parts []string
+	for _, iface := range d.Interfaces {
+		driver := iface.Driver
+		if driver == "" {
+			driver = "(未绑定)"
+		}
+		name := iface.Name
+		if i := strings.IndexByte(name, ':'); i >= 0 {
+			name = name[i+1:]
+		}
+		parts = append(parts, name+"="+driver)
+	}
+	if len(parts) == 0 {
+		return "(无接口)"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// String 节点权限描述，例如 /dev/hidraw0 (crw-rw---- root:plugdev, 可读写)
+func (n usbNode) String() string {
+	if n.Err != nil {
+		return fmt.Sprintf("%s (%v)", n.Path, n.Err)
+	}
+	access := "无读写权限"
+	switch {
+	case n.Readable && n.Writable:
+		access = "可读写"
+	case n.Readable:
+		access = "只读"
+	case n.Writable:
+		access = "只写"
+	}
+	return fmt.Sprintf("%s (%v %s:%s, %s)", n.Path, n.Mode, userName(n.UID), groupName(n.GID), access)
+}
+
+// userName 返回 uid 对应的用户名，查不到时返回数字
+func userName(uid int) string {
+	if uid < 0 {
+		return "?"
+	}
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		return u.Username
+	}
+	return strconv.Itoa(uid)
+}
+
+// groupName 返回 gid 对应的组名，查不到时返回数字
+func groupName(gid int) string {
+	if gid < 0 {
+		return "?"
+	}
+	if g, err := user.LookupGroupId(strconv.Itoa(gid)); err == nil {
+		return g.Name
+	}
+	return strconv.Itoa(gid)
+}
+
+// currentUser 返回当前用户名和所属的组名
+func currentUser() (string, []string) {
+	name := strconv.Itoa(os.Getuid())
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	groups := []string{groupName(os.Getgid())}
+	if gids, err := os.Getgroups(); err == nil {
+		for _, gid := range gids {
+			if gid != os.Getgid() {
+				groups = append(groups, groupName(gid))
+			}
+		}
+	}
+	return name, groups
+}
+
+// parseUSBID 解析十六进制的 USB 厂商/产品ID，可带 0x 前缀，sysfs 中的值不带前缀
+func parseUSBID(s string) (uint16, error) {
+	v, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "0x"), 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("无效的 USB ID: %s", s)
+	}
+	return uint16(v), nil
+}
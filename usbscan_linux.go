@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// SYSFS_MODULES 已加载内核模块在 sysfs 中的目录
+const SYSFS_MODULES = "/sys/module"
+
+// scanUSB 遍历 sysRoot 下的 USB 设备，设备节点在 devRoot 下查找
+//
+// 正常使用时 sysRoot 为 SYSFS_USB_DEVICES，devRoot 为 "/dev"；两者可以指向
+// 伪造的目录树。
+func scanUSB(sysRoot, devRoot string) ([]usbDevice, error) {
+	entries, err := os.ReadDir(sysRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []usbDevice
+	for _, entry := range entries {
+		name := entry.Name()
+		// 接口 (1-1:1.0) 和根集线器 (usb1) 不是外接设备
+		if strings.Contains(name, ":") || strings.HasPrefix(name, "usb") {
+			continue
+		}
+		dir := filepath.Join(sysRoot, name)
+		vendor, err1 := parseUSBID(readSysfs(dir, "idVendor"))
+		product, err2 := parseUSBID(readSysfs(dir, "idProduct"))
+		if err1 != nil || err2 != nil {
+			continue
+		}
+
+		dev := usbDevice{
+			Name:         name,
+			Vendor:       vendor,
+			Product:      product,
+			Manufacturer: readSysfs(dir, "manufacturer"),
+			ProductName:  readSysfs(dir, "product"),
+			Serial:       readSysfs(dir, "serial"),
+		}
+		if id, ok := matchDongleID(vendor, product); ok {
+			dev.Known = id.Name
+		}
+		dev.Bus, _ = strconv.Atoi(readSysfs(dir, "busnum"))
+		dev.DevNum, _ = strconv.Atoi(readSysfs(dir, "devnum"))
+		if _, port, ok := strings.Cut(name, "-"); ok {
+			dev.Port = port
+		}
+		dev.DevNode = statNode(filepath.Join(devRoot, "bus", "usb", fmt.Sprintf("%03d", dev.Bus), fmt.Sprintf("%03d", dev.DevNum)))
+
+		ifaces, _ := filepath.Glob(filepath.Join(sysRoot, name+":*"))
+		sort.Strings(ifaces)
+		for _, ifaceDir := range ifaces {
+			iface := usbInterface{Name: filepath.Base(ifaceDir)}
+			if class, err := strconv.ParseUint(readSysfs(ifaceDir, "bInterfaceClass"), 16, 8); err == nil {
+				iface.Class = uint8(class)
+			}
+			if target, err := os.Readlink(filepath.Join(ifaceDir, "driver")); err == nil {
+				iface.Driver = filepath.Base(target)
+			}
+			dev.Interfaces = append(dev.Interfaces, iface)
+
+			// HID 接口下的 hidraw 节点: <接口>/<HID设备>/hidraw/hidrawN
+			hidraws, _ := filepath.Glob(filepath.Join(ifaceDir, "*", "hidraw", "hidraw*"))
+			sort.Strings(hidraws)
+			for _, h := range hidraws {
+				dev.HIDRaw = append(dev.HIDRaw, statNode(filepath.Join(devRoot, filepath.Base(h))))
+			}
+		}
+		devices = append(devices, dev)
+	}
+
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Name < devices[j].Name })
+	return devices, nil
+}
+
+// readSysfs 读取 sysfs 属性，失败时返回空字符串
+func readSysfs(dir, attr string) string {
+	data, err := os.ReadFile(filepath.Join(dir, attr))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// statNode 读取设备节点的权限和当前进程的访问能力
+func statNode(path string) usbNode {
+	node := usbNode{Path: path, UID: -1, GID: -1}
+	info, err := os.Stat(path)
+	if err != nil {
+		node.Err = err
+		return node
+	}
+	node.Mode = info.Mode()
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		node.UID = int(st.Uid)
+		node.GID = int(st.Gid)
+	}
+	node.Readable = syscall.Access(path, 4) == nil // R_OK
+	node.Writable = syscall.Access(path, 2) == nil // W_OK
+	return node
+}
+
+// kernelModuleLoaded 检查内核模块是否已加载或内建
+func kernelModuleLoaded(name string) bool {
+	_, err := os.Stat(filepath.Join(SYSFS_MODULES, name))
+	return err == nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeUSBDevice 伪造 sysfs 中的一个 USB 设备
+type fakeUSBDevice struct {
+	name    string
+	attrs   map[string]string
+	ifaces  map[string]string // 接口名 -> 驱动
+	hidraws map[string]string // 接口名 -> hidraw 节点
+}
+
+// writeFakeSysfs 在 root 下创建 sys/bus/usb/devices 和 dev 目录树
+func writeFakeSysfs(t *testing.T, root string, devices []fakeUSBDevice) (string, string) {
+	t.Helper()
+	sysRoot := filepath.Join(root, "sys", "bus", "usb", "devices")
+	devRoot := filepath.Join(root, "dev")
+	write := func(path, content string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0660); err != nil {
+			t.Fatal(err)
+		}
+		// 不受 umask 影响
+		if err := os.Chmod(path, 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 根集线器不是外接设备
+	write(filepath.Join(sysRoot, "usb1", "idVendor"), "1d6b\n")
+	write(filepath.Join(sysRoot, "usb1", "idProduct"), "0002\n")
+
+	for _, dev := range devices {
+		for attr, value := range dev.attrs {
+			write(filepath.Join(sysRoot, dev.name, attr), value+"\n")
+		}
+		write(filepath.Join(devRoot, "bus", "usb", "001", dev.attrs["devnum"]), "")
+		for iface, driver := range dev.ifaces {
+			dir := filepath.Join(sysRoot, dev.name+":"+iface)
+			write(filepath.Join(dir, "bInterfaceClass"), "03\n")
+			if err := os.Symlink(filepath.Join(root, "sys", "bus", "usb", "drivers", driver), filepath.Join(dir, "driver")); err != nil {
+				t.Fatal(err)
+			}
+			if node, ok := dev.hidraws[iface]; ok {
+				write(filepath.Join(dir, "0003:"+dev.attrs["idVendor"]+":"+dev.attrs["idProduct"]+".0001", "hidraw", node, "dev"), "")
+				write(filepath.Join(devRoot, node), "")
+			}
+		}
+	}
+	return sysRoot, devRoot
+}
+
+func TestScanUSBFakeSysfs(t *testing.T) {
+	sysRoot, devRoot := writeFakeSysfs(t, t.TempDir(), []fakeUSBDevice{
+		{
+			name: "1-1.2",
+			attrs: map[string]string{
+				"idVendor": "096e", "idProduct": "0006", "busnum": "1", "devnum": "005",
+				"manufacturer": "Feitian", "product": "ROCKEY-ARM", "serial": "0123456789",
+			},
+			ifaces:  map[string]string{"1.0": "usbhid"},
+			hidraws: map[string]string{"1.0": "hidraw2"},
+		},
+		{
+			// 同一厂商的 FIDO 令牌不是加密锁
+			name:    "1-3",
+			attrs:   map[string]string{"idVendor": "096e", "idProduct": "0858", "busnum": "1", "devnum": "007", "product": "FIDO U2F"},
+			ifaces:  map[string]string{"1.0": "usbhid"},
+			hidraws: map[string]string{"1.0": "hidraw3"},
+		},
+		{
+			name:   "1-4",
+			attrs:  map[string]string{"idVendor": "046d", "idProduct": "c52b", "busnum": "1", "devnum": "009"},
+			ifaces: map[string]string{"1.0": "usbhid", "1.1": "usbhid"},
+		},
+	})
+
+	devices, err := scanUSB(sysRoot, devRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 3 {
+		t.Fatalf("发现 %d 个设备，期望 3", len(devices))
+	}
+
+	dongle := devices[0]
+	if dongle.Name != "1-1.2" || !dongle.IsDongle() {
+		t.Fatalf("devices[0] = %s, IsDongle = %v", dongle.Name, dongle.IsDongle())
+	}
+	if dongle.Bus != 1 || dongle.Port != "1.2" || dongle.DevNum != 5 {
+		t.Errorf("总线 %d 端口 %s 设备号 %d", dongle.Bus, dongle.Port, dongle.DevNum)
+	}
+	if dongle.Serial != "0123456789" || dongle.ProductName != "ROCKEY-ARM" || dongle.Manufacturer != "Feitian" {
+		t.Errorf("序列号 %q 产品 %q 厂商 %q", dongle.Serial, dongle.ProductName, dongle.Manufacturer)
+	}
+	if got := dongle.Drivers(); got != "1.0=usbhid" {
+		t.Errorf("Drivers() = %s", got)
+	}
+	if dongle.Interfaces[0].Class != 0x03 {
+		t.Errorf("接口类 %02X", dongle.Interfaces[0].Class)
+	}
+	if want := filepath.Join(devRoot, "bus", "usb", "001", "005"); dongle.DevNode.Path != want || dongle.DevNode.Err != nil {
+		t.Errorf("DevNode = %s (%v), 期望 %s", dongle.DevNode.Path, dongle.DevNode.Err, want)
+	}
+	if len(dongle.HIDRaw) != 1 || dongle.HIDRaw[0].Path != filepath.Join(devRoot, "hidraw2") || dongle.HIDRaw[0].Err != nil {
+		t.Errorf("HIDRaw = %+v", dongle.HIDRaw)
+	}
+	if dongle.DevNode.Mode.Perm() != 0660 || !dongle.DevNode.Readable {
+		t.Errorf("DevNode 权限 %v, 可读 %v", dongle.DevNode.Mode, dongle.DevNode.Readable)
+	}
+
+	if devices[1].IsDongle() {
+		t.Errorf("同一厂商的 %04X:%04X 被识别为加密锁", devices[1].Vendor, devices[1].Product)
+	}
+	if devices[2].IsDongle() || devices[2].Drivers() != "1.0=usbhid, 1.1=usbhid" {
+		t.Errorf("devices[2] IsDongle = %v, Drivers = %s", devices[2].IsDongle(), devices[2].Drivers())
+	}
+}
+
+func TestAddDongleIDs(t *testing.T) {
+	saved := append([]usbID(nil), knownDongleIDs...)
+	defer func() { knownDongleIDs = saved }()
+
+	if _, ok := matchDongleID(ROCKEY_USB_VENDOR, 0x0201); ok {
+		t.Fatal("未登记的产品ID被识别为加密锁")
+	}
+	if err := addDongleIDs("096e:0201, 0x096E:0x0202"); err != nil {
+		t.Fatal(err)
+	}
+	for _, pid := range []uint16{0x0201, 0x0202} {
+		if _, ok := matchDongleID(ROCKEY_USB_VENDOR, pid); !ok {
+			t.Errorf("-usb-id 指定的 %04X 未被识别", pid)
+		}
+	}
+	for _, bad := range []string{"096e", "096e:0", "096e:zz", "10000:0001"} {
+		if err := addDongleIDs(bad); err == nil {
+			t.Errorf("addDongleIDs(%q) 应返回错误", bad)
+		}
+	}
+}
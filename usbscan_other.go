@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// scanUSB 非 Linux 平台没有 sysfs
+func scanUSB(sysRoot, devRoot string) ([]usbDevice, error) {
+	return nil, fmt.Errorf("%s 平台不支持 sysfs", runtime.GOOS)
+}
+
+// kernelModuleLoaded 非 Linux 平台没有内核模块信息
+func kernelModuleLoaded(name string) bool {
+	return false
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ============ 热插拔监视 ============
+//
+// 定时调用 Dongle_Enum 并按硬件ID对比前后两次结果得到插入、拔出和变化事件。
+// Linux 上还可以监听内核 uevent，加密锁的 USB 设备增删时立即重新枚举，
+// 不必等到下一个轮询周期。拔出事件携带的是设备最后一次被枚举到的信息。
+
+// ROCKEY_USB_VENDOR 飞天诚信的 USB 厂商ID
+const ROCKEY_USB_VENDOR = 0x096E
+
+// DeviceEventType 设备事件类型
+type DeviceEventType string
+
+// 设备事件类型
+const (
+	DeviceAdded   DeviceEventType = "added"   // 插入
+	DeviceRemoved DeviceEventType = "removed" // 拔出
+	DeviceChanged DeviceEventType = "changed" // 同一硬件ID的设备信息变化
+)
+
+// DeviceEvent 设备事件
+type DeviceEvent struct {
+	Type DeviceEventType
+	Time time.Time
+	Info DongleInfo
+	Err  error // 非空时表示枚举失败，Type 与 Info 无意义
+}
+
+// dongleInfoJSON 设备信息的 JSON 表示
+type dongleInfoJSON struct {
+	HID      string `json:"hid"`
+	PID      string `json:"pid"`
+	UserID   string `json:"user_id"`
+	Agent    uint32 `json:"agent"`
+	Version  uint16 `json:"version"`
+	Type     uint16 `json:"type"`
+	DevType  uint32 `json:"dev_type"`
+	IsMother bool   `json:"is_mother"`
+	BirthDay string `json:"birthday"`
+}
+
+// newDongleInfoJSON 转换设备信息
+func newDongleInfoJSON(info DongleInfo) *dongleInfoJSON {
+	return &dongleInfoJSON{
+		HID:      info.HID(),
+		PID:      fmt.Sprintf("%08X", info.MPID),
+		UserID:   fmt.Sprintf("%08X", info.MUserID),
+		Agent:    info.MAgent,
+		Version:  info.MVer,
+		Type:     info.MType,
+		DevType:  info.MDevType,
+		IsMother: info.MIsMother != 0,
+		BirthDay: fmt.Sprintf("%X", info.MBirthDay[:]),
+	}
+}
+
+// MarshalJSON 输出便于阅读的 JSON
+func (e DeviceEvent) MarshalJSON() ([]byte, error) {
+	type eventJSON struct {
+		Type  DeviceEventType `json:"event,omitempty"`
+		Time  time.Time       `json:"time"`
+		Info  *dongleInfoJSON `json:"info,omitempty"`
+		Error string          `json:"error,omitempty"`
+	}
+
+	out := eventJSON{Type: e.Type, Time: e.Time}
+	if e.Err != nil {
+		out.Type = "error"
+		out.Error = e.Err.Error()
+	} else {
+		out.Info = newDongleInfoJSON(e.Info)
+	}
+	return json.Marshal(out)
+}
+
+// Watcher 设备监视器
+type Watcher struct {
+	Backend  Backend
+	Interval time.Duration // 轮询间隔
+	Timeout  time.Duration // 单次枚举超时，不超过 -timeout
+	UEvent   bool          // 是否监听内核 uevent
+	Vendor   uint16        // uevent 过滤用的 USB 厂商ID
+	Product  uint16        // uevent 过滤用的 USB 产品ID，0 表示按已知加密锁 ID 过滤
+	Stats    *opRecorder   // 非空时记录枚举调用的统计
+}
+
+// Watch 开始监视，ctx 结束后关闭返回的通道
+//
+// 启动时已连接的设备以插入事件报告。uevent 监听失败时退回纯轮询。
+func (w *Watcher) Watch(ctx context.Context) <-chan DeviceEvent {
+	events := make(chan DeviceEvent, 16)
+
+	var trigger <-chan struct{}
+	if w.UEvent {
+		if ch, err := listenUEvents(ctx, w.Vendor, w.Product); err == nil {
+			trigger = ch
+		} else {
+			logger.Warn("无法监听 uevent，仅使用轮询", "err", err)
+		}
+	}
+
+	go w.loop(ctx, events, trigger)
+	return events
+}
+
+// loop 轮询并发送事件
+func (w *Watcher) loop(ctx context.Context, events chan<- DeviceEvent, trigger <-chan struct{}) {
+	defer close(events)
+
+	interval := w.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	known := make(map[string]DongleInfo)
+
+	poll := func() bool {
+		if stuckCalls.Load() > 0 {
+			return true // 卡住的调用返回前不再调用动态库，超时已经报告过
+		}
+
+		timeout := w.Timeout
+		if timeout <= 0 {
+			timeout = *callTimeout
+		}
+		pollCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		// callNative 返回 nil 时调用已经结束，超时后不再读取 keyList 和 err
+		var keyList []DongleInfo
+		var err error
+		if ctxErr := callNative(pollCtx, FUNC_ENUM, func() {
+			start := time.Now()
+			var retCode uint32
+			keyList, retCode, err = w.Backend.Enum()
+			if w.Stats != nil {
+				w.Stats.observe(FUNC_ENUM, start, time.Since(start), retCode, err)
+			}
+			if err != nil && retCode == DONGLE_NOT_FOUND {
+				keyList, err = nil, nil
+			}
+		}); ctxErr != nil {
+			if ctx.Err() != nil {
+				return false
+			}
+			if w.Stats != nil {
+				w.Stats.record(FUNC_ENUM, func(m *OpMetrics) { m.TimedOut++ })
+			}
+			keyList, err = nil, ctxErr
+		}
+
+		now := time.Now()
+		if err != nil {
+			return send(ctx, events, DeviceEvent{Time: now, Err: err})
+		}
+		for _, ev := range diffDevices(known, keyList) {
+			ev.Time = now
+			if !send(ctx, events, ev) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if !poll() {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-trigger:
+			// 内核刚报告设备变化时动态库未必已能枚举到，稍等片刻
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(200 * time.Millisecond):
+			}
+		}
+		if !poll() {
+			return
+		}
+	}
+}
+
+// send 发送事件，ctx 结束时返回 false
+func send(ctx context.Context, events chan<- DeviceEvent, ev DeviceEvent) bool {
+	select {
+	case events <- ev:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// diffDevices 按硬件ID对比并更新已知设备，返回事件列表
+func diffDevices(known map[string]DongleInfo, keyList []DongleInfo) []DeviceEvent {
+	var events []DeviceEvent
+	seen := make(map[string]bool, len(keyList))
+
+	for _, info := range keyList {
+		hid := info.HID()
+		seen[hid] = true
+		old, ok := known[hid]
+		switch {
+		case !ok:
+			events = append(events, DeviceEvent{Type: DeviceAdded, Info: info})
+		case old != info:
+			events = append(events, DeviceEvent{Type: DeviceChanged, Info: info})
+		}
+		known[hid] = info
+	}
+	for hid, info := range known {
+		if !seen[hid] {
+			events = append(events, DeviceEvent{Type: DeviceRemoved, Info: info})
+			delete(known, hid)
+		}
+	}
+	return events
+}
+
+// matchUEvent 判断 uevent 是否为指定 USB 设备的增删
+//
+// 消息格式为 "动作@路径" 后跟以 NUL 分隔的 KEY=VALUE，
+// USB 设备的 PRODUCT 为 "厂商ID/产品ID/版本"，均为不带前导零的十六进制。
+// product 为 0 时按 knownDongleIDs 匹配，忽略 vendor，
+// 以免同一厂商的其他 USB 设备 (如 FIDO 令牌) 触发重新枚举。
+func matchUEvent(msg []byte, vendor, product uint16) bool {
+	fields := bytes.Split(msg, []byte{0})
+	env := make(map[string]string, len(fields))
+	for _, f := range fields[1:] {
+		if k, v, ok := strings.Cut(string(f), "="); ok {
+			env[k] = v
+		}
+	}
+
+	if env["SUBSYSTEM"] != "usb" || env["DEVTYPE"] != "usb_device" {
+		return false
+	}
+	if action := env["ACTION"]; action != "add" && action != "remove" && action != "change" {
+		return false
+	}
+
+	parts := strings.Split(env["PRODUCT"], "/")
+	if len(parts) < 2 {
+		return false
+	}
+	vid, err1 := strconv.ParseUint(parts[0], 16, 16)
+	pid, err2 := strconv.ParseUint(parts[1], 16, 16)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	if product == 0 {
+		_, ok := matchDongleID(uint16(vid), uint16(pid))
+		return ok
+	}
+	return uint16(vid) == vendor && uint16(pid) == product
+}
+
+// runWatchCommand 监视设备插拔并以 NDJSON 输出事件
+func runWatchCommand(args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	interval := fs.Duration("interval", time.Second, "轮询间隔")
+	uevent := fs.Bool("uevent", true, "监听内核 uevent 以便立即发现插拔")
+	vendor := fs.String("vid", fmt.Sprintf("%04x", ROCKEY_USB_VENDOR), "uevent 过滤用的 USB 厂商ID (十六进制，仅在指定 -pid 时使用)")
+	product := fs.String("pid", "0", "uevent 过滤用的 USB 产品ID (十六进制，0 表示按已知加密锁 ID 及 -usb-id 过滤)")
+	metricsAddr := fs.String("metrics", "", "在该地址上提供 Prometheus /metrics，为空时不启用")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	vid, err := parseUSBID(*vendor)
+	if err != nil {
+		return err
+	}
+	pid, err := parseUSBID(*product)
+	if err != nil {
+		return err
+	}
+
+	// 标准输出只输出事件，日志在标准错误
+	out := json.NewEncoder(os.Stdout)
+
+	backend, err := newNativeBackend(getLibraryPath())
+	if err != nil {
+		return err
+	}
+	// 枚举可能仍卡在原生调用中，不卸载动态库
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	w := &Watcher{Backend: backend, Interval: *interval, UEvent: *uevent, Vendor: vid, Product: pid}
+	var health *healthMetrics
+	if *metricsAddr != "" {
+		w.Stats = newOpRecorder()
+		health = newHealthMetrics(w.Stats.snapshot)
+		if err := serveMetrics(ctx, *metricsAddr, health); err != nil {
+			return err
+		}
+	}
+
+	for ev := range w.Watch(ctx) {
+		if health != nil {
+			health.applyEvent(ev)
+		}
+		if err := out.Encode(ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
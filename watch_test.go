@@ -0,0 +1,98 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+// ueventMsg 按内核格式拼出 uevent 消息
+func ueventMsg(action string, env ...string) []byte {
+	fields := append([]string{action + "@/devices/pci0000:00/usb1/1-1", "ACTION=" + action}, env...)
+	return []byte(strings.Join(fields, "\x00"))
+}
+
+func TestMatchUEvent(t *testing.T) {
+	usb := []string{"SUBSYSTEM=usb", "DEVTYPE=usb_device"}
+	with := func(extra ...string) []string {
+		return append(append([]string{}, usb...), extra...)
+	}
+
+	cases := []struct {
+		name    string
+		msg     []byte
+		vendor  uint16
+		product uint16
+		want    bool
+	}{
+		{"已知加密锁插入", ueventMsg("add", with("PRODUCT=96e/6/100")...), ROCKEY_USB_VENDOR, 0, true},
+		{"已知加密锁拔出", ueventMsg("remove", with("PRODUCT=96e/6/100")...), ROCKEY_USB_VENDOR, 0, true},
+		{"已知加密锁变化", ueventMsg("change", with("PRODUCT=96e/6/100")...), ROCKEY_USB_VENDOR, 0, true},
+		{"同厂商其他产品", ueventMsg("add", with("PRODUCT=96e/858/100")...), ROCKEY_USB_VENDOR, 0, false},
+		{"其他厂商", ueventMsg("add", with("PRODUCT=1050/407/100")...), ROCKEY_USB_VENDOR, 0, false},
+		{"指定产品ID", ueventMsg("add", with("PRODUCT=96e/858/100")...), ROCKEY_USB_VENDOR, 0x0858, true},
+		{"指定产品ID不符", ueventMsg("add", with("PRODUCT=96e/6/100")...), ROCKEY_USB_VENDOR, 0x0858, false},
+		{"指定产品ID厂商不符", ueventMsg("add", with("PRODUCT=1050/858/100")...), ROCKEY_USB_VENDOR, 0x0858, false},
+		{"绑定动作", ueventMsg("bind", with("PRODUCT=96e/6/100")...), ROCKEY_USB_VENDOR, 0, false},
+		{"接口而非设备", ueventMsg("add", "SUBSYSTEM=usb", "DEVTYPE=usb_interface", "PRODUCT=96e/6/100"), ROCKEY_USB_VENDOR, 0, false},
+		{"hidraw 子系统", ueventMsg("add", "SUBSYSTEM=hidraw", "PRODUCT=96e/6/100"), ROCKEY_USB_VENDOR, 0, false},
+		{"缺少 PRODUCT", ueventMsg("add", usb...), ROCKEY_USB_VENDOR, 0, false},
+		{"PRODUCT 无效", ueventMsg("add", with("PRODUCT=xyz/6/100")...), ROCKEY_USB_VENDOR, 0, false},
+		{"只有头部", []byte("add@/devices/usb1"), ROCKEY_USB_VENDOR, 0, false},
+	}
+	for _, tc := range cases {
+		if got := matchUEvent(tc.msg, tc.vendor, tc.product); got != tc.want {
+			t.Errorf("%s: matchUEvent = %v, 期望 %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// watchDongle 构造指定硬件ID和用户ID的设备信息
+func watchDongle(hid byte, user uint32) DongleInfo {
+	return DongleInfo{MHID: [8]byte{hid}, MUserID: user}
+}
+
+func TestDiffDevices(t *testing.T) {
+	a, b, c := watchDongle(0xA, 1), watchDongle(0xB, 1), watchDongle(0xC, 1)
+	aChanged := watchDongle(0xA, 2)
+
+	cases := []struct {
+		name  string
+		known []DongleInfo
+		list  []DongleInfo
+		want  []string // "类型 硬件ID"
+	}{
+		{"首次枚举", nil, []DongleInfo{a, b}, []string{"added " + a.HID(), "added " + b.HID()}},
+		{"无变化", []DongleInfo{a, b}, []DongleInfo{b, a}, nil},
+		{"插入", []DongleInfo{a}, []DongleInfo{a, c}, []string{"added " + c.HID()}},
+		{"拔出", []DongleInfo{a, b}, []DongleInfo{a}, []string{"removed " + b.HID()}},
+		{"全部拔出", []DongleInfo{a, b}, nil, []string{"removed " + a.HID(), "removed " + b.HID()}},
+		{"信息变化", []DongleInfo{a, b}, []DongleInfo{aChanged, b}, []string{"changed " + a.HID()}},
+		{"同时插拔", []DongleInfo{a, b}, []DongleInfo{a, c}, []string{"added " + c.HID(), "removed " + b.HID()}},
+	}
+	for _, tc := range cases {
+		known := make(map[string]DongleInfo)
+		for _, info := range tc.known {
+			known[info.HID()] = info
+		}
+
+		var got []string
+		for _, ev := range diffDevices(known, tc.list) {
+			got = append(got, string(ev.Type)+" "+ev.Info.HID())
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("%s: 事件 = %v, 期望 %v", tc.name, got, tc.want)
+		}
+
+		// 已知设备应更新为本次枚举结果
+		if len(known) != len(tc.list) {
+			t.Errorf("%s: 已知设备 %d 个, 期望 %d", tc.name, len(known), len(tc.list))
+		}
+		for _, info := range tc.list {
+			if known[info.HID()] != info {
+				t.Errorf("%s: 已知设备 %s = %+v, 期望 %+v", tc.name, info.HID(), known[info.HID()], info)
+			}
+		}
+	}
+}